@@ -23,6 +23,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/console"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
@@ -214,6 +215,27 @@ func unlockAccount(ctx *cli.Context, accman *accounts.Manager, address string, i
 	return accounts.Account{}, ""
 }
 
+// unlockDevAccount imports the prefunded developer account into the keystore
+// (with an empty passphrase) if missing, and unlocks it indefinitely. As its key
+// is publicly known, this is only done if the chain is the local dev chain.
+func unlockDevAccount(accman *accounts.Manager, chain *core.BlockChain) {
+	if genesis := chain.Genesis().Hash(); !core.IsDevGenesis(genesis) {
+		glog.V(logger.Warn).Infof("Not unlocking developer account, genesis %x is not the dev genesis", genesis[:4])
+		return
+	}
+	account := accounts.Account{Address: core.DevAccount}
+	if !accman.HasAddress(core.DevAccount) {
+		var err error
+		if account, err = accman.ImportECDSA(core.DevAccountKey, ""); err != nil {
+			utils.Fatalf("Failed to import developer account: %v", err)
+		}
+	}
+	if err := accman.Unlock(account, ""); err != nil {
+		utils.Fatalf("Failed to unlock developer account: %v", err)
+	}
+	glog.V(logger.Warn).Infof("WARNING: developer account %x unlocked with a publicly known key, never send real funds to it!", core.DevAccount)
+}
+
 // getPassPhrase retrieves the passwor associated with an account, either fetched
 // from a list of preloaded passphrases, or requested interactively from the user.
 func getPassPhrase(prompt string, confirmation bool, i int, passwords []string) string {
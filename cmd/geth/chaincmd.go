@@ -22,11 +22,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/console"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
@@ -52,6 +54,14 @@ This is a destructive action and changes the network in which you will be
 participating.
 `,
 	}
+	importTrustedFlag = cli.BoolFlag{
+		Name:  "trusted",
+		Usage: "Insert archived blocks and receipts without executing them (chain archives only)",
+	}
+	importRootsFlag = cli.StringFlag{
+		Name:  "roots",
+		Usage: "Comma separated accumulator roots every archive epoch must match (chain archives only)",
+	}
 	importCommand = cli.Command{
 		Action:    importChain,
 		Name:      "import",
@@ -59,8 +69,21 @@ participating.
 		ArgsUsage: "<filename>",
 		Category:  "BLOCKCHAIN COMMANDS",
 		Description: `
-TODO: Please write this
+Imports blocks from the given file. Files ending in .era (or .era.gz) are
+treated as chain archives: every epoch is verified against its accumulator and
+checksum before being imported. As anyone can create a consistent archive,
+--roots should list the epoch roots published by a trusted source (exports log
+the roots of their epochs), rejecting archives with any other epochs.
+
+With --trusted, the blocks of an archive are not executed, only their headers,
+bodies and receipts are stored. No state is imported: the imported blocks only
+advance the fast sync head, and their state stays unavailable until the node
+fast syncs it from the network.
 `,
+		Flags: []cli.Flag{
+			importTrustedFlag,
+			importRootsFlag,
+		},
 	}
 	exportCommand = cli.Command{
 		Action:    exportChain,
@@ -73,6 +96,10 @@ Requires a first argument of the file to write to.
 Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing.
+
+Files ending in .era (or .era.gz) are written as chain archives,
+which also contain the receipts and total difficulty of every
+block. Archives are always overwritten.
 `,
 	}
 	upgradedbCommand = cli.Command{
@@ -157,7 +184,21 @@ func importChain(ctx *cli.Context) error {
 	}()
 	// Import the chain
 	start := time.Now()
-	if err := utils.ImportChain(chain, ctx.Args().First()); err != nil {
+	if fn := ctx.Args().First(); utils.IsArchive(fn) {
+		var roots []common.Hash
+		if list := ctx.String(importRootsFlag.Name); list != "" {
+			for _, root := range strings.Split(list, ",") {
+				hash, err := hexutil.Decode(strings.TrimSpace(root))
+				if err != nil || len(hash) != common.HashLength {
+					utils.Fatalf("Invalid accumulator root %q", root)
+				}
+				roots = append(roots, common.BytesToHash(hash))
+			}
+		}
+		if err := utils.ImportArchive(chain, fn, ctx.Bool(importTrustedFlag.Name), roots); err != nil {
+			utils.Fatalf("Import error: %v", err)
+		}
+	} else if err := utils.ImportChain(chain, fn); err != nil {
 		utils.Fatalf("Import error: %v", err)
 	}
 	fmt.Printf("Import done in %v.\n\n", time.Since(start))
@@ -210,7 +251,11 @@ func exportChain(ctx *cli.Context) error {
 	var err error
 	fp := ctx.Args().First()
 	if len(ctx.Args()) < 3 {
-		err = utils.ExportChain(chain, fp)
+		if utils.IsArchive(fp) {
+			err = utils.ExportArchive(chain, fp, 0, chain.CurrentBlock().NumberU64())
+		} else {
+			err = utils.ExportChain(chain, fp)
+		}
 	} else {
 		// This can be improved to allow for numbers larger than 9223372036854775807
 		first, ferr := strconv.ParseInt(ctx.Args().Get(1), 10, 64)
@@ -221,7 +266,11 @@ func exportChain(ctx *cli.Context) error {
 		if first < 0 || last < 0 {
 			utils.Fatalf("Export error: block number must be greater than 0\n")
 		}
-		err = utils.ExportAppendChain(chain, fp, uint64(first), uint64(last))
+		if utils.IsArchive(fp) {
+			err = utils.ExportArchive(chain, fp, uint64(first), uint64(last))
+		} else {
+			err = utils.ExportAppendChain(chain, fp, uint64(first), uint64(last))
+		}
 	}
 
 	if err != nil {
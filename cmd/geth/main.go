@@ -76,6 +76,7 @@ func init() {
 		attachCommand,
 		javascriptCommand,
 		// See misccmd.go:
+		makecacheCommand,
 		makedagCommand,
 		versionCommand,
 		licenseCommand,
@@ -93,6 +94,18 @@ func init() {
 		utils.LightServFlag,
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
+		utils.PruneDepthFlag,
+		utils.TxLookupLimitFlag,
+		utils.NoTxLookupFlag,
+		utils.TrustedCheckpointFlag,
+		utils.TxPoolJournalFlag,
+		utils.TxPoolRejournalFlag,
+		utils.TxPoolPriceBumpFlag,
+		utils.TxPoolAccountSlotsFlag,
+		utils.TxPoolGlobalSlotsFlag,
+		utils.TxPoolAccountQueueFlag,
+		utils.TxPoolGlobalQueueFlag,
+		utils.TxPoolLifetimeFlag,
 		utils.CacheFlag,
 		utils.TrieCacheGenFlag,
 		utils.JSpathFlag,
@@ -104,6 +117,12 @@ func init() {
 		utils.MinerThreadsFlag,
 		utils.MiningEnabledFlag,
 		utils.AutoDAGFlag,
+		utils.EthashCacheDirFlag,
+		utils.EthashCachesInMemoryFlag,
+		utils.EthashCachesOnDiskFlag,
+		utils.EthashDatasetDirFlag,
+		utils.EthashDatasetsInMemoryFlag,
+		utils.EthashDatasetsOnDiskFlag,
 		utils.TargetGasLimitFlag,
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
@@ -127,6 +146,7 @@ func init() {
 		utils.PreloadJSFlag,
 		utils.WhisperEnabledFlag,
 		utils.DevModeFlag,
+		utils.DevPeriodFlag,
 		utils.TestNetFlag,
 		utils.VMForceJitFlag,
 		utils.VMJitCacheFlag,
@@ -134,10 +154,14 @@ func init() {
 		utils.VMEnableDebugFlag,
 		utils.NetworkIdFlag,
 		utils.RPCCORSDomainFlag,
+		utils.LogsMaxRangeFlag,
+		utils.LogsMaxResultsFlag,
 		utils.EthStatsURLFlag,
 		utils.MetricsEnabledFlag,
 		utils.FakePoWFlag,
 		utils.SolcPathFlag,
+		utils.GpoBlocksFlag,
+		utils.GpoPercentileFlag,
 		utils.GpoMinGasPriceFlag,
 		utils.GpoMaxGasPriceFlag,
 		utils.GpoFullBlockRatioFlag,
@@ -145,6 +169,11 @@ func init() {
 		utils.GpobaseStepUpFlag,
 		utils.GpobaseCorrectionFactorFlag,
 		utils.ExtraDataFlag,
+		utils.MinerTxOrderFlag,
+		utils.MinerRecommitFlag,
+		utils.StratumEnabledFlag,
+		utils.StratumListenAddrFlag,
+		utils.StratumPortFlag,
 	}
 	app.Flags = append(app.Flags, debug.Flags...)
 
@@ -253,13 +282,30 @@ func startNode(ctx *cli.Context, stack *node.Node) {
 			unlockAccount(ctx, accman, trimmed, i, passwords)
 		}
 	}
+	// Make the prefunded developer account usable in dev mode, unless an explicit
+	// keystore was requested (don't litter user key directories with it)
+	dev := ctx.GlobalBool(utils.DevModeFlag.Name) && !ctx.GlobalBool(utils.LightModeFlag.Name)
+	if dev && !ctx.GlobalIsSet(utils.KeyStoreDirFlag.Name) {
+		var ethereum *eth.Ethereum
+		if err := stack.Service(&ethereum); err != nil {
+			utils.Fatalf("ethereum service not running: %v", err)
+		}
+		unlockDevAccount(accman, ethereum.BlockChain())
+	}
 	// Start auxiliary services if enabled
-	if ctx.GlobalBool(utils.MiningEnabledFlag.Name) {
+	if ctx.GlobalBool(utils.MiningEnabledFlag.Name) || ctx.GlobalBool(utils.StratumEnabledFlag.Name) || dev {
 		var ethereum *eth.Ethereum
 		if err := stack.Service(&ethereum); err != nil {
 			utils.Fatalf("ethereum service not running: %v", err)
 		}
-		if err := ethereum.StartMining(ctx.GlobalInt(utils.MinerThreadsFlag.Name)); err != nil {
+		// Stratum and dev mode need no local threads, these are opt-in via --mine
+		threads := 0
+		if ctx.GlobalBool(utils.MiningEnabledFlag.Name) {
+			threads = ctx.GlobalInt(utils.MinerThreadsFlag.Name)
+		} else if ctx.GlobalBool(utils.StratumEnabledFlag.Name) {
+			glog.V(logger.Info).Infof("Mining for Stratum workers only, enable local threads with --%s", utils.MiningEnabledFlag.Name)
+		}
+		if err := ethereum.StartMining(threads); err != nil {
 			utils.Fatalf("Failed to start mining: %v", err)
 		}
 	}
@@ -69,12 +69,41 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NetworkIdFlag,
 			utils.TestNetFlag,
 			utils.DevModeFlag,
+			utils.DevPeriodFlag,
 			utils.IdentityFlag,
 			utils.FastSyncFlag,
 			utils.LightModeFlag,
 			utils.LightServFlag,
 			utils.LightPeersFlag,
 			utils.LightKDFFlag,
+			utils.PruneDepthFlag,
+			utils.TxLookupLimitFlag,
+			utils.NoTxLookupFlag,
+			utils.TrustedCheckpointFlag,
+		},
+	},
+	{
+		Name: "ETHASH",
+		Flags: []cli.Flag{
+			utils.EthashCacheDirFlag,
+			utils.EthashCachesInMemoryFlag,
+			utils.EthashCachesOnDiskFlag,
+			utils.EthashDatasetDirFlag,
+			utils.EthashDatasetsInMemoryFlag,
+			utils.EthashDatasetsOnDiskFlag,
+		},
+	},
+	{
+		Name: "TRANSACTION POOL",
+		Flags: []cli.Flag{
+			utils.TxPoolJournalFlag,
+			utils.TxPoolRejournalFlag,
+			utils.TxPoolPriceBumpFlag,
+			utils.TxPoolAccountSlotsFlag,
+			utils.TxPoolGlobalSlotsFlag,
+			utils.TxPoolAccountQueueFlag,
+			utils.TxPoolGlobalQueueFlag,
+			utils.TxPoolLifetimeFlag,
 		},
 	},
 	{
@@ -107,6 +136,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.IPCApiFlag,
 			utils.IPCPathFlag,
 			utils.RPCCORSDomainFlag,
+			utils.LogsMaxRangeFlag,
+			utils.LogsMaxResultsFlag,
 			utils.JSpathFlag,
 			utils.ExecFlag,
 			utils.PreloadJSFlag,
@@ -136,11 +167,18 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TargetGasLimitFlag,
 			utils.GasPriceFlag,
 			utils.ExtraDataFlag,
+			utils.MinerTxOrderFlag,
+			utils.MinerRecommitFlag,
+			utils.StratumEnabledFlag,
+			utils.StratumListenAddrFlag,
+			utils.StratumPortFlag,
 		},
 	},
 	{
 		Name: "GAS PRICE ORACLE",
 		Flags: []cli.Flag{
+			utils.GpoBlocksFlag,
+			utils.GpoPercentileFlag,
 			utils.GpoMinGasPriceFlag,
 			utils.GpoMaxGasPriceFlag,
 			utils.GpoFullBlockRatioFlag,
@@ -31,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/internal/debug"
+	"github.com/ethereum/go-ethereum/internal/era"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/node"
@@ -38,7 +39,9 @@ import (
 )
 
 const (
-	importBatchSize = 2500
+	importBatchSize      = 2500
+	archiveHeaderCheck   = 100 // Verification frequency of header seals during trusted archive imports
+	archiveFileExtension = ".era"
 )
 
 func openLogFile(Datadir string, filename string) *os.File {
@@ -240,3 +243,178 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	glog.Infoln("Exported blockchain to ", fn)
 	return nil
 }
+
+// IsArchive checks whether the given file name refers to a chain archive (as
+// opposed to a raw RLP block dump), based on its extension.
+func IsArchive(fn string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(fn, ".gz"), archiveFileExtension)
+}
+
+// ImportArchive imports a chain archive into the local chain. In trusted mode the
+// blocks are not executed, rather their headers, bodies and receipts are inserted
+// directly, as done by fast sync. No state is imported: only the fast sync head
+// is advanced, while the state of the imported blocks stays unavailable until the
+// node fast syncs it from the network.
+//
+// If roots are given, every epoch of the archive must have one of them as its
+// accumulator root, authenticating the archive against a trusted source.
+func ImportArchive(chain *core.BlockChain, fn string, trusted bool, roots []common.Hash) error {
+	// Watch for Ctrl-C while the import is running.
+	// If a signal is received, the import will stop at the next epoch.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	glog.Infoln("Importing chain archive ", fn)
+	fh, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return err
+		}
+	}
+	archive, err := era.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	if len(roots) > 0 {
+		archive.SetTrustedRoots(roots)
+	} else if trusted {
+		glog.V(logger.Warn).Infoln("Importing archive without trusted roots, only its integrity is verified")
+	}
+	for {
+		select {
+		case <-interrupt:
+			return fmt.Errorf("interrupted")
+		default:
+		}
+		entries, acc, err := archive.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		glog.Infof("verified epoch #%d-#%d: root %x", acc.Start, acc.Start+acc.Count-1, acc.Root)
+
+		// Skip the already known prefix of the epoch
+		for len(entries) > 0 {
+			block := entries[0].Block
+			if block.NumberU64() == 0 && block.Hash() != chain.Genesis().Hash() {
+				return fmt.Errorf("genesis mismatch: have %x, want %x", block.Hash(), chain.Genesis().Hash())
+			}
+			if !chain.HasBlock(block.Hash()) {
+				break
+			}
+			entries = entries[1:]
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if err := importArchiveEntries(chain, entries, trusted); err != nil {
+			return err
+		}
+		// Cross check the total difficulties against the archive
+		for _, entry := range entries {
+			block := entry.Block
+			if td := chain.GetTd(block.Hash(), block.NumberU64()); td == nil || td.Cmp(entry.TD) != 0 {
+				return fmt.Errorf("block #%d: total difficulty mismatch: have %v, want %v", block.NumberU64(), td, entry.TD)
+			}
+		}
+	}
+}
+
+// importArchiveEntries inserts a contiguous batch of verified archive entries.
+func importArchiveEntries(chain *core.BlockChain, entries []*era.Entry, trusted bool) error {
+	for len(entries) > 0 {
+		batch := entries
+		if len(batch) > importBatchSize {
+			batch = batch[:importBatchSize]
+		}
+		entries = entries[len(batch):]
+
+		blocks := make(types.Blocks, len(batch))
+		for i, entry := range batch {
+			blocks[i] = entry.Block
+		}
+		if !trusted {
+			if n, err := chain.InsertChain(blocks); err != nil {
+				return fmt.Errorf("invalid block #%d: %v", blocks[n].NumberU64(), err)
+			}
+			continue
+		}
+		headers := make([]*types.Header, len(batch))
+		receipts := make([]types.Receipts, len(batch))
+		for i, entry := range batch {
+			headers[i], receipts[i] = entry.Block.Header(), entry.Receipts
+		}
+		if n, err := chain.InsertHeaderChain(headers, archiveHeaderCheck); err != nil {
+			return fmt.Errorf("invalid header #%d: %v", headers[n].Number, err)
+		}
+		if n, err := chain.InsertReceiptChain(blocks, receipts); err != nil {
+			return fmt.Errorf("invalid block #%d: %v", blocks[n].NumberU64(), err)
+		}
+	}
+	return nil
+}
+
+// ExportArchive exports a range of the canonical chain, including receipts and
+// total difficulties, into a chain archive.
+func ExportArchive(chain *core.BlockChain, fn string, first uint64, last uint64) error {
+	if first > last {
+		return fmt.Errorf("export failed: first (%d) is greater than last (%d)", first, last)
+	}
+	glog.Infoln("Exporting chain archive to ", fn)
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var (
+		writer io.Writer = fh
+		gz     *gzip.Writer
+	)
+	if strings.HasSuffix(fn, ".gz") {
+		gz = gzip.NewWriter(writer)
+		writer = gz
+	}
+	archive, err := era.NewWriter(writer, era.DefaultEpochSize)
+	if err != nil {
+		return err
+	}
+	for nr := first; nr <= last; nr++ {
+		block := chain.GetBlockByNumber(nr)
+		if block == nil {
+			return fmt.Errorf("export failed on #%d: not found", nr)
+		}
+		receipts := chain.GetReceiptsByHash(block.Hash())
+		td := chain.GetTd(block.Hash(), nr)
+		if td == nil {
+			return fmt.Errorf("export failed on #%d: total difficulty not found", nr)
+		}
+		if err := archive.Write(block, receipts, td); err != nil {
+			return fmt.Errorf("export failed on #%d: %v", nr, err)
+		}
+	}
+	if err := archive.Flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	for _, acc := range archive.Accumulators() {
+		glog.Infof("exported epoch #%d-#%d: root %x", acc.Start, acc.Start+acc.Count-1, acc.Root)
+	}
+	glog.Infoln("Exported chain archive to ", fn)
+	return nil
+}
@@ -27,8 +27,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -43,6 +43,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/miner"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/discv5"
@@ -127,6 +128,10 @@ var (
 		Name:  "dev",
 		Usage: "Developer mode: pre-configured private network with several debugging flags",
 	}
+	DevPeriodFlag = cli.Uint64Flag{
+		Name:  "dev.period",
+		Usage: "Block period in seconds to seal on in developer mode (0 = seal on transaction arrival)",
+	}
 	IdentityFlag = cli.StringFlag{
 		Name:  "identity",
 		Usage: "Custom node name",
@@ -154,10 +159,100 @@ var (
 		Usage: "Maximum number of LES client peers",
 		Value: 20,
 	}
+	PruneDepthFlag = cli.Uint64Flag{
+		Name:  "prunedepth",
+		Usage: "Number of recent blocks to retain bodies and receipts for (0 = archive node)",
+		Value: 0,
+	}
+	TxLookupLimitFlag = cli.Uint64Flag{
+		Name:  "txlookuplimit",
+		Usage: "Number of recent blocks to index transactions for (0 = entire chain)",
+		Value: 0,
+	}
+	NoTxLookupFlag = cli.BoolFlag{
+		Name:  "notxlookup",
+		Usage: "Disable indexing transactions for hash based lookups",
+	}
+	TrustedCheckpointFlag = cli.StringFlag{
+		Name:  "checkpoint",
+		Usage: "Trusted checkpoint for light clients to sync from (<section index>:<section head>:<CHT root>[:<bloom trie root>])",
+	}
 	LightKDFFlag = cli.BoolFlag{
 		Name:  "lightkdf",
 		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
 	}
+	// Ethash settings
+	EthashCacheDirFlag = DirectoryFlag{
+		Name:  "ethash.cachedir",
+		Usage: "Directory to store the ethash verification caches (default = inside the datadir)",
+		Value: DirectoryString{"ethash"},
+	}
+	EthashCachesInMemoryFlag = cli.IntFlag{
+		Name:  "ethash.cachesinmem",
+		Usage: "Number of recent ethash caches to keep in memory (16MB each)",
+		Value: 2,
+	}
+	EthashCachesOnDiskFlag = cli.IntFlag{
+		Name:  "ethash.cachesondisk",
+		Usage: "Number of recent ethash caches to keep on disk (16MB each)",
+		Value: 3,
+	}
+	EthashDatasetDirFlag = DirectoryFlag{
+		Name:  "ethash.dagdir",
+		Usage: "Directory to store the ethash mining DAGs",
+		Value: DirectoryString{pow.DefaultDir},
+	}
+	EthashDatasetsInMemoryFlag = cli.IntFlag{
+		Name:  "ethash.dagsinmem",
+		Usage: "Number of recent ethash mining DAGs to keep in memory (1+GB each)",
+		Value: 1,
+	}
+	EthashDatasetsOnDiskFlag = cli.IntFlag{
+		Name:  "ethash.dagsondisk",
+		Usage: "Number of recent ethash mining DAGs to keep on disk (1+GB each)",
+		Value: 2,
+	}
+	// Transaction pool settings
+	TxPoolJournalFlag = cli.StringFlag{
+		Name:  "txpool.journal",
+		Usage: "Disk journal for local transaction to survive node restarts",
+		Value: core.DefaultTxPoolConfig.Journal,
+	}
+	TxPoolRejournalFlag = cli.DurationFlag{
+		Name:  "txpool.rejournal",
+		Usage: "Time interval to regenerate the local transaction journal",
+		Value: core.DefaultTxPoolConfig.Rejournal,
+	}
+	TxPoolPriceBumpFlag = cli.Uint64Flag{
+		Name:  "txpool.pricebump",
+		Usage: "Price bump percentage to replace an already existing transaction",
+		Value: core.DefaultTxPoolConfig.PriceBump,
+	}
+	TxPoolAccountSlotsFlag = cli.Uint64Flag{
+		Name:  "txpool.accountslots",
+		Usage: "Minimum number of executable transaction slots guaranteed per account",
+		Value: core.DefaultTxPoolConfig.AccountSlots,
+	}
+	TxPoolGlobalSlotsFlag = cli.Uint64Flag{
+		Name:  "txpool.globalslots",
+		Usage: "Maximum number of executable transaction slots for all accounts",
+		Value: core.DefaultTxPoolConfig.GlobalSlots,
+	}
+	TxPoolAccountQueueFlag = cli.Uint64Flag{
+		Name:  "txpool.accountqueue",
+		Usage: "Maximum number of non-executable transaction slots permitted per account",
+		Value: core.DefaultTxPoolConfig.AccountQueue,
+	}
+	TxPoolGlobalQueueFlag = cli.Uint64Flag{
+		Name:  "txpool.globalqueue",
+		Usage: "Maximum number of non-executable transaction slots for all accounts",
+		Value: core.DefaultTxPoolConfig.GlobalQueue,
+	}
+	TxPoolLifetimeFlag = cli.DurationFlag{
+		Name:  "txpool.lifetime",
+		Usage: "Maximum amount of time non-executable transaction are queued",
+		Value: core.DefaultTxPoolConfig.Lifetime,
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -186,7 +281,7 @@ var (
 	}
 	AutoDAGFlag = cli.BoolFlag{
 		Name:  "autodag",
-		Usage: "Enable automatic DAG pregeneration",
+		Usage: "Deprecated, ignored (the next DAG is pregenerated while mining)",
 	}
 	EtherbaseFlag = cli.StringFlag{
 		Name:  "etherbase",
@@ -202,6 +297,30 @@ var (
 		Name:  "extradata",
 		Usage: "Block extra data set by the miner (default = client version)",
 	}
+	MinerTxOrderFlag = cli.StringFlag{
+		Name:  "minertxorder",
+		Usage: "Transaction ordering strategy for mined blocks (price, fifo, priority:<address>[,<address>...], capped:<limit>)",
+		Value: miner.DefaultTxOrder,
+	}
+	MinerRecommitFlag = cli.DurationFlag{
+		Name:  "minerrecommit",
+		Usage: "Time interval to recreate the block being mined with newly arrived transactions",
+		Value: miner.DefaultRecommitInterval,
+	}
+	StratumEnabledFlag = cli.BoolFlag{
+		Name:  "stratum",
+		Usage: "Enable the Stratum mining server for remote miners (no local mining threads without --mine)",
+	}
+	StratumListenAddrFlag = cli.StringFlag{
+		Name:  "stratumaddr",
+		Usage: "Stratum server listening interface",
+		Value: "127.0.0.1",
+	}
+	StratumPortFlag = cli.IntFlag{
+		Name:  "stratumport",
+		Usage: "Stratum server listening port",
+		Value: 8008,
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -308,6 +427,16 @@ var (
 		Usage: "Origins from which to accept websockets requests",
 		Value: "",
 	}
+	LogsMaxRangeFlag = cli.Uint64Flag{
+		Name:  "getlogs.maxrange",
+		Usage: "Maximum number of blocks a single log query may search (0 = unlimited)",
+		Value: 0,
+	}
+	LogsMaxResultsFlag = cli.IntFlag{
+		Name:  "getlogs.maxresults",
+		Usage: "Maximum number of logs a single log query may return (0 = unlimited)",
+		Value: 0,
+	}
 	ExecFlag = cli.StringFlag{
 		Name:  "exec",
 		Usage: "Execute JavaScript statement (only in combination with console/attach)",
@@ -392,25 +521,32 @@ var (
 		Usage: "Maximum suggested gas price",
 		Value: new(big.Int).Mul(big.NewInt(500), common.Shannon).String(),
 	}
+	GpoBlocksFlag = cli.IntFlag{
+		Name:  "gpoblocks",
+		Usage: "Number of recent blocks to check for gas prices",
+		Value: 10,
+	}
+	GpoPercentileFlag = cli.IntFlag{
+		Name:  "gpopercentile",
+		Usage: "Suggested gas price is the given percentile of a set of recent transaction gas prices",
+		Value: 50,
+	}
+	// Deprecated gas price oracle settings, accepted but ignored
 	GpoFullBlockRatioFlag = cli.IntFlag{
 		Name:  "gpofull",
-		Usage: "Full block threshold for gas price calculation (%)",
-		Value: 80,
+		Usage: "Deprecated, ignored (use --gpoblocks and --gpopercentile)",
 	}
 	GpobaseStepDownFlag = cli.IntFlag{
 		Name:  "gpobasedown",
-		Usage: "Suggested gas price base step down ratio (1/1000)",
-		Value: 10,
+		Usage: "Deprecated, ignored (use --gpoblocks and --gpopercentile)",
 	}
 	GpobaseStepUpFlag = cli.IntFlag{
 		Name:  "gpobaseup",
-		Usage: "Suggested gas price base step up ratio (1/1000)",
-		Value: 100,
+		Usage: "Deprecated, ignored (use --gpoblocks and --gpopercentile)",
 	}
 	GpobaseCorrectionFactorFlag = cli.IntFlag{
 		Name:  "gpobasecf",
-		Usage: "Suggested gas price base correction factor (%)",
-		Value: 110,
+		Usage: "Deprecated, ignored (use --gpoblocks and --gpopercentile)",
 	}
 )
 
@@ -620,6 +756,28 @@ func MakeEtherbase(accman *accounts.Manager, ctx *cli.Context) common.Address {
 	return account.Address
 }
 
+// MakeStratumAddr resolves the listening address of the Stratum mining server,
+// returning an empty string if it's disabled.
+func MakeStratumAddr(ctx *cli.Context) string {
+	if !ctx.GlobalBool(StratumEnabledFlag.Name) {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", ctx.GlobalString(StratumListenAddrFlag.Name), ctx.GlobalInt(StratumPortFlag.Name))
+}
+
+// MakeTrustedCheckpoint parses the trusted checkpoint from the command line
+// flags, returning nil if none was set (network default).
+func MakeTrustedCheckpoint(ctx *cli.Context) *params.TrustedCheckpoint {
+	if !ctx.GlobalIsSet(TrustedCheckpointFlag.Name) {
+		return nil
+	}
+	checkpoint, err := params.ParseTrustedCheckpoint(ctx.GlobalString(TrustedCheckpointFlag.Name))
+	if err != nil {
+		Fatalf("Option %q: %v", TrustedCheckpointFlag.Name, err)
+	}
+	return checkpoint
+}
+
 // MakeMinerExtra resolves extradata for the miner from the set command line flags
 // or returns a default one composed on the client, runtime and OS metadata.
 func MakeMinerExtra(extra []byte, ctx *cli.Context) []byte {
@@ -629,6 +787,21 @@ func MakeMinerExtra(extra []byte, ctx *cli.Context) []byte {
 	return extra
 }
 
+// MakeTxPoolConfig assembles the transaction pool limits, replacement policy and
+// local journal settings from the set command line flags.
+func MakeTxPoolConfig(ctx *cli.Context) core.TxPoolConfig {
+	return core.TxPoolConfig{
+		Journal:      ctx.GlobalString(TxPoolJournalFlag.Name),
+		Rejournal:    ctx.GlobalDuration(TxPoolRejournalFlag.Name),
+		PriceBump:    ctx.GlobalUint64(TxPoolPriceBumpFlag.Name),
+		AccountSlots: ctx.GlobalUint64(TxPoolAccountSlotsFlag.Name),
+		GlobalSlots:  ctx.GlobalUint64(TxPoolGlobalSlotsFlag.Name),
+		AccountQueue: ctx.GlobalUint64(TxPoolAccountQueueFlag.Name),
+		GlobalQueue:  ctx.GlobalUint64(TxPoolGlobalQueueFlag.Name),
+		Lifetime:     ctx.GlobalDuration(TxPoolLifetimeFlag.Name),
+	}
+}
+
 // MakePasswordList reads password lines from the file specified by --password.
 func MakePasswordList(ctx *cli.Context) []string {
 	path := ctx.GlobalString(PasswordFileFlag.Name)
@@ -717,7 +890,16 @@ func RegisterEthService(ctx *cli.Context, stack *node.Node, extra []byte) {
 	if networks > 1 {
 		Fatalf("The %v flags are mutually exclusive", netFlags)
 	}
+	// The old gas price oracle settings have no effect on the percentile based one
+	for _, flag := range []cli.IntFlag{GpoFullBlockRatioFlag, GpobaseStepDownFlag, GpobaseStepUpFlag, GpobaseCorrectionFactorFlag} {
+		if ctx.GlobalIsSet(flag.Name) {
+			glog.V(logger.Warn).Infof("The --%s flag is deprecated and ignored, use --%s and --%s instead", flag.Name, GpoBlocksFlag.Name, GpoPercentileFlag.Name)
+		}
+	}
 
+	if ctx.GlobalIsSet(AutoDAGFlag.Name) {
+		glog.V(logger.Warn).Infof("The --%s flag is deprecated and ignored, the next DAG is pregenerated while mining", AutoDAGFlag.Name)
+	}
 	ethConf := &eth.Config{
 		Etherbase:               MakeEtherbase(stack.AccountManager(), ctx),
 		ChainConfig:             MakeChainConfig(ctx, stack),
@@ -726,21 +908,34 @@ func RegisterEthService(ctx *cli.Context, stack *node.Node, extra []byte) {
 		LightServ:               ctx.GlobalInt(LightServFlag.Name),
 		LightPeers:              ctx.GlobalInt(LightPeersFlag.Name),
 		MaxPeers:                ctx.GlobalInt(MaxPeersFlag.Name),
+		Checkpoint:              MakeTrustedCheckpoint(ctx),
+		PruneDepth:              ctx.GlobalUint64(PruneDepthFlag.Name),
+		TxLookupLimit:           ctx.GlobalUint64(TxLookupLimitFlag.Name),
+		NoTxLookup:              ctx.GlobalBool(NoTxLookupFlag.Name),
+		TxPool:                  MakeTxPoolConfig(ctx),
 		DatabaseCache:           ctx.GlobalInt(CacheFlag.Name),
 		DatabaseHandles:         MakeDatabaseHandles(),
 		NetworkId:               ctx.GlobalInt(NetworkIdFlag.Name),
 		MinerThreads:            ctx.GlobalInt(MinerThreadsFlag.Name),
+		MinerTxOrder:            ctx.GlobalString(MinerTxOrderFlag.Name),
+		MinerRecommit:           ctx.GlobalDuration(MinerRecommitFlag.Name),
+		StratumAddr:             MakeStratumAddr(ctx),
 		ExtraData:               MakeMinerExtra(extra, ctx),
 		DocRoot:                 ctx.GlobalString(DocRootFlag.Name),
 		GasPrice:                common.String2Big(ctx.GlobalString(GasPriceFlag.Name)),
+		GpoBlocks:               ctx.GlobalInt(GpoBlocksFlag.Name),
+		GpoPercentile:           ctx.GlobalInt(GpoPercentileFlag.Name),
 		GpoMinGasPrice:          common.String2Big(ctx.GlobalString(GpoMinGasPriceFlag.Name)),
 		GpoMaxGasPrice:          common.String2Big(ctx.GlobalString(GpoMaxGasPriceFlag.Name)),
-		GpoFullBlockRatio:       ctx.GlobalInt(GpoFullBlockRatioFlag.Name),
-		GpobaseStepDown:         ctx.GlobalInt(GpobaseStepDownFlag.Name),
-		GpobaseStepUp:           ctx.GlobalInt(GpobaseStepUpFlag.Name),
-		GpobaseCorrectionFactor: ctx.GlobalInt(GpobaseCorrectionFactorFlag.Name),
+		LogsMaxRange:            ctx.GlobalUint64(LogsMaxRangeFlag.Name),
+		LogsMaxResults:          ctx.GlobalInt(LogsMaxResultsFlag.Name),
 		SolcPath:                ctx.GlobalString(SolcPathFlag.Name),
-		AutoDAG:                 ctx.GlobalBool(AutoDAGFlag.Name) || ctx.GlobalBool(MiningEnabledFlag.Name),
+		EthashCacheDir:          ctx.GlobalString(EthashCacheDirFlag.Name),
+		EthashCachesInMem:       ctx.GlobalInt(EthashCachesInMemoryFlag.Name),
+		EthashCachesOnDisk:      ctx.GlobalInt(EthashCachesOnDiskFlag.Name),
+		EthashDatasetDir:        ctx.GlobalString(EthashDatasetDirFlag.Name),
+		EthashDatasetsInMem:     ctx.GlobalInt(EthashDatasetsInMemoryFlag.Name),
+		EthashDatasetsOnDisk:    ctx.GlobalInt(EthashDatasetsOnDiskFlag.Name),
 		EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name),
 	}
 
@@ -757,7 +952,12 @@ func RegisterEthService(ctx *cli.Context, stack *node.Node, extra []byte) {
 		if !ctx.GlobalIsSet(GasPriceFlag.Name) {
 			ethConf.GasPrice = new(big.Int)
 		}
-		ethConf.PowTest = true
+		if !ctx.GlobalIsSet(EtherbaseFlag.Name) {
+			ethConf.Etherbase = core.DevAccount
+		}
+		ethConf.PowFake = true
+		ethConf.DevMode = true
+		ethConf.DevPeriod = time.Duration(ctx.GlobalUint64(DevPeriodFlag.Name)) * time.Second
 	}
 	// Override any global options pertaining to the Ethereum protocol
 	if gen := ctx.GlobalInt(TrieCacheGenFlag.Name); gen > 0 {
@@ -909,11 +1109,12 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 
 	chainConfig := MakeChainConfigFromDb(ctx, chainDb)
 
-	pow := pow.PoW(core.FakePow{})
+	seal := pow.PoW(pow.NewFakeEthash())
 	if !ctx.GlobalBool(FakePoWFlag.Name) {
-		pow = ethash.New()
+		seal = pow.New(stack.ResolvePath(ctx.GlobalString(EthashCacheDirFlag.Name)), ctx.GlobalInt(EthashCachesInMemoryFlag.Name), ctx.GlobalInt(EthashCachesOnDiskFlag.Name),
+			ctx.GlobalString(EthashDatasetDirFlag.Name), ctx.GlobalInt(EthashDatasetsInMemoryFlag.Name), ctx.GlobalInt(EthashDatasetsOnDiskFlag.Name))
 	}
-	chain, err = core.NewBlockChain(chainDb, chainConfig, pow, new(event.TypeMux), vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)})
+	chain, err = core.NewBlockChain(chainDb, chainConfig, seal, new(event.TypeMux), vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)})
 	if err != nil {
 		Fatalf("Could not start chainmanager: %v", err)
 	}
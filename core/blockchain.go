@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	mrand "math/rand"
 	"runtime"
@@ -50,6 +51,10 @@ var (
 	blockInsertTimer = metrics.NewTimer("chain/inserts")
 
 	ErrNoGenesis = errors.New("Genesis not found in chain")
+
+	// ErrBlockPruned is returned if the body or receipts of a canonical block
+	// were requested, but they have already been pruned from the database.
+	ErrBlockPruned = errors.New("block body and receipts pruned")
 )
 
 const (
@@ -57,6 +62,11 @@ const (
 	blockCacheLimit     = 256
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
+	minPruneDepth       = 1024            // Minimum number of recent blocks to always retain bodies for
+	pruneBatchSize      = 256             // Maximum number of blocks to prune in a single iteration
+	pruneRecheck        = 1 * time.Minute // Time interval between two pruning iterations
+	txIndexBatchSize    = 4096            // Maximum number of blocks to (un)index in a single iteration
+	txIndexRecheck      = 1 * time.Minute // Time interval between two transaction index maintenance runs
 	// must be bumped when consensus algorithm is changed, this forces the upgradedb
 	// command to be run (forces the blocks to be imported again using the new algorithm)
 	BlockChainVersion = 3
@@ -84,11 +94,15 @@ type BlockChain struct {
 	eventMux     *event.TypeMux
 	genesisBlock *types.Block
 
-	mu      sync.RWMutex // global mutex for locking chain operations
-	chainmu sync.RWMutex // blockchain insertion lock
-	procmu  sync.RWMutex // block processor lock
+	mu           sync.RWMutex // global mutex for locking chain operations
+	chainmu      sync.RWMutex // blockchain insertion lock
+	procmu       sync.RWMutex // block processor lock
+	txLookupLock sync.RWMutex // transaction index window lock (separate, as reorgs run under mu)
 
 	checkpoint       int          // checkpoint counts towards the new checkpoint
+	pruneDepth       uint64       // Number of recent blocks to retain bodies and receipts for (0 = archive)
+	txLookupLimit    uint64       // Number of recent blocks to index transactions for (0 = entire chain)
+	txLookupDisabled bool         // Whether transactions are not indexed for hash based lookups at all
 	currentBlock     *types.Block // Current head of the block chain
 	currentFastBlock *types.Block // Current head of the fast-sync chain (may be above the block chain!)
 
@@ -167,6 +181,67 @@ func NewBlockChain(chainDb ethdb.Database, config *params.ChainConfig, pow pow.P
 	return bc, nil
 }
 
+// SetPruneDepth sets the number of most recent canonical blocks for which the
+// bodies, receipts and transaction lookup entries are retained. Anything older
+// than that is deleted in the background, leaving only the headers. A depth of
+// zero disables pruning (archive mode).
+func (bc *BlockChain) SetPruneDepth(depth uint64) {
+	if depth > 0 && depth < minPruneDepth {
+		glog.V(logger.Warn).Infof("prune depth %d too low, raising to %d", depth, minPruneDepth)
+		depth = minPruneDepth
+	}
+	bc.mu.Lock()
+	bc.pruneDepth = depth
+	bc.mu.Unlock()
+}
+
+// SetTxLookupLimit configures the window of recent canonical blocks for which
+// transaction and receipt lookup entries are maintained. A limit of zero indexes
+// the entire chain, whereas disabling the index drops all existing entries. The
+// transition to the new window is done by a background indexer.
+func (bc *BlockChain) SetTxLookupLimit(limit uint64, enabled bool) {
+	bc.txLookupLock.Lock()
+	bc.txLookupLimit, bc.txLookupDisabled = limit, !enabled
+	bc.txLookupLock.Unlock()
+
+	// A fresh database has nothing indexed yet, start the index out empty if not
+	// all of the chain needs to be indexed, leaving it to the background indexer
+	// to index the configured window (instead of indexing everything on import,
+	// only to drop most of it again afterwards)
+	if (limit > 0 || !enabled) && GetTxIndexTail(bc.chainDb) == nil && bc.CurrentBlock().NumberU64() == 0 {
+		bc.writeTxIndexTail(bc.chainDb, 0, true)
+	}
+}
+
+// TxIndexTail returns the number of the oldest canonical block whose transactions
+// are indexed for hash based lookups.
+func (bc *BlockChain) TxIndexTail() uint64 {
+	if tail := GetTxIndexTail(bc.chainDb); tail != nil {
+		return *tail
+	}
+	return 0
+}
+
+// txLookupWanted checks whether the transactions of a canonical block need to
+// be indexed. Only the background indexer moves the index tail according to the
+// configured window, new blocks are indexed iff they are above the tail, so that
+// the index never has gaps and a missing lookup can be classified against it.
+func (bc *BlockChain) txLookupWanted(number uint64) bool {
+	return number >= bc.TxIndexTail()
+}
+
+// PruneTail returns the number of the oldest canonical block (apart from the
+// genesis) whose body and receipts are still available in the database.
+func (bc *BlockChain) PruneTail() uint64 {
+	return GetPruneTail(bc.chainDb)
+}
+
+// IsPruned checks whether the body and receipts of the canonical block with the
+// given number have been deleted by the history pruner.
+func (bc *BlockChain) IsPruned(number uint64) bool {
+	return number > 0 && number < bc.PruneTail()
+}
+
 func (self *BlockChain) getProcInterrupt() bool {
 	return atomic.LoadInt32(&self.procInterrupt) == 1
 }
@@ -548,6 +623,15 @@ func (self *BlockChain) GetBlockByNumber(number uint64) *types.Block {
 	return self.GetBlock(hash, number)
 }
 
+// GetReceiptsByHash retrieves the receipts for all transactions in a given block.
+func (self *BlockChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
+	number := self.hc.GetBlockNumber(hash)
+	if number == missingNumber {
+		return nil
+	}
+	return GetBlockReceipts(self.chainDb, hash, number)
+}
+
 // [deprecated by eth/62]
 // GetBlocksFromHash returns the block corresponding to hash and up to n-1 ancestors.
 func (self *BlockChain) GetBlocksFromHash(hash common.Hash, n int) (blocks []*types.Block) {
@@ -740,23 +824,19 @@ func (self *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain
 				glog.Fatal(errs[index])
 				return
 			}
-			if err := WriteMipmapBloom(self.chainDb, block.NumberU64(), receipts); err != nil {
-				errs[index] = fmt.Errorf("failed to write log blooms: %v", err)
-				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
-				return
-			}
-			if err := WriteTransactions(self.chainDb, block); err != nil {
-				errs[index] = fmt.Errorf("failed to write individual transactions: %v", err)
-				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
-				return
-			}
-			if err := WriteReceipts(self.chainDb, receipts); err != nil {
-				errs[index] = fmt.Errorf("failed to write individual receipts: %v", err)
-				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
-				return
+			if self.txLookupWanted(block.NumberU64()) {
+				if err := WriteTransactions(self.chainDb, block); err != nil {
+					errs[index] = fmt.Errorf("failed to write individual transactions: %v", err)
+					atomic.AddInt32(&failed, 1)
+					glog.Fatal(errs[index])
+					return
+				}
+				if err := WriteReceipts(self.chainDb, receipts); err != nil {
+					errs[index] = fmt.Errorf("failed to write individual receipts: %v", err)
+					atomic.AddInt32(&failed, 1)
+					glog.Fatal(errs[index])
+					return
+				}
 			}
 			atomic.AddInt32(&stats.processed, 1)
 		}
@@ -909,6 +989,7 @@ func (self *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 		if BadHashes[block.Hash()] {
 			err := BadHashError(block.Hash())
 			self.reportBlock(block, nil, err)
+			self.storeBadBlock(block, err)
 			return i, err
 		}
 		// Stage 1 validation of the block using the chain's validator
@@ -941,6 +1022,9 @@ func (self *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 			}
 
 			self.reportBlock(block, nil, err)
+			if !IsParentErr(err) {
+				self.storeBadBlock(block, err)
+			}
 			return i, err
 		}
 		// Create a new statedb using the parent block and report an
@@ -959,12 +1043,14 @@ func (self *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 		receipts, logs, usedGas, err := self.processor.Process(block, self.stateCache, self.vmConfig)
 		if err != nil {
 			self.reportBlock(block, receipts, err)
+			self.storeBadBlock(block, err)
 			return i, err
 		}
 		// Validate the state using the default validator
 		err = self.Validator().ValidateState(block, self.GetBlock(block.ParentHash(), block.NumberU64()-1), self.stateCache, receipts, usedGas)
 		if err != nil {
 			self.reportBlock(block, receipts, err)
+			self.storeBadBlock(block, err)
 			return i, err
 		}
 		// Write state changes to database
@@ -994,17 +1080,14 @@ func (self *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 			blockInsertTimer.UpdateSince(bstart)
 			events = append(events, ChainEvent{block, block.Hash(), logs})
 
-			// This puts transactions in a extra db for rpc
-			if err := WriteTransactions(self.chainDb, block); err != nil {
-				return i, err
-			}
-			// store the receipts
-			if err := WriteReceipts(self.chainDb, receipts); err != nil {
-				return i, err
-			}
-			// Write map map bloom filters
-			if err := WriteMipmapBloom(self.chainDb, block.NumberU64(), receipts); err != nil {
-				return i, err
+			// This puts transactions and receipts in a extra db for rpc
+			if self.txLookupWanted(block.NumberU64()) {
+				if err := WriteTransactions(self.chainDb, block); err != nil {
+					return i, err
+				}
+				if err := WriteReceipts(self.chainDb, receipts); err != nil {
+					return i, err
+				}
 			}
 			// Write hash preimages
 			if err := WritePreimages(self.chainDb, block.NumberU64(), self.stateCache.Preimages()); err != nil {
@@ -1169,17 +1252,14 @@ func (self *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 		// insert the block in the canonical way, re-writing history
 		self.insert(block)
 		// write canonical receipts and transactions
-		if err := WriteTransactions(self.chainDb, block); err != nil {
-			return err
-		}
 		receipts := GetBlockReceipts(self.chainDb, block.Hash(), block.NumberU64())
-		// write receipts
-		if err := WriteReceipts(self.chainDb, receipts); err != nil {
-			return err
-		}
-		// Write map map bloom filters
-		if err := WriteMipmapBloom(self.chainDb, block.NumberU64(), receipts); err != nil {
-			return err
+		if self.txLookupWanted(block.NumberU64()) {
+			if err := WriteTransactions(self.chainDb, block); err != nil {
+				return err
+			}
+			if err := WriteReceipts(self.chainDb, receipts); err != nil {
+				return err
+			}
 		}
 		addedTxs = append(addedTxs, block.Transactions()...)
 	}
@@ -1232,16 +1312,223 @@ func (self *BlockChain) postChainEvents(events []interface{}, logs []*types.Log)
 
 func (self *BlockChain) update() {
 	futureTimer := time.Tick(5 * time.Second)
+	pruneTimer := time.Tick(pruneRecheck)
+	txIndexTimer := time.Tick(txIndexRecheck)
 	for {
 		select {
 		case <-futureTimer:
 			self.procFutureBlocks()
+		case <-pruneTimer:
+			self.prune()
+		case <-txIndexTimer:
+			self.indexTransactions()
 		case <-self.quit:
 			return
 		}
 	}
 }
 
+// prune deletes the bodies, receipts and transaction lookup entries of the
+// canonical blocks that fell more than the configured prune depth behind the
+// current head, advancing the persisted prune tail as it goes. The headers and
+// total difficulties are retained so the chain itself remains verifiable.
+func (bc *BlockChain) prune() {
+	for bc.pruneBatch() {
+		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
+			return
+		}
+	}
+}
+
+// pruneBatch deletes the history of at most pruneBatchSize blocks, returning
+// whether there are more blocks waiting to be pruned.
+func (bc *BlockChain) pruneBatch() bool {
+	bc.mu.RLock()
+	depth, head := bc.pruneDepth, bc.currentBlock.NumberU64()
+	bc.mu.RUnlock()
+
+	if depth == 0 || head <= depth {
+		return false
+	}
+	// Block insertions (and thus reorgs) until the current batch is pruned
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	// Never touch the genesis block, it's needed to initialise the chain
+	start, limit := GetPruneTail(bc.chainDb), head-depth
+	if start == 0 {
+		start = 1
+	}
+	if start >= limit {
+		return false
+	}
+	more := false
+	if limit-start > pruneBatchSize {
+		limit, more = start+pruneBatchSize, true
+	}
+	// If the entire chain is indexed, keep the positional lookup entries of the
+	// pruned transactions, so they can be told apart from unknown ones. A limited
+	// window would need the pruned bodies to drop them again, so delete them then.
+	bc.txLookupLock.RLock()
+	keepLookups := bc.txLookupLimit == 0 && !bc.txLookupDisabled
+	bc.txLookupLock.RUnlock()
+
+	// Collect all deletions into a single batch, so a crash can't leave the
+	// prune tail behind the actually deleted history
+	batch := bc.chainDb.NewBatch()
+	hashes := make([]common.Hash, 0, limit-start)
+	for number := start; number < limit; number++ {
+		hash := GetCanonicalHash(bc.chainDb, number)
+		if body := GetBody(bc.chainDb, hash, number); body != nil {
+			for _, tx := range body.Transactions {
+				if keepLookups {
+					DeleteTransactionData(batch, tx.Hash())
+				} else {
+					DeleteTransaction(batch, tx.Hash())
+				}
+				DeleteReceipt(batch, tx.Hash())
+			}
+		}
+		DeleteBody(batch, hash, number)
+		DeleteBlockReceipts(batch, hash, number)
+		hashes = append(hashes, hash)
+	}
+	WritePruneTail(batch, limit)
+	if err := batch.Write(); err != nil {
+		glog.Fatalf("failed to prune blocks #%d-#%d: %v", start, limit-1, err)
+	}
+	for _, hash := range hashes {
+		bc.bodyCache.Remove(hash)
+		bc.bodyRLPCache.Remove(hash)
+		bc.blockCache.Remove(hash)
+	}
+	glog.V(logger.Debug).Infof("pruned block bodies and receipts #%d-#%d", start, limit-1)
+	return more
+}
+
+// indexTransactions moves the transaction lookup index towards the configured
+// window of recent blocks, deleting the entries of blocks that fell out of it and
+// (re)indexing the ones that should be, but aren't covered yet. Progress is kept
+// in the database, so an interrupted run is carried on after a restart.
+func (bc *BlockChain) indexTransactions() {
+	for bc.indexTransactionsBatch() {
+		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
+			return
+		}
+	}
+}
+
+// indexTransactionsBatch (un)indexes the transactions of at most txIndexBatchSize
+// blocks, returning whether there is more work to do.
+func (bc *BlockChain) indexTransactionsBatch() bool {
+	bc.txLookupLock.RLock()
+	limit, disabled := bc.txLookupLimit, bc.txLookupDisabled
+	bc.txLookupLock.RUnlock()
+
+	head := bc.CurrentBlock().NumberU64()
+
+	// Block insertions (and thus reorgs) until the current batch is done
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	// Calculate the first block that should be indexed (head+1 if none)
+	from := uint64(0)
+	switch {
+	case disabled:
+		from = head + 1
+	case limit > 0 && head >= limit:
+		from = head - limit + 1
+	}
+	if pruned := GetPruneTail(bc.chainDb); from < pruned {
+		from = pruned
+	}
+	// Retrieve the current tail of the index, legacy databases have everything indexed
+	tail := uint64(0)
+	if stored := GetTxIndexTail(bc.chainDb); stored != nil {
+		tail = *stored
+	}
+	if tail > head+1 {
+		tail = head + 1
+	}
+	switch {
+	case tail < from:
+		// Blocks fell out of the window, drop their lookup entries
+		end := from
+		if end-tail > txIndexBatchSize {
+			end = tail + txIndexBatchSize
+		}
+		batch := bc.chainDb.NewBatch()
+		for number := tail; number < end; number++ {
+			hash := GetCanonicalHash(bc.chainDb, number)
+			if body := GetBody(bc.chainDb, hash, number); body != nil {
+				for _, tx := range body.Transactions {
+					DeleteTransaction(batch, tx.Hash())
+					DeleteReceipt(batch, tx.Hash())
+				}
+			}
+		}
+		bc.writeTxIndexTail(batch, end, disabled && end == from)
+		if err := batch.Write(); err != nil {
+			glog.Fatalf("failed to unindex transactions of blocks #%d-#%d: %v", tail, end-1, err)
+		}
+		glog.V(logger.Debug).Infof("unindexed transactions of blocks #%d-#%d", tail, end-1)
+		return end < from
+
+	case tail > from:
+		// Blocks entered the window, index them backwards until the new limit
+		start := from
+		if tail-start > txIndexBatchSize {
+			start = tail - txIndexBatchSize
+		}
+		number, missing := tail, false
+		for ; number > start; number-- {
+			hash := GetCanonicalHash(bc.chainDb, number-1)
+			block := GetBlock(bc.chainDb, hash, number-1)
+			if block == nil {
+				// The body is unavailable (e.g. not yet synced), retry on the next recheck
+				glog.V(logger.Debug).Infof("body of block #%d missing, transaction indexing paused", number-1)
+				missing = true
+				break
+			}
+			if err := WriteTransactions(bc.chainDb, block); err != nil {
+				glog.Fatalf("failed to index transactions of block #%d: %v", number-1, err)
+			}
+			if err := WriteReceipts(bc.chainDb, GetBlockReceipts(bc.chainDb, hash, number-1)); err != nil {
+				glog.Fatalf("failed to index receipts of block #%d: %v", number-1, err)
+			}
+		}
+		bc.writeTxIndexTail(bc.chainDb, number, false)
+		glog.V(logger.Debug).Infof("indexed transactions of blocks #%d-#%d", number, tail-1)
+		return !missing && number > from
+
+	default:
+		// Index up to date, make sure a disabled one stays marked as such
+		bc.writeTxIndexTail(bc.chainDb, tail, disabled)
+		return false
+	}
+}
+
+// writeTxIndexTail persists the transaction index tail, or marks the index as
+// completely disabled, making sure any blocks imported later on are correctly
+// detected as unindexed when the index is turned back on.
+func (bc *BlockChain) writeTxIndexTail(db ethdb.Putter, tail uint64, disabled bool) {
+	if disabled {
+		tail = math.MaxUint64
+	}
+	if err := WriteTxIndexTail(db, tail); err != nil {
+		glog.Fatalf("failed to update transaction index tail: %v", err)
+	}
+}
+
+// storeBadBlock persists a block that failed consensus validation for later
+// inspection. Blocks rejected for missing their ancestors or the local state are
+// not bad and must not be stored, lest they push the actually bad ones out.
+func (bc *BlockChain) storeBadBlock(block *types.Block, err error) {
+	if err := WriteBadBlock(bc.chainDb, block, err); err != nil {
+		glog.V(logger.Error).Infof("failed to store bad block #%d [%x…]: %v", block.Number(), block.Hash().Bytes()[:4], err)
+	}
+}
+
 // reportBlock logs a bad block error.
 func (bc *BlockChain) reportBlock(block *types.Block, receipts types.Receipts, err error) {
 	if glog.V(logger.Error) {
@@ -1263,6 +1550,18 @@ Error: %v
 	}
 }
 
+// BadBlocks returns the most recently rejected blocks along with the reasons of
+// their rejection, newest first.
+func (bc *BlockChain) BadBlocks() []*BadBlock {
+	return GetBadBlocks(bc.chainDb)
+}
+
+// BadBlock returns a recently rejected block by its hash, or nil if it's not
+// tracked.
+func (bc *BlockChain) BadBlock(hash common.Hash) *BadBlock {
+	return GetBadBlock(bc.chainDb, hash)
+}
+
 // InsertHeaderChain attempts to insert the given header chain in to the local
 // chain, possibly creating a reorg. If an error is returned, it will return the
 // index number of the failing header as well an error describing what went wrong.
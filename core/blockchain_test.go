@@ -18,6 +18,7 @@ package core
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 	"math/rand"
 	"os"
@@ -27,7 +28,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -46,8 +46,7 @@ func init() {
 }
 
 func thePow() pow.PoW {
-	pow, _ := ethash.NewForTesting()
-	return pow
+	return pow.NewTestEthash()
 }
 
 func theBlockChain(db ethdb.Database, t *testing.T) *BlockChain {
@@ -1295,3 +1294,234 @@ func TestEIP161AccountRemoval(t *testing.T) {
 		t.Error("account should not expect")
 	}
 }
+
+// newHistoryTestChain creates a chain of the given length with a single transaction
+// in every block, along with a fresh blockchain (containing only the genesis) to
+// import it into, for testing history pruning and transaction indexing.
+func newHistoryTestChain(n int) (ethdb.Database, *BlockChain, types.Blocks) {
+	var (
+		gendb, _ = ethdb.NewMemDatabase()
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		funds    = big.NewInt(1000000000)
+		genesis  = GenesisBlockForTesting(gendb, address, funds)
+		signer   = types.NewEIP155Signer(big.NewInt(1))
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, gendb, n, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	db, _ := ethdb.NewMemDatabase()
+	WriteGenesisBlockForTesting(db, GenesisAccount{address, funds})
+
+	blockchain, _ := NewBlockChain(db, testChainConfig(), FakePow{}, new(event.TypeMux), vm.Config{})
+
+	return db, blockchain, blocks
+}
+
+// Tests that the history pruner deletes the bodies, receipts and transactions of
+// old canonical blocks, while retaining the headers, the positional lookups of
+// the transactions if the entire chain is indexed, and everything within the
+// configured depth.
+func TestBlockHistoryPruning(t *testing.T) {
+	db, blockchain, blocks := newHistoryTestChain(64)
+	defer blockchain.Stop()
+
+	if n, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	// Circumvent the minimum depth enforcement to keep the test fast
+	blockchain.pruneDepth = 16
+	blockchain.prune()
+
+	if tail := blockchain.PruneTail(); tail != 48 {
+		t.Fatalf("prune tail mismatch: have %d, want %d", tail, 48)
+	}
+	if blockchain.GetBlockByNumber(0) == nil {
+		t.Errorf("genesis block pruned")
+	}
+	for _, block := range blocks {
+		num, hash, txhash := block.NumberU64(), block.Hash(), block.Transactions()[0].Hash()
+
+		if blockchain.GetHeaderByHash(hash) == nil {
+			t.Errorf("block #%d: header missing", num)
+		}
+		pruned := num < 48
+		if have := blockchain.IsPruned(num); have != pruned {
+			t.Errorf("block #%d: pruned mismatch: have %v, want %v", num, have, pruned)
+		}
+		if body := blockchain.GetBody(hash); (body == nil) != pruned {
+			t.Errorf("block #%d: body existence mismatch: have %v, want %v", num, body != nil, !pruned)
+		}
+		if receipts := GetBlockReceipts(db, hash, num); (receipts == nil) != pruned {
+			t.Errorf("block #%d: receipts existence mismatch: have %v, want %v", num, receipts != nil, !pruned)
+		}
+		if tx, _, _, _ := GetTransaction(db, txhash); (tx == nil) != pruned {
+			t.Errorf("block #%d: transaction existence mismatch: have %v, want %v", num, tx != nil, !pruned)
+		}
+		if receipt := GetReceipt(db, txhash); (receipt == nil) != pruned {
+			t.Errorf("block #%d: receipt existence mismatch: have %v, want %v", num, receipt != nil, !pruned)
+		}
+		if data, _ := db.Get(append(txhash.Bytes(), txMetaSuffix...)); len(data) == 0 {
+			t.Errorf("block #%d: transaction lookup missing", num)
+		}
+	}
+}
+
+// Tests that the transaction lookup index is shrunk and extended in the background
+// as the configured lookup limit changes, and that it can be dropped altogether.
+func TestTxLookupLimit(t *testing.T) {
+	db, blockchain, blocks := newHistoryTestChain(64)
+	defer blockchain.Stop()
+
+	// Limit the index during import, old blocks should be unindexed in the background
+	blockchain.SetTxLookupLimit(32, true)
+	if n, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	check := func(tail uint64) {
+		for _, block := range blocks {
+			num, txhash := block.NumberU64(), block.Transactions()[0].Hash()
+
+			indexed := num >= tail
+			if tx, _, _, _ := GetTransaction(db, txhash); (tx == nil) == indexed {
+				t.Errorf("tail %d, block #%d: transaction existence mismatch: have %v, want %v", tail, num, tx != nil, indexed)
+			}
+			if receipt := GetReceipt(db, txhash); (receipt == nil) == indexed {
+				t.Errorf("tail %d, block #%d: receipt existence mismatch: have %v, want %v", tail, num, receipt != nil, indexed)
+			}
+		}
+	}
+	blockchain.indexTransactions()
+	if tail := blockchain.TxIndexTail(); tail != 33 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, 33)
+	}
+	check(33)
+
+	// Shrink the window and ensure the surplus is unindexed
+	blockchain.SetTxLookupLimit(16, true)
+	blockchain.indexTransactions()
+	if tail := blockchain.TxIndexTail(); tail != 49 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, 49)
+	}
+	check(49)
+
+	// Lift the limit and ensure everything is reindexed
+	blockchain.SetTxLookupLimit(0, true)
+	blockchain.indexTransactions()
+	if tail := blockchain.TxIndexTail(); tail != 0 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, 0)
+	}
+	check(0)
+
+	// Disable the index altogether and ensure everything is dropped
+	blockchain.SetTxLookupLimit(0, false)
+	blockchain.indexTransactions()
+	if tail := blockchain.TxIndexTail(); tail <= blockchain.CurrentBlock().NumberU64() {
+		t.Fatalf("index tail mismatch: have %d, want beyond head", tail)
+	}
+	check(65)
+}
+
+// Tests that blocks imported while the transaction index is disabled are left
+// unindexed without creating gaps above the index tail, and get indexed once
+// the index is turned back on.
+func TestTxLookupDisabledImport(t *testing.T) {
+	db, blockchain, blocks := newHistoryTestChain(64)
+	defer blockchain.Stop()
+
+	// A fresh database should start out with an empty index
+	blockchain.SetTxLookupLimit(0, false)
+	if tail := blockchain.TxIndexTail(); tail != math.MaxUint64 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, uint64(math.MaxUint64))
+	}
+	if n, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	for _, block := range blocks {
+		if tx, _, _, _ := GetTransaction(db, block.Transactions()[0].Hash()); tx != nil {
+			t.Errorf("block #%d: transaction indexed while disabled", block.NumberU64())
+		}
+	}
+	// Enable a limited index and ensure the window gets indexed
+	blockchain.SetTxLookupLimit(16, true)
+	blockchain.indexTransactions()
+	if tail := blockchain.TxIndexTail(); tail != 49 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, 49)
+	}
+	for _, block := range blocks {
+		num, txhash := block.NumberU64(), block.Transactions()[0].Hash()
+		if tx, _, _, _ := GetTransaction(db, txhash); (tx != nil) != (num >= 49) {
+			t.Errorf("block #%d: transaction existence mismatch: have %v, want %v", num, tx != nil, num >= 49)
+		}
+	}
+}
+
+// Tests that reindexing transactions stops at blocks whose bodies are missing,
+// instead of spinning on them, and resumes once they become available.
+func TestTxLookupMissingBody(t *testing.T) {
+	db, blockchain, blocks := newHistoryTestChain(64)
+	defer blockchain.Stop()
+
+	blockchain.SetTxLookupLimit(16, true)
+	if n, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	blockchain.indexTransactions()
+
+	// Drop the body of a block below the index tail and lift the limit
+	missing := blocks[39]
+	body := GetBodyRLP(db, missing.Hash(), missing.NumberU64())
+	DeleteBody(db, missing.Hash(), missing.NumberU64())
+
+	blockchain.SetTxLookupLimit(0, true)
+	done := make(chan struct{})
+	go func() {
+		blockchain.indexTransactions()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("transaction indexing stuck on missing body")
+	}
+	if tail := blockchain.TxIndexTail(); tail != missing.NumberU64()+1 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, missing.NumberU64()+1)
+	}
+	// Restore the body and ensure indexing continues to the genesis
+	WriteBodyRLP(db, missing.Hash(), missing.NumberU64(), body)
+	blockchain.indexTransactions()
+	if tail := blockchain.TxIndexTail(); tail != 0 {
+		t.Fatalf("index tail mismatch: have %d, want %d", tail, 0)
+	}
+}
+
+// Tests that only blocks failing consensus validation are stored as bad blocks,
+// not ones rejected for missing their ancestors.
+func TestBadBlockReporting(t *testing.T) {
+	_, blockchain, blocks := newHistoryTestChain(4)
+	defer blockchain.Stop()
+
+	// Import a chain segment with an unknown parent, which isn't bad
+	if _, err := blockchain.InsertChain(blocks[2:]); !IsParentErr(err) {
+		t.Fatalf("error mismatch: have %v, want parent error", err)
+	}
+	if bad := blockchain.BadBlocks(); len(bad) != 0 {
+		t.Fatalf("block with unknown parent stored as bad: %d blocks", len(bad))
+	}
+	// Import a block with an invalid state root, which is bad
+	header := blocks[0].Header()
+	header.Root = common.Hash{0x01}
+	block := types.NewBlockWithHeader(header).WithBody(blocks[0].Transactions(), nil)
+
+	if _, err := blockchain.InsertChain(types.Blocks{block}); err == nil {
+		t.Fatalf("block with invalid state root imported")
+	}
+	bad := blockchain.BadBlocks()
+	if len(bad) != 1 || bad[0].Block.Hash() != block.Hash() {
+		t.Fatalf("bad block mismatch: have %v, want [%x]", bad, block.Hash())
+	}
+}
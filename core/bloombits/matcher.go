@@ -0,0 +1,153 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// bloomIndexes represents the bit indexes inside the bloom filter that belong
+// to some key.
+type bloomIndexes [3]uint
+
+// calcBloomIndexes returns the bloom filter bit indexes belonging to the given key.
+func calcBloomIndexes(b []byte) bloomIndexes {
+	b = crypto.Keccak256(b)
+
+	var idxs bloomIndexes
+	for i := 0; i < len(idxs); i++ {
+		idxs[i] = (uint(b[2*i])<<8)&2047 + uint(b[2*i+1])
+	}
+	return idxs
+}
+
+// Retriever is a callback to fetch the decompressed bit vectors of the given
+// bloom bits in a section. The returned vectors must be in the order of the
+// requested bits.
+type Retriever func(bits []uint, section uint64) ([][]byte, error)
+
+// Matcher is a pipeline of bloom bit vector operations, testing entire sections
+// of the chain for potential log matches.
+type Matcher struct {
+	sectionSize uint64           // Number of blocks in a single section
+	filters     [][]bloomIndexes // Filter groups the matcher is looking for
+	bits        []uint           // Distinct bloom bits needed to evaluate the filters
+}
+
+// NewMatcher creates a new matcher for sections of the given size. The filters
+// are groups of alternative keys (e.g. addresses or topics at one position): a
+// group matches if any of its keys does, and a block matches if all the groups
+// do. Groups containing an empty key are wildcards and are skipped.
+func NewMatcher(sectionSize uint64, filters [][][]byte) *Matcher {
+	m := &Matcher{sectionSize: sectionSize}
+
+	seen := make(map[uint]bool)
+	for _, filter := range filters {
+		if len(filter) == 0 {
+			continue
+		}
+		var (
+			group    []bloomIndexes
+			wildcard bool
+		)
+		for _, key := range filter {
+			if len(key) == 0 {
+				wildcard = true
+				break
+			}
+			group = append(group, calcBloomIndexes(key))
+		}
+		if wildcard {
+			continue
+		}
+		for _, idxs := range group {
+			for _, bit := range idxs {
+				if !seen[bit] {
+					seen[bit] = true
+					m.bits = append(m.bits, bit)
+				}
+			}
+		}
+		m.filters = append(m.filters, group)
+	}
+	sort.Sort(uintSlice(m.bits))
+	return m
+}
+
+// Match tests the given section of the chain against the filters, retrieving the
+// required bit vectors through the provided callback. It returns the numbers of
+// the blocks that may contain matching logs, which need to be verified against
+// the actual log entries as the blooms may report false positives.
+func (m *Matcher) Match(section uint64, retrieve Retriever) ([]uint64, error) {
+	// Retrieve all the bit vectors needed for the section
+	vectors := make(map[uint][]byte)
+	if len(m.bits) > 0 {
+		data, err := retrieve(m.bits, section)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != len(m.bits) {
+			return nil, fmt.Errorf("bloom bit vector count mismatch: have %d, want %d", len(data), len(m.bits))
+		}
+		for i, bit := range m.bits {
+			if uint64(len(data[i])) != m.sectionSize/8 {
+				return nil, fmt.Errorf("bloom bit %d vector length mismatch: have %d, want %d", bit, len(data[i]), m.sectionSize/8)
+			}
+			vectors[bit] = data[i]
+		}
+	}
+	// Every group must match, any key within a group may
+	result := make([]byte, m.sectionSize/8)
+	for i := range result {
+		result[i] = 0xff
+	}
+	for _, group := range m.filters {
+		matches := make([]byte, len(result))
+		for _, idxs := range group {
+			for i := range matches {
+				matches[i] |= vectors[idxs[0]][i] & vectors[idxs[1]][i] & vectors[idxs[2]][i]
+			}
+		}
+		for i := range result {
+			result[i] &= matches[i]
+		}
+	}
+	// Convert the matching bits into block numbers
+	var blocks []uint64
+	for i, b := range result {
+		if b == 0 {
+			continue
+		}
+		for bit := uint(0); bit < 8; bit++ {
+			if b&(0x80>>bit) != 0 {
+				blocks = append(blocks, section*m.sectionSize+uint64(i)*8+uint64(bit))
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// uintSlice attaches the methods of sort.Interface to []uint, sorting in
+// increasing order.
+type uintSlice []uint
+
+func (s uintSlice) Len() int           { return len(s) }
+func (s uintSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uintSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,121 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const testSectionSize = 64
+
+// Tests that the bloom bit indexes calculated by the matcher are the same ones
+// set in the header blooms.
+func TestBloomIndexes(t *testing.T) {
+	key := []byte("the quick brown fox")
+
+	var bloom types.Bloom
+	bloom.Add(new(big.Int).SetBytes(key))
+
+	idxs := calcBloomIndexes(key)
+	for _, idx := range idxs {
+		if bloom[types.BloomByteLength-1-idx/8]&(1<<(idx%8)) == 0 {
+			t.Errorf("bloom bit %d not set", idx)
+		}
+	}
+}
+
+// Tests that the matcher finds the blocks whose blooms contain the filtered
+// addresses and topics.
+func TestMatcher(t *testing.T) {
+	var (
+		addr1 = common.HexToAddress("0x01")
+		addr2 = common.HexToAddress("0x02")
+		topic = common.HexToHash("0x03")
+	)
+	// Create two sections of blooms with a few interesting blocks
+	logs := map[uint64][]*types.Log{
+		3:   {{Address: addr1}},
+		17:  {{Address: addr2, Topics: []common.Hash{topic}}},
+		70:  {{Address: addr1, Topics: []common.Hash{topic}}},
+		127: {{Address: addr2}},
+	}
+	vectors := make(map[uint64]*Generator)
+	for section := uint64(0); section < 2; section++ {
+		gen, _ := NewGenerator(testSectionSize)
+		for i := uint64(0); i < testSectionSize; i++ {
+			bloom := types.BytesToBloom(types.LogsBloom(logs[section*testSectionSize+i]).Bytes())
+			if err := gen.AddBloom(uint(i), bloom); err != nil {
+				t.Fatalf("section %d, bloom %d: failed to add: %v", section, i, err)
+			}
+		}
+		vectors[section] = gen
+	}
+	retrieve := func(bits []uint, section uint64) ([][]byte, error) {
+		res := make([][]byte, len(bits))
+		for i, bit := range bits {
+			res[i], _ = vectors[section].Bitset(bit)
+		}
+		return res, nil
+	}
+
+	tests := []struct {
+		filters [][][]byte
+		want    []uint64
+	}{
+		// Single address and alternative addresses
+		{[][][]byte{{addr1.Bytes()}}, []uint64{3, 70}},
+		{[][][]byte{{addr1.Bytes(), addr2.Bytes()}}, []uint64{3, 17, 70, 127}},
+
+		// Address and topic must both match
+		{[][][]byte{{addr1.Bytes()}, {topic.Bytes()}}, []uint64{70}},
+		{[][][]byte{{addr2.Bytes()}, {topic.Bytes()}}, []uint64{17}},
+
+		// Wildcard groups match everything
+		{[][][]byte{{addr2.Bytes()}, {nil}}, []uint64{17, 127}},
+
+		// Unknown keys don't match anything
+		{[][][]byte{{common.HexToAddress("0x04").Bytes()}}, nil},
+	}
+	for i, tt := range tests {
+		matcher := NewMatcher(testSectionSize, tt.filters)
+
+		var have []uint64
+		for section := uint64(0); section < 2; section++ {
+			blocks, err := matcher.Match(section, retrieve)
+			if err != nil {
+				t.Fatalf("test %d, section %d: failed to match: %v", i, section, err)
+			}
+			have = append(have, blocks...)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: matching blocks mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+	// An empty filter should match every block of the section
+	blocks, err := NewMatcher(testSectionSize, nil).Match(1, retrieve)
+	if err != nil {
+		t.Fatalf("failed to match empty filter: %v", err)
+	}
+	if len(blocks) != testSectionSize || blocks[0] != testSectionSize {
+		t.Errorf("empty filter mismatch: have %v", blocks)
+	}
+}
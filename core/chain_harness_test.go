@@ -0,0 +1,64 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/internal/chaintest"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// harnessChain adapts the full block chain to the fork choice test harness.
+type harnessChain struct {
+	*core.BlockChain
+	db ethdb.Database
+}
+
+func (c *harnessChain) InsertBlocks(blocks []*types.Block) error {
+	_, err := c.InsertChain(blocks)
+	return err
+}
+
+func (c *harnessChain) CurrentHash() common.Hash {
+	return c.CurrentBlock().Hash()
+}
+
+func (c *harnessChain) GetReceipts(block *types.Block) types.Receipts {
+	return core.GetBlockReceipts(c.db, block.Hash(), block.NumberU64())
+}
+
+func (c *harnessChain) GetReceipt(hash common.Hash) *types.Receipt {
+	return core.GetReceipt(c.db, hash)
+}
+
+// Tests the fork choice of the full block chain against the declarative scenarios.
+func TestBlockChainHarness(t *testing.T) {
+	chaintest.Run(t, func(db ethdb.Database, mux *event.TypeMux, tree *core.BlockTree) (chaintest.Chain, error) {
+		blockchain, err := core.NewBlockChain(db, params.TestChainConfig, core.FakePow{}, mux, vm.Config{})
+		if err != nil {
+			return nil, err
+		}
+		return &harnessChain{BlockChain: blockchain, db: db}, nil
+	}, true)
+}
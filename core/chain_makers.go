@@ -245,6 +245,112 @@ func makeHeader(config *params.ChainConfig, parent *types.Block, state *state.St
 	}
 }
 
+// BlockSpec describes a single block of a block tree to generate.
+type BlockSpec struct {
+	Name   string          // Unique label of the block, also used to derive its coinbase
+	Parent string          // Label of the parent block, empty for the genesis
+	Time   uint64          // Timestamp of the block (0 = 10 seconds after the parent)
+	Uncles []string        // Labels of previously generated blocks to include as uncles
+	Gen    func(*BlockGen) // Optional generator to add transactions to the block
+}
+
+// BlockTree is a set of generated blocks forming a tree rooted at a genesis,
+// indexed by their labels.
+type BlockTree struct {
+	Genesis  *types.Block
+	Blocks   map[string]*types.Block
+	Receipts map[string]types.Receipts
+}
+
+// GenerateBlockTree creates a tree of blocks according to the given specs. The
+// specs must be ordered so that every parent and uncle precedes the blocks that
+// reference it. db is used to store the intermediate states and should contain
+// the genesis state trie.
+//
+// As with GenerateChain, the blocks do not contain valid proof of work values.
+func GenerateBlockTree(config *params.ChainConfig, genesis *types.Block, db ethdb.Database, specs []BlockSpec) *BlockTree {
+	tree := &BlockTree{
+		Genesis:  genesis,
+		Blocks:   make(map[string]*types.Block),
+		Receipts: make(map[string]types.Receipts),
+	}
+	for _, spec := range specs {
+		if _, ok := tree.Blocks[spec.Name]; ok || spec.Name == "" {
+			panic(fmt.Sprintf("invalid or duplicate block label %q", spec.Name))
+		}
+		parent := genesis
+		if spec.Parent != "" {
+			if parent = tree.Blocks[spec.Parent]; parent == nil {
+				panic(fmt.Sprintf("unknown parent %q of block %q", spec.Parent, spec.Name))
+			}
+		}
+		blocks, receipts := GenerateChain(config, parent, db, 1, func(i int, b *BlockGen) {
+			b.SetCoinbase(common.BytesToAddress([]byte(spec.Name)))
+			if spec.Time != 0 {
+				b.OffsetTime(int64(spec.Time) - b.header.Time.Int64())
+			}
+			for _, name := range spec.Uncles {
+				uncle := tree.Blocks[name]
+				if uncle == nil {
+					panic(fmt.Sprintf("unknown uncle %q of block %q", name, spec.Name))
+				}
+				b.AddUncle(uncle.Header())
+			}
+			if spec.Gen != nil {
+				spec.Gen(b)
+			}
+		})
+		tree.Blocks[spec.Name], tree.Receipts[spec.Name] = blocks[0], receipts[0]
+	}
+	return tree
+}
+
+// Select returns the blocks with the given labels, in the requested order.
+func (t *BlockTree) Select(names ...string) []*types.Block {
+	blocks := make([]*types.Block, len(names))
+	for i, name := range names {
+		if blocks[i] = t.Blocks[name]; blocks[i] == nil {
+			panic(fmt.Sprintf("unknown block %q", name))
+		}
+	}
+	return blocks
+}
+
+// Chain returns the blocks leading from the genesis (exclusive) up to and
+// including the block with the given label.
+func (t *BlockTree) Chain(name string) []*types.Block {
+	var chain []*types.Block
+	for block := t.Select(name)[0]; block.Hash() != t.Genesis.Hash(); {
+		chain = append([]*types.Block{block}, chain...)
+		if block = t.parent(block); block == nil {
+			panic(fmt.Sprintf("block %q not rooted at genesis", name))
+		}
+	}
+	return chain
+}
+
+// TD returns the total difficulty of the block with the given label.
+func (t *BlockTree) TD(name string) *big.Int {
+	td := new(big.Int).Set(t.Genesis.Difficulty())
+	for _, block := range t.Chain(name) {
+		td.Add(td, block.Difficulty())
+	}
+	return td
+}
+
+// parent retrieves the parent of a block within the tree.
+func (t *BlockTree) parent(block *types.Block) *types.Block {
+	if block.ParentHash() == t.Genesis.Hash() {
+		return t.Genesis
+	}
+	for _, candidate := range t.Blocks {
+		if candidate.Hash() == block.ParentHash() {
+			return candidate
+		}
+	}
+	return nil
+}
+
 // newCanonical creates a chain database, and injects a deterministic canonical
 // chain. Depending on the full flag, if creates either a full block chain or a
 // header only chain.
@@ -23,7 +23,6 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -39,6 +38,10 @@ var (
 	headHeaderKey = []byte("LastHeader")
 	headBlockKey  = []byte("LastBlock")
 	headFastKey   = []byte("LastFast")
+	pruneTailKey  = []byte("PruneTail")
+	badBlockKey   = []byte("InvalidBlocks")
+	txIndexKey    = []byte("TxIndexTail")
+	fastSyncKey   = []byte("FastSyncProgress")
 
 	headerPrefix        = []byte("h")   // headerPrefix + num (uint64 big endian) + hash -> header
 	tdSuffix            = []byte("t")   // headerPrefix + num (uint64 big endian) + hash + tdSuffix -> td
@@ -51,8 +54,11 @@ var (
 	txMetaSuffix   = []byte{0x01}
 	receiptsPrefix = []byte("receipts-")
 
-	mipmapPre    = []byte("mipmap-log-bloom-")
-	MIPMapLevels = []uint64{1000000, 500000, 100000, 50000, 1000}
+	badBlockPrefix = []byte("InvalidBlock-") // badBlockPrefix + hash -> rejected block and reason
+
+	bloomBitsPrefix        = []byte("B")       // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
+	bloomSectionsKey       = []byte("iBcount") // number of sections in the bloom bit index
+	bloomSectionHeadPrefix = []byte("iBshead") // bloomSectionHeadPrefix + section (uint64 big endian) -> section head hash
 
 	configPrefix = []byte("ethereum-config-") // config prefix for the db
 
@@ -67,8 +73,6 @@ var (
 
 	ChainConfigNotFoundErr = errors.New("ChainConfig not found") // general config not found error
 
-	mipmapBloomMu sync.Mutex // protect against race condition when updating mipmap blooms
-
 	preimageCounter    = metrics.NewCounter("db/preimage/total")
 	preimageHitCounter = metrics.NewCounter("db/preimage/hits")
 )
@@ -148,6 +152,28 @@ func GetHeadFastBlockHash(db ethdb.Database) common.Hash {
 	return common.BytesToHash(data)
 }
 
+// GetPruneTail retrieves the number of the oldest canonical block whose body and
+// receipts have not been pruned yet, or zero if nothing was pruned.
+func GetPruneTail(db ethdb.Database) uint64 {
+	data, _ := db.Get(pruneTailKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// GetTxIndexTail retrieves the number of the oldest canonical block whose
+// transactions are indexed for hash based lookups. A nil result means that the
+// index tail isn't tracked, and the entire chain is indexed.
+func GetTxIndexTail(db ethdb.Database) *uint64 {
+	data, _ := db.Get(txIndexKey)
+	if len(data) != 8 {
+		return nil
+	}
+	tail := binary.BigEndian.Uint64(data)
+	return &tail
+}
+
 // GetHeaderRLP retrieves a block header in its raw RLP database encoding, or nil
 // if the header's not found.
 func GetHeaderRLP(db ethdb.Database, hash common.Hash, number uint64) rlp.RawValue {
@@ -332,6 +358,24 @@ func WriteHeadFastBlockHash(db ethdb.Database, hash common.Hash) error {
 	return nil
 }
 
+// WritePruneTail stores the number of the oldest canonical block whose body and
+// receipts have not been pruned yet.
+func WritePruneTail(db ethdb.Putter, number uint64) error {
+	if err := db.Put(pruneTailKey, encodeBlockNumber(number)); err != nil {
+		glog.Fatalf("failed to store prune tail into database: %v", err)
+	}
+	return nil
+}
+
+// WriteTxIndexTail stores the number of the oldest canonical block whose
+// transactions are indexed for hash based lookups.
+func WriteTxIndexTail(db ethdb.Putter, number uint64) error {
+	if err := db.Put(txIndexKey, encodeBlockNumber(number)); err != nil {
+		glog.Fatalf("failed to store transaction index tail into database: %v", err)
+	}
+	return nil
+}
+
 // WriteHeader serializes a block header into the database.
 func WriteHeader(db ethdb.Database, header *types.Header) error {
 	data, err := rlp.EncodeToBytes(header)
@@ -507,7 +551,7 @@ func DeleteHeader(db ethdb.Database, hash common.Hash, number uint64) {
 }
 
 // DeleteBody removes all block body data associated with a hash.
-func DeleteBody(db ethdb.Database, hash common.Hash, number uint64) {
+func DeleteBody(db ethdb.Deleter, hash common.Hash, number uint64) {
 	db.Delete(append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
 }
 
@@ -525,18 +569,24 @@ func DeleteBlock(db ethdb.Database, hash common.Hash, number uint64) {
 }
 
 // DeleteBlockReceipts removes all receipt data associated with a block hash.
-func DeleteBlockReceipts(db ethdb.Database, hash common.Hash, number uint64) {
+func DeleteBlockReceipts(db ethdb.Deleter, hash common.Hash, number uint64) {
 	db.Delete(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
 }
 
 // DeleteTransaction removes all transaction data associated with a hash.
-func DeleteTransaction(db ethdb.Database, hash common.Hash) {
+func DeleteTransaction(db ethdb.Deleter, hash common.Hash) {
 	db.Delete(hash.Bytes())
 	db.Delete(append(hash.Bytes(), txMetaSuffix...))
 }
 
+// DeleteTransactionData removes a transaction itself, but keeps its positional
+// metadata, so that the block it was included in can still be looked up.
+func DeleteTransactionData(db ethdb.Deleter, hash common.Hash) {
+	db.Delete(hash.Bytes())
+}
+
 // DeleteReceipt removes all receipt data associated with a transaction hash.
-func DeleteReceipt(db ethdb.Database, hash common.Hash) {
+func DeleteReceipt(db ethdb.Deleter, hash common.Hash) {
 	db.Delete(append(receiptsPrefix, hash.Bytes()...))
 }
 
@@ -558,46 +608,197 @@ func GetBlockByHashOld(db ethdb.Database, hash common.Hash) *types.Block {
 	return (*types.Block)(&block)
 }
 
-// returns a formatted MIP mapped key by adding prefix, canonical number and level
-//
-// ex. fn(98, 1000) = (prefix || 1000 || 0)
-func mipmapKey(num, level uint64) []byte {
-	lkey := make([]byte, 8)
-	binary.BigEndian.PutUint64(lkey, level)
-	key := new(big.Int).SetUint64(num / level * level)
+// bloomBitsKey returns the database key of a bloom bit vector of a section.
+func bloomBitsKey(bit uint, section uint64, head common.Hash) []byte {
+	key := make([]byte, 0, len(bloomBitsPrefix)+2+8+common.HashLength)
+	key = append(key, bloomBitsPrefix...)
+	key = append(key, byte(bit>>8), byte(bit))
+	key = append(key, encodeBlockNumber(section)...)
+	return append(key, head.Bytes()...)
+}
+
+// GetBloomBits retrieves the compressed bit vector of a bloom bit belonging to
+// the section with the given head hash. As all-zero vectors compress to empty
+// data, an error is returned if the vector is not found.
+func GetBloomBits(db ethdb.Database, bit uint, section uint64, head common.Hash) ([]byte, error) {
+	return db.Get(bloomBitsKey(bit, section, head))
+}
+
+// WriteBloomBits stores the compressed bit vector of a bloom bit belonging to
+// the section with the given head hash.
+func WriteBloomBits(db ethdb.Putter, bit uint, section uint64, head common.Hash, bits []byte) error {
+	if err := db.Put(bloomBitsKey(bit, section, head), bits); err != nil {
+		glog.Fatalf("failed to store bloom bits into database: %v", err)
+	}
+	return nil
+}
+
+// GetBloomSections retrieves the number of sections in the bloom bit index,
+// counted from the genesis block.
+func GetBloomSections(db ethdb.Database) uint64 {
+	data, _ := db.Get(bloomSectionsKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WriteBloomSections stores the number of sections in the bloom bit index.
+func WriteBloomSections(db ethdb.Putter, sections uint64) error {
+	if err := db.Put(bloomSectionsKey, encodeBlockNumber(sections)); err != nil {
+		glog.Fatalf("failed to store bloom section count into database: %v", err)
+	}
+	return nil
+}
+
+// GetBloomSectionHead retrieves the hash of the last block of an indexed bloom
+// bit section, which the section's bit vectors are keyed by.
+func GetBloomSectionHead(db ethdb.Database, section uint64) common.Hash {
+	data, _ := db.Get(append(bloomSectionHeadPrefix, encodeBlockNumber(section)...))
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WriteBloomSectionHead stores the hash of the last block of an indexed bloom
+// bit section.
+func WriteBloomSectionHead(db ethdb.Putter, section uint64, head common.Hash) error {
+	if err := db.Put(append(bloomSectionHeadPrefix, encodeBlockNumber(section)...), head.Bytes()); err != nil {
+		glog.Fatalf("failed to store bloom section head into database: %v", err)
+	}
+	return nil
+}
+
+// maxBadBlocks is the maximum number of rejected blocks retained in the database.
+const maxBadBlocks = 10
+
+// BadBlock is a block rejected during chain import, persisted together with the
+// error that caused its rejection for later inspection.
+type BadBlock struct {
+	Block  *types.Block
+	Reason string
+}
+
+// GetBadBlock retrieves a rejected block by its hash, or nil if it's not (or no
+// longer) tracked.
+func GetBadBlock(db ethdb.Database, hash common.Hash) *BadBlock {
+	data, _ := db.Get(append(badBlockPrefix, hash.Bytes()...))
+	if len(data) == 0 {
+		return nil
+	}
+	bad := new(BadBlock)
+	if err := rlp.DecodeBytes(data, bad); err != nil {
+		glog.V(logger.Error).Infof("invalid bad block RLP for hash %x: %v", hash, err)
+		return nil
+	}
+	return bad
+}
 
-	return append(mipmapPre, append(lkey, key.Bytes()...)...)
+// getBadBlockHashes retrieves the hashes of the tracked rejected blocks, newest
+// first.
+func getBadBlockHashes(db ethdb.Database) []common.Hash {
+	data, _ := db.Get(badBlockKey)
+	if len(data) == 0 {
+		return nil
+	}
+	var hashes []common.Hash
+	if err := rlp.DecodeBytes(data, &hashes); err != nil {
+		glog.V(logger.Error).Infof("invalid bad block hash list RLP: %v", err)
+		return nil
+	}
+	return hashes
 }
 
-// WriteMapmapBloom writes each address included in the receipts' logs to the
-// MIP bloom bin.
-func WriteMipmapBloom(db ethdb.Database, number uint64, receipts types.Receipts) error {
-	mipmapBloomMu.Lock()
-	defer mipmapBloomMu.Unlock()
+// GetBadBlocks retrieves the most recently rejected blocks, newest first.
+func GetBadBlocks(db ethdb.Database) []*BadBlock {
+	var blocks []*BadBlock
+	for _, hash := range getBadBlockHashes(db) {
+		if bad := GetBadBlock(db, hash); bad != nil {
+			blocks = append(blocks, bad)
+		}
+	}
+	return blocks
+}
 
+// WriteBadBlock stores a rejected block along with the reason of its rejection,
+// discarding the oldest entries if more than maxBadBlocks are tracked. Blocks are
+// stored individually, keyed by their hash, so only the short list of tracked
+// hashes needs to be rewritten. Already tracked blocks are skipped.
+func WriteBadBlock(db ethdb.Database, block *types.Block, reason error) error {
+	hash := block.Hash()
+	if data, _ := db.Get(append(badBlockPrefix, hash.Bytes()...)); len(data) > 0 {
+		return nil
+	}
+	data, err := rlp.EncodeToBytes(&BadBlock{Block: block, Reason: reason.Error()})
+	if err != nil {
+		return err
+	}
 	batch := db.NewBatch()
-	for _, level := range MIPMapLevels {
-		key := mipmapKey(number, level)
-		bloomDat, _ := db.Get(key)
-		bloom := types.BytesToBloom(bloomDat)
-		for _, receipt := range receipts {
-			for _, log := range receipt.Logs {
-				bloom.Add(log.Address.Big())
+	if err := batch.Put(append(badBlockPrefix, hash.Bytes()...), data); err != nil {
+		return err
+	}
+	hashes := append([]common.Hash{hash}, getBadBlockHashes(db)...)
+	if len(hashes) > maxBadBlocks {
+		for _, evicted := range hashes[maxBadBlocks:] {
+			if err := batch.Delete(append(badBlockPrefix, evicted.Bytes()...)); err != nil {
+				return err
 			}
 		}
-		batch.Put(key, bloom.Bytes())
+		hashes = hashes[:maxBadBlocks]
+	}
+	list, err := rlp.EncodeToBytes(hashes)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(badBlockKey, list); err != nil {
+		return err
 	}
 	if err := batch.Write(); err != nil {
-		return fmt.Errorf("mipmap write fail for: %d: %v", number, err)
+		glog.Fatalf("failed to store bad block into database: %v", err)
+	}
+	return nil
+}
+
+// FastSyncProgress is the checkpointed progress of an interrupted fast sync,
+// allowing a restarted node to resume the state download instead of starting
+// it over.
+type FastSyncProgress struct {
+	Pivot uint64      // Number of the pivot block the state was being synced for
+	Root  common.Hash // State root being synced when the checkpoint was made
+	Nodes [][]byte    // Retrieved state entries not yet committed to the database
+}
+
+// GetFastSyncProgress retrieves the checkpointed progress of an interrupted fast
+// sync, or nil if there is none.
+func GetFastSyncProgress(db ethdb.Database) *FastSyncProgress {
+	data, _ := db.Get(fastSyncKey)
+	if len(data) == 0 {
+		return nil
+	}
+	progress := new(FastSyncProgress)
+	if err := rlp.DecodeBytes(data, progress); err != nil {
+		glog.V(logger.Error).Infof("invalid fast sync progress RLP: %v", err)
+		return nil
+	}
+	return progress
+}
+
+// WriteFastSyncProgress checkpoints the progress of a running fast sync.
+func WriteFastSyncProgress(db ethdb.Database, progress *FastSyncProgress) error {
+	data, err := rlp.EncodeToBytes(progress)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(fastSyncKey, data); err != nil {
+		glog.Fatalf("failed to store fast sync progress into database: %v", err)
 	}
 	return nil
 }
 
-// GetMipmapBloom returns a bloom filter using the number and level as input
-// parameters. For available levels see MIPMapLevels.
-func GetMipmapBloom(db ethdb.Database, number, level uint64) types.Bloom {
-	bloomDat, _ := db.Get(mipmapKey(number, level))
-	return types.BytesToBloom(bloomDat)
+// DeleteFastSyncProgress removes the fast sync checkpoint once the sync is done.
+func DeleteFastSyncProgress(db ethdb.Database) {
+	db.Delete(fastSyncKey)
 }
 
 // PreimageTable returns a Database instance with the key prefix for preimage entries.
@@ -19,14 +19,13 @@ package core
 import (
 	"bytes"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"math/big"
 	"os"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/sha3"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
@@ -500,106 +499,87 @@ func TestBlockReceiptStorage(t *testing.T) {
 	}
 }
 
-func TestMipmapBloom(t *testing.T) {
+// Tests that rejected blocks are persisted newest first, deduplicated and capped.
+func TestBadBlockStorage(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 
-	receipt1 := new(types.Receipt)
-	receipt1.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test"))},
-		{Address: common.BytesToAddress([]byte("address"))},
+	if blocks := GetBadBlocks(db); len(blocks) != 0 {
+		t.Fatalf("Non existent bad blocks returned: %v", blocks)
+	}
+	// Write more bad blocks than retained, some of them multiple times
+	var hashes []common.Hash
+	for i := 0; i < maxBadBlocks+2; i++ {
+		block := types.NewBlockWithHeader(&types.Header{
+			Number:      big.NewInt(int64(i)),
+			Extra:       []byte("bad block"),
+			UncleHash:   types.EmptyUncleHash,
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+		})
+		for j := 0; j < 2; j++ {
+			if err := WriteBadBlock(db, block, fmt.Errorf("invalid block %d", i)); err != nil {
+				t.Fatalf("Failed to write bad block %d: %v", i, err)
+			}
+		}
+		hashes = append(hashes, block.Hash())
 	}
-	receipt2 := new(types.Receipt)
-	receipt2.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test"))},
-		{Address: common.BytesToAddress([]byte("address1"))},
+	blocks := GetBadBlocks(db)
+	if len(blocks) != maxBadBlocks {
+		t.Fatalf("Bad block count mismatch: have %d, want %d", len(blocks), maxBadBlocks)
 	}
-
-	WriteMipmapBloom(db, 1, types.Receipts{receipt1})
-	WriteMipmapBloom(db, 2, types.Receipts{receipt2})
-
-	for _, level := range MIPMapLevels {
-		bloom := GetMipmapBloom(db, 2, level)
-		if !bloom.Test(new(big.Int).SetBytes([]byte("address1"))) {
-			t.Error("expected test to be included on level:", level)
+	for i, bad := range blocks {
+		index := len(hashes) - 1 - i
+		if hash := bad.Block.Hash(); hash != hashes[index] {
+			t.Errorf("Bad block %d: hash mismatch: have %x, want %x", i, hash, hashes[index])
+		}
+		if want := fmt.Sprintf("invalid block %d", index); bad.Reason != want {
+			t.Errorf("Bad block %d: reason mismatch: have %q, want %q", i, bad.Reason, want)
 		}
 	}
-
-	// reset
-	db, _ = ethdb.NewMemDatabase()
-	receipt := new(types.Receipt)
-	receipt.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test"))},
+	// Ensure evicted blocks are deleted, and retained ones retrievable by hash
+	for i, hash := range hashes {
+		if bad := GetBadBlock(db, hash); (bad != nil) != (i >= len(hashes)-maxBadBlocks) {
+			t.Errorf("Bad block %d: existence mismatch: have %v, want %v", i, bad != nil, i >= len(hashes)-maxBadBlocks)
+		}
 	}
-	WriteMipmapBloom(db, 999, types.Receipts{receipt1})
+}
 
-	receipt = new(types.Receipt)
-	receipt.Logs = []*types.Log{
-		{Address: common.BytesToAddress([]byte("test 1"))},
-	}
-	WriteMipmapBloom(db, 1000, types.Receipts{receipt})
+// Tests that bloom bit vectors and the bloom index metadata can be stored and
+// retrieved.
+func TestBloomBitsStorage(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
 
-	bloom := GetMipmapBloom(db, 1000, 1000)
-	if bloom.TestBytes([]byte("test")) {
-		t.Error("test should not have been included")
+	head := common.BytesToHash([]byte{1, 2, 3})
+	if bits, err := GetBloomBits(db, 1, 2, head); err == nil {
+		t.Fatalf("non existent bloom bits returned: %x", bits)
 	}
-}
-
-func TestMipmapChain(t *testing.T) {
-	dir, err := ioutil.TempDir("", "mipmap")
-	if err != nil {
-		t.Fatal(err)
+	if sections := GetBloomSections(db); sections != 0 {
+		t.Fatalf("non existent bloom section count returned: %d", sections)
 	}
-	defer os.RemoveAll(dir)
-
-	var (
-		db, _   = ethdb.NewLDBDatabase(dir, 0, 0)
-		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		addr    = crypto.PubkeyToAddress(key1.PublicKey)
-		addr2   = common.BytesToAddress([]byte("jeff"))
-
-		hash1 = common.BytesToHash([]byte("topic1"))
-	)
-	defer db.Close()
-
-	genesis := WriteGenesisBlockForTesting(db, GenesisAccount{addr, big.NewInt(1000000)})
-	chain, receipts := GenerateChain(params.TestChainConfig, genesis, db, 1010, func(i int, gen *BlockGen) {
-		var receipts types.Receipts
-		switch i {
-		case 1:
-			receipt := types.NewReceipt(nil, new(big.Int))
-			receipt.Logs = []*types.Log{{Address: addr, Topics: []common.Hash{hash1}}}
-			gen.AddUncheckedReceipt(receipt)
-			receipts = types.Receipts{receipt}
-		case 1000:
-			receipt := types.NewReceipt(nil, new(big.Int))
-			receipt.Logs = []*types.Log{{Address: addr2}}
-			gen.AddUncheckedReceipt(receipt)
-			receipts = types.Receipts{receipt}
-
-		}
-
-		// store the receipts
-		err := WriteReceipts(db, receipts)
-		if err != nil {
-			t.Fatal(err)
-		}
-		WriteMipmapBloom(db, uint64(i+1), receipts)
-	})
-	for i, block := range chain {
-		WriteBlock(db, block)
-		if err := WriteCanonicalHash(db, block.Hash(), block.NumberU64()); err != nil {
-			t.Fatalf("failed to insert block number: %v", err)
-		}
-		if err := WriteHeadBlockHash(db, block.Hash()); err != nil {
-			t.Fatalf("failed to insert block number: %v", err)
-		}
-		if err := WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[i]); err != nil {
-			t.Fatal("error writing block receipts:", err)
-		}
+	// Write some bloom bits and check that they're keyed by bit, section and head
+	WriteBloomBits(db, 1, 2, head, []byte{0xde, 0xad})
+	if bits, err := GetBloomBits(db, 1, 2, head); err != nil || !bytes.Equal(bits, []byte{0xde, 0xad}) {
+		t.Fatalf("bloom bits mismatch: have %x/%v, want %x", bits, err, []byte{0xde, 0xad})
+	}
+	if bits, err := GetBloomBits(db, 2, 1, head); err == nil {
+		t.Fatalf("bloom bits of other bit and section returned: %x", bits)
 	}
+	if bits, err := GetBloomBits(db, 1, 2, common.Hash{}); err == nil {
+		t.Fatalf("bloom bits of other section head returned: %x", bits)
+	}
+	// Empty vectors should be stored too
+	WriteBloomBits(db, 3, 2, head, nil)
+	if bits, err := GetBloomBits(db, 3, 2, head); err != nil || len(bits) != 0 {
+		t.Fatalf("empty bloom bits mismatch: have %x/%v, want empty", bits, err)
+	}
+	// Write the index metadata and check that it's retrievable
+	WriteBloomSectionHead(db, 2, head)
+	WriteBloomSections(db, 3)
 
-	bloom := GetMipmapBloom(db, 0, 1000)
-	if bloom.TestBytes(addr2[:]) {
-		t.Error("address was included in bloom and should not have")
+	if have := GetBloomSectionHead(db, 2); have != head {
+		t.Fatalf("bloom section head mismatch: have %x, want %x", have, head)
+	}
+	if sections := GetBloomSections(db); sections != 3 {
+		t.Fatalf("bloom section count mismatch: have %d, want %d", sections, 3)
 	}
 }
@@ -26,6 +26,15 @@ import (
 // TxPreEvent is posted when a transaction enters the transaction pool.
 type TxPreEvent struct{ Tx *types.Transaction }
 
+// TxPoolEvent is posted when a transaction enters, moves within or leaves the
+// transaction pool.
+type TxPoolEvent struct {
+	Tx          *types.Transaction
+	Kind        TxEventKind
+	Reason      TxDropReason // Reason of the removal for dropped transactions
+	Replacement common.Hash  // Hash of the superseding transaction for replaced ones
+}
+
 // TxPostEvent is posted when a transaction has been processed.
 type TxPostEvent struct{ Tx *types.Transaction }
 
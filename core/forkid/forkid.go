@@ -0,0 +1,189 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package forkid implements a compact identifier of the fork rules a node is
+// running with, allowing peers on incompatible chains to be detected during
+// the protocol handshake.
+package forkid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	// ErrRemoteStale is returned by the validator if a remote fork checksum is
+	// a subset of our already applied forks, but the announced next fork block
+	// is not on our already passed chain.
+	ErrRemoteStale = errors.New("remote needs update")
+
+	// ErrLocalIncompatibleOrStale is returned by the validator if a remote fork
+	// checksum does not match any local checksum variation, signalling that the
+	// two chains have diverged in the past at some point (possibly at genesis).
+	ErrLocalIncompatibleOrStale = errors.New("local incompatible or needs update")
+)
+
+// ID is a fork identifier: a CRC32 checksum of the genesis hash and all the fork
+// blocks already passed, along with the number of the next upcoming fork (or 0
+// if no fork is scheduled).
+type ID struct {
+	Hash [4]byte // CRC32 checksum of the genesis block and passed fork block numbers
+	Next uint64  // Block number of the next upcoming fork, or 0 if no forks are known
+}
+
+// String implements the Stringer interface.
+func (id ID) String() string {
+	return fmt.Sprintf("%x/%d", id.Hash, id.Next)
+}
+
+// Filter is a fork identifier validator, deciding whether a remote fork ID is
+// compatible with the local chain.
+type Filter func(id ID) error
+
+// NewID calculates the fork ID of a chain with the given configuration and
+// genesis hash at the given head block number.
+func NewID(config *params.ChainConfig, genesis common.Hash, head uint64) ID {
+	hash := crc32.ChecksumIEEE(genesis[:])
+
+	for _, fork := range gatherForks(config) {
+		if fork > head {
+			return ID{Hash: checksumToBytes(hash), Next: fork}
+		}
+		hash = checksumUpdate(hash, fork)
+	}
+	return ID{Hash: checksumToBytes(hash), Next: 0}
+}
+
+// NewFilter creates a fork ID validator for a chain with the given configuration
+// and genesis hash. The head callback is used to retrieve the current local head
+// block number at the time of each validation.
+//
+// The rules of the validation are:
+//  1. If the remote checksum matches the local one at the current head, the two
+//     nodes are on the same fork. The remote is only rejected if it announces
+//     a fork that we've already passed without applying it.
+//  2. If the remote checksum is a subset of our passed forks, the remote is
+//     accepted only if its next fork is the one we've applied after it.
+//  3. If the remote checksum is a superset of our passed forks, the remote is
+//     accepted (we're simply not synced up to it yet).
+//  4. Any other case means the chains diverged and the remote is rejected.
+func NewFilter(config *params.ChainConfig, genesis common.Hash, head func() uint64) Filter {
+	// Calculate all the valid fork hash and fork next combos
+	forks := gatherForks(config)
+	sums := make([][4]byte, len(forks)+1) // 0th is the genesis
+
+	hash := crc32.ChecksumIEEE(genesis[:])
+	sums[0] = checksumToBytes(hash)
+	for i, fork := range forks {
+		hash = checksumUpdate(hash, fork)
+		sums[i+1] = checksumToBytes(hash)
+	}
+	// Add a sentinel fork so the loop below always finds an unpassed fork
+	forks = append(forks, math.MaxUint64)
+
+	return func(id ID) error {
+		number := head()
+		for i, fork := range forks {
+			// Skip over all the forks we've already passed
+			if number >= fork {
+				continue
+			}
+			// Found the first unpassed fork, sums[i] is our current checksum
+			if sums[i] == id.Hash {
+				// Same fork state, reject only if the remote's next fork is
+				// already behind us (we didn't apply it, or they're stale)
+				if id.Next > 0 && number >= id.Next {
+					return ErrLocalIncompatibleOrStale
+				}
+				return nil
+			}
+			// The remote is on a subset of our forks, its next fork must be ours
+			for j := 0; j < i; j++ {
+				if sums[j] == id.Hash {
+					if forks[j] != id.Next {
+						return ErrRemoteStale
+					}
+					return nil
+				}
+			}
+			// The remote is on a superset of our forks, we're just not synced yet
+			for j := i + 1; j < len(sums); j++ {
+				if sums[j] == id.Hash {
+					return nil
+				}
+			}
+			return ErrLocalIncompatibleOrStale
+		}
+		return ErrLocalIncompatibleOrStale // unreachable, the sentinel is never passed
+	}
+}
+
+// gatherForks collects all the block numbers at which the chain rules change,
+// in increasing order, without duplicates and without genesis (0) forks.
+func gatherForks(config *params.ChainConfig) []uint64 {
+	blocks := []*big.Int{config.HomesteadBlock, config.EIP150Block, config.EIP155Block, config.EIP158Block}
+
+	// The DAO fork block only changes the rules if it's supported, which makes
+	// it the point where the two sides of the fork become distinguishable.
+	if config.DAOForkSupport {
+		blocks = append(blocks, config.DAOForkBlock)
+	}
+	var forks []uint64
+	for _, block := range blocks {
+		if block != nil && block.Sign() > 0 {
+			forks = append(forks, block.Uint64())
+		}
+	}
+	sort.Sort(uint64Slice(forks))
+
+	// Deduplicate block numbers applying multiple forks
+	for i := 1; i < len(forks); i++ {
+		if forks[i] == forks[i-1] {
+			forks = append(forks[:i], forks[i+1:]...)
+			i--
+		}
+	}
+	return forks
+}
+
+// checksumUpdate extends a fork checksum with an additional fork block number.
+func checksumUpdate(hash uint32, fork uint64) uint32 {
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	return crc32.Update(hash, crc32.IEEETable, blob[:])
+}
+
+// checksumToBytes converts a uint32 checksum into a [4]byte array.
+func checksumToBytes(hash uint32) [4]byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], hash)
+	return blob
+}
+
+// uint64Slice attaches the methods of sort.Interface to []uint64.
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
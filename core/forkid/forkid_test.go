@@ -0,0 +1,108 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that fork IDs are correctly calculated at different fork points.
+func TestCreation(t *testing.T) {
+	noDAOConfig := *params.MainnetChainConfig
+	noDAOConfig.DAOForkSupport = false
+
+	tests := []struct {
+		config *params.ChainConfig
+		head   uint64
+		want   ID
+	}{
+		// Mainnet on the DAO hard-fork side
+		{params.MainnetChainConfig, 0, ID{Hash: [4]byte{0xfc, 0x64, 0xec, 0x04}, Next: 1150000}},       // Unsynced
+		{params.MainnetChainConfig, 1149999, ID{Hash: [4]byte{0xfc, 0x64, 0xec, 0x04}, Next: 1150000}}, // Last Frontier block
+		{params.MainnetChainConfig, 1150000, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 1920000}}, // First Homestead block
+		{params.MainnetChainConfig, 1919999, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 1920000}}, // Last Homestead block
+		{params.MainnetChainConfig, 1920000, ID{Hash: [4]byte{0x91, 0xd1, 0xf9, 0x48}, Next: 2463000}}, // First DAO block
+		{params.MainnetChainConfig, 2462999, ID{Hash: [4]byte{0x91, 0xd1, 0xf9, 0x48}, Next: 2463000}}, // Last DAO block
+		{params.MainnetChainConfig, 2463000, ID{Hash: [4]byte{0x7a, 0x64, 0xda, 0x13}, Next: 2675000}}, // First Tangerine block
+		{params.MainnetChainConfig, 2674999, ID{Hash: [4]byte{0x7a, 0x64, 0xda, 0x13}, Next: 2675000}}, // Last Tangerine block
+		{params.MainnetChainConfig, 2675000, ID{Hash: [4]byte{0x3e, 0xdd, 0x5b, 0x10}, Next: 0}},       // First Spurious block
+		{params.MainnetChainConfig, 4000000, ID{Hash: [4]byte{0x3e, 0xdd, 0x5b, 0x10}, Next: 0}},       // Future Spurious block
+
+		// Mainnet on the side opposing the DAO hard-fork
+		{&noDAOConfig, 1919999, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 2463000}}, // Last Homestead block
+		{&noDAOConfig, 1920000, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 2463000}}, // Non-forked DAO block
+	}
+	for i, tt := range tests {
+		if have := NewID(tt.config, params.MainNetGenesisHash, tt.head); have != tt.want {
+			t.Errorf("test %d: fork ID mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that remote fork IDs are correctly validated against the local chain.
+func TestValidation(t *testing.T) {
+	noDAOConfig := *params.MainnetChainConfig
+	noDAOConfig.DAOForkSupport = false
+
+	tests := []struct {
+		config *params.ChainConfig
+		head   uint64
+		id     ID
+		err    error
+	}{
+		// Local is mainnet Tangerine, remote announces the same. No future fork is announced.
+		{params.MainnetChainConfig, 2500000, ID{Hash: [4]byte{0x7a, 0x64, 0xda, 0x13}, Next: 0}, nil},
+
+		// Local is mainnet Tangerine, remote announces the same, along with Spurious as the next fork.
+		{params.MainnetChainConfig, 2500000, ID{Hash: [4]byte{0x7a, 0x64, 0xda, 0x13}, Next: 2675000}, nil},
+
+		// Local is mainnet Tangerine, remote announces the same, but with a future fork we don't know of.
+		{params.MainnetChainConfig, 2500000, ID{Hash: [4]byte{0x7a, 0x64, 0xda, 0x13}, Next: 5000000}, nil},
+
+		// Local is mainnet Homestead, remote is already on Spurious. We're just not synced yet.
+		{params.MainnetChainConfig, 1500000, ID{Hash: [4]byte{0x3e, 0xdd, 0x5b, 0x10}, Next: 0}, nil},
+
+		// Local is mainnet Spurious, remote is still on Homestead announcing the DAO fork. Remote is syncing.
+		{params.MainnetChainConfig, 3000000, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 1920000}, nil},
+
+		// Local is mainnet Spurious, remote is on Homestead announcing Tangerine next. Remote skipped
+		// the DAO fork, so it's on the other side of the split.
+		{params.MainnetChainConfig, 3000000, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 2463000}, ErrRemoteStale},
+
+		// Local is mainnet Spurious, remote is on Homestead with no next fork. Remote is stale.
+		{params.MainnetChainConfig, 3000000, ID{Hash: [4]byte{0x97, 0xc2, 0xc3, 0x4c}, Next: 0}, ErrRemoteStale},
+
+		// Local is mainnet Tangerine, remote announces the same, but with Spurious at a block we've
+		// already passed. One of us is on a different chain or needs updating.
+		{params.MainnetChainConfig, 2600000, ID{Hash: [4]byte{0x7a, 0x64, 0xda, 0x13}, Next: 2550000}, ErrLocalIncompatibleOrStale},
+
+		// Local is mainnet on the non-DAO side past Tangerine, remote is on the DAO side.
+		{&noDAOConfig, 3000000, ID{Hash: [4]byte{0x3e, 0xdd, 0x5b, 0x10}, Next: 0}, ErrLocalIncompatibleOrStale},
+
+		// Local is mainnet, remote is on a completely different genesis.
+		{params.MainnetChainConfig, 3000000, ID{Hash: [4]byte{0xaf, 0xec, 0x6b, 0x27}, Next: 0}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		head := tt.head
+		filter := NewFilter(tt.config, params.MainNetGenesisHash, func() uint64 { return head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package core
 import (
 	"compress/bzip2"
 	"compress/gzip"
+	"crypto/ecdsa"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -30,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
@@ -203,12 +205,60 @@ func DefaultTestnetGenesisBlock() string {
 	return string(blob)
 }
 
-// DevGenesisBlock assembles a JSON string representing a local dev genesis block.
+var (
+	// DevAccountKey is the well known private key of the developer account that
+	// is prefunded in the dev genesis block. Never use it on a public network!
+	DevAccountKey = crypto.ToECDSA(crypto.Keccak256([]byte("go-ethereum developer account")))
+
+	// DevAccount is the address of the prefunded developer account.
+	DevAccount = crypto.PubkeyToAddress(DevAccountKey.PublicKey)
+
+	// devAccountBalance is the initial balance of the developer account (2^200 wei).
+	devAccountBalance = new(big.Int).Lsh(big.NewInt(1), 200)
+)
+
+// DevGenesisBlock assembles a JSON string representing a local dev genesis block,
+// prefunding DevAccount in addition to the accounts of the embedded devnet.
 func DevGenesisBlock() string {
 	reader := bzip2.NewReader(base64.NewDecoder(base64.StdEncoding, strings.NewReader(defaultDevnetGenesisBlock)))
 	blob, err := ioutil.ReadAll(reader)
 	if err != nil {
 		panic(fmt.Sprintf("failed to load dev genesis: %v", err))
 	}
-	return string(blob)
+	return string(prefundGenesis(blob, DevAccountKey, devAccountBalance))
+}
+
+// IsDevGenesis reports whether hash is the hash of the local dev genesis block.
+func IsDevGenesis(hash common.Hash) bool {
+	db, _ := ethdb.NewMemDatabase()
+	genesis, err := WriteGenesisBlock(db, strings.NewReader(DevGenesisBlock()))
+	if err != nil {
+		panic(fmt.Sprintf("failed to write dev genesis: %v", err))
+	}
+	return genesis.Hash() == hash
+}
+
+// prefundGenesis injects the account belonging to key with the given balance
+// into the alloc section of a JSON genesis specification.
+func prefundGenesis(blob []byte, key *ecdsa.PrivateKey, balance *big.Int) []byte {
+	var genesis map[string]interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(string(blob)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&genesis); err != nil {
+		panic(fmt.Sprintf("failed to parse genesis: %v", err))
+	}
+	alloc, _ := genesis["alloc"].(map[string]interface{})
+	if alloc == nil {
+		alloc = make(map[string]interface{})
+		genesis["alloc"] = alloc
+	}
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	alloc[common.Bytes2Hex(addr[:])] = map[string]string{"balance": balance.String()}
+
+	blob, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("failed to encode genesis: %v", err))
+	}
+	return blob
 }
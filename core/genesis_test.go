@@ -0,0 +1,42 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that the dev genesis block prefunds the developer account.
+func TestDevGenesisBlock(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+
+	genesis, err := WriteGenesisBlock(db, strings.NewReader(DevGenesisBlock()))
+	if err != nil {
+		t.Fatalf("failed to write dev genesis: %v", err)
+	}
+	statedb, err := state.New(genesis.Root(), db)
+	if err != nil {
+		t.Fatalf("failed to open genesis state: %v", err)
+	}
+	if balance := statedb.GetBalance(DevAccount); balance.Cmp(devAccountBalance) != 0 {
+		t.Errorf("developer account balance mismatch: have %v, want %v", balance, devAccountBalance)
+	}
+}
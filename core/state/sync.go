@@ -69,3 +69,15 @@ func (s *StateSync) Process(list []trie.SyncResult, dbw trie.DatabaseWriter) (bo
 func (s *StateSync) Pending() int {
 	return (*trie.TrieSync)(s).Pending()
 }
+
+// Uncommitted retrieves the state entries already retrieved, but not yet written
+// to the database due to missing children, up to limit entries if positive.
+func (s *StateSync) Uncommitted(limit int) [][]byte {
+	return (*trie.TrieSync)(s).Uncommitted(limit)
+}
+
+// Restore reinjects a batch of previously retrieved, but uncommitted state entries
+// into the sync, returning the number of entries needed and restored.
+func (s *StateSync) Restore(nodes [][]byte, dbw trie.DatabaseWriter) (int, error) {
+	return (*trie.TrieSync)(s).Restore(nodes, dbw)
+}
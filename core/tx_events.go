@@ -0,0 +1,128 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+const (
+	// txStatusCacheSize is the number of recently included, dropped or replaced
+	// transactions the pool remembers to answer status queries about.
+	txStatusCacheSize = 8192
+
+	// txEventQueueLimit is the maximum number of transaction pool events waiting
+	// for delivery. If subscribers fall further behind, the oldest are dropped.
+	txEventQueueLimit = 16384
+)
+
+// TxEventKind is the type of status change a transaction went through within
+// the transaction pool.
+type TxEventKind string
+
+const (
+	TxAdded    TxEventKind = "added"    // Transaction entered the pool
+	TxPromoted TxEventKind = "promoted" // Transaction became executable (pending)
+	TxReplaced TxEventKind = "replaced" // Transaction was superseded by a higher priced one
+	TxIncluded TxEventKind = "included" // Transaction was included in a canonical block
+	TxDropped  TxEventKind = "dropped"  // Transaction was removed from the pool
+)
+
+// TxDropReason describes why a transaction was dropped from the pool.
+type TxDropReason string
+
+const (
+	TxDropNonceTooLow TxDropReason = "nonce too low"      // Nonce already used by a transaction not seen included
+	TxDropUnderpriced TxDropReason = "underpriced"        // Cheaper than the pool or the transaction it tried to replace
+	TxDropUnpayable   TxDropReason = "insufficient funds" // Sender can no longer pay for the transaction
+	TxDropEvicted     TxDropReason = "evicted"            // Pushed out to honour the pool limits
+	TxDropLifetime    TxDropReason = "lifetime expired"   // Queued for too long without the account being active
+	TxDropRemoved     TxDropReason = "removed"            // Explicitly removed (e.g. rejected by the miner)
+)
+
+// TxStatus is the whereabouts of a transaction as known by the transaction pool.
+type TxStatus struct {
+	Status      string       // One of "pending", "queued", "included", "replaced", "dropped" or "unknown"
+	Reason      TxDropReason // Reason of the removal if the transaction was dropped
+	Replacement common.Hash  // Hash of the superseding transaction if replaced
+}
+
+// txEventFeed is a bounded, ordered queue of transaction pool events that are
+// posted to the event mux from a dedicated goroutine, ensuring that slow
+// subscribers never block the pool while notifications arrive in order. If the
+// subscribers fall too far behind, the oldest undelivered events are dropped.
+type txEventFeed struct {
+	mux     *event.TypeMux
+	queue   []TxPoolEvent
+	limit   int // Maximum number of undelivered events to keep
+	dropped int // Number of events dropped since the last delivery
+	lock    sync.Mutex
+	notify  chan struct{}
+}
+
+// newTxEventFeed creates an event feed posting onto the given mux.
+func newTxEventFeed(mux *event.TypeMux) *txEventFeed {
+	return &txEventFeed{
+		mux:    mux,
+		limit:  txEventQueueLimit,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// post schedules an event to be delivered to the mux, dropping the oldest one
+// if the queue is full.
+func (feed *txEventFeed) post(ev TxPoolEvent) {
+	feed.lock.Lock()
+	if len(feed.queue) >= feed.limit {
+		feed.queue = feed.queue[1:]
+		feed.dropped++
+	}
+	feed.queue = append(feed.queue, ev)
+	feed.lock.Unlock()
+
+	select {
+	case feed.notify <- struct{}{}:
+	default:
+	}
+}
+
+// loop delivers the scheduled events until quit is closed.
+func (feed *txEventFeed) loop(quit chan struct{}) {
+	for {
+		select {
+		case <-feed.notify:
+			feed.lock.Lock()
+			events, dropped := feed.queue, feed.dropped
+			feed.queue, feed.dropped = nil, 0
+			feed.lock.Unlock()
+
+			if dropped > 0 {
+				glog.V(logger.Warn).Infof("Transaction pool event subscribers too slow, dropped %d events", dropped)
+			}
+			for _, ev := range events {
+				feed.mux.Post(ev)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
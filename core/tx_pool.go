@@ -32,28 +32,26 @@ import (
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
-	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
+	"github.com/hashicorp/golang-lru"
 )
 
 var (
 	// Transaction Pool Errors
-	ErrInvalidSender     = errors.New("Invalid sender")
-	ErrNonce             = errors.New("Nonce too low")
-	ErrCheap             = errors.New("Gas price too low for acceptance")
-	ErrBalance           = errors.New("Insufficient balance")
-	ErrInsufficientFunds = errors.New("Insufficient funds for gas * price + value")
-	ErrIntrinsicGas      = errors.New("Intrinsic gas too low")
-	ErrGasLimit          = errors.New("Exceeds block gas limit")
-	ErrNegativeValue     = errors.New("Negative value")
+	ErrInvalidSender      = errors.New("Invalid sender")
+	ErrNonce              = errors.New("Nonce too low")
+	ErrCheap              = errors.New("Gas price too low for acceptance")
+	ErrBalance            = errors.New("Insufficient balance")
+	ErrInsufficientFunds  = errors.New("Insufficient funds for gas * price + value")
+	ErrIntrinsicGas       = errors.New("Intrinsic gas too low")
+	ErrGasLimit           = errors.New("Exceeds block gas limit")
+	ErrNegativeValue      = errors.New("Negative value")
+	ErrUnderpriced        = errors.New("Transaction underpriced")
+	ErrReplaceUnderpriced = errors.New("Replacement transaction underpriced")
 )
 
 var (
-	minPendingPerAccount = uint64(16)    // Min number of guaranteed transaction slots per address
-	maxPendingTotal      = uint64(4096)  // Max limit of pending transactions from all accounts (soft)
-	maxQueuedPerAccount  = uint64(64)    // Max limit of queued transactions per address
-	maxQueuedInTotal     = uint64(1024)  // Max limit of queued transactions from all accounts
-	maxQueuedLifetime    = 3 * time.Hour // Max amount of time transactions from idle accounts are queued
-	evictionInterval     = time.Minute   // Time interval to check for evictable transactions
+	evictionInterval = time.Minute // Time interval to check for evictable transactions
+	minRejournal     = time.Second // Minimum time interval to regenerate the local transaction journal
 )
 
 var (
@@ -70,11 +68,78 @@ var (
 	queuedNofundsCounter = metrics.NewCounter("txpool/queued/nofunds")   // Dropped due to out-of-funds
 
 	// General tx metrics
-	invalidTxCounter = metrics.NewCounter("txpool/invalid")
+	invalidTxCounter     = metrics.NewCounter("txpool/invalid")
+	underpricedTxCounter = metrics.NewCounter("txpool/underpriced")
 )
 
 type stateFn func() (*state.StateDB, error)
 
+// TxPoolConfig are the configuration parameters of the transaction pool.
+type TxPoolConfig struct {
+	Journal   string        // Journal of local transactions to survive node restarts (empty = disabled)
+	Rejournal time.Duration // Time interval to regenerate the local transaction journal
+
+	PriceBump uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
+
+	AccountSlots uint64 // Minimum number of executable transaction slots guaranteed per account
+	GlobalSlots  uint64 // Maximum number of executable transaction slots for all accounts (soft)
+	AccountQueue uint64 // Maximum number of non-executable transaction slots permitted per account
+	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
+
+	Lifetime time.Duration // Maximum amount of time non-executable transactions of idle accounts are queued
+}
+
+// DefaultTxPoolConfig contains the default configurations for the transaction
+// pool.
+var DefaultTxPoolConfig = TxPoolConfig{
+	Journal:   "transactions.rlp",
+	Rejournal: time.Hour,
+
+	PriceBump: 10,
+
+	AccountSlots: 16,
+	GlobalSlots:  4096,
+	AccountQueue: 64,
+	GlobalQueue:  1024,
+
+	Lifetime: 3 * time.Hour,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *TxPoolConfig) sanitize() TxPoolConfig {
+	conf := *config
+	if conf.Rejournal < minRejournal {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool journal time: %v -> %v", conf.Rejournal, minRejournal)
+		conf.Rejournal = minRejournal
+	}
+	if conf.PriceBump < 1 {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool price bump: %d -> %d", conf.PriceBump, DefaultTxPoolConfig.PriceBump)
+		conf.PriceBump = DefaultTxPoolConfig.PriceBump
+	}
+	if conf.AccountSlots < 1 {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool account slots: %d -> %d", conf.AccountSlots, DefaultTxPoolConfig.AccountSlots)
+		conf.AccountSlots = DefaultTxPoolConfig.AccountSlots
+	}
+	if conf.GlobalSlots < 1 {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool global slots: %d -> %d", conf.GlobalSlots, DefaultTxPoolConfig.GlobalSlots)
+		conf.GlobalSlots = DefaultTxPoolConfig.GlobalSlots
+	}
+	if conf.AccountQueue < 1 {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool account queue: %d -> %d", conf.AccountQueue, DefaultTxPoolConfig.AccountQueue)
+		conf.AccountQueue = DefaultTxPoolConfig.AccountQueue
+	}
+	if conf.GlobalQueue < 1 {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool global queue: %d -> %d", conf.GlobalQueue, DefaultTxPoolConfig.GlobalQueue)
+		conf.GlobalQueue = DefaultTxPoolConfig.GlobalQueue
+	}
+	if conf.Lifetime < 1 {
+		glog.V(logger.Warn).Infof("sanitizing invalid txpool lifetime: %v -> %v", conf.Lifetime, DefaultTxPoolConfig.Lifetime)
+		conf.Lifetime = DefaultTxPoolConfig.Lifetime
+	}
+	return conf
+}
+
 // TxPool contains all currently known transactions. Transactions
 // enter the pool when they are received from the network or submitted
 // locally. They exit the pool when they are included in the blockchain.
@@ -83,7 +148,8 @@ type stateFn func() (*state.StateDB, error)
 // current state) and future transactions. Transactions move between those
 // two states over time as they are received and processed.
 type TxPool struct {
-	config       *params.ChainConfig
+	config       TxPoolConfig
+	chainconfig  *params.ChainConfig
 	currentState stateFn // The state function which will allow us to do some pre checks
 	pendingState *state.ManagedState
 	gasLimit     func() *big.Int // The current gas limit function callback
@@ -94,10 +160,18 @@ type TxPool struct {
 	signer       types.Signer
 	mu           sync.RWMutex
 
+	journal   *txJournal               // Journal of local transaction to back up to disk
+	journaled map[common.Hash]struct{} // Local transactions written into the journal
+
 	pending map[common.Address]*txList         // All currently processable transactions
 	queue   map[common.Address]*txList         // Queued but non-processable transactions
 	all     map[common.Hash]*types.Transaction // All transactions to allow lookups
 	beats   map[common.Address]time.Time       // Last heartbeat from each known account
+	priced  *txPricedList                      // All transactions sorted by price
+
+	feed     *txEventFeed             // Ordered feed of transaction status changes
+	dropped  *lru.Cache               // Recently included, dropped or replaced transactions for status queries
+	included map[common.Hash]struct{} // Transactions included in canonical blocks since the last reset
 
 	wg   sync.WaitGroup // for shutdown sync
 	quit chan struct{}
@@ -105,10 +179,17 @@ type TxPool struct {
 	homestead bool
 }
 
-func NewTxPool(config *params.ChainConfig, eventMux *event.TypeMux, currentStateFn stateFn, gasLimitFn func() *big.Int) *TxPool {
+// NewTxPool creates a new transaction pool to gather, sort and filter inbound
+// transactions from the network.
+func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, eventMux *event.TypeMux, currentStateFn stateFn, gasLimitFn func() *big.Int) *TxPool {
+	// Sanitize the input to ensure no vulnerable gas prices are set
+	config = (&config).sanitize()
+
+	// Create the transaction pool with its initial settings
 	pool := &TxPool{
 		config:       config,
-		signer:       types.NewEIP155Signer(config.ChainId),
+		chainconfig:  chainconfig,
+		signer:       types.NewEIP155Signer(chainconfig.ChainId),
 		pending:      make(map[common.Address]*txList),
 		queue:        make(map[common.Address]*txList),
 		all:          make(map[common.Hash]*types.Transaction),
@@ -119,15 +200,39 @@ func NewTxPool(config *params.ChainConfig, eventMux *event.TypeMux, currentState
 		minGasPrice:  new(big.Int),
 		pendingState: nil,
 		localTx:      newTxSet(),
-		events:       eventMux.Subscribe(ChainHeadEvent{}, GasPriceChanged{}, RemovedTransactionEvent{}),
+		journaled:    make(map[common.Hash]struct{}),
+		included:     make(map[common.Hash]struct{}),
+		events:       eventMux.Subscribe(ChainHeadEvent{}, ChainEvent{}, GasPriceChanged{}, RemovedTransactionEvent{}),
 		quit:         make(chan struct{}),
 	}
-
+	pool.priced = newTxPricedList(&pool.all)
+	pool.feed = newTxEventFeed(eventMux)
+	pool.dropped, _ = lru.New(txStatusCacheSize)
 	pool.resetState()
 
-	pool.wg.Add(2)
+	// If journaling is enabled, load from disk and start the rotation loop
+	if config.Journal != "" {
+		pool.journal = newTxJournal(config.Journal)
+
+		if err := pool.journal.load(pool.addLocal); err != nil {
+			glog.V(logger.Warn).Infof("failed to load transaction journal: %v", err)
+		}
+		if state, err := pool.currentState(); err == nil {
+			pool.promoteExecutables(state)
+		}
+		if err := pool.journal.rotate(pool.journaledTxs()); err != nil {
+			glog.V(logger.Warn).Infof("failed to rotate transaction journal: %v", err)
+		}
+		pool.wg.Add(1)
+		go pool.journalLoop()
+	}
+	pool.wg.Add(3)
 	go pool.eventLoop()
 	go pool.expirationLoop()
+	go func() {
+		defer pool.wg.Done()
+		pool.feed.loop(pool.quit)
+	}()
 
 	return pool
 }
@@ -143,12 +248,18 @@ func (pool *TxPool) eventLoop() {
 		case ChainHeadEvent:
 			pool.mu.Lock()
 			if ev.Block != nil {
-				if pool.config.IsHomestead(ev.Block.Number()) {
+				if pool.chainconfig.IsHomestead(ev.Block.Number()) {
 					pool.homestead = true
 				}
+				pool.includeTxs(ev.Block.Transactions())
 			}
 
 			pool.resetState()
+			pool.included = make(map[common.Hash]struct{})
+			pool.mu.Unlock()
+		case ChainEvent:
+			pool.mu.Lock()
+			pool.includeTxs(ev.Block.Transactions())
 			pool.mu.Unlock()
 		case GasPriceChanged:
 			pool.mu.Lock()
@@ -193,6 +304,10 @@ func (pool *TxPool) Stop() {
 	pool.events.Unsubscribe()
 	close(pool.quit)
 	pool.wg.Wait()
+
+	if pool.journal != nil {
+		pool.journal.close()
+	}
 	glog.V(logger.Info).Infoln("Transaction pool stopped")
 }
 
@@ -218,6 +333,69 @@ func (pool *TxPool) Stats() (pending int, queued int) {
 	return
 }
 
+// Status returns the whereabouts of a transaction: whether it is pending or
+// queued in the pool, or whether it was recently included, dropped or replaced.
+func (pool *TxPool) Status(hash common.Hash) TxStatus {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if tx := pool.all[hash]; tx != nil {
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		if list := pool.pending[from]; list != nil {
+			if pending := list.txs.Get(tx.Nonce()); pending != nil && pending.Hash() == hash {
+				return TxStatus{Status: "pending"}
+			}
+		}
+		return TxStatus{Status: "queued"}
+	}
+	if status, ok := pool.dropped.Get(hash); ok {
+		return status.(TxStatus)
+	}
+	return TxStatus{Status: "unknown"}
+}
+
+// txDropped records the removal of a transaction for later status queries and
+// notifies any subscribers about it.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) txDropped(tx *types.Transaction, reason TxDropReason) {
+	pool.dropped.Add(tx.Hash(), TxStatus{Status: string(TxDropped), Reason: reason})
+	pool.feed.post(TxPoolEvent{Tx: tx, Kind: TxDropped, Reason: reason})
+}
+
+// includeTxs marks the transactions of a new canonical block as included, so the
+// next reset can report them as such instead of dropped for their used nonces.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) includeTxs(txs types.Transactions) {
+	for _, tx := range txs {
+		pool.included[tx.Hash()] = struct{}{}
+	}
+}
+
+// txForwarded records the removal of a transaction whose nonce was used up by
+// the chain, reporting it as included if it was seen in a canonical block, or
+// as dropped otherwise, and notifies any subscribers about it.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) txForwarded(tx *types.Transaction) {
+	if _, ok := pool.included[tx.Hash()]; !ok {
+		pool.txDropped(tx, TxDropNonceTooLow)
+		return
+	}
+	pool.dropped.Add(tx.Hash(), TxStatus{Status: string(TxIncluded)})
+	pool.feed.post(TxPoolEvent{Tx: tx, Kind: TxIncluded})
+}
+
+// txReplaced records the replacement of a transaction by a higher priced one
+// for later status queries and notifies any subscribers about it.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) txReplaced(old, tx *types.Transaction) {
+	pool.dropped.Add(old.Hash(), TxStatus{Status: string(TxReplaced), Replacement: tx.Hash()})
+	pool.feed.post(TxPoolEvent{Tx: old, Kind: TxReplaced, Replacement: tx.Hash()})
+}
+
 // Content retrieves the data content of the transaction pool, returning all the
 // pending as well as queued transactions, grouped by account and sorted by nonce.
 func (pool *TxPool) Content() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
@@ -268,6 +446,55 @@ func (pool *TxPool) SetLocal(tx *types.Transaction) {
 	pool.localTx.add(tx.Hash())
 }
 
+// addLocal marks a transaction as local and adds it to the pool. It is used to
+// reinject the transactions of the journal on startup.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) addLocal(tx *types.Transaction) error {
+	pool.localTx.add(tx.Hash())
+	return pool.add(tx)
+}
+
+// journalLoop periodically regenerates the local transaction journal, dropping
+// all transactions that left the pool in the meantime.
+func (pool *TxPool) journalLoop() {
+	defer pool.wg.Done()
+
+	journal := time.NewTicker(pool.config.Rejournal)
+	defer journal.Stop()
+
+	for {
+		select {
+		case <-journal.C:
+			pool.mu.Lock()
+			if err := pool.journal.rotate(pool.journaledTxs()); err != nil {
+				glog.V(logger.Warn).Infof("failed to rotate transaction journal: %v", err)
+			}
+			pool.mu.Unlock()
+
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// journaledTxs retrieves all the journaled local transactions still contained
+// in the pool, ordered by nonce. Transactions that left the pool are forgotten.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) journaledTxs() types.Transactions {
+	var txs types.Transactions
+	for hash := range pool.journaled {
+		if tx := pool.all[hash]; tx != nil {
+			txs = append(txs, tx)
+		} else {
+			delete(pool.journaled, hash)
+		}
+	}
+	sort.Sort(types.TxByNonce(txs))
+	return txs
+}
+
 // validateTx checks whether a transaction is valid according
 // to the consensus rules.
 func (pool *TxPool) validateTx(tx *types.Transaction) error {
@@ -331,8 +558,66 @@ func (pool *TxPool) add(tx *types.Transaction) error {
 		invalidTxCounter.Inc(1)
 		return err
 	}
-	pool.enqueueTx(hash, tx)
+	// If the transaction pool is full, discard underpriced transactions
+	if uint64(len(pool.all)) >= pool.config.GlobalSlots+pool.config.GlobalQueue {
+		// If the new transaction is underpriced, don't accept it
+		if pool.priced.Underpriced(tx, pool.localTx) {
+			if glog.V(logger.Debug) {
+				glog.Infof("Discarding underpriced transaction %x: price %v", hash[:4], tx.GasPrice())
+			}
+			underpricedTxCounter.Inc(1)
+			pool.txDropped(tx, TxDropUnderpriced)
+			return ErrUnderpriced
+		}
+		// New transaction is better than our worse ones, make room for it
+		drop := pool.priced.Discard(len(pool.all)-int(pool.config.GlobalSlots+pool.config.GlobalQueue-1), pool.localTx, nil)
+		for _, tx := range drop {
+			if glog.V(logger.Debug) {
+				glog.Infof("Discarding freshly underpriced transaction %x: price %v", tx.Hash().Bytes()[:4], tx.GasPrice())
+			}
+			underpricedTxCounter.Inc(1)
+			pool.removeTx(tx.Hash(), TxDropEvicted, true)
+		}
+	}
+	// If the transaction is replacing an already pending one, do directly
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	if list := pool.pending[from]; list != nil && list.Overlaps(tx) {
+		// Nonce already pending, check if required price bump is met
+		inserted, old := list.Add(tx, pool.config.PriceBump)
+		if !inserted {
+			pendingDiscardCounter.Inc(1)
+			pool.txDropped(tx, TxDropUnderpriced)
+			return ErrReplaceUnderpriced
+		}
+		// New transaction is better, replace old one
+		pool.feed.post(TxPoolEvent{Tx: tx, Kind: TxAdded})
+		if old != nil {
+			delete(pool.all, old.Hash())
+			pool.priced.Removed()
+			pool.txReplaced(old, tx)
+			pendingReplaceCounter.Inc(1)
+		}
+		pool.all[hash] = tx
+		pool.priced.Put(tx)
+		pool.beats[from] = time.Now()
+		pool.feed.post(TxPoolEvent{Tx: tx, Kind: TxPromoted})
+		go pool.eventMux.Post(TxPreEvent{tx})
+	} else {
+		// New transaction isn't replacing a pending one, push into queue
+		if err := pool.enqueueTx(hash, tx); err != nil {
+			pool.txDropped(tx, TxDropUnderpriced)
+			return err
+		}
+		pool.feed.post(TxPoolEvent{Tx: tx, Kind: TxAdded})
+	}
 
+	// Back up local transactions to disk if journaling is enabled
+	if pool.journal != nil && pool.localTx.contains(hash) {
+		pool.journaled[hash] = struct{}{}
+		if err := pool.journal.insert(tx); err != nil && err != errNoActiveJournal {
+			glog.V(logger.Warn).Infof("failed to journal local transaction %x: %v", hash, err)
+		}
+	}
 	// Print a log message if low enough level is set
 	if glog.V(logger.Debug) {
 		rcpt := "[NEW_CONTRACT]"
@@ -348,23 +633,30 @@ func (pool *TxPool) add(tx *types.Transaction) error {
 // enqueueTx inserts a new transaction into the non-executable transaction queue.
 //
 // Note, this method assumes the pool lock is held!
-func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) {
+func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) error {
 	// Try to insert the transaction into the future queue
 	from, _ := types.Sender(pool.signer, tx) // already validated
 	if pool.queue[from] == nil {
 		pool.queue[from] = newTxList(false)
 	}
-	inserted, old := pool.queue[from].Add(tx)
+	inserted, old := pool.queue[from].Add(tx, pool.config.PriceBump)
 	if !inserted {
+		// An older transaction was better, discard this
 		queuedDiscardCounter.Inc(1)
-		return // An older transaction was better, discard this
+		return ErrReplaceUnderpriced
 	}
 	// Discard any previous transaction and mark this
 	if old != nil {
 		delete(pool.all, old.Hash())
+		pool.priced.Removed()
+		pool.txReplaced(old, tx)
 		queuedReplaceCounter.Inc(1)
 	}
-	pool.all[hash] = tx
+	if pool.all[hash] == nil {
+		pool.all[hash] = tx
+		pool.priced.Put(tx)
+	}
+	return nil
 }
 
 // promoteTx adds a transaction to the pending (processable) list of transactions.
@@ -377,23 +669,32 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 	}
 	list := pool.pending[addr]
 
-	inserted, old := list.Add(tx)
+	inserted, old := list.Add(tx, pool.config.PriceBump)
 	if !inserted {
 		// An older transaction was better, discard this
 		delete(pool.all, hash)
+		pool.priced.Removed()
+		pool.txDropped(tx, TxDropUnderpriced)
 		pendingDiscardCounter.Inc(1)
 		return
 	}
 	// Otherwise discard any previous transaction and mark this
 	if old != nil {
 		delete(pool.all, old.Hash())
+		pool.priced.Removed()
+		pool.txReplaced(old, tx)
 		pendingReplaceCounter.Inc(1)
 	}
-	pool.all[hash] = tx // Failsafe to work around direct pending inserts (tests)
+	// Failsafe to work around direct pending inserts (tests)
+	if pool.all[hash] == nil {
+		pool.all[hash] = tx
+		pool.priced.Put(tx)
+	}
 
 	// Set the potentially new pending nonce and notify any subsystems of the new tx
 	pool.beats[addr] = time.Now()
 	pool.pendingState.SetNonce(addr, tx.Nonce()+1)
+	pool.feed.post(TxPoolEvent{Tx: tx, Kind: TxPromoted})
 	go pool.eventMux.Post(TxPreEvent{tx})
 }
 
@@ -451,7 +752,7 @@ func (pool *TxPool) Remove(hash common.Hash) {
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
-	pool.removeTx(hash)
+	pool.removeTx(hash, TxDropRemoved, false)
 }
 
 // RemoveBatch removes all given transactions from the pool.
@@ -460,13 +761,15 @@ func (pool *TxPool) RemoveBatch(txs types.Transactions) {
 	defer pool.mu.Unlock()
 
 	for _, tx := range txs {
-		pool.removeTx(tx.Hash())
+		pool.removeTx(tx.Hash(), TxDropRemoved, false)
 	}
 }
 
 // removeTx removes a single transaction from the queue, moving all subsequent
-// transactions back to the future queue.
-func (pool *TxPool) removeTx(hash common.Hash) {
+// transactions back to the future queue. The reason is reported to any status
+// subscribers. Transactions already discarded from the price heap are flagged,
+// so they aren't counted as stale price points.
+func (pool *TxPool) removeTx(hash common.Hash, reason TxDropReason, discarded bool) {
 	// Fetch the transaction we wish to delete
 	tx, ok := pool.all[hash]
 	if !ok {
@@ -476,6 +779,10 @@ func (pool *TxPool) removeTx(hash common.Hash) {
 
 	// Remove it from the list of known transactions
 	delete(pool.all, hash)
+	if !discarded {
+		pool.priced.Removed()
+	}
+	pool.txDropped(tx, reason)
 
 	// Remove the transaction from the pending lists and reset the account nonce
 	if pending := pool.pending[addr]; pending != nil {
@@ -510,7 +817,6 @@ func (pool *TxPool) removeTx(hash common.Hash) {
 // invalidated transactions (low nonce, low balance) are deleted.
 func (pool *TxPool) promoteExecutables(state *state.StateDB) {
 	// Iterate over all accounts and promote any executable transactions
-	queued := uint64(0)
 	for addr, list := range pool.queue {
 		// Drop all transactions that are deemed too old (low nonce)
 		for _, tx := range list.Forward(state.GetNonce(addr)) {
@@ -518,6 +824,8 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB) {
 				glog.Infof("Removed old queued transaction: %v", tx)
 			}
 			delete(pool.all, tx.Hash())
+			pool.priced.Removed()
+			pool.txForwarded(tx)
 		}
 		// Drop all transactions that are too costly (low balance)
 		drops, _ := list.Filter(state.GetBalance(addr))
@@ -526,6 +834,8 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB) {
 				glog.Infof("Removed unpayable queued transaction: %v", tx)
 			}
 			delete(pool.all, tx.Hash())
+			pool.priced.Removed()
+			pool.txDropped(tx, TxDropUnpayable)
 			queuedNofundsCounter.Inc(1)
 		}
 		// Gather all executable transactions and promote them
@@ -536,109 +846,100 @@ func (pool *TxPool) promoteExecutables(state *state.StateDB) {
 			pool.promoteTx(addr, tx.Hash(), tx)
 		}
 		// Drop all transactions over the allowed limit
-		for _, tx := range list.Cap(int(maxQueuedPerAccount)) {
+		for _, tx := range list.Cap(int(pool.config.AccountQueue)) {
 			if glog.V(logger.Core) {
 				glog.Infof("Removed cap-exceeding queued transaction: %v", tx)
 			}
 			delete(pool.all, tx.Hash())
+			pool.priced.Removed()
+			pool.txDropped(tx, TxDropEvicted)
 			queuedRLCounter.Inc(1)
 		}
-		queued += uint64(list.Len())
-
 		// Delete the entire queue entry if it became empty.
 		if list.Empty() {
 			delete(pool.queue, addr)
 		}
 	}
-	// If the pending limit is overflown, start equalizing allowances
+	// If the pending or queued limits are overflown, evict the cheapest remote
+	// transactions until back within bounds
+	pool.truncatePending()
+	pool.truncateQueue()
+}
+
+// truncatePending evicts the cheapest remote pending transactions if the pending
+// limit is overflown, skipping any accounts that are within their guaranteed
+// slot allowance. Evicted transactions gap the pending lists of their accounts,
+// so any subsequent ones are moved back into the future queue.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) truncatePending() {
 	pending := uint64(0)
 	for _, list := range pool.pending {
 		pending += uint64(list.Len())
 	}
-	if pending > maxPendingTotal {
-		pendingBeforeCap := pending
-		// Assemble a spam order to penalize large transactors first
-		spammers := prque.New()
-		for addr, list := range pool.pending {
-			// Only evict transactions from high rollers
-			if uint64(list.Len()) > minPendingPerAccount {
-				// Skip local accounts as pools should maintain backlogs for themselves
-				for _, tx := range list.txs.items {
-					if !pool.localTx.contains(tx.Hash()) {
-						spammers.Push(addr, float32(list.Len()))
-					}
-					break // Checking on transaction for locality is enough
-				}
+	pendingBeforeCap := pending
+	for pending > pool.config.GlobalSlots {
+		// Pick the cheapest pending transactions of accounts above their allowance
+		picked := make(map[common.Address]int)
+		drop := pool.priced.Discard(int(pending-pool.config.GlobalSlots), pool.localTx, func(tx *types.Transaction) bool {
+			addr, _ := types.Sender(pool.signer, tx) // already validated during insertion
+			list := pool.pending[addr]
+			if list == nil || uint64(list.Len()-picked[addr]) <= pool.config.AccountSlots {
+				return false
 			}
-		}
-		// Gradually drop transactions from offenders
-		offenders := []common.Address{}
-		for pending > maxPendingTotal && !spammers.Empty() {
-			// Retrieve the next offender if not local address
-			offender, _ := spammers.Pop()
-			offenders = append(offenders, offender.(common.Address))
-
-			// Equalize balances until all the same or below threshold
-			if len(offenders) > 1 {
-				// Calculate the equalization threshold for all current offenders
-				threshold := pool.pending[offender.(common.Address)].Len()
-
-				// Iteratively reduce all offenders until below limit or threshold reached
-				for pending > maxPendingTotal && pool.pending[offenders[len(offenders)-2]].Len() > threshold {
-					for i := 0; i < len(offenders)-1; i++ {
-						list := pool.pending[offenders[i]]
-						list.Cap(list.Len() - 1)
-						pending--
-					}
-				}
+			if cur := list.txs.Get(tx.Nonce()); cur == nil || cur.Hash() != tx.Hash() {
+				return false
 			}
+			picked[addr]++
+			return true
+		})
+		if len(drop) == 0 {
+			break
 		}
-		// If still above threshold, reduce to limit or min allowance
-		if pending > maxPendingTotal && len(offenders) > 0 {
-			for pending > maxPendingTotal && uint64(pool.pending[offenders[len(offenders)-1]].Len()) > minPendingPerAccount {
-				for _, addr := range offenders {
-					list := pool.pending[addr]
-					list.Cap(list.Len() - 1)
-					pending--
-				}
+		for _, tx := range drop {
+			if glog.V(logger.Core) {
+				glog.Infof("Evicting underpriced pending transaction: %v", tx)
 			}
+			pool.removeTx(tx.Hash(), TxDropEvicted, true)
 		}
+		pending = 0
+		for _, list := range pool.pending {
+			pending += uint64(list.Len())
+		}
+	}
+	if pendingBeforeCap > pending {
 		pendingRLCounter.Inc(int64(pendingBeforeCap - pending))
 	}
-	// If we've queued more transactions than the hard limit, drop oldest ones
-	if queued > maxQueuedInTotal {
-		// Sort all accounts with queued transactions by heartbeat
-		addresses := make(addresssByHeartbeat, 0, len(pool.queue))
-		for addr := range pool.queue {
-			addresses = append(addresses, addressByHeartbeat{addr, pool.beats[addr]})
-		}
-		sort.Sort(addresses)
-
-		// Drop transactions until the total is below the limit
-		for drop := queued - maxQueuedInTotal; drop > 0; {
-			addr := addresses[len(addresses)-1]
-			list := pool.queue[addr.address]
-
-			addresses = addresses[:len(addresses)-1]
+}
 
-			// Drop all transactions if they are less than the overflow
-			if size := uint64(list.Len()); size <= drop {
-				for _, tx := range list.Flatten() {
-					pool.removeTx(tx.Hash())
-				}
-				drop -= size
-				queuedRLCounter.Inc(int64(size))
-				continue
-			}
-			// Otherwise drop only last few transactions
-			txs := list.Flatten()
-			for i := len(txs) - 1; i >= 0 && drop > 0; i-- {
-				pool.removeTx(txs[i].Hash())
-				drop--
-				queuedRLCounter.Inc(1)
+// truncateQueue evicts the cheapest remote queued transactions if the queue limit
+// is overflown.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) truncateQueue() {
+	queued := uint64(0)
+	for _, list := range pool.queue {
+		queued += uint64(list.Len())
+	}
+	if queued <= pool.config.GlobalQueue {
+		return
+	}
+	drop := pool.priced.Discard(int(queued-pool.config.GlobalQueue), pool.localTx, func(tx *types.Transaction) bool {
+		addr, _ := types.Sender(pool.signer, tx) // already validated during insertion
+		if list := pool.pending[addr]; list != nil {
+			if cur := list.txs.Get(tx.Nonce()); cur != nil && cur.Hash() == tx.Hash() {
+				return false
 			}
 		}
+		return true
+	})
+	for _, tx := range drop {
+		if glog.V(logger.Core) {
+			glog.Infof("Evicting underpriced queued transaction: %v", tx)
+		}
+		pool.removeTx(tx.Hash(), TxDropEvicted, true)
 	}
+	queuedRLCounter.Inc(int64(len(drop)))
 }
 
 // demoteUnexecutables removes invalid and processed transactions from the pools
@@ -655,6 +956,8 @@ func (pool *TxPool) demoteUnexecutables(state *state.StateDB) {
 				glog.Infof("Removed old pending transaction: %v", tx)
 			}
 			delete(pool.all, tx.Hash())
+			pool.priced.Removed()
+			pool.txForwarded(tx)
 		}
 		// Drop all transactions that are too costly (low balance), and queue any invalids back for later
 		drops, invalids := list.Filter(state.GetBalance(addr))
@@ -663,6 +966,8 @@ func (pool *TxPool) demoteUnexecutables(state *state.StateDB) {
 				glog.Infof("Removed unpayable pending transaction: %v", tx)
 			}
 			delete(pool.all, tx.Hash())
+			pool.priced.Removed()
+			pool.txDropped(tx, TxDropUnpayable)
 			pendingNofundsCounter.Inc(1)
 		}
 		for _, tx := range invalids {
@@ -693,9 +998,9 @@ func (pool *TxPool) expirationLoop() {
 		case <-evict.C:
 			pool.mu.Lock()
 			for addr := range pool.queue {
-				if time.Since(pool.beats[addr]) > maxQueuedLifetime {
+				if time.Since(pool.beats[addr]) > pool.config.Lifetime {
 					for _, tx := range pool.queue[addr].Flatten() {
-						pool.removeTx(tx.Hash())
+						pool.removeTx(tx.Hash(), TxDropLifetime, false)
 					}
 				}
 			}
@@ -707,18 +1012,6 @@ func (pool *TxPool) expirationLoop() {
 	}
 }
 
-// addressByHeartbeat is an account address tagged with its last activity timestamp.
-type addressByHeartbeat struct {
-	address   common.Address
-	heartbeat time.Time
-}
-
-type addresssByHeartbeat []addressByHeartbeat
-
-func (a addresssByHeartbeat) Len() int           { return len(a) }
-func (a addresssByHeartbeat) Less(i, j int) bool { return a[i].heartbeat.Before(a[j].heartbeat) }
-func (a addresssByHeartbeat) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-
 // txSet represents a set of transaction hashes in which entries
 //  are automatically dropped after txSetDuration time
 type txSet struct {
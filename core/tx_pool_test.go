@@ -18,8 +18,11 @@ package core
 
 import (
 	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 
@@ -32,16 +35,29 @@ import (
 )
 
 func transaction(nonce uint64, gaslimit *big.Int, key *ecdsa.PrivateKey) *types.Transaction {
-	tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(100), gaslimit, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+	return pricedTransaction(nonce, gaslimit, big.NewInt(1), key)
+}
+
+func pricedTransaction(nonce uint64, gaslimit, gasprice *big.Int, key *ecdsa.PrivateKey) *types.Transaction {
+	tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(100), gaslimit, gasprice, nil), types.HomesteadSigner{}, key)
 	return tx
 }
 
+// testTxPoolConfig is a transaction pool configuration without stateful disk
+// sideeffects used during testing.
+var testTxPoolConfig TxPoolConfig
+
+func init() {
+	testTxPoolConfig = DefaultTxPoolConfig
+	testTxPoolConfig.Journal = ""
+}
+
 func setupTxPool() (*TxPool, *ecdsa.PrivateKey) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, db)
 
 	key, _ := crypto.GenerateKey()
-	newPool := NewTxPool(testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	newPool := NewTxPool(testTxPoolConfig, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	newPool.resetState()
 
 	return newPool, key
@@ -90,7 +106,7 @@ func TestStateChangeDuringPoolReset(t *testing.T) {
 
 	gasLimitFunc := func() *big.Int { return big.NewInt(1000000000) }
 
-	txpool := NewTxPool(testChainConfig(), mux, stateFunc, gasLimitFunc)
+	txpool := NewTxPool(testTxPoolConfig, testChainConfig(), mux, stateFunc, gasLimitFunc)
 	txpool.resetState()
 
 	nonce := txpool.State().GetNonce(address)
@@ -307,8 +323,8 @@ func TestTransactionDoubleNonce(t *testing.T) {
 		t.Errorf("transaction mismatch: have %x, want %x", tx.Hash(), tx2.Hash())
 	}
 	// Add the thid transaction and ensure it's not saved (smaller price)
-	if err := pool.add(tx3); err != nil {
-		t.Error("didn't expect error", err)
+	if err := pool.add(tx3); err != ErrReplaceUnderpriced {
+		t.Errorf("replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
 	}
 	pool.promoteExecutables(state)
 	if pool.pending[addr].Len() != 1 {
@@ -530,25 +546,25 @@ func TestTransactionQueueAccountLimiting(t *testing.T) {
 	pool.resetState()
 
 	// Keep queuing up transactions and make sure all above a limit are dropped
-	for i := uint64(1); i <= maxQueuedPerAccount+5; i++ {
+	for i := uint64(1); i <= testTxPoolConfig.AccountQueue+5; i++ {
 		if err := pool.Add(transaction(i, big.NewInt(100000), key)); err != nil {
 			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
 		}
 		if len(pool.pending) != 0 {
 			t.Errorf("tx %d: pending pool size mismatch: have %d, want %d", i, len(pool.pending), 0)
 		}
-		if i <= maxQueuedPerAccount {
+		if i <= testTxPoolConfig.AccountQueue {
 			if pool.queue[account].Len() != int(i) {
 				t.Errorf("tx %d: queue size mismatch: have %d, want %d", i, pool.queue[account].Len(), i)
 			}
 		} else {
-			if pool.queue[account].Len() != int(maxQueuedPerAccount) {
-				t.Errorf("tx %d: queue limit mismatch: have %d, want %d", i, pool.queue[account].Len(), maxQueuedPerAccount)
+			if pool.queue[account].Len() != int(testTxPoolConfig.AccountQueue) {
+				t.Errorf("tx %d: queue limit mismatch: have %d, want %d", i, pool.queue[account].Len(), testTxPoolConfig.AccountQueue)
 			}
 		}
 	}
-	if len(pool.all) != int(maxQueuedPerAccount) {
-		t.Errorf("total transaction mismatch: have %d, want %d", len(pool.all), maxQueuedPerAccount)
+	if len(pool.all) != int(testTxPoolConfig.AccountQueue) {
+		t.Errorf("total transaction mismatch: have %d, want %d", len(pool.all), testTxPoolConfig.AccountQueue)
 	}
 }
 
@@ -556,14 +572,14 @@ func TestTransactionQueueAccountLimiting(t *testing.T) {
 // some threshold, the higher transactions are dropped to prevent DOS attacks.
 func TestTransactionQueueGlobalLimiting(t *testing.T) {
 	// Reduce the queue limits to shorten test time
-	defer func(old uint64) { maxQueuedInTotal = old }(maxQueuedInTotal)
-	maxQueuedInTotal = maxQueuedPerAccount * 3
+	config := testTxPoolConfig
+	config.GlobalQueue = config.AccountQueue * 3
 
 	// Create the pool to test the limit enforcement with
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, db)
 
-	pool := NewTxPool(testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	pool.resetState()
 
 	// Create a number of test accounts and fund them
@@ -577,7 +593,7 @@ func TestTransactionQueueGlobalLimiting(t *testing.T) {
 	// Generate and queue a batch of transactions
 	nonces := make(map[common.Address]uint64)
 
-	txs := make(types.Transactions, 0, 3*maxQueuedInTotal)
+	txs := make(types.Transactions, 0, 3*config.GlobalQueue)
 	for len(txs) < cap(txs) {
 		key := keys[rand.Intn(len(keys))]
 		addr := crypto.PubkeyToAddress(key.PublicKey)
@@ -590,13 +606,13 @@ func TestTransactionQueueGlobalLimiting(t *testing.T) {
 
 	queued := 0
 	for addr, list := range pool.queue {
-		if list.Len() > int(maxQueuedPerAccount) {
-			t.Errorf("addr %x: queued accounts overflown allowance: %d > %d", addr, list.Len(), maxQueuedPerAccount)
+		if list.Len() > int(config.AccountQueue) {
+			t.Errorf("addr %x: queued accounts overflown allowance: %d > %d", addr, list.Len(), config.AccountQueue)
 		}
 		queued += list.Len()
 	}
-	if queued > int(maxQueuedInTotal) {
-		t.Fatalf("total transactions overflow allowance: %d > %d", queued, maxQueuedInTotal)
+	if queued > int(config.GlobalQueue) {
+		t.Fatalf("total transactions overflow allowance: %d > %d", queued, config.GlobalQueue)
 	}
 }
 
@@ -605,20 +621,28 @@ func TestTransactionQueueGlobalLimiting(t *testing.T) {
 // on shuffling them around.
 func TestTransactionQueueTimeLimiting(t *testing.T) {
 	// Reduce the queue limits to shorten test time
-	defer func(old time.Duration) { maxQueuedLifetime = old }(maxQueuedLifetime)
 	defer func(old time.Duration) { evictionInterval = old }(evictionInterval)
-	maxQueuedLifetime = time.Second
 	evictionInterval = time.Second
 
+	config := testTxPoolConfig
+	config.Lifetime = time.Second
+
+	// Create the pool to test the non-expiration enforcement
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool.resetState()
+
 	// Create a test account and fund it
-	pool, key := setupTxPool()
+	key, _ := crypto.GenerateKey()
 	account, _ := deriveSender(transaction(0, big.NewInt(0), key))
 
 	state, _ := pool.currentState()
 	state.AddBalance(account, big.NewInt(1000000))
 
 	// Queue up a batch of transactions
-	for i := uint64(1); i <= maxQueuedPerAccount; i++ {
+	for i := uint64(1); i <= testTxPoolConfig.AccountQueue; i++ {
 		if err := pool.Add(transaction(i, big.NewInt(100000), key)); err != nil {
 			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
 		}
@@ -643,7 +667,7 @@ func TestTransactionPendingLimiting(t *testing.T) {
 	pool.resetState()
 
 	// Keep queuing up transactions and make sure all above a limit are dropped
-	for i := uint64(0); i < maxQueuedPerAccount+5; i++ {
+	for i := uint64(0); i < testTxPoolConfig.AccountQueue+5; i++ {
 		if err := pool.Add(transaction(i, big.NewInt(100000), key)); err != nil {
 			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
 		}
@@ -654,8 +678,8 @@ func TestTransactionPendingLimiting(t *testing.T) {
 			t.Errorf("tx %d: queue size mismatch: have %d, want %d", i, pool.queue[account].Len(), 0)
 		}
 	}
-	if len(pool.all) != int(maxQueuedPerAccount+5) {
-		t.Errorf("total transaction mismatch: have %d, want %d", len(pool.all), maxQueuedPerAccount+5)
+	if len(pool.all) != int(testTxPoolConfig.AccountQueue+5) {
+		t.Errorf("total transaction mismatch: have %d, want %d", len(pool.all), testTxPoolConfig.AccountQueue+5)
 	}
 }
 
@@ -671,7 +695,7 @@ func testTransactionLimitingEquivalency(t *testing.T, origin uint64) {
 	state1, _ := pool1.currentState()
 	state1.AddBalance(account1, big.NewInt(1000000))
 
-	for i := uint64(0); i < maxQueuedPerAccount+5; i++ {
+	for i := uint64(0); i < testTxPoolConfig.AccountQueue+5; i++ {
 		if err := pool1.Add(transaction(origin+i, big.NewInt(100000), key1)); err != nil {
 			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
 		}
@@ -683,7 +707,7 @@ func testTransactionLimitingEquivalency(t *testing.T, origin uint64) {
 	state2.AddBalance(account2, big.NewInt(1000000))
 
 	txns := []*types.Transaction{}
-	for i := uint64(0); i < maxQueuedPerAccount+5; i++ {
+	for i := uint64(0); i < testTxPoolConfig.AccountQueue+5; i++ {
 		txns = append(txns, transaction(origin+i, big.NewInt(100000), key2))
 	}
 	pool2.AddBatch(txns)
@@ -705,14 +729,14 @@ func testTransactionLimitingEquivalency(t *testing.T, origin uint64) {
 // attacks.
 func TestTransactionPendingGlobalLimiting(t *testing.T) {
 	// Reduce the queue limits to shorten test time
-	defer func(old uint64) { maxPendingTotal = old }(maxPendingTotal)
-	maxPendingTotal = minPendingPerAccount * 10
+	config := testTxPoolConfig
+	config.GlobalSlots = config.AccountSlots * 10
 
 	// Create the pool to test the limit enforcement with
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, db)
 
-	pool := NewTxPool(testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	pool.resetState()
 
 	// Create a number of test accounts and fund them
@@ -729,7 +753,7 @@ func TestTransactionPendingGlobalLimiting(t *testing.T) {
 	txs := types.Transactions{}
 	for _, key := range keys {
 		addr := crypto.PubkeyToAddress(key.PublicKey)
-		for j := 0; j < int(maxPendingTotal)/len(keys)*2; j++ {
+		for j := 0; j < int(config.GlobalSlots)/len(keys)*2; j++ {
 			txs = append(txs, transaction(nonces[addr], big.NewInt(100000), key))
 			nonces[addr]++
 		}
@@ -741,8 +765,8 @@ func TestTransactionPendingGlobalLimiting(t *testing.T) {
 	for _, list := range pool.pending {
 		pending += list.Len()
 	}
-	if pending > int(maxPendingTotal) {
-		t.Fatalf("total pending transactions overflow allowance: %d > %d", pending, maxPendingTotal)
+	if pending > int(config.GlobalSlots) {
+		t.Fatalf("total pending transactions overflow allowance: %d > %d", pending, config.GlobalSlots)
 	}
 }
 
@@ -751,14 +775,14 @@ func TestTransactionPendingGlobalLimiting(t *testing.T) {
 // the transactions are still kept.
 func TestTransactionPendingMinimumAllowance(t *testing.T) {
 	// Reduce the queue limits to shorten test time
-	defer func(old uint64) { maxPendingTotal = old }(maxPendingTotal)
-	maxPendingTotal = 0
+	config := testTxPoolConfig
+	config.GlobalSlots = 1
 
 	// Create the pool to test the limit enforcement with
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, db)
 
-	pool := NewTxPool(testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
 	pool.resetState()
 
 	// Create a number of test accounts and fund them
@@ -775,7 +799,7 @@ func TestTransactionPendingMinimumAllowance(t *testing.T) {
 	txs := types.Transactions{}
 	for _, key := range keys {
 		addr := crypto.PubkeyToAddress(key.PublicKey)
-		for j := 0; j < int(minPendingPerAccount)*2; j++ {
+		for j := 0; j < int(config.AccountSlots)*2; j++ {
 			txs = append(txs, transaction(nonces[addr], big.NewInt(100000), key))
 			nonces[addr]++
 		}
@@ -784,10 +808,483 @@ func TestTransactionPendingMinimumAllowance(t *testing.T) {
 	pool.AddBatch(txs)
 
 	for addr, list := range pool.pending {
-		if list.Len() != int(minPendingPerAccount) {
-			t.Errorf("addr %x: total pending transactions mismatch: have %d, want %d", addr, list.Len(), minPendingPerAccount)
+		if list.Len() != int(config.AccountSlots) {
+			t.Errorf("addr %x: total pending transactions mismatch: have %d, want %d", addr, list.Len(), config.AccountSlots)
+		}
+	}
+}
+
+// Tests that when the pool reaches its global transaction limit, underpriced
+// transactions are gradually shifted out for more expensive ones and any gapped
+// pending transactions are moved into te queue.
+//
+// Note, local transactions are never allowed to be dropped.
+func TestTransactionPoolUnderpricing(t *testing.T) {
+	// Create the pool to test the pricing enforcement with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	config := testTxPoolConfig
+	config.GlobalSlots = 2
+	config.GlobalQueue = 2
+
+	pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool.resetState()
+
+	// Create a number of test accounts and fund them
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		statedb.AddBalance(crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	// Generate and queue a batch of transactions, both pending and queued
+	txs := types.Transactions{}
+
+	txs = append(txs, pricedTransaction(0, big.NewInt(100000), big.NewInt(1), keys[0]))
+	txs = append(txs, pricedTransaction(1, big.NewInt(100000), big.NewInt(2), keys[0]))
+
+	txs = append(txs, pricedTransaction(1, big.NewInt(100000), big.NewInt(1), keys[1]))
+
+	ltx := pricedTransaction(0, big.NewInt(100000), big.NewInt(1), keys[2])
+
+	// Import the batch and that both pending and queued transactions match up
+	pool.AddBatch(txs)
+	pool.SetLocal(ltx)
+	pool.Add(ltx)
+
+	if pending, queued := pool.Stats(); pending != 3 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want %d/%d", pending, queued, 3, 1)
+	}
+	// Ensure that adding an underpriced transaction on block limit fails
+	if err := pool.Add(pricedTransaction(0, big.NewInt(100000), big.NewInt(1), keys[1])); err != ErrUnderpriced {
+		t.Fatalf("adding underpriced pending transaction error mismatch: have %v, want %v", err, ErrUnderpriced)
+	}
+	// Ensure that adding high priced transactions drops cheap ones, but not own
+	if err := pool.Add(pricedTransaction(0, big.NewInt(100000), big.NewInt(3), keys[1])); err != nil {
+		t.Fatalf("failed to add well priced transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(2, big.NewInt(100000), big.NewInt(4), keys[1])); err != nil {
+		t.Fatalf("failed to add well priced transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(3, big.NewInt(100000), big.NewInt(5), keys[1])); err != nil {
+		t.Fatalf("failed to add well priced transaction: %v", err)
+	}
+	if len(pool.all) > int(config.GlobalSlots+config.GlobalQueue) {
+		t.Fatalf("pool size overflow: have %d, want at most %d", len(pool.all), config.GlobalSlots+config.GlobalQueue)
+	}
+	if pool.all[ltx.Hash()] == nil {
+		t.Fatalf("local transaction dropped")
+	}
+	for _, tx := range txs {
+		if pool.all[tx.Hash()] != nil {
+			t.Errorf("cheap remote transaction %x remained after eviction", tx.Hash())
+		}
+	}
+	// Ensure that adding local transactions can push out even higher priced ones
+	tx := pricedTransaction(1, big.NewInt(100000), big.NewInt(0), keys[2])
+
+	pool.SetLocal(tx)
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("failed to add underpriced local transaction: %v", err)
+	}
+	if pool.all[tx.Hash()] == nil || pool.all[ltx.Hash()] == nil {
+		t.Fatalf("local transactions dropped")
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that when the queue limit is overflown, the cheapest remote transactions
+// are evicted in favour of more expensive ones, regardless of account activity.
+//
+// Note, local transactions are never allowed to be dropped.
+func TestTransactionQueuePriceEviction(t *testing.T) {
+	// Create the pool to test the eviction with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	config := testTxPoolConfig
+	config.GlobalQueue = 2
+
+	pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool.resetState()
+
+	// Create a number of test accounts and fund them
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		statedb.AddBalance(crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000))
+	}
+	// Fill the queue with cheap remote transactions
+	cheap := types.Transactions{
+		pricedTransaction(1, big.NewInt(100000), big.NewInt(1), keys[0]),
+		pricedTransaction(2, big.NewInt(100000), big.NewInt(1), keys[0]),
+	}
+	pool.AddBatch(cheap)
+
+	// Queue up an even cheaper local transaction and an expensive remote one
+	ltx := pricedTransaction(1, big.NewInt(100000), big.NewInt(0), keys[1])
+	pool.SetLocal(ltx)
+	if err := pool.Add(ltx); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	rtx := pricedTransaction(1, big.NewInt(100000), big.NewInt(5), keys[2])
+	if err := pool.Add(rtx); err != nil {
+		t.Fatalf("failed to add expensive remote transaction: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 0 || queued != 2 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want %d/%d", pending, queued, 0, 2)
+	}
+	if pool.all[ltx.Hash()] == nil {
+		t.Errorf("local transaction evicted")
+	}
+	if pool.all[rtx.Hash()] == nil {
+		t.Errorf("expensive remote transaction evicted")
+	}
+	for i, tx := range cheap {
+		if pool.all[tx.Hash()] != nil {
+			t.Errorf("cheap remote transaction %d remained after eviction", i)
 		}
 	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// validateTxPoolInternals checks various consistency invariants within the pool.
+func validateTxPoolInternals(pool *TxPool) error {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	// Ensure the total transaction set is consistent with pending + queued
+	pending, queued := 0, 0
+	for _, list := range pool.pending {
+		pending += list.Len()
+	}
+	for _, list := range pool.queue {
+		queued += list.Len()
+	}
+	if total := len(pool.all); total != pending+queued {
+		return fmt.Errorf("total transaction count %d != %d pending + %d queued", total, pending, queued)
+	}
+	// Ensure the price heap tracks all live transactions
+	live := 0
+	for _, tx := range *pool.priced.items {
+		if pool.all[tx.Hash()] != nil {
+			live++
+		}
+	}
+	if live < len(pool.all) {
+		return fmt.Errorf("priced heap tracks %d live transactions, want %d", live, len(pool.all))
+	}
+	if stales := len(*pool.priced.items) - live; stales != pool.priced.stales {
+		return fmt.Errorf("priced heap stale count mismatch: have %d, want %d", pool.priced.stales, stales)
+	}
+	return nil
+}
+
+// Tests that the pool rejects replacement transactions that don't meet the minimum
+// price bump required, both in the pending and the queued sets.
+func TestTransactionReplacement(t *testing.T) {
+	// Create the pool to test the pricing enforcement with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	pool := NewTxPool(testTxPoolConfig, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool.resetState()
+
+	// Create a test account to add transactions with
+	key, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	// Add pending transactions, ensuring the minimum price bump is enforced for replacement (for ultra low prices too)
+	price := int64(100)
+	threshold := (price * (100 + int64(testTxPoolConfig.PriceBump))) / 100
+
+	if err := pool.Add(pricedTransaction(0, big.NewInt(100000), big.NewInt(1), key)); err != nil {
+		t.Fatalf("failed to add original cheap pending transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(0, big.NewInt(100001), big.NewInt(1), key)); err != ErrReplaceUnderpriced {
+		t.Fatalf("original cheap pending transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.Add(pricedTransaction(0, big.NewInt(100000), big.NewInt(2), key)); err != nil {
+		t.Fatalf("failed to replace original cheap pending transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(1, big.NewInt(100000), big.NewInt(price), key)); err != nil {
+		t.Fatalf("failed to add original proper pending transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(1, big.NewInt(100001), big.NewInt(threshold-1), key)); err != ErrReplaceUnderpriced {
+		t.Fatalf("original proper pending transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.Add(pricedTransaction(1, big.NewInt(100000), big.NewInt(threshold), key)); err != nil {
+		t.Fatalf("failed to replace original proper pending transaction: %v", err)
+	}
+	// Add queued transactions, ensuring the minimum price bump is enforced for replacement (for ultra low prices too)
+	if err := pool.Add(pricedTransaction(3, big.NewInt(100000), big.NewInt(1), key)); err != nil {
+		t.Fatalf("failed to add original queued transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(3, big.NewInt(100001), big.NewInt(1), key)); err != ErrReplaceUnderpriced {
+		t.Fatalf("original queued transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.Add(pricedTransaction(3, big.NewInt(100000), big.NewInt(2), key)); err != nil {
+		t.Fatalf("failed to replace original queued transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(4, big.NewInt(100000), big.NewInt(price), key)); err != nil {
+		t.Fatalf("failed to add original queued transaction: %v", err)
+	}
+	if err := pool.Add(pricedTransaction(4, big.NewInt(100001), big.NewInt(threshold-1), key)); err != ErrReplaceUnderpriced {
+		t.Fatalf("original queued transaction replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.Add(pricedTransaction(4, big.NewInt(100000), big.NewInt(threshold), key)); err != nil {
+		t.Fatalf("failed to replace original queued transaction: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 2 || queued != 2 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want %d/%d", pending, queued, 2, 2)
+	}
+}
+
+// Tests that the pool emits ordered status change events for its transactions and
+// that the whereabouts of dropped and replaced transactions can be queried.
+func TestTransactionStatusEvents(t *testing.T) {
+	// Create the pool to test the status tracking with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+	mux := new(event.TypeMux)
+
+	pool := NewTxPool(testTxPoolConfig, testChainConfig(), mux, func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool.resetState()
+	defer pool.Stop()
+
+	sub := mux.Subscribe(TxPoolEvent{})
+	defer sub.Unsubscribe()
+
+	// Create a test account and run its transactions through various pool states
+	key, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	var (
+		future   = pricedTransaction(1, big.NewInt(100000), big.NewInt(1), key)
+		original = pricedTransaction(0, big.NewInt(100000), big.NewInt(1), key)
+		cheap    = pricedTransaction(0, big.NewInt(100001), big.NewInt(1), key)
+		replaced = pricedTransaction(0, big.NewInt(100000), big.NewInt(2), key)
+	)
+	if err := pool.Add(future); err != nil {
+		t.Fatalf("failed to add future transaction: %v", err)
+	}
+	if status := pool.Status(future.Hash()); status.Status != "queued" {
+		t.Fatalf("future transaction status mismatch: have %q, want %q", status.Status, "queued")
+	}
+	if err := pool.Add(original); err != nil {
+		t.Fatalf("failed to add original transaction: %v", err)
+	}
+	if err := pool.Add(cheap); err != ErrReplaceUnderpriced {
+		t.Fatalf("underpriced replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.Add(replaced); err != nil {
+		t.Fatalf("failed to replace original transaction: %v", err)
+	}
+	pool.Remove(future.Hash())
+
+	// Ensure all the events arrived in the correct order
+	want := []TxPoolEvent{
+		{Tx: future, Kind: TxAdded},
+		{Tx: original, Kind: TxAdded},
+		{Tx: original, Kind: TxPromoted},
+		{Tx: future, Kind: TxPromoted},
+		{Tx: cheap, Kind: TxDropped, Reason: TxDropUnderpriced},
+		{Tx: replaced, Kind: TxAdded},
+		{Tx: original, Kind: TxReplaced, Replacement: replaced.Hash()},
+		{Tx: replaced, Kind: TxPromoted},
+		{Tx: future, Kind: TxDropped, Reason: TxDropRemoved},
+	}
+	for i, exp := range want {
+		select {
+		case ev := <-sub.Chan():
+			have := ev.Data.(TxPoolEvent)
+			if have.Tx.Hash() != exp.Tx.Hash() || have.Kind != exp.Kind || have.Reason != exp.Reason || have.Replacement != exp.Replacement {
+				t.Errorf("event %d: mismatch: have %x %s %q %x, want %x %s %q %x", i, have.Tx.Hash(), have.Kind, have.Reason, have.Replacement, exp.Tx.Hash(), exp.Kind, exp.Reason, exp.Replacement)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d: timeout", i)
+		}
+	}
+	// Ensure the status lookups report the correct whereabouts
+	tests := []struct {
+		tx   *types.Transaction
+		want TxStatus
+	}{
+		{replaced, TxStatus{Status: "pending"}},
+		{original, TxStatus{Status: "replaced", Replacement: replaced.Hash()}},
+		{cheap, TxStatus{Status: "dropped", Reason: TxDropUnderpriced}},
+		{future, TxStatus{Status: "dropped", Reason: TxDropRemoved}},
+		{transaction(5, big.NewInt(100000), key), TxStatus{Status: "unknown"}},
+	}
+	for i, tt := range tests {
+		if status := pool.Status(tt.tx.Hash()); status != tt.want {
+			t.Errorf("test %d: status mismatch: have %+v, want %+v", i, status, tt.want)
+		}
+	}
+}
+
+// Tests that transactions removed from the pool because of a new chain head are
+// reported as included if they were in a canonical block, and as dropped if their
+// nonce was used by some other transaction.
+func TestTransactionStatusIncluded(t *testing.T) {
+	// Create the pool to test the status tracking with
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+	mux := new(event.TypeMux)
+
+	pool := NewTxPool(testTxPoolConfig, testChainConfig(), mux, func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+	pool.resetState()
+	defer pool.Stop()
+
+	sub := mux.Subscribe(TxPoolEvent{})
+	defer sub.Unsubscribe()
+
+	// Add a transaction of two accounts each, and mine only one of them
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key1.PublicKey), big.NewInt(1000000000))
+	statedb.AddBalance(crypto.PubkeyToAddress(key2.PublicKey), big.NewInt(1000000000))
+
+	mined, other := transaction(0, big.NewInt(100000), key1), transaction(0, big.NewInt(100000), key2)
+	if err := pool.AddBatch([]*types.Transaction{mined, other}); err != nil {
+		t.Fatalf("failed to add transactions: %v", err)
+	}
+	for i := 0; i < 4; i++ { // added and promoted for both
+		select {
+		case <-sub.Chan():
+		case <-time.After(time.Second):
+			t.Fatalf("event %d: timeout", i)
+		}
+	}
+	statedb.SetNonce(crypto.PubkeyToAddress(key1.PublicKey), 1)
+	statedb.SetNonce(crypto.PubkeyToAddress(key2.PublicKey), 1)
+
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, []*types.Transaction{mined}, nil, nil)
+	mux.Post(ChainHeadEvent{Block: block})
+
+	want := map[common.Hash]TxPoolEvent{
+		mined.Hash(): {Tx: mined, Kind: TxIncluded},
+		other.Hash(): {Tx: other, Kind: TxDropped, Reason: TxDropNonceTooLow},
+	}
+	for i := 0; i < len(want); i++ {
+		select {
+		case ev := <-sub.Chan():
+			have := ev.Data.(TxPoolEvent)
+			if exp := want[have.Tx.Hash()]; have.Kind != exp.Kind || have.Reason != exp.Reason {
+				t.Errorf("event %d: mismatch: have %x %s %q, want %s %q", i, have.Tx.Hash(), have.Kind, have.Reason, exp.Kind, exp.Reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d: timeout", i)
+		}
+	}
+	if status := pool.Status(mined.Hash()); status != (TxStatus{Status: "included"}) {
+		t.Errorf("mined transaction status mismatch: have %+v, want %+v", status, TxStatus{Status: "included"})
+	}
+	if status := pool.Status(other.Hash()); status != (TxStatus{Status: "dropped", Reason: TxDropNonceTooLow}) {
+		t.Errorf("other transaction status mismatch: have %+v, want %+v", status, TxStatus{Status: "dropped", Reason: TxDropNonceTooLow})
+	}
+}
+
+// Tests that the transaction event feed drops the oldest undelivered events if
+// the subscribers fall too far behind.
+func TestTxEventFeedLimit(t *testing.T) {
+	feed := newTxEventFeed(new(event.TypeMux))
+	feed.limit = 2
+
+	txs := make([]*types.Transaction, 3)
+	for i := range txs {
+		txs[i] = types.NewTransaction(uint64(i), common.Address{}, new(big.Int), new(big.Int), new(big.Int), nil)
+		feed.post(TxPoolEvent{Tx: txs[i], Kind: TxAdded})
+	}
+	if len(feed.queue) != 2 || feed.dropped != 1 {
+		t.Fatalf("queue mismatch: have %d queued, %d dropped, want %d queued, %d dropped", len(feed.queue), feed.dropped, 2, 1)
+	}
+	for i, ev := range feed.queue {
+		if ev.Tx != txs[i+1] {
+			t.Errorf("event %d: transaction mismatch: have nonce %d, want %d", i, ev.Tx.Nonce(), i+1)
+		}
+	}
+}
+
+// Tests that local transactions are journaled to disk, but remote transactions
+// get discarded between restarts.
+func TestTransactionJournaling(t *testing.T) {
+	// Create a temporary file for the journal
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary journal: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	// Clean up the temporary file, we only need the path for now
+	file.Close()
+	os.Remove(journal)
+
+	// Create the original pool to inject transaction into the journal
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	newPool := func() *TxPool {
+		config := testTxPoolConfig
+		config.Journal = journal
+		config.Rejournal = time.Hour
+
+		pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+		pool.resetState()
+		return pool
+	}
+	pool := newPool()
+
+	// Create two test accounts to ensure remotes expire but locals do not
+	local, _ := crypto.GenerateKey()
+	remote, _ := crypto.GenerateKey()
+
+	statedb.AddBalance(crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+	statedb.AddBalance(crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	// Add three local and a remote transactions and ensure they are queued up
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx := transaction(nonce, big.NewInt(100000), local)
+		pool.SetLocal(tx)
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("failed to add local transaction %d: %v", nonce, err)
+		}
+	}
+	if err := pool.Add(transaction(0, big.NewInt(100000), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	if pending, queued := pool.Stats(); pending != 4 || queued != 0 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want %d/%d", pending, queued, 4, 0)
+	}
+	// Terminate the old pool, create a new one and ensure only the locals are loaded
+	pool.Stop()
+
+	pool = newPool()
+	if pending, queued := pool.Stats(); pending != 3 || queued != 0 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want %d/%d", pending, queued, 3, 0)
+	}
+	// Bump the nonce temporarily, rotate the journal and ensure the mined ones are dropped
+	statedb.SetNonce(crypto.PubkeyToAddress(local.PublicKey), 2)
+	pool.mu.Lock()
+	pool.resetState()
+	if err := pool.journal.rotate(pool.journaledTxs()); err != nil {
+		t.Fatalf("failed to rotate journal: %v", err)
+	}
+	pool.mu.Unlock()
+	pool.Stop()
+
+	statedb.SetNonce(crypto.PubkeyToAddress(local.PublicKey), 1)
+	pool = newPool()
+	defer pool.Stop()
+
+	if pending, queued := pool.Stats(); pending != 0 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want %d/%d", pending, queued, 0, 1)
+	}
 }
 
 // Benchmarks the speed of validating the contents of the pending queue of the
@@ -882,3 +1379,43 @@ func benchmarkPoolBatchInsert(b *testing.B, size int) {
 		pool.AddBatch(batch)
 	}
 }
+
+// Tests that a zero valued pool configuration is sanitized into a working one,
+// instead of capping the pool at zero transactions.
+func TestTxPoolConfigSanitize(t *testing.T) {
+	want := DefaultTxPoolConfig
+	want.Journal, want.Rejournal = "", time.Second
+
+	if have := (&TxPoolConfig{}).sanitize(); have != want {
+		t.Fatalf("sanitized config mismatch: have %+v, want %+v", have, want)
+	}
+}
+
+// Tests that invalid journal regeneration intervals are rejected instead of
+// crashing the pool.
+func TestTransactionJournalingInterval(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary journal: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	file.Close()
+	os.Remove(journal)
+
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	for _, rejournal := range []time.Duration{0, -time.Second} {
+		config := testTxPoolConfig
+		config.Journal = journal
+		config.Rejournal = rejournal
+
+		pool := NewTxPool(config, testChainConfig(), new(event.TypeMux), func() (*state.StateDB, error) { return statedb, nil }, func() *big.Int { return big.NewInt(1000000) })
+		if pool.config.Rejournal != minRejournal {
+			t.Errorf("rejournal %v: sanitized interval mismatch: have %v, want %v", rejournal, pool.config.Rejournal, minRejournal)
+		}
+		pool.Stop()
+	}
+}
@@ -29,7 +29,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core"
@@ -41,6 +40,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/miner"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/pow"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 	"golang.org/x/net/context"
@@ -83,10 +83,7 @@ type PublicMinerAPI struct {
 
 // NewPublicMinerAPI create a new PublicMinerAPI instance.
 func NewPublicMinerAPI(e *Ethereum) *PublicMinerAPI {
-	agent := miner.NewRemoteAgent(e.Pow())
-	e.Miner().Register(agent)
-
-	return &PublicMinerAPI{e, agent}
+	return &PublicMinerAPI{e, e.remoteAgent}
 }
 
 // Mining returns an indication if this node is currently mining.
@@ -139,7 +136,6 @@ func NewPrivateMinerAPI(e *Ethereum) *PrivateMinerAPI {
 // Start the miner with the given number of threads. If threads is nil the number of
 // workers started is equal to the number of logical CPU's that are usable by this process.
 func (s *PrivateMinerAPI) Start(threads *int) (bool, error) {
-	s.e.StartAutoDAG()
 	var err error
 	if threads == nil {
 		err = s.e.StartMining(runtime.NumCPU())
@@ -175,22 +171,27 @@ func (s *PrivateMinerAPI) SetEtherbase(etherbase common.Address) bool {
 	return true
 }
 
-// StartAutoDAG starts auto DAG generation. This will prevent the DAG generating on epoch change
-// which will cause the node to stop mining during the generation process.
-func (s *PrivateMinerAPI) StartAutoDAG() bool {
-	s.e.StartAutoDAG()
-	return true
-}
-
-// StopAutoDAG stops auto DAG generation
-func (s *PrivateMinerAPI) StopAutoDAG() bool {
-	s.e.StopAutoDAG()
-	return true
+// StratumWorkers returns the statistics of the remote miners logged in to the
+// Stratum server.
+func (s *PrivateMinerAPI) StratumWorkers() ([]miner.StratumWorker, error) {
+	if s.e.stratum == nil {
+		return nil, errors.New("stratum server not enabled")
+	}
+	return s.e.stratum.Workers(), nil
 }
 
 // MakeDAG creates the new DAG for the given block number
 func (s *PrivateMinerAPI) MakeDAG(blockNr rpc.BlockNumber) (bool, error) {
-	if err := ethash.MakeDAG(uint64(blockNr.Int64()), ""); err != nil {
+	dir := s.e.dagdir
+	if dir == "" {
+		dir = pow.DefaultDir
+	}
+	// Keep at least the requested DAG, even if disk storage is otherwise disabled
+	limit := s.e.dagsondisk
+	if limit < 1 {
+		limit = 1
+	}
+	if err := pow.MakeDataset(uint64(blockNr.Int64()), dir, limit); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -401,6 +402,51 @@ func (api *PrivateDebugAPI) TraceBlockByHash(hash common.Hash, config *vm.LogCon
 	}
 }
 
+// BadBlockArgs represents the entries in the list returned when bad blocks are
+// queried.
+type BadBlockArgs struct {
+	Hash   common.Hash   `json:"hash"`
+	Number uint64        `json:"number"`
+	RLP    hexutil.Bytes `json:"rlp"`
+	Error  string        `json:"error"`
+}
+
+// GetBadBlocks returns the most recently rejected blocks (newest first) along
+// with the validation errors that caused their rejection. The RLP of any of
+// them can be fed into debug_traceBlock for further inspection.
+func (api *PrivateDebugAPI) GetBadBlocks() ([]*BadBlockArgs, error) {
+	bad := api.eth.BlockChain().BadBlocks()
+
+	results := make([]*BadBlockArgs, 0, len(bad))
+	for _, entry := range bad {
+		blockRlp, err := rlp.EncodeToBytes(entry.Block)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &BadBlockArgs{
+			Hash:   entry.Block.Hash(),
+			Number: entry.Block.NumberU64(),
+			RLP:    blockRlp,
+			Error:  entry.Reason,
+		})
+	}
+	return results, nil
+}
+
+// TraceBadBlock reprocesses a previously rejected block, identified by its hash.
+func (api *PrivateDebugAPI) TraceBadBlock(hash common.Hash, config *vm.LogConfig) BlockTraceResult {
+	entry := api.eth.BlockChain().BadBlock(hash)
+	if entry == nil {
+		return BlockTraceResult{Error: fmt.Sprintf("bad block %x not found", hash)}
+	}
+	validated, logs, err := api.traceBlock(entry.Block, config)
+	return BlockTraceResult{
+		Validated:  validated,
+		StructLogs: ethapi.FormatLogs(logs),
+		Error:      formatError(err),
+	}
+}
+
 // traceBlock processes the given block but does not save the state.
 func (api *PrivateDebugAPI) traceBlock(block *types.Block, logConfig *vm.LogConfig) (bool, []vm.StructLog, error) {
 	// Validate and reprocess the block
@@ -417,10 +463,14 @@ func (api *PrivateDebugAPI) traceBlock(block *types.Block, logConfig *vm.LogConf
 		Tracer: structLogger,
 	}
 
-	if err := core.ValidateHeader(api.config, blockchain.AuxValidator(), block.Header(), blockchain.GetHeader(block.ParentHash(), block.NumberU64()-1), true, false); err != nil {
+	parent := blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return false, structLogger.StructLogs(), fmt.Errorf("parent %x not found", block.ParentHash())
+	}
+	if err := core.ValidateHeader(api.config, blockchain.AuxValidator(), block.Header(), parent.Header(), true, false); err != nil {
 		return false, structLogger.StructLogs(), err
 	}
-	statedb, err := blockchain.StateAt(blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1).Root())
+	statedb, err := blockchain.StateAt(parent.Root())
 	if err != nil {
 		return false, structLogger.StructLogs(), err
 	}
@@ -429,7 +479,7 @@ func (api *PrivateDebugAPI) traceBlock(block *types.Block, logConfig *vm.LogConf
 	if err != nil {
 		return false, structLogger.StructLogs(), err
 	}
-	if err := validator.ValidateState(block, blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1), statedb, receipts, usedGas); err != nil {
+	if err := validator.ValidateState(block, parent, statedb, receipts, usedGas); err != nil {
 		return false, structLogger.StructLogs(), err
 	}
 	return true, structLogger.StructLogs(), nil
@@ -38,7 +38,7 @@ import (
 // EthApiBackend implements ethapi.Backend for full nodes
 type EthApiBackend struct {
 	eth *Ethereum
-	gpo *gasprice.GasPriceOracle
+	gpo *gasprice.Oracle
 }
 
 func (b *EthApiBackend) ChainConfig() *params.ChainConfig {
@@ -76,7 +76,11 @@ func (b *EthApiBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumb
 	if blockNr == rpc.LatestBlockNumber {
 		return b.eth.blockchain.CurrentBlock(), nil
 	}
-	return b.eth.blockchain.GetBlockByNumber(uint64(blockNr)), nil
+	block := b.eth.blockchain.GetBlockByNumber(uint64(blockNr))
+	if block == nil && b.eth.blockchain.IsPruned(uint64(blockNr)) {
+		return nil, core.ErrBlockPruned
+	}
+	return block, nil
 }
 
 func (b *EthApiBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (ethapi.State, *types.Header, error) {
@@ -95,11 +99,30 @@ func (b *EthApiBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.
 }
 
 func (b *EthApiBackend) GetBlock(ctx context.Context, blockHash common.Hash) (*types.Block, error) {
-	return b.eth.blockchain.GetBlockByHash(blockHash), nil
+	block := b.eth.blockchain.GetBlockByHash(blockHash)
+	if block == nil && b.isPruned(blockHash) {
+		return nil, core.ErrBlockPruned
+	}
+	return block, nil
 }
 
 func (b *EthApiBackend) GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error) {
-	return core.GetBlockReceipts(b.eth.chainDb, blockHash, core.GetBlockNumber(b.eth.chainDb, blockHash)), nil
+	receipts := core.GetBlockReceipts(b.eth.chainDb, blockHash, core.GetBlockNumber(b.eth.chainDb, blockHash))
+	if receipts == nil && b.isPruned(blockHash) {
+		return nil, core.ErrBlockPruned
+	}
+	return receipts, nil
+}
+
+// isPruned checks whether the block with the given hash is a canonical one
+// whose body and receipts were already deleted by the history pruner.
+func (b *EthApiBackend) isPruned(blockHash common.Hash) bool {
+	header := b.eth.blockchain.GetHeaderByHash(blockHash)
+	if header == nil {
+		return false
+	}
+	number := header.Number.Uint64()
+	return b.eth.blockchain.IsPruned(number) && core.GetCanonicalHash(b.eth.chainDb, number) == blockHash
 }
 
 func (b *EthApiBackend) GetTd(blockHash common.Hash) *big.Int {
@@ -175,6 +198,10 @@ func (b *EthApiBackend) TxPoolContent() (map[common.Address]types.Transactions,
 	return b.eth.TxPool().Content()
 }
 
+func (b *EthApiBackend) TxPoolStatus(txHash common.Hash) core.TxStatus {
+	return b.eth.TxPool().Status(txHash)
+}
+
 func (b *EthApiBackend) Downloader() *downloader.Downloader {
 	return b.eth.Downloader()
 }
@@ -184,7 +211,11 @@ func (b *EthApiBackend) ProtocolVersion() int {
 }
 
 func (b *EthApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
-	return b.gpo.SuggestPrice(), nil
+	return b.gpo.SuggestPrice(ctx)
+}
+
+func (b *EthApiBackend) FeeHistory(ctx context.Context, blockCount int, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*big.Int, [][]*big.Int, []float64, error) {
+	return b.gpo.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
 }
 
 func (b *EthApiBackend) ChainDb() ethdb.Database {
@@ -199,6 +230,14 @@ func (b *EthApiBackend) AccountManager() *accounts.Manager {
 	return b.eth.AccountManager()
 }
 
+func (b *EthApiBackend) BloomStatus() (uint64, uint64) {
+	return b.eth.bloomIndexer.status()
+}
+
+func (b *EthApiBackend) GetBloomBits(ctx context.Context, bits []uint, section uint64) ([][]byte, error) {
+	return b.eth.bloomIndexer.bloomBits(bits, section)
+}
+
 type EthApiState struct {
 	state *state.StateDB
 }
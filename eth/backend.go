@@ -21,14 +21,11 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -50,14 +47,6 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
-const (
-	epochLength    = 30000
-	ethashRevision = 23
-
-	autoDAGcheckInterval = 10 * time.Hour
-	autoDAGepochHeight   = epochLength / 2
-)
-
 var (
 	datadirInUseErrnos = map[uint]bool{11: true, 32: true, 35: true}
 	portInUseErrRE     = regexp.MustCompile("address already in use")
@@ -74,28 +63,48 @@ type Config struct {
 	LightPeers int    // Maximum number of LES client peers
 	MaxPeers   int    // Maximum number of global peers
 
-	SkipBcVersionCheck bool // e.g. blockchain export
+	Checkpoint *params.TrustedCheckpoint // Trusted checkpoint for light clients to sync from (nil = network default)
+
+	SkipBcVersionCheck bool   // e.g. blockchain export
+	PruneDepth         uint64 // Number of recent blocks to retain bodies and receipts for (0 = archive)
+	TxLookupLimit      uint64 // Number of recent blocks to index transactions for (0 = entire chain)
+	NoTxLookup         bool   // Disables indexing transactions for hash based lookups
 	DatabaseCache      int
 	DatabaseHandles    int
 
+	TxPool core.TxPoolConfig // Transaction pool limits, replacement policy and local journal
+
 	DocRoot   string
-	AutoDAG   bool
 	PowFake   bool
 	PowTest   bool
 	PowShared bool
 	ExtraData []byte
 
-	Etherbase    common.Address
-	GasPrice     *big.Int
-	MinerThreads int
-	SolcPath     string
+	EthashCacheDir       string // Directory to store the ethash verification caches in (relative to the data directory)
+	EthashCachesInMem    int    // Number of recent ethash verification caches to keep in memory
+	EthashCachesOnDisk   int    // Number of recent ethash verification caches to keep on disk
+	EthashDatasetDir     string // Directory to store the ethash mining datasets in
+	EthashDatasetsInMem  int    // Number of recent ethash mining datasets to keep in memory
+	EthashDatasetsOnDisk int    // Number of recent ethash mining datasets to keep on disk
 
-	GpoMinGasPrice          *big.Int
-	GpoMaxGasPrice          *big.Int
-	GpoFullBlockRatio       int
-	GpobaseStepDown         int
-	GpobaseStepUp           int
-	GpobaseCorrectionFactor int
+	DevMode   bool          // Seal blocks without proof-of-work for developer networks
+	DevPeriod time.Duration // Block period in developer mode (0 = seal on transaction arrival)
+
+	Etherbase     common.Address
+	GasPrice      *big.Int
+	MinerThreads  int
+	MinerTxOrder  string        // Transaction ordering strategy for mined blocks, as name[:args] (empty = price and nonce)
+	MinerRecommit time.Duration // Interval to rebuild the mined block with newly arrived transactions
+	StratumAddr   string        // Listening address of the Stratum server for remote miners (empty = disabled)
+	SolcPath      string
+
+	GpoBlocks      int      // Number of recent blocks to sample gas prices from
+	GpoPercentile  int      // Percentile of the sampled gas prices to suggest
+	GpoMinGasPrice *big.Int // Minimum suggested gas price
+	GpoMaxGasPrice *big.Int // Maximum suggested gas price
+
+	LogsMaxRange   uint64 // Maximum number of blocks a log query may search (0 = unlimited)
+	LogsMaxResults int    // Maximum number of logs a log query may return (0 = unlimited)
 
 	EnablePreimageRecording bool
 
@@ -122,6 +131,7 @@ type Ethereum struct {
 	blockchain      *core.BlockChain
 	protocolManager *ProtocolManager
 	lesServer       LesServer
+	bloomIndexer    *bloomIndexer // Background generator of the bloom bit index
 	// DB interfaces
 	chainDb ethdb.Database // Block chain database
 
@@ -132,15 +142,19 @@ type Ethereum struct {
 	ApiBackend *EthApiBackend
 
 	miner        *miner.Miner
+	remoteAgent  *miner.RemoteAgent
+	stratum      *miner.StratumServer
 	Mining       bool
 	MinerThreads int
-	AutoDAG      bool
-	autodagquit  chan bool
+	dagdir       string
+	dagsondisk   int // Number of mining datasets to keep on disk
 	etherbase    common.Address
 	solcPath     string
 
-	netVersionId  int
-	netRPCService *ethapi.PublicNetAPI
+	netVersionId   int
+	netRPCService  *ethapi.PublicNetAPI
+	logsMaxRange   uint64 // Maximum number of blocks a log query may search
+	logsMaxResults int    // Maximum number of logs a log query may return
 }
 
 func (s *Ethereum) AddLesServer(ls LesServer) {
@@ -159,7 +173,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err := SetupGenesisBlock(&chainDb, config); err != nil {
 		return nil, err
 	}
-	pow, err := CreatePoW(config)
+	pow, err := CreatePoW(ctx, config)
 	if err != nil {
 		return nil, err
 	}
@@ -174,16 +188,16 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		netVersionId:   config.NetworkId,
 		etherbase:      config.Etherbase,
 		MinerThreads:   config.MinerThreads,
-		AutoDAG:        config.AutoDAG,
+		dagdir:         config.EthashDatasetDir,
+		dagsondisk:     config.EthashDatasetsOnDisk,
 		solcPath:       config.SolcPath,
+		logsMaxRange:   config.LogsMaxRange,
+		logsMaxResults: config.LogsMaxResults,
 	}
 
 	if err := upgradeChainDatabase(chainDb); err != nil {
 		return nil, err
 	}
-	if err := addMipmapBloomBins(chainDb); err != nil {
-		return nil, err
-	}
 
 	glog.V(logger.Info).Infof("Protocol Versions: %v, Network Id: %v", ProtocolVersions, config.NetworkId)
 
@@ -222,7 +236,14 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		}
 		return nil, err
 	}
-	newPool := core.NewTxPool(eth.chainConfig, eth.EventMux(), eth.blockchain.State, eth.blockchain.GasLimit)
+	eth.blockchain.SetPruneDepth(config.PruneDepth)
+	eth.blockchain.SetTxLookupLimit(config.TxLookupLimit, !config.NoTxLookup)
+
+	txConfig := config.TxPool
+	if txConfig.Journal != "" {
+		txConfig.Journal = ctx.ResolvePath(txConfig.Journal)
+	}
+	newPool := core.NewTxPool(txConfig, eth.chainConfig, eth.EventMux(), eth.blockchain.State, eth.blockchain.GasLimit)
 	eth.txPool = newPool
 
 	maxPeers := config.MaxPeers
@@ -242,17 +263,29 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.pow)
 	eth.miner.SetGasPrice(config.GasPrice)
 	eth.miner.SetExtra(config.ExtraData)
-
-	gpoParams := &gasprice.GpoParams{
-		GpoMinGasPrice:          config.GpoMinGasPrice,
-		GpoMaxGasPrice:          config.GpoMaxGasPrice,
-		GpoFullBlockRatio:       config.GpoFullBlockRatio,
-		GpobaseStepDown:         config.GpobaseStepDown,
-		GpobaseStepUp:           config.GpobaseStepUp,
-		GpobaseCorrectionFactor: config.GpobaseCorrectionFactor,
+	if config.MinerTxOrder != "" {
+		orderer, err := miner.LookupTxOrderer(config.MinerTxOrder)
+		if err != nil {
+			return nil, err
+		}
+		eth.miner.SetTxOrderer(orderer)
+	}
+	if config.MinerRecommit != 0 {
+		eth.miner.SetRecommitInterval(config.MinerRecommit)
+	}
+	if config.DevMode {
+		eth.miner.SetDevMode(config.DevPeriod)
 	}
-	gpo := gasprice.NewGasPriceOracle(eth.blockchain, chainDb, eth.eventMux, gpoParams)
-	eth.ApiBackend = &EthApiBackend{eth, gpo}
+	eth.remoteAgent = miner.NewRemoteAgent(eth.pow)
+	eth.miner.Register(eth.remoteAgent)
+	if config.StratumAddr != "" {
+		eth.stratum = miner.NewStratumServer(eth.remoteAgent, config.StratumAddr)
+	}
+
+	eth.bloomIndexer = newBloomIndexer(chainDb, eth.eventMux)
+
+	eth.ApiBackend = &EthApiBackend{eth, nil}
+	eth.ApiBackend.gpo = gasprice.NewOracle(eth.ApiBackend, config.GasPriceOracle())
 
 	return eth, nil
 }
@@ -289,26 +322,40 @@ func SetupGenesisBlock(chainDb *ethdb.Database, config *Config) error {
 	return nil
 }
 
+// GasPriceOracle returns the gas price oracle settings of the configuration.
+func (config *Config) GasPriceOracle() gasprice.Config {
+	return gasprice.Config{
+		Blocks:     config.GpoBlocks,
+		Percentile: config.GpoPercentile,
+		MinPrice:   config.GpoMinGasPrice,
+		MaxPrice:   config.GpoMaxGasPrice,
+	}
+}
+
 // CreatePoW creates the required type of PoW instance for an Ethereum service
-func CreatePoW(config *Config) (pow.PoW, error) {
+func CreatePoW(ctx *node.ServiceContext, config *Config) (pow.PoW, error) {
 	switch {
 	case config.PowFake:
 		glog.V(logger.Info).Infof("ethash used in fake mode")
-		return pow.PoW(core.FakePow{}), nil
+		return pow.NewFakeEthash(), nil
 	case config.PowTest:
 		glog.V(logger.Info).Infof("ethash used in test mode")
-		return ethash.NewForTesting()
+		return pow.NewTestEthash(), nil
 	case config.PowShared:
 		glog.V(logger.Info).Infof("ethash used in shared mode")
-		return ethash.NewShared(), nil
+		return pow.NewShared(), nil
 	default:
-		return ethash.New(), nil
+		return pow.New(ctx.ResolvePath(config.EthashCacheDir), config.EthashCachesInMem, config.EthashCachesOnDisk,
+			config.EthashDatasetDir, config.EthashDatasetsInMem, config.EthashDatasetsOnDisk), nil
 	}
 }
 
 // APIs returns the collection of RPC services the ethereum package offers.
 // NOTE, some of these services probably need to be moved to somewhere else.
 func (s *Ethereum) APIs() []rpc.API {
+	filterAPI := filters.NewPublicFilterAPI(s.ApiBackend, false)
+	filterAPI.SetLogLimits(s.logsMaxRange, s.logsMaxResults)
+
 	return append(ethapi.GetAPIs(s.ApiBackend, s.solcPath), []rpc.API{
 		{
 			Namespace: "eth",
@@ -333,7 +380,7 @@ func (s *Ethereum) APIs() []rpc.API {
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
-			Service:   filters.NewPublicFilterAPI(s.ApiBackend, false),
+			Service:   filterAPI,
 			Public:    true,
 		}, {
 			Namespace: "admin",
@@ -419,13 +466,16 @@ func (s *Ethereum) Protocols() []p2p.Protocol {
 // Ethereum protocol implementation.
 func (s *Ethereum) Start(srvr *p2p.Server) error {
 	s.netRPCService = ethapi.NewPublicNetAPI(srvr, s.NetVersion())
-	if s.AutoDAG {
-		s.StartAutoDAG()
-	}
+	s.bloomIndexer.Start()
 	s.protocolManager.Start()
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	if s.stratum != nil {
+		if err := s.stratum.Start(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -435,17 +485,19 @@ func (s *Ethereum) Stop() error {
 	if s.stopDbUpgrade != nil {
 		s.stopDbUpgrade()
 	}
+	s.bloomIndexer.Stop()
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
 		s.lesServer.Stop()
 	}
+	if s.stratum != nil {
+		s.stratum.Stop()
+	}
 	s.txPool.Stop()
 	s.miner.Stop()
 	s.eventMux.Stop()
 
-	s.StopAutoDAG()
-
 	s.chainDb.Close()
 	close(s.shutdownChan)
 
@@ -456,74 +508,3 @@ func (s *Ethereum) Stop() error {
 func (s *Ethereum) WaitForShutdown() {
 	<-s.shutdownChan
 }
-
-// StartAutoDAG() spawns a go routine that checks the DAG every autoDAGcheckInterval
-// by default that is 10 times per epoch
-// in epoch n, if we past autoDAGepochHeight within-epoch blocks,
-// it calls ethash.MakeDAG  to pregenerate the DAG for the next epoch n+1
-// if it does not exist yet as well as remove the DAG for epoch n-1
-// the loop quits if autodagquit channel is closed, it can safely restart and
-// stop any number of times.
-// For any more sophisticated pattern of DAG generation, use CLI subcommand
-// makedag
-func (self *Ethereum) StartAutoDAG() {
-	if self.autodagquit != nil {
-		return // already started
-	}
-	go func() {
-		glog.V(logger.Info).Infof("Automatic pregeneration of ethash DAG ON (ethash dir: %s)", ethash.DefaultDir)
-		var nextEpoch uint64
-		timer := time.After(0)
-		self.autodagquit = make(chan bool)
-		for {
-			select {
-			case <-timer:
-				glog.V(logger.Info).Infof("checking DAG (ethash dir: %s)", ethash.DefaultDir)
-				currentBlock := self.BlockChain().CurrentBlock().NumberU64()
-				thisEpoch := currentBlock / epochLength
-				if nextEpoch <= thisEpoch {
-					if currentBlock%epochLength > autoDAGepochHeight {
-						if thisEpoch > 0 {
-							previousDag, previousDagFull := dagFiles(thisEpoch - 1)
-							os.Remove(filepath.Join(ethash.DefaultDir, previousDag))
-							os.Remove(filepath.Join(ethash.DefaultDir, previousDagFull))
-							glog.V(logger.Info).Infof("removed DAG for epoch %d (%s)", thisEpoch-1, previousDag)
-						}
-						nextEpoch = thisEpoch + 1
-						dag, _ := dagFiles(nextEpoch)
-						if _, err := os.Stat(dag); os.IsNotExist(err) {
-							glog.V(logger.Info).Infof("Pregenerating DAG for epoch %d (%s)", nextEpoch, dag)
-							err := ethash.MakeDAG(nextEpoch*epochLength, "") // "" -> ethash.DefaultDir
-							if err != nil {
-								glog.V(logger.Error).Infof("Error generating DAG for epoch %d (%s)", nextEpoch, dag)
-								return
-							}
-						} else {
-							glog.V(logger.Error).Infof("DAG for epoch %d (%s)", nextEpoch, dag)
-						}
-					}
-				}
-				timer = time.After(autoDAGcheckInterval)
-			case <-self.autodagquit:
-				return
-			}
-		}
-	}()
-}
-
-// stopAutoDAG stops automatic DAG pregeneration by quitting the loop
-func (self *Ethereum) StopAutoDAG() {
-	if self.autodagquit != nil {
-		close(self.autodagquit)
-		self.autodagquit = nil
-	}
-	glog.V(logger.Info).Infof("Automatic pregeneration of ethash DAG OFF (ethash dir: %s)", ethash.DefaultDir)
-}
-
-// dagFiles(epoch) returns the two alternative DAG filenames (not a path)
-// 1) <revision>-<hex(seedhash[8])> 2) full-R<revision>-<hex(seedhash[8])>
-func dagFiles(epoch uint64) (string, string) {
-	seedHash, _ := ethash.GetSeedHash(epoch * epochLength)
-	dag := fmt.Sprintf("full-R%d-%x", ethashRevision, seedHash[:8])
-	return dag, "full-R" + dag
-}
@@ -0,0 +1,218 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/bitutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	// bloomConfirms is the number of confirmation blocks before a bloom section
+	// is considered final and gets indexed.
+	bloomConfirms = 256
+
+	// bloomThrottling is the time to wait between indexing two consecutive sections,
+	// so that the initial indexing of the chain doesn't hog the database.
+	bloomThrottling = 100 * time.Millisecond
+)
+
+// bloomIndexer generates the rotated bloom bit index of the canonical chain in
+// the background, one section at a time. Sections are keyed by the hash of their
+// last block, and are dropped from the index if a reorg removes that block from
+// the canonical chain.
+type bloomIndexer struct {
+	db          ethdb.Database
+	mux         *event.TypeMux
+	sectionSize uint64        // Number of blocks in a single section
+	confirms    uint64        // Number of confirmations before a section is indexed
+	throttling  time.Duration // Time to wait between indexing two sections
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newBloomIndexer creates a bloom bit indexer for the given chain database,
+// indexing new sections as chain head events are posted to the mux.
+func newBloomIndexer(db ethdb.Database, mux *event.TypeMux) *bloomIndexer {
+	return &bloomIndexer{
+		db:          db,
+		mux:         mux,
+		sectionSize: params.BloomBitsBlocks,
+		confirms:    bloomConfirms,
+		throttling:  bloomThrottling,
+		quit:        make(chan struct{}),
+	}
+}
+
+// Start begins indexing the chain in the background.
+func (b *bloomIndexer) Start() {
+	b.wg.Add(1)
+	go b.loop()
+}
+
+// Stop terminates the background indexing and waits for any section being
+// processed to be finished.
+func (b *bloomIndexer) Stop() {
+	close(b.quit)
+	b.wg.Wait()
+}
+
+// loop indexes a new section whenever the chain head changes, continuing with
+// the following ones after a short pause until the index is up to date.
+func (b *bloomIndexer) loop() {
+	defer b.wg.Done()
+
+	sub := b.mux.Subscribe(core.ChainHeadEvent{})
+	defer sub.Unsubscribe()
+
+	for {
+		var next <-chan time.Time
+		if b.update() {
+			next = time.After(b.throttling)
+		}
+		select {
+		case <-b.quit:
+			return
+		case _, ok := <-sub.Chan():
+			if !ok {
+				return
+			}
+		case <-next:
+		}
+	}
+}
+
+// update drops any sections invalidated by a reorg, then indexes the next section
+// if it's already confirmed. It returns whether further sections are waiting to
+// be indexed.
+func (b *bloomIndexer) update() bool {
+	head := core.GetHeadBlockHash(b.db)
+	number := core.GetBlockNumber(b.db, head)
+	if head == (common.Hash{}) || number+1 < b.confirms {
+		return false
+	}
+	// Roll back the sections whose heads are not canonical any more
+	sections := core.GetBloomSections(b.db)
+	for valid := sections; ; valid-- {
+		if valid == 0 || core.GetCanonicalHash(b.db, valid*b.sectionSize-1) == core.GetBloomSectionHead(b.db, valid-1) {
+			if valid != sections {
+				glog.V(logger.Info).Infof("Bloom index rolled back from %d to %d sections", sections, valid)
+				core.WriteBloomSections(b.db, valid)
+				sections = valid
+			}
+			break
+		}
+	}
+	// Index the next section if all its blocks are confirmed
+	confirmed := (number + 1 - b.confirms) / b.sectionSize
+	if sections >= confirmed {
+		return false
+	}
+	start := time.Now()
+	if err := b.processSection(sections); err != nil {
+		glog.V(logger.Debug).Infof("Failed to index bloom section %d: %v", sections, err)
+		return false
+	}
+	glog.V(logger.Detail).Infof("Indexed bloom section %d in %v", sections, time.Since(start))
+
+	return sections+1 < confirmed
+}
+
+// processSection generates the bloom bit vectors of a section from the canonical
+// headers and writes them, together with the updated section count, into the
+// database.
+func (b *bloomIndexer) processSection(section uint64) error {
+	gen, err := bloombits.NewGenerator(uint(b.sectionSize))
+	if err != nil {
+		return err
+	}
+	var parent common.Hash
+	if section > 0 {
+		parent = core.GetBloomSectionHead(b.db, section-1)
+	}
+	for i := uint64(0); i < b.sectionSize; i++ {
+		number := section*b.sectionSize + i
+
+		hash := core.GetCanonicalHash(b.db, number)
+		header := core.GetHeader(b.db, hash, number)
+		if header == nil {
+			return fmt.Errorf("canonical block #%d unknown", number)
+		}
+		// Make sure a reorg didn't happen in the middle of the section
+		if number > 0 && header.ParentHash != parent {
+			return fmt.Errorf("canonical chain changed at block #%d", number)
+		}
+		if err := gen.AddBloom(uint(i), header.Bloom); err != nil {
+			return err
+		}
+		parent = hash
+	}
+	batch := b.db.NewBatch()
+	for bit := uint(0); bit < types.BloomBitLength; bit++ {
+		bits, err := gen.Bitset(bit)
+		if err != nil {
+			return err
+		}
+		core.WriteBloomBits(batch, bit, section, parent, bitutil.CompressBytes(bits))
+	}
+	core.WriteBloomSectionHead(batch, section, parent)
+	core.WriteBloomSections(batch, section+1)
+
+	return batch.Write()
+}
+
+// status returns the number of blocks in a section and the number of sections
+// indexed from the genesis block.
+func (b *bloomIndexer) status() (uint64, uint64) {
+	return b.sectionSize, core.GetBloomSections(b.db)
+}
+
+// bloomBits retrieves and decompresses the bit vectors of the requested bloom
+// bits in an indexed section.
+func (b *bloomIndexer) bloomBits(bits []uint, section uint64) ([][]byte, error) {
+	if section >= core.GetBloomSections(b.db) {
+		return nil, fmt.Errorf("bloom section %d not indexed", section)
+	}
+	head := core.GetBloomSectionHead(b.db, section)
+
+	vectors := make([][]byte, len(bits))
+	for i, bit := range bits {
+		compressed, err := core.GetBloomBits(b.db, bit, section, head)
+		if err != nil {
+			return nil, fmt.Errorf("bloom bit %d of section %d missing: %v", bit, section, err)
+		}
+		vector, err := bitutil.DecompressBytes(compressed, int(b.sectionSize/8))
+		if err != nil {
+			return nil, fmt.Errorf("bloom bit %d of section %d corrupted: %v", bit, section, err)
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// writeTestChain stores the given blocks as the canonical chain, without any
+// validation so that the header blooms can contain arbitrary logs.
+func writeTestChain(t *testing.T, db ethdb.Database, blocks []*types.Block) {
+	for _, block := range blocks {
+		if err := core.WriteBlock(db, block); err != nil {
+			t.Fatalf("failed to write block #%d: %v", block.NumberU64(), err)
+		}
+		core.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		core.WriteHeadBlockHash(db, block.Hash())
+	}
+}
+
+// Tests that the bloom indexer only indexes confirmed sections, that the index
+// can be used to find the blocks containing logs, and that sections are indexed
+// again after a reorg.
+func TestBloomIndexer(t *testing.T) {
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		genesis = core.WriteGenesisBlockForTesting(db)
+		addr    = common.HexToAddress("0x01")
+	)
+	// Create a chain with logs of the test address in every tenth block
+	chain, _ := core.GenerateChain(params.TestChainConfig, genesis, db, 40, func(i int, gen *core.BlockGen) {
+		if i%10 == 3 {
+			receipt := types.NewReceipt(nil, new(big.Int))
+			receipt.Logs = []*types.Log{{Address: addr}}
+			gen.AddUncheckedReceipt(receipt)
+		}
+	})
+	writeTestChain(t, db, chain)
+
+	indexer := newBloomIndexer(db, new(event.TypeMux))
+	indexer.sectionSize, indexer.confirms = 16, 4
+
+	matcher := bloombits.NewMatcher(16, [][][]byte{{addr.Bytes()}})
+	check := func(sections uint64, want []uint64) {
+		for indexer.update() {
+		}
+		if _, have := indexer.status(); have != sections {
+			t.Fatalf("indexed section count mismatch: have %d, want %d", have, sections)
+		}
+		var blocks []uint64
+		for section := uint64(0); section < sections; section++ {
+			matches, err := matcher.Match(section, indexer.bloomBits)
+			if err != nil {
+				t.Fatalf("failed to match section %d: %v", section, err)
+			}
+			blocks = append(blocks, matches...)
+		}
+		if !reflect.DeepEqual(blocks, want) {
+			t.Fatalf("matching blocks mismatch: have %v, want %v", blocks, want)
+		}
+	}
+	// Blocks 0-36 are confirmed, so only the first two sections are indexed
+	check(2, []uint64{4, 14, 24})
+
+	// Reorg the chain from block 21 onwards to one without logs, and check that
+	// the second section is indexed again
+	fork, _ := core.GenerateChain(params.TestChainConfig, chain[19], db, 30, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(common.Address{1})
+	})
+	writeTestChain(t, db, fork)
+
+	check(2, []uint64{4, 14})
+	if _, err := indexer.bloomBits([]uint{0}, 2); err == nil {
+		t.Fatalf("unindexed section retrieved")
+	}
+}
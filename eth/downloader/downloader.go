@@ -30,6 +30,7 @@ import (
 
 	ethereum "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
@@ -64,12 +65,17 @@ var (
 	maxHeadersProcess = 2048      // Number of header download results to import at once into the chain
 	maxResultsProcess = 2048      // Number of content download results to import at once into the chain
 
-	fsHeaderCheckFrequency = 100        // Verification frequency of the downloaded headers during fast sync
-	fsHeaderSafetyNet      = 2048       // Number of headers to discard in case a chain violation is detected
-	fsHeaderForceVerify    = 24         // Number of headers to verify before and after the pivot to accept it
-	fsPivotInterval        = 256        // Number of headers out of which to randomize the pivot point
-	fsMinFullBlocks        = 64         // Number of blocks to retrieve fully even in fast sync
-	fsCriticalTrials       = uint32(32) // Number of times to retry in the cricical section before bailing
+	fsHeaderCheckFrequency = 100             // Verification frequency of the downloaded headers during fast sync
+	fsHeaderSafetyNet      = 2048            // Number of headers to discard in case a chain violation is detected
+	fsHeaderForceVerify    = 24              // Number of headers to verify before and after the pivot to accept it
+	fsPivotInterval        = 256             // Number of headers out of which to randomize the pivot point
+	fsMinFullBlocks        = 64              // Number of blocks to retrieve fully even in fast sync
+	fsCriticalTrials       = uint32(32)      // Number of times to retry in the cricical section before bailing
+	fsPivotReuseLimit      = 8192            // Maximum number of blocks a checkpointed pivot may lag behind the head to be reused
+	fsPivotMoveDistance    = 1024            // Number of blocks the head may advance past the pivot before moving it forward
+	fsCheckpointInterval   = time.Minute     // Time interval between persisting the state sync progress
+	fsCheckpointMaxNodes   = 16384           // Maximum number of uncommitted state entries to persist in a checkpoint
+	fsHeaderContCheck      = 3 * time.Second // Time interval to check for new headers while the pivot state downloads
 )
 
 var (
@@ -101,8 +107,13 @@ type Downloader struct {
 	mode SyncMode       // Synchronisation mode defining the strategy used (per sync cycle)
 	mux  *event.TypeMux // Event multiplexer to announce sync operation events
 
-	queue *queue   // Scheduler for selecting the hashes to download
-	peers *peerSet // Set of active peers from which download can proceed
+	queue   *queue         // Scheduler for selecting the hashes to download
+	peers   *peerSet       // Set of active peers from which download can proceed
+	stateDb ethdb.Database // Database to checkpoint the fast sync progress into
+
+	checkpoint     uint64       // Block number of the trusted checkpoint head to enforce (if any)
+	checkpointHash common.Hash  // Block hash of the trusted checkpoint head (zero = disabled)
+	checkpointLock sync.RWMutex // Lock protecting the trusted checkpoint
 
 	fsPivotLock  *types.Header // Pivot header on critical section entry (cannot change between retries)
 	fsPivotFails uint32        // Number of subsequent fast sync failures in the critical section
@@ -174,6 +185,7 @@ func New(mode SyncMode, stateDb ethdb.Database, mux *event.TypeMux, hasHeader he
 		mux:              mux,
 		queue:            newQueue(stateDb),
 		peers:            newPeerSet(),
+		stateDb:          stateDb,
 		rttEstimate:      uint64(rttMaxEstimate),
 		rttConfidence:    uint64(1000000),
 		hasHeader:        hasHeader,
@@ -238,6 +250,38 @@ func (d *Downloader) Progress() ethereum.SyncProgress {
 	}
 }
 
+// SetCheckpoint sets a trusted checkpoint that any synchronised chain must contain.
+// Peers feeding a chain with a different header at the checkpoint's number are
+// considered invalid and dropped. It is set by light clients, whose header chain
+// already starts at the checkpoint proven through the canonical hash trie; full
+// nodes don't use it. A checkpoint set mid-sync only takes effect from the next
+// synchronisation cycle.
+func (d *Downloader) SetCheckpoint(number uint64, hash common.Hash) {
+	d.checkpointLock.Lock()
+	defer d.checkpointLock.Unlock()
+
+	d.checkpoint = number
+	d.checkpointHash = hash
+}
+
+// SetPeerReputation sets the callback used to check whether a peer has a poor
+// reputation. Idle peers failing the check are only assigned download tasks if
+// no other idle peers are available.
+func (d *Downloader) SetPeerReputation(poor func(id string) bool) {
+	d.peers.lock.Lock()
+	defer d.peers.lock.Unlock()
+
+	d.peers.poor = poor
+}
+
+// trustedCheckpoint retrieves the number and hash of the trusted checkpoint head.
+func (d *Downloader) trustedCheckpoint() (uint64, common.Hash) {
+	d.checkpointLock.RLock()
+	defer d.checkpointLock.RUnlock()
+
+	return d.checkpoint, d.checkpointHash
+}
+
 // Synchronising returns whether the downloader is currently retrieving blocks.
 func (d *Downloader) Synchronising() bool {
 	return atomic.LoadInt32(&d.synchronising) > 0
@@ -411,6 +455,14 @@ func (d *Downloader) syncWithPeer(p *peer, hash common.Hash, td *big.Int) (err e
 	d.syncStatsChainHeight = height
 	d.syncStatsLock.Unlock()
 
+	// If the trusted checkpoint is ahead of the common ancestor, verify it before
+	// retrieving anything from the peer
+	checkpoint, checkpointHash := d.trustedCheckpoint()
+	if checkpointHash != (common.Hash{}) && origin < checkpoint && checkpoint <= height {
+		if err := d.fetchCheckpoint(p, checkpoint, checkpointHash); err != nil {
+			return err
+		}
+	}
 	// Initiate the sync using a concurrent header and content retrieval algorithm
 	pivot := uint64(0)
 	switch d.mode {
@@ -426,6 +478,15 @@ func (d *Downloader) syncWithPeer(p *peer, hash common.Hash, td *big.Int) (err e
 			if height > uint64(fsMinFullBlocks)+pivotOffset.Uint64() {
 				pivot = height - uint64(fsMinFullBlocks) - pivotOffset.Uint64()
 			}
+			// If an earlier sync was interrupted, reuse its pivot if still recent enough,
+			// carrying over the state entries it already retrieved if the root matches
+			if progress := core.GetFastSyncProgress(d.stateDb); progress != nil {
+				if progress.Pivot > 0 && progress.Pivot <= height && height-progress.Pivot <= uint64(fsPivotReuseLimit) {
+					glog.V(logger.Info).Infof("Resuming fast sync with pivot block #%d", progress.Pivot)
+					pivot = progress.Pivot
+					d.queue.RestoreState(progress.Root, progress.Nodes)
+				}
+			}
 		} else {
 			// Pivot point locked in, use this and do not pick a new one!
 			pivot = d.fsPivotLock.Number.Uint64()
@@ -481,6 +542,10 @@ func (d *Downloader) spawnSync(origin uint64, fetchers ...func() error) error {
 	d.cancel()
 	wg.Wait()
 
+	// Persist any state sync progress to resume from if the node is restarted
+	if d.mode == FastSync {
+		d.checkpointState()
+	}
 	// If sync failed in the critical section, bump the fail counter
 	if err != nil && d.mode == FastSync && d.fsPivotLock != nil {
 		atomic.AddUint32(&d.fsPivotFails, 1)
@@ -488,6 +553,27 @@ func (d *Downloader) spawnSync(origin uint64, fetchers ...func() error) error {
 	return err
 }
 
+// checkpointState persists the progress of a running state sync into the
+// database, allowing a restarted node to pick up where it left off. Only the
+// uncommitted entries closest to the state root are kept, bounding the size of
+// the checkpoint rewritten on every interval.
+func (d *Downloader) checkpointState() {
+	root, nodes, ok := d.queue.StateProgress(fsCheckpointMaxNodes)
+	if !ok {
+		return
+	}
+	progress := &core.FastSyncProgress{
+		Pivot: d.queue.FastSyncPivot(),
+		Root:  root,
+		Nodes: nodes,
+	}
+	if err := core.WriteFastSyncProgress(d.stateDb, progress); err != nil {
+		glog.V(logger.Warn).Infof("Failed to checkpoint fast sync progress: %v", err)
+		return
+	}
+	glog.V(logger.Debug).Infof("Checkpointed fast sync progress: pivot #%d, root %x…, %d entries", progress.Pivot, root[:4], len(nodes))
+}
+
 // cancel cancels all of the operations and resets the queue. It returns true
 // if the cancel operation was completed.
 func (d *Downloader) cancel() {
@@ -527,7 +613,7 @@ func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
 
 	// Request the advertised remote head block and wait for the response
 	head, _ := p.currentHead()
-	go p.getRelHeaders(head, 1, 0, false)
+	go p.RequestHeadersByHash(head, 1, 0, false)
 
 	timeout := time.After(d.requestTTL())
 	for {
@@ -561,6 +647,50 @@ func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
 	}
 }
 
+// fetchCheckpoint retrieves the header at the trusted checkpoint's number from
+// the remote peer and verifies that it's the trusted one, allowing a peer on a
+// different chain to be rejected before any of its data is downloaded.
+func (d *Downloader) fetchCheckpoint(p *peer, number uint64, hash common.Hash) error {
+	glog.V(logger.Debug).Infof("%v: verifying trusted checkpoint #%d", p, number)
+
+	go p.RequestHeadersByNumber(number, 1, 0, false)
+
+	timeout := time.After(d.requestTTL())
+	for {
+		select {
+		case <-d.cancelCh:
+			return errCancelHeaderFetch
+
+		case packet := <-d.headerCh:
+			// Discard anything not from the origin peer
+			if packet.PeerId() != p.id {
+				glog.V(logger.Debug).Infof("Received headers from incorrect peer(%s)", packet.PeerId())
+				break
+			}
+			// Make sure the peer actually gave something valid
+			headers := packet.(*headerPack).headers
+			if len(headers) != 1 || headers[0].Number.Uint64() != number {
+				glog.V(logger.Debug).Infof("%v: invalid checkpoint header reply: %d headers", p, len(headers))
+				return errBadPeer
+			}
+			if headers[0].Hash() != hash {
+				glog.V(logger.Warn).Infof("Checkpoint mismatch: have #%d [%x…], want [%x…]", number, headers[0].Hash().Bytes()[:4], hash[:4])
+				return errInvalidChain
+			}
+			return nil
+
+		case <-timeout:
+			glog.V(logger.Debug).Infof("%v: checkpoint header timeout", p)
+			return errTimeout
+
+		case <-d.bodyCh:
+		case <-d.stateCh:
+		case <-d.receiptCh:
+			// Out of bounds delivery, ignore
+		}
+	}
+}
+
 // findAncestor tries to locate the common ancestor link of the local chain and
 // a remote peers blockchain. In the general case when our node was in sync and
 // on the correct chain, checking the top N links should already get us a match.
@@ -594,7 +724,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 	if count > limit {
 		count = limit
 	}
-	go p.getAbsHeaders(uint64(from), count, 15, false)
+	go p.RequestHeadersByNumber(uint64(from), count, 15, false)
 
 	// Wait for the remote response to the head fetch
 	number, hash := uint64(0), common.Hash{}
@@ -673,7 +803,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 		check := (start + end) / 2
 
 		timeout := time.After(d.requestTTL())
-		go p.getAbsHeaders(uint64(check), 1, 0, false)
+		go p.RequestHeadersByNumber(uint64(check), 1, 0, false)
 
 		// Wait until a reply arrives to this request
 		for arrived := false; !arrived; {
@@ -752,10 +882,10 @@ func (d *Downloader) fetchHeaders(p *peer, from uint64) error {
 
 		if skeleton {
 			glog.V(logger.Detail).Infof("%v: fetching %d skeleton headers from #%d", p, MaxHeaderFetch, from)
-			go p.getAbsHeaders(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
+			go p.RequestHeadersByNumber(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
 		} else {
 			glog.V(logger.Detail).Infof("%v: fetching %d full headers from #%d", p, MaxHeaderFetch, from)
-			go p.getAbsHeaders(from, MaxHeaderFetch, 0, false)
+			go p.RequestHeadersByNumber(from, MaxHeaderFetch, 0, false)
 		}
 	}
 	// Start pulling the header chain skeleton until all is done
@@ -783,6 +913,18 @@ func (d *Downloader) fetchHeaders(p *peer, from uint64) error {
 			}
 			// If no more headers are inbound, notify the content fetchers and return
 			if packet.Items() == 0 {
+				// Don't abort header fetches while the pivot state downloads, the pivot
+				// might need to be moved forward if the chain progresses too much
+				if d.mode == FastSync && d.queue.FastSyncPivotPending() {
+					glog.V(logger.Detail).Infof("%v: no available headers, waiting for pivot state", p)
+					select {
+					case <-time.After(fsHeaderContCheck):
+						getHeaders(from)
+						continue
+					case <-d.cancelCh:
+						return errCancelHeaderFetch
+					}
+				}
 				glog.V(logger.Debug).Infof("%v: no available headers", p)
 				select {
 				case d.headerProcCh <- nil:
@@ -928,6 +1070,24 @@ func (d *Downloader) fetchReceipts(from uint64) error {
 func (d *Downloader) fetchNodeData() error {
 	glog.V(logger.Debug).Infof("Downloading node state data")
 
+	// Periodically checkpoint the state sync progress while downloading
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(fsCheckpointInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.checkpointState()
+			case <-done:
+				return
+			}
+		}
+	}()
+
 	var (
 		deliver = func(packet dataPack) (int, error) {
 			start := time.Now()
@@ -976,12 +1136,43 @@ func (d *Downloader) fetchNodeData() error {
 		capacity = func(p *peer) int { return p.NodeDataCapacity(d.requestRTT()) }
 		setIdle  = func(p *peer, accepted int) { p.SetNodeDataIdle(accepted) }
 	)
-	err := d.fetchParts(errCancelStateFetch, d.stateCh, deliver, d.stateWakeCh, expire,
-		d.queue.PendingNodeData, d.queue.InFlightNodeData, throttle, reserve, nil, fetch,
-		d.queue.CancelNodeData, capacity, d.peers.NodeDataIdlePeers, setIdle, "State")
+	for {
+		err := d.fetchParts(errCancelStateFetch, d.stateCh, deliver, d.stateWakeCh, expire,
+			d.queue.PendingNodeData, d.queue.InFlightNodeData, throttle, reserve, nil, fetch,
+			d.queue.CancelNodeData, capacity, d.peers.NodeDataIdlePeers, setIdle, "State")
 
-	glog.V(logger.Debug).Infof("Node state data download terminated: %v", err)
-	return err
+		// If none of the peers have the pivot state any more, try to move it forward
+		if err == errPeersUnavailable && d.mode == FastSync {
+			if head := d.headHeader().Number.Uint64(); head > uint64(fsMinFullBlocks) && d.movePivot(head-uint64(fsMinFullBlocks)) {
+				continue
+			}
+		}
+		glog.V(logger.Debug).Infof("Node state data download terminated: %v", err)
+		return err
+	}
+}
+
+// movePivot shifts the fast sync pivot forward to the given block, reusing all
+// the state already retrieved for the old pivot to heal into the new one. The
+// pivot is never moved if it was locked in by a critical section failure.
+func (d *Downloader) movePivot(pivot uint64) bool {
+	if d.fsPivotLock != nil {
+		return false
+	}
+	old := d.queue.FastSyncPivot()
+	if !d.queue.MovePivot(pivot) {
+		return false
+	}
+	glog.V(logger.Info).Infof("Moved fast sync pivot from #%d to #%d", old, pivot)
+
+	// Wake the receipt and state fetchers to pick up the new tasks
+	for _, ch := range []chan bool{d.receiptWakeCh, d.stateWakeCh} {
+		select {
+		case ch <- true:
+		default:
+		}
+	}
+	return true
 }
 
 // fetchParts iteratively downloads scheduled block parts, taking any available
@@ -1169,6 +1360,7 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 	// Calculate the pivoting point for switching from fast to slow sync
 	pivot := d.queue.FastSyncPivot()
+	checkpoint, checkpointHash := d.trustedCheckpoint()
 
 	// Keep a count of uncertain headers to roll back
 	rollback := []*types.Header{}
@@ -1198,6 +1390,7 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 				len(hashes), lastHeader, d.headHeader().Number, lastFastBlock, curFastBlock, lastBlock, curBlock)
 
 			// If we're already past the pivot point, this could be an attack, thread carefully
+			pivot := d.queue.FastSyncPivot() // Pivot might have moved since processing started
 			if rollback[len(rollback)-1].Number.Uint64() > pivot {
 				// If we didn't ever fail, lock in te pivot header (must! not! change!)
 				if atomic.LoadUint32(&d.fsPivotFails) == 0 {
@@ -1280,6 +1473,13 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 				}
 				chunk := headers[:limit]
 
+				// If the chunk contains the trusted checkpoint, make sure it's the same chain
+				if checkpointHash != (common.Hash{}) && chunk[0].Number.Uint64() <= checkpoint && chunk[len(chunk)-1].Number.Uint64() >= checkpoint {
+					if header := chunk[int(checkpoint-chunk[0].Number.Uint64())]; header.Hash() != checkpointHash {
+						glog.V(logger.Warn).Infof("Checkpoint mismatch: have #%v [%x…], want [%x…]", header.Number, header.Hash().Bytes()[:4], checkpointHash[:4])
+						return errInvalidChain
+					}
+				}
 				// In case of header only syncing, validate the chunk immediately
 				if d.mode == FastSync || d.mode == LightSync {
 					// Collect the yet unknown headers to mark them as uncertain
@@ -1331,6 +1531,14 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 						glog.V(logger.Debug).Infof("stale headers")
 						return errBadPeer
 					}
+					// If the head progressed too far past the pivot, its state might be
+					// pruned by the remote peers, move the pivot forward to a fresh block
+					if d.mode == FastSync {
+						head := chunk[len(chunk)-1].Number.Uint64()
+						if pivot := d.queue.FastSyncPivot(); pivot > 0 && head >= pivot+uint64(fsPivotMoveDistance) {
+							d.movePivot(head - uint64(fsMinFullBlocks))
+						}
+					}
 				}
 				headers = headers[limit:]
 				origin += uint64(limit)
@@ -1349,12 +1557,13 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 // processContent takes fetch results from the queue and tries to import them
 // into the chain. The type of import operation will depend on the result contents.
 func (d *Downloader) processContent() error {
-	pivot := d.queue.FastSyncPivot()
 	for {
 		results := d.queue.WaitResults()
 		if len(results) == 0 {
 			return nil // queue empty
 		}
+		// Retrieve the pivot after the results, as it is fixed only once delivered
+		pivot := d.queue.FastSyncPivot()
 		if d.chainInsertHook != nil {
 			d.chainInsertHook(results)
 		}
@@ -1398,6 +1607,9 @@ func (d *Downloader) processContent() error {
 				if err == nil && blocks[len(blocks)-1].NumberU64() == pivot {
 					glog.V(logger.Debug).Infof("Committing block #%d [%x…] as the new head", blocks[len(blocks)-1].Number(), blocks[len(blocks)-1].Hash().Bytes()[:4])
 					index, err = len(blocks)-1, d.commitHeadBlock(blocks[len(blocks)-1].Hash())
+					if err == nil {
+						core.DeleteFastSyncProgress(d.stateDb)
+					}
 				}
 			default:
 				index, err = d.insertBlocks(blocks)
@@ -1413,28 +1625,36 @@ func (d *Downloader) processContent() error {
 }
 
 // DeliverHeaders injects a new batch of block headers received from a remote
-// node into the download schedule.
-func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) (err error) {
-	return d.deliver(id, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter)
+// node into the download schedule. The request ID is that of the request being
+// answered, or zero if the protocol does not support request IDs.
+func (d *Downloader) DeliverHeaders(id string, reqID uint64, headers []*types.Header) (err error) {
+	pending := func(p *peer) *uint64 { return &p.headerReq }
+	return d.deliver(id, reqID, pending, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter)
 }
 
 // DeliverBodies injects a new batch of block bodies received from a remote node.
-func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
-	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter)
+func (d *Downloader) DeliverBodies(id string, reqID uint64, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
+	pending := func(p *peer) *uint64 { return &p.blockReq }
+	return d.deliver(id, reqID, pending, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter)
 }
 
 // DeliverReceipts injects a new batch of receipts received from a remote node.
-func (d *Downloader) DeliverReceipts(id string, receipts [][]*types.Receipt) (err error) {
-	return d.deliver(id, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter)
+func (d *Downloader) DeliverReceipts(id string, reqID uint64, receipts [][]*types.Receipt) (err error) {
+	pending := func(p *peer) *uint64 { return &p.receiptReq }
+	return d.deliver(id, reqID, pending, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter)
 }
 
 // DeliverNodeData injects a new batch of node state data received from a remote node.
-func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
-	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
+func (d *Downloader) DeliverNodeData(id string, reqID uint64, data [][]byte) (err error) {
+	pending := func(p *peer) *uint64 { return &p.stateReq }
+	return d.deliver(id, reqID, pending, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
-// deliver injects a new batch of data received from a remote node.
-func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
+// deliver injects a new batch of data received from a remote node. If the data
+// is tagged with a request ID, it is only accepted if it answers the request the
+// peer currently has pending, anything else being a late reply to an abandoned
+// (timed out or superseded) request.
+func (d *Downloader) deliver(id string, reqID uint64, pending func(*peer) *uint64, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
 	inMeter.Mark(int64(packet.Items()))
 	defer func() {
@@ -1442,6 +1662,12 @@ func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, i
 			dropMeter.Mark(int64(packet.Items()))
 		}
 	}()
+	// Discard replies to requests that are not pending any more
+	if reqID != 0 {
+		if p := d.peers.Peer(id); p == nil || atomic.LoadUint64(pending(p)) != reqID {
+			return errStaleDelivery
+		}
+	}
 	// Deliver or abort if the sync is canceled while queuing
 	d.cancelLock.RLock()
 	cancel := d.cancelCh
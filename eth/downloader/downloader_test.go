@@ -46,6 +46,7 @@ func init() {
 	MaxForkAncestry = uint64(10000)
 	blockCacheLimit = 1024
 	fsCriticalTrials = 10
+	fsHeaderContCheck = 500 * time.Millisecond
 }
 
 // downloadTester is a test simulator for mocking out local block chain.
@@ -485,8 +486,8 @@ func (dl *downloadTester) peerCurrentHeadFn(id string) func() (common.Hash, *big
 // peerGetRelHeadersFn constructs a GetBlockHeaders function based on a hashed
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
-func (dl *downloadTester) peerGetRelHeadersFn(id string, delay time.Duration) func(common.Hash, int, int, bool) error {
-	return func(origin common.Hash, amount int, skip int, reverse bool) error {
+func (dl *downloadTester) peerGetRelHeadersFn(id string, delay time.Duration) func(uint64, common.Hash, int, int, bool) error {
+	return func(reqID uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 		// Find the canonical number of the hash
 		dl.lock.RLock()
 		number := uint64(0)
@@ -499,15 +500,15 @@ func (dl *downloadTester) peerGetRelHeadersFn(id string, delay time.Duration) fu
 		dl.lock.RUnlock()
 
 		// Use the absolute header fetcher to satisfy the query
-		return dl.peerGetAbsHeadersFn(id, delay)(number, amount, skip, reverse)
+		return dl.peerGetAbsHeadersFn(id, delay)(reqID, number, amount, skip, reverse)
 	}
 }
 
 // peerGetAbsHeadersFn constructs a GetBlockHeaders function based on a numbered
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
-func (dl *downloadTester) peerGetAbsHeadersFn(id string, delay time.Duration) func(uint64, int, int, bool) error {
-	return func(origin uint64, amount int, skip int, reverse bool) error {
+func (dl *downloadTester) peerGetAbsHeadersFn(id string, delay time.Duration) func(uint64, uint64, int, int, bool) error {
+	return func(reqID uint64, origin uint64, amount int, skip int, reverse bool) error {
 		time.Sleep(delay)
 
 		dl.lock.RLock()
@@ -525,7 +526,7 @@ func (dl *downloadTester) peerGetAbsHeadersFn(id string, delay time.Duration) fu
 		// Delay delivery a bit to allow attacks to unfold
 		go func() {
 			time.Sleep(time.Millisecond)
-			dl.downloader.DeliverHeaders(id, result)
+			dl.downloader.DeliverHeaders(id, reqID, result)
 		}()
 		return nil
 	}
@@ -534,8 +535,8 @@ func (dl *downloadTester) peerGetAbsHeadersFn(id string, delay time.Duration) fu
 // peerGetBodiesFn constructs a getBlockBodies method associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block bodies from the particularly requested peer.
-func (dl *downloadTester) peerGetBodiesFn(id string, delay time.Duration) func([]common.Hash) error {
-	return func(hashes []common.Hash) error {
+func (dl *downloadTester) peerGetBodiesFn(id string, delay time.Duration) func(uint64, []common.Hash) error {
+	return func(reqID uint64, hashes []common.Hash) error {
 		time.Sleep(delay)
 
 		dl.lock.RLock()
@@ -552,7 +553,7 @@ func (dl *downloadTester) peerGetBodiesFn(id string, delay time.Duration) func([
 				uncles = append(uncles, block.Uncles())
 			}
 		}
-		go dl.downloader.DeliverBodies(id, transactions, uncles)
+		go dl.downloader.DeliverBodies(id, reqID, transactions, uncles)
 
 		return nil
 	}
@@ -561,8 +562,8 @@ func (dl *downloadTester) peerGetBodiesFn(id string, delay time.Duration) func([
 // peerGetReceiptsFn constructs a getReceipts method associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block receipts from the particularly requested peer.
-func (dl *downloadTester) peerGetReceiptsFn(id string, delay time.Duration) func([]common.Hash) error {
-	return func(hashes []common.Hash) error {
+func (dl *downloadTester) peerGetReceiptsFn(id string, delay time.Duration) func(uint64, []common.Hash) error {
+	return func(reqID uint64, hashes []common.Hash) error {
 		time.Sleep(delay)
 
 		dl.lock.RLock()
@@ -576,7 +577,7 @@ func (dl *downloadTester) peerGetReceiptsFn(id string, delay time.Duration) func
 				results = append(results, receipt)
 			}
 		}
-		go dl.downloader.DeliverReceipts(id, results)
+		go dl.downloader.DeliverReceipts(id, reqID, results)
 
 		return nil
 	}
@@ -585,8 +586,8 @@ func (dl *downloadTester) peerGetReceiptsFn(id string, delay time.Duration) func
 // peerGetNodeDataFn constructs a getNodeData method associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of node state data from the particularly requested peer.
-func (dl *downloadTester) peerGetNodeDataFn(id string, delay time.Duration) func([]common.Hash) error {
-	return func(hashes []common.Hash) error {
+func (dl *downloadTester) peerGetNodeDataFn(id string, delay time.Duration) func(uint64, []common.Hash) error {
+	return func(reqID uint64, hashes []common.Hash) error {
 		time.Sleep(delay)
 
 		dl.lock.RLock()
@@ -600,7 +601,7 @@ func (dl *downloadTester) peerGetNodeDataFn(id string, delay time.Duration) func
 				}
 			}
 		}
-		go dl.downloader.DeliverNodeData(id, results)
+		go dl.downloader.DeliverNodeData(id, reqID, results)
 
 		return nil
 	}
@@ -927,10 +928,10 @@ func TestInactiveDownloader62(t *testing.T) {
 	defer tester.terminate()
 
 	// Check that neither block headers nor bodies are accepted
-	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverHeaders("bad peer", 0, []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", 0, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
 }
@@ -944,17 +945,48 @@ func TestInactiveDownloader63(t *testing.T) {
 	defer tester.terminate()
 
 	// Check that neither block headers nor bodies are accepted
-	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverHeaders("bad peer", 0, []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", 0, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverReceipts("bad peer", [][]*types.Receipt{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverReceipts("bad peer", 0, [][]*types.Receipt{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
 }
 
+// Tests that deliveries tagged with a request ID are only accepted if they answer
+// the request currently pending from the peer, while untagged ones are not checked.
+func TestRequestIDDeliveries(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	tester.newPeer("peer", 64, []common.Hash{tester.genesis.Hash()}, nil, nil, nil)
+	p := tester.downloader.peers.Peer("peer")
+	atomic.StoreUint64(&p.blockReq, 1)
+
+	// Deliveries answering other requests or coming from unknown peers are stale
+	if err := tester.downloader.DeliverBodies("peer", 2, [][]*types.Transaction{}, [][]*types.Header{}); err != errStaleDelivery {
+		t.Errorf("mismatching ID error mismatch: have %v, want %v", err, errStaleDelivery)
+	}
+	if err := tester.downloader.DeliverBodies("bad peer", 1, [][]*types.Transaction{}, [][]*types.Header{}); err != errStaleDelivery {
+		t.Errorf("unknown peer error mismatch: have %v, want %v", err, errStaleDelivery)
+	}
+	if err := tester.downloader.DeliverReceipts("peer", 1, [][]*types.Receipt{}); err != errStaleDelivery {
+		t.Errorf("unpending request error mismatch: have %v, want %v", err, errStaleDelivery)
+	}
+	// Deliveries answering the pending request or lacking IDs pass through
+	if err := tester.downloader.DeliverBodies("peer", 1, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+		t.Errorf("matching ID error mismatch: have %v, want %v", err, errNoSyncActive)
+	}
+	if err := tester.downloader.DeliverBodies("peer", 0, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+		t.Errorf("missing ID error mismatch: have %v, want %v", err, errNoSyncActive)
+	}
+}
+
 // Tests that a canceled download wipes all previously accumulated state.
 func TestCancel62(t *testing.T)      { testCancel(t, 62, FullSync) }
 func TestCancel63Full(t *testing.T)  { testCancel(t, 63, FullSync) }
@@ -1685,18 +1717,18 @@ func testDeliverHeadersHang(t *testing.T, protocol int, mode SyncMode) {
 		tester.newPeer("peer", protocol, hashes, headers, blocks, receipts)
 		// Whenever the downloader requests headers, flood it with
 		// a lot of unrequested header deliveries.
-		tester.downloader.peers.peers["peer"].getAbsHeaders = func(from uint64, count, skip int, reverse bool) error {
+		tester.downloader.peers.peers["peer"].getAbsHeaders = func(reqID uint64, from uint64, count, skip int, reverse bool) error {
 			deliveriesDone := make(chan struct{}, 500)
 			for i := 0; i < cap(deliveriesDone); i++ {
 				peer := fmt.Sprintf("fake-peer%d", i)
 				go func() {
-					tester.downloader.DeliverHeaders(peer, fakeHeads)
+					tester.downloader.DeliverHeaders(peer, 0, fakeHeads)
 					deliveriesDone <- struct{}{}
 				}()
 			}
 			// Deliver the actual requested headers.
 			impl := tester.peerGetAbsHeadersFn("peer", 0)
-			go impl(from, count, skip, reverse)
+			go impl(reqID, from, count, skip, reverse)
 			// None of the extra deliveries should block.
 			timeout := time.After(15 * time.Second)
 			for i := 0; i < cap(deliveriesDone); i++ {
@@ -1795,3 +1827,203 @@ func testFastCriticalRestarts(t *testing.T, protocol int, progress bool) {
 	// completed using a single mode of operation, whereas fast-then-slow can result
 	// in arbitrary intermediate state that's not cleanly verifiable.
 }
+
+// Tests that a checkpointed fast sync progress is picked up by a restarted sync,
+// reusing its pivot block, and that the checkpoint is removed once the pivot is
+// committed.
+func TestFastSyncCheckpointResume63(t *testing.T) { testFastSyncCheckpointResume(t, 63) }
+func TestFastSyncCheckpointResume64(t *testing.T) { testFastSyncCheckpointResume(t, 64) }
+
+func testFastSyncCheckpointResume(t *testing.T, protocol int) {
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a large enough blockchain to actually fast sync on
+	targetBlocks := fsMinFullBlocks + 2*fsPivotInterval - 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+	tester.newPeer("peer", protocol, hashes, headers, blocks, receipts)
+
+	// Checkpoint a pivot that no random selection could produce and sync
+	pivot := uint64(targetBlocks - fsMinFullBlocks - fsPivotInterval - 10)
+	core.WriteFastSyncProgress(tester.stateDb, &core.FastSyncProgress{Pivot: pivot})
+
+	if err := tester.sync("peer", nil, FastSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if have := tester.downloader.queue.FastSyncPivot(); have != pivot {
+		t.Fatalf("checkpointed pivot not reused: have %d, want %d", have, pivot)
+	}
+	if progress := core.GetFastSyncProgress(tester.stateDb); progress != nil {
+		t.Fatalf("fast sync checkpoint not removed after pivot commit: %+v", progress)
+	}
+	if headers := len(tester.ownHeaders); headers != targetBlocks+1 {
+		t.Fatalf("synchronised headers mismatch: have %v, want %v", headers, targetBlocks+1)
+	}
+	if receipts := len(tester.ownReceipts); receipts != int(pivot)+1 {
+		t.Fatalf("synchronised receipts mismatch: have %v, want %v", receipts, pivot+1)
+	}
+}
+
+// Tests that the state entries of a fast sync checkpoint are restored into the
+// resumed sync if they belong to the pivot's state root, and discarded otherwise.
+func TestFastSyncCheckpointRestore63(t *testing.T) { testFastSyncCheckpointRestore(t, 63) }
+func TestFastSyncCheckpointRestore64(t *testing.T) { testFastSyncCheckpointRestore(t, 64) }
+
+func testFastSyncCheckpointRestore(t *testing.T, protocol int) {
+	for _, matching := range []bool{true, false} {
+		tester := newTester()
+
+		// Create a large enough blockchain to actually fast sync on
+		targetBlocks := fsMinFullBlocks + 2*fsPivotInterval - 15
+		hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+		tester.newPeer("peer", protocol, hashes, headers, blocks, receipts)
+
+		// Track the state entries requested from the peer
+		var (
+			lock      sync.Mutex
+			requested = make(map[common.Hash]bool)
+			getStates = tester.peerGetNodeDataFn("peer", 0)
+		)
+		tester.downloader.peers.peers["peer"].getNodeData = func(reqID uint64, hashes []common.Hash) error {
+			lock.Lock()
+			for _, hash := range hashes {
+				requested[hash] = true
+			}
+			lock.Unlock()
+			return getStates(reqID, hashes)
+		}
+		// Checkpoint the root node of the pivot state, real or mislabeled, and sync
+		pivot := uint64(targetBlocks - fsMinFullBlocks - fsPivotInterval - 10)
+		root := headers[hashes[targetBlocks-int(pivot)]].Root
+		blob, err := tester.peerDb.Get(root.Bytes())
+		if err != nil {
+			t.Fatalf("failed to retrieve pivot state root: %v", err)
+		}
+		progress := &core.FastSyncProgress{Pivot: pivot, Root: root, Nodes: [][]byte{blob}}
+		if !matching {
+			progress.Root = common.Hash{0x01}
+		}
+		core.WriteFastSyncProgress(tester.stateDb, progress)
+
+		if err := tester.sync("peer", nil, FastSync); err != nil {
+			t.Fatalf("matching %v: failed to synchronise blocks: %v", matching, err)
+		}
+		lock.Lock()
+		if requested[root] == matching {
+			t.Errorf("matching %v: state root requested mismatch: have %v, want %v", matching, requested[root], !matching)
+		}
+		lock.Unlock()
+
+		// Make sure the pivot state is complete either way
+		if _, err := trie.NewSecure(root, tester.stateDb, 0); err != nil {
+			t.Fatalf("matching %v: pivot state incomplete: %v", matching, err)
+		}
+		tester.terminate()
+	}
+}
+
+// Tests that if the chain head progresses too far past the fast sync pivot, the
+// pivot is moved forward and the sync completes on the new one.
+func TestFastSyncPivotMove63(t *testing.T) { testFastSyncPivotMove(t, 63) }
+func TestFastSyncPivotMove64(t *testing.T) { testFastSyncPivotMove(t, 64) }
+
+func testFastSyncPivotMove(t *testing.T, protocol int) {
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a blockchain long enough to run past an old pivot
+	targetBlocks := fsPivotMoveDistance + fsPivotInterval
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+	tester.newPeer("peer", protocol, hashes, headers, blocks, receipts)
+
+	// Slow down state replies to allow the header chain to outrun the pivot
+	tester.downloader.peers.peers["peer"].getNodeData = tester.peerGetNodeDataFn("peer", 500*time.Millisecond)
+
+	// Force an old pivot through a checkpoint and sync
+	origin := uint64(2 * fsMinFullBlocks)
+	core.WriteFastSyncProgress(tester.stateDb, &core.FastSyncProgress{Pivot: origin})
+
+	if err := tester.sync("peer", nil, FastSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	pivot := tester.downloader.queue.FastSyncPivot()
+	if pivot < origin+uint64(fsPivotMoveDistance-fsMinFullBlocks) {
+		t.Fatalf("pivot not moved forward: have #%d, want at least #%d", pivot, origin+uint64(fsPivotMoveDistance-fsMinFullBlocks))
+	}
+	if headers := len(tester.ownHeaders); headers != targetBlocks+1 {
+		t.Fatalf("synchronised headers mismatch: have %v, want %v", headers, targetBlocks+1)
+	}
+	if blocks := len(tester.ownBlocks); blocks != targetBlocks+1 {
+		t.Fatalf("synchronised blocks mismatch: have %v, want %v", blocks, targetBlocks+1)
+	}
+	if receipts := len(tester.ownReceipts); receipts != int(pivot)+1 {
+		t.Fatalf("synchronised receipts mismatch: have %v, want %v", receipts, pivot+1)
+	}
+	// Make sure the state of the final pivot is complete
+	if _, err := trie.NewSecure(headers[hashes[targetBlocks-int(pivot)]].Root, tester.stateDb, 0); err != nil {
+		t.Fatalf("pivot state incomplete: %v", err)
+	}
+}
+
+// Tests that a trusted checkpoint is enforced during synchronisation, rejecting
+// any chain that doesn't contain it.
+func TestCheckpointEnforcement63Fast(t *testing.T)  { testCheckpointEnforcement(t, 63, FastSync) }
+func TestCheckpointEnforcement64Fast(t *testing.T)  { testCheckpointEnforcement(t, 64, FastSync) }
+func TestCheckpointEnforcement64Light(t *testing.T) { testCheckpointEnforcement(t, 64, LightSync) }
+
+func testCheckpointEnforcement(t *testing.T, protocol int, mode SyncMode) {
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create two forks of the chain, only one of them containing the checkpoint
+	common, fork := MaxHashFetch, 2*MaxHashFetch
+	hashesA, hashesB, headersA, headersB, blocksA, blocksB, receiptsA, receiptsB := tester.makeChainFork(common+fork, fork, tester.genesis, nil, true)
+
+	tester.newPeer("fork A", protocol, hashesA, headersA, blocksA, receiptsA)
+	tester.newPeer("fork B", protocol, hashesB, headersB, blocksB, receiptsB)
+
+	checkpoint := uint64(common + fork/2)
+	tester.downloader.SetCheckpoint(checkpoint, hashesA[len(hashesA)-1-int(checkpoint)])
+
+	// Synchronising with the wrong fork should fail before importing anything,
+	// the right one succeed
+	if err := tester.sync("fork B", nil, mode); err != errInvalidChain {
+		t.Fatalf("checkpoint violation error mismatch: have %v, want %v", err, errInvalidChain)
+	}
+	assertOwnChain(t, tester, 1)
+
+	if err := tester.sync("fork A", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if headers := len(tester.ownHeaders); headers != common+fork+1 {
+		t.Fatalf("synchronised headers mismatch: have %v, want %v", headers, common+fork+1)
+	}
+}
+
+// Tests that idle peers with a poor reputation are only offered after all the
+// other idle peers, regardless of their throughput.
+func TestIdlePeersReputation(t *testing.T) {
+	ps := newPeerSet()
+	ps.poor = func(id string) bool { return id == "poor" }
+
+	throughputs := map[string]float64{"poor": 100, "slow": 1, "fast": 10}
+	for id := range throughputs {
+		if err := ps.Register(newPeer(id, 63, nil, nil, nil, nil, nil, nil)); err != nil {
+			t.Fatalf("failed to register peer %s: %v", id, err)
+		}
+	}
+	for id, throughput := range throughputs {
+		ps.Peer(id).blockThroughput = throughput
+	}
+	idle, _ := ps.BodyIdlePeers()
+
+	want := []string{"fast", "slow", "poor"}
+	if len(idle) != len(want) {
+		t.Fatalf("idle peer count mismatch: have %d, want %d", len(idle), len(want))
+	}
+	for i, p := range idle {
+		if p.id != want[i] {
+			t.Errorf("idle peer %d mismatch: have %s, want %s", i, p.id, want[i])
+		}
+	}
+}
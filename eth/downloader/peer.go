@@ -41,12 +41,22 @@ const (
 // Head hash and total difficulty retriever for
 type currentHeadRetrievalFn func() (common.Hash, *big.Int)
 
-// Block header and body fetchers belonging to eth/62 and above
-type relativeHeaderFetcherFn func(common.Hash, int, int, bool) error
-type absoluteHeaderFetcherFn func(uint64, int, int, bool) error
-type blockBodyFetcherFn func([]common.Hash) error
-type receiptFetcherFn func([]common.Hash) error
-type stateFetcherFn func([]common.Hash) error
+// Block header and body fetchers belonging to eth/62 and above. The first parameter
+// is the downloader's ID of the request, which the responses need to be delivered
+// with from eth/66 onwards (older protocols deliver with a zero ID).
+type relativeHeaderFetcherFn func(uint64, common.Hash, int, int, bool) error
+type absoluteHeaderFetcherFn func(uint64, uint64, int, int, bool) error
+type blockBodyFetcherFn func(uint64, []common.Hash) error
+type receiptFetcherFn func(uint64, []common.Hash) error
+type stateFetcherFn func(uint64, []common.Hash) error
+
+// reqIDCounter is the source of the unique IDs of the downloader's requests.
+var reqIDCounter uint64
+
+// nextReqID generates a new, non-zero request ID.
+func nextReqID() uint64 {
+	return atomic.AddUint64(&reqIDCounter, 1)
+}
 
 var (
 	errAlreadyFetching   = errors.New("already fetching blocks from peer")
@@ -63,6 +73,11 @@ type peer struct {
 	receiptIdle int32 // Current receipt activity state of the peer (idle = 0, active = 1)
 	stateIdle   int32 // Current node data activity state of the peer (idle = 0, active = 1)
 
+	headerReq  uint64 // ID of the last header request sent to the peer
+	blockReq   uint64 // ID of the pending block (body) request (0 = none)
+	receiptReq uint64 // ID of the pending receipt request (0 = none)
+	stateReq   uint64 // ID of the pending node data request (0 = none)
+
 	headerThroughput  float64 // Number of headers measured to be retrievable per second
 	blockThroughput   float64 // Number of blocks (bodies) measured to be retrievable per second
 	receiptThroughput float64 // Number of receipts measured to be retrievable per second
@@ -142,7 +157,7 @@ func (p *peer) FetchHeaders(from uint64, count int) error {
 	p.headerStarted = time.Now()
 
 	// Issue the header retrieval request (absolut upwards without gaps)
-	go p.getAbsHeaders(from, count, 0, false)
+	go p.RequestHeadersByNumber(from, count, 0, false)
 
 	return nil
 }
@@ -164,7 +179,9 @@ func (p *peer) FetchBodies(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.getBlockBodies(hashes)
+	reqID := nextReqID()
+	atomic.StoreUint64(&p.blockReq, reqID)
+	go p.getBlockBodies(reqID, hashes)
 
 	return nil
 }
@@ -186,7 +203,9 @@ func (p *peer) FetchReceipts(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.getReceipts(hashes)
+	reqID := nextReqID()
+	atomic.StoreUint64(&p.receiptReq, reqID)
+	go p.getReceipts(reqID, hashes)
 
 	return nil
 }
@@ -208,11 +227,29 @@ func (p *peer) FetchNodeData(request *fetchRequest) error {
 	for hash := range request.Hashes {
 		hashes = append(hashes, hash)
 	}
-	go p.getNodeData(hashes)
+	reqID := nextReqID()
+	atomic.StoreUint64(&p.stateReq, reqID)
+	go p.getNodeData(reqID, hashes)
 
 	return nil
 }
 
+// RequestHeadersByHash sends a header retrieval request to the remote peer based
+// on the hash of an origin block, superseding any previous header request.
+func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+	reqID := nextReqID()
+	atomic.StoreUint64(&p.headerReq, reqID)
+	return p.getRelHeaders(reqID, origin, amount, skip, reverse)
+}
+
+// RequestHeadersByNumber sends a header retrieval request to the remote peer
+// based on the number of an origin block, superseding any previous header request.
+func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+	reqID := nextReqID()
+	atomic.StoreUint64(&p.headerReq, reqID)
+	return p.getAbsHeaders(reqID, origin, amount, skip, reverse)
+}
+
 // SetHeadersIdle sets the peer to idle, allowing it to execute new header retrieval
 // requests. Its estimated header retrieval throughput is updated with that measured
 // just now.
@@ -224,6 +261,7 @@ func (p *peer) SetHeadersIdle(delivered int) {
 // requests. Its estimated block retrieval throughput is updated with that measured
 // just now.
 func (p *peer) SetBlocksIdle(delivered int) {
+	atomic.StoreUint64(&p.blockReq, 0)
 	p.setIdle(p.blockStarted, delivered, &p.blockThroughput, &p.blockIdle)
 }
 
@@ -231,6 +269,7 @@ func (p *peer) SetBlocksIdle(delivered int) {
 // requests. Its estimated body retrieval throughput is updated with that measured
 // just now.
 func (p *peer) SetBodiesIdle(delivered int) {
+	atomic.StoreUint64(&p.blockReq, 0)
 	p.setIdle(p.blockStarted, delivered, &p.blockThroughput, &p.blockIdle)
 }
 
@@ -238,6 +277,7 @@ func (p *peer) SetBodiesIdle(delivered int) {
 // retrieval requests. Its estimated receipt retrieval throughput is updated
 // with that measured just now.
 func (p *peer) SetReceiptsIdle(delivered int) {
+	atomic.StoreUint64(&p.receiptReq, 0)
 	p.setIdle(p.receiptStarted, delivered, &p.receiptThroughput, &p.receiptIdle)
 }
 
@@ -245,6 +285,7 @@ func (p *peer) SetReceiptsIdle(delivered int) {
 // data retrieval requests. Its estimated state retrieval throughput is updated
 // with that measured just now.
 func (p *peer) SetNodeDataIdle(delivered int) {
+	atomic.StoreUint64(&p.stateReq, 0)
 	p.setIdle(p.stateStarted, delivered, &p.stateThroughput, &p.stateIdle)
 }
 
@@ -351,6 +392,7 @@ func (p *peer) String() string {
 // download procedure.
 type peerSet struct {
 	peers map[string]*peer
+	poor  peerPoorFn // Optional reputation check to deprioritise poor peers
 	lock  sync.RWMutex
 }
 
@@ -508,7 +550,8 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peer, int) {
 
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
 // protocol version constraints, using the provided function to check idleness.
-// The resulting set of peers are sorted by their measure throughput.
+// The resulting set of peers are sorted by their measure throughput, with peers
+// of poor reputation moved to the end, so they are only used as a last resort.
 func (ps *peerSet) idlePeers(minProtocol, maxProtocol int, idleCheck func(*peer) bool, throughput func(*peer) float64) ([]*peer, int) {
 	ps.lock.RLock()
 	defer ps.lock.RUnlock()
@@ -522,8 +565,20 @@ func (ps *peerSet) idlePeers(minProtocol, maxProtocol int, idleCheck func(*peer)
 			total++
 		}
 	}
+	poor := make(map[*peer]bool, len(idle))
+	if ps.poor != nil {
+		for _, p := range idle {
+			poor[p] = ps.poor(p.id)
+		}
+	}
 	for i := 0; i < len(idle); i++ {
 		for j := i + 1; j < len(idle); j++ {
+			if poor[idle[i]] != poor[idle[j]] {
+				if poor[idle[i]] {
+					idle[i], idle[j] = idle[j], idle[i]
+				}
+				continue
+			}
 			if throughput(idle[i]) < throughput(idle[j]) {
 				idle[i], idle[j] = idle[j], idle[i]
 			}
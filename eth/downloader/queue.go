@@ -101,10 +101,13 @@ type queue struct {
 	stateTaskQueue *prque.Prque             // [eth/63] Priority queue of the hashes to fetch the node data for
 	statePendPool  map[string]*fetchRequest // [eth/63] Currently pending node data retrieval operations
 
-	stateDatabase   ethdb.Database   // [eth/63] Trie database to populate during state reassembly
-	stateScheduler  *state.StateSync // [eth/63] State trie synchronisation scheduler and integrator
-	stateProcessors int32            // [eth/63] Number of currently running state processors
-	stateSchedLock  sync.RWMutex     // [eth/63] Lock serialising access to the state scheduler
+	stateDatabase    ethdb.Database   // [eth/63] Trie database to populate during state reassembly
+	stateScheduler   *state.StateSync // [eth/63] State trie synchronisation scheduler and integrator
+	stateRoot        common.Hash      // [eth/63] Root hash of the state trie currently being synchronised
+	stateRestore     [][]byte         // [eth/63] Checkpointed state entries to reinject into the next scheduler
+	stateRestoreRoot common.Hash      // [eth/63] Root hash of the state trie the checkpointed entries belong to
+	stateProcessors  int32            // [eth/63] Number of currently running state processors
+	stateSchedLock   sync.RWMutex     // [eth/63] Lock serialising access to the state scheduler
 
 	resultCache  []*fetchResult // Downloaded but not yet delivered fetch results
 	resultOffset uint64         // Offset of the first cached fetch result in the block chain
@@ -169,6 +172,9 @@ func (q *queue) Reset() {
 	q.stateTaskQueue.Reset()
 	q.statePendPool = make(map[string]*fetchRequest)
 	q.stateScheduler = nil
+	q.stateRoot = common.Hash{}
+	q.stateRestore = nil
+	q.stateRestoreRoot = common.Hash{}
 
 	q.resultCache = make([]*fetchResult, blockCacheLimit)
 	q.resultOffset = 0
@@ -273,6 +279,85 @@ func (q *queue) Idle() bool {
 	return (queued + pending + cached) == 0
 }
 
+// StateProgress retrieves the root hash of the state trie currently being
+// synchronised, along with up to limit retrieved, but not yet committed state
+// entries. If there is no state sync in progress, false is returned.
+func (q *queue) StateProgress(limit int) (common.Hash, [][]byte, bool) {
+	q.stateSchedLock.RLock()
+	defer q.stateSchedLock.RUnlock()
+
+	if q.stateScheduler == nil || q.stateScheduler.Pending() == 0 {
+		return common.Hash{}, nil, false
+	}
+	return q.stateRoot, q.stateScheduler.Uncommitted(limit), true
+}
+
+// RestoreState sets the checkpointed state entries of an earlier sync of the
+// given root to be reinjected once this sync switches to the same root. They
+// are never used for any other root, and dropped when the queue is reset.
+func (q *queue) RestoreState(root common.Hash, nodes [][]byte) {
+	q.stateSchedLock.Lock()
+	defer q.stateSchedLock.Unlock()
+
+	q.stateRestoreRoot, q.stateRestore = root, nodes
+}
+
+// FastSyncPivotPending reports whether the fast sync pivot block is yet to be
+// delivered for import, i.e. whether its state is still being retrieved and the
+// pivot may still be moved.
+func (q *queue) FastSyncPivotPending() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.mode == FastSync && q.fastSyncPivot > 0 && q.resultOffset <= q.fastSyncPivot
+}
+
+// MovePivot shifts the fast sync pivot point forward to a newer, already scheduled
+// block. The receipts of all the blocks promoted into the fast phase are scheduled
+// for retrieval and the state sync is switched over to the new pivot's root, with
+// all the state entries retrieved for the old pivot being reused for healing.
+//
+// False is returned if the pivot cannot be moved, either because it was already
+// delivered for import or because the new pivot header is not yet scheduled.
+func (q *queue) MovePivot(pivot uint64) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.mode != FastSync || q.fastSyncPivot == 0 || pivot <= q.fastSyncPivot || q.resultOffset > q.fastSyncPivot {
+		return false
+	}
+	// Gather all the scheduled headers between the old and the new pivot
+	headers := make(map[uint64]*types.Header)
+	for _, result := range q.resultCache {
+		if result != nil {
+			if number := result.Header.Number.Uint64(); number > q.fastSyncPivot && number <= pivot {
+				headers[number] = result.Header
+			}
+		}
+	}
+	for _, header := range q.blockTaskPool {
+		if number := header.Number.Uint64(); number > q.fastSyncPivot && number <= pivot {
+			headers[number] = header
+		}
+	}
+	head := headers[pivot]
+	if head == nil {
+		return false
+	}
+	// Schedule the receipts of the promoted blocks, expanding any existing results
+	for number, header := range headers {
+		if index := int(number) - int(q.resultOffset); index >= 0 && index < len(q.resultCache) && q.resultCache[index] != nil {
+			q.resultCache[index].Pending++
+		}
+		q.receiptTaskPool[header.Hash()] = header
+		q.receiptTaskQueue.Push(header, -float32(number))
+	}
+	q.fastSyncPivot = pivot
+	q.switchState(head)
+
+	return true
+}
+
 // FastSyncPivot retrieves the currently used fast sync pivot point.
 func (q *queue) FastSyncPivot() uint64 {
 	q.lock.Lock()
@@ -389,18 +474,7 @@ func (q *queue) Schedule(headers []*types.Header, from uint64) []*types.Header {
 		}
 		if q.mode == FastSync && header.Number.Uint64() == q.fastSyncPivot {
 			// Pivoting point of the fast sync, switch the state retrieval to this
-			glog.V(logger.Debug).Infof("Switching state downloads to %d [%x…]", header.Number.Uint64(), header.Hash().Bytes()[:4])
-
-			q.stateTaskIndex = 0
-			q.stateTaskPool = make(map[common.Hash]int)
-			q.stateTaskQueue.Reset()
-			for _, req := range q.statePendPool {
-				req.Hashes = make(map[common.Hash]int) // Make sure executing requests fail, but don't disappear
-			}
-
-			q.stateSchedLock.Lock()
-			q.stateScheduler = state.NewStateSync(header.Root, q.stateDatabase)
-			q.stateSchedLock.Unlock()
+			q.switchState(header)
 		}
 		inserts = append(inserts, header)
 		q.headerHead = hash
@@ -1129,10 +1203,12 @@ func (q *queue) deliverNodeData(results []trie.SyncResult, callback func(int, bo
 		if err != nil {
 			q.stateSchedLock.Unlock()
 			callback(i, progressed, err)
+			return
 		}
 		if err = batch.Write(); err != nil {
 			q.stateSchedLock.Unlock()
 			callback(i, progressed, err)
+			return
 		}
 
 		// Item processing succeeded, release the lock (temporarily)
@@ -1157,6 +1233,65 @@ func (q *queue) Prepare(offset uint64, mode SyncMode, pivot uint64, head *types.
 
 	// If long running fast sync, also start up a head stateretrieval immediately
 	if mode == FastSync && pivot > 0 {
-		q.stateScheduler = state.NewStateSync(head.Root, q.stateDatabase)
+		q.stateSchedLock.Lock()
+		q.syncState(head.Root)
+		q.stateSchedLock.Unlock()
+	}
+}
+
+// switchState redirects the state retrieval to the state trie of a new pivot
+// header, failing any in-flight requests of the previous one.
+//
+// Note, this method expects the queue lock to be already held.
+func (q *queue) switchState(header *types.Header) {
+	glog.V(logger.Debug).Infof("Switching state downloads to %d [%x…]", header.Number.Uint64(), header.Hash().Bytes()[:4])
+
+	q.stateTaskIndex = 0
+	q.stateTaskPool = make(map[common.Hash]int)
+	q.stateTaskQueue.Reset()
+	for id, req := range q.statePendPool {
+		// Make sure executing requests fail, but don't disappear. The request is
+		// replaced instead of modified as its network fetch might still run.
+		stale := *req
+		stale.Hashes = make(map[common.Hash]int)
+		q.statePendPool[id] = &stale
+	}
+
+	q.stateSchedLock.Lock()
+	q.syncState(header.Root)
+	q.stateSchedLock.Unlock()
+}
+
+// syncState switches the state synchronisation to a new root. Any entries that
+// were already retrieved by the previous scheduler, or restored from an earlier
+// checkpoint of the same root, are reinjected into the new one to avoid
+// downloading them again.
+//
+// Note, this method expects the state scheduler lock to be already held.
+func (q *queue) syncState(root common.Hash) {
+	var nodes [][]byte
+	if q.stateRestore != nil && q.stateRestoreRoot == root {
+		nodes, q.stateRestore = q.stateRestore, nil
+	}
+	if q.stateScheduler != nil {
+		nodes = append(q.stateScheduler.Uncommitted(0), nodes...)
+	}
+	q.stateScheduler = state.NewStateSync(root, q.stateDatabase)
+	q.stateRoot = root
+
+	if len(nodes) == 0 {
+		return
+	}
+	// Entries committed during restoration must be flushed even on failure, as
+	// the scheduler already considers them done
+	batch := q.stateDatabase.NewBatch()
+	restored, err := q.stateScheduler.Restore(nodes, batch)
+	if werr := batch.Write(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		glog.V(logger.Warn).Infof("Failed to restore state entries for root %x…: %v", root[:4], err)
+		return
 	}
+	glog.V(logger.Info).Infof("Restored %d of %d retrieved state entries for root %x…", restored, len(nodes), root[:4])
 }
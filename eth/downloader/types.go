@@ -66,6 +66,9 @@ type chainRollbackFn func([]common.Hash)
 // peerDropFn is a callback type for dropping a peer detected as malicious.
 type peerDropFn func(id string)
 
+// peerPoorFn is a callback type for checking whether a peer has a poor reputation.
+type peerPoorFn func(id string) bool
+
 // dataPack is a data message returned by a peer for some query.
 type dataPack interface {
 	PeerId() string
@@ -14,7 +14,8 @@
 // You should have received a copy of the GNU Lesser General Public License
 // along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
 
-// Package fetcher contains the block announcement based synchronisation.
+// Package fetcher contains the block and transaction announcement based
+// synchronisation.
 package fetcher
 
 import (
@@ -69,6 +70,10 @@ type chainInsertFn func(types.Blocks) (int, error)
 // peerDropFn is a callback type for dropping a peer detected as malicious.
 type peerDropFn func(id string)
 
+// announceFailedFn is a callback type for reporting a peer that announced a block
+// but didn't deliver it when requested.
+type announceFailedFn func(id string)
+
 // announce is the hash notification of the availability of a new block in the
 // network.
 type announce struct {
@@ -136,6 +141,7 @@ type Fetcher struct {
 	chainHeight    chainHeightFn      // Retrieves the current chain's height
 	insertChain    chainInsertFn      // Injects a batch of blocks into the chain
 	dropPeer       peerDropFn         // Drops a peer for misbehaving
+	announceFailed announceFailedFn   // Reports a peer failing to deliver an announced block (optional)
 
 	// Testing hooks
 	announceChangeHook func(common.Hash, bool) // Method to call upon adding or deleting a hash from the announce list
@@ -172,6 +178,13 @@ func New(getBlock blockRetrievalFn, validateBlock blockValidatorFn, broadcastBlo
 	}
 }
 
+// SetAnnounceFailedHandler sets a callback to invoke whenever a peer fails to
+// deliver the header of a block it announced in time. It must be called before
+// the fetcher is started.
+func (f *Fetcher) SetAnnounceFailedHandler(fn announceFailedFn) {
+	f.announceFailed = fn
+}
+
 // Start boots up the announcement based synchroniser, accepting and processing
 // hash notifications and block fetches until termination requested.
 func (f *Fetcher) Start() {
@@ -285,6 +298,9 @@ func (f *Fetcher) loop() {
 		// Clean up any expired block fetches
 		for hash, announce := range f.fetching {
 			if time.Since(announce.time) > fetchTimeout {
+				if f.announceFailed != nil {
+					f.announceFailed(announce.origin)
+				}
 				f.forgetHash(hash)
 			}
 		}
@@ -40,4 +40,11 @@ var (
 	headerFilterOutMeter = metrics.NewMeter("eth/fetcher/filter/headers/out")
 	bodyFilterInMeter    = metrics.NewMeter("eth/fetcher/filter/bodies/in")
 	bodyFilterOutMeter   = metrics.NewMeter("eth/fetcher/filter/bodies/out")
+
+	txAnnounceInMeter    = metrics.NewMeter("eth/fetcher/prop/txannounces/in")
+	txAnnounceKnownMeter = metrics.NewMeter("eth/fetcher/prop/txannounces/known")
+	txAnnounceDOSMeter   = metrics.NewMeter("eth/fetcher/prop/txannounces/dos")
+
+	txFetchMeter        = metrics.NewMeter("eth/fetcher/fetch/txs")
+	txFetchTimeoutMeter = metrics.NewMeter("eth/fetcher/fetch/txs/timeout")
 )
@@ -0,0 +1,429 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+const (
+	txArriveTimeout = 500 * time.Millisecond // Time allowance before an announced transaction is explicitly requested
+	txGatherSlack   = 100 * time.Millisecond // Interval used to collate almost-expired announces with fetches
+	txFetchTimeout  = 5 * time.Second        // Maximum allotted time to return an explicitly requested transaction
+	maxTxAnnounces  = 4096                   // Maximum number of unique transactions a peer may have announced
+	maxTxRetrievals = 256                    // Maximum number of transactions to request from a peer at once
+)
+
+// txRetrievalFn is a callback type for checking whether a transaction is already
+// known locally.
+type txRetrievalFn func(common.Hash) bool
+
+// txRequesterFn is a callback type for sending a transaction retrieval request.
+type txRequesterFn func([]common.Hash) error
+
+// txInsertFn is a callback type to insert a batch of transactions into the pool.
+type txInsertFn func([]*types.Transaction) error
+
+// txAnnounce is the hash notification of the availability of a new transaction
+// in the network.
+type txAnnounce struct {
+	origin   string        // Identifier of the peer originating the notification
+	time     time.Time     // Timestamp of the announcement (or of the retrieval request)
+	fetchTxs txRequesterFn // Fetcher function to retrieve the announced transactions
+}
+
+// txNotify is a batch of transaction announcements from a single peer.
+type txNotify struct {
+	origin   string
+	hashes   []common.Hash
+	time     time.Time
+	fetchTxs txRequesterFn
+}
+
+// txDelivery is a batch of transactions that arrived from a remote peer, either
+// as a reply to an explicit request or via direct propagation.
+type txDelivery struct {
+	origin string
+	hashes []common.Hash
+	direct bool
+}
+
+// TxFetcher is responsible for accumulating transaction announcements from
+// various peers and scheduling them for retrieval. Each announced transaction
+// is requested from a single announcer only, falling back to the others if the
+// chosen peer does not deliver it in time.
+type TxFetcher struct {
+	// Various event channels
+	notify  chan *txNotify
+	cleanup chan *txDelivery
+	drop    chan string
+	quit    chan struct{}
+
+	// Announce states
+	announces  map[string]int                // Per peer announce counts to prevent memory exhaustion
+	announced  map[common.Hash][]*txAnnounce // Announced transactions, scheduled for fetching
+	fetching   map[common.Hash]*txAnnounce   // Announced transactions, currently fetching
+	alternates map[common.Hash][]*txAnnounce // Other announcers of transactions currently fetching
+
+	// Callbacks
+	hasTx  txRetrievalFn // Checks whether a transaction is already known locally
+	addTxs txInsertFn    // Injects a batch of transactions into the pool
+
+	// Testing hooks
+	fetchingHook func(string, []common.Hash) // Method to call upon starting a transaction retrieval
+}
+
+// NewTxFetcher creates a transaction fetcher to retrieve transactions based on
+// hash announcements.
+func NewTxFetcher(hasTx txRetrievalFn, addTxs txInsertFn) *TxFetcher {
+	return &TxFetcher{
+		notify:     make(chan *txNotify),
+		cleanup:    make(chan *txDelivery),
+		drop:       make(chan string),
+		quit:       make(chan struct{}),
+		announces:  make(map[string]int),
+		announced:  make(map[common.Hash][]*txAnnounce),
+		fetching:   make(map[common.Hash]*txAnnounce),
+		alternates: make(map[common.Hash][]*txAnnounce),
+		hasTx:      hasTx,
+		addTxs:     addTxs,
+	}
+}
+
+// Start boots up the announcement based transaction retriever, accepting and
+// processing hash notifications and transaction deliveries until termination.
+func (f *TxFetcher) Start() {
+	go f.loop()
+}
+
+// Stop terminates the announcement based transaction retriever, canceling all
+// pending operations.
+func (f *TxFetcher) Stop() {
+	close(f.quit)
+}
+
+// Notify announces the fetcher of the potential availability of a batch of new
+// transactions in the network.
+func (f *TxFetcher) Notify(peer string, hashes []common.Hash, time time.Time, fetchTxs txRequesterFn) error {
+	notification := &txNotify{
+		origin:   peer,
+		hashes:   hashes,
+		time:     time,
+		fetchTxs: fetchTxs,
+	}
+	select {
+	case f.notify <- notification:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Enqueue imports a batch of transactions delivered by a remote peer into the
+// pool, and marks them as no longer needing retrieval. The direct flag signals
+// whether the transactions were propagated by the peer (true) or are a reply to
+// a retrieval request (false), in which case anything missing from the batch is
+// considered unavailable at that peer.
+func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool) error {
+	// Import the transactions on the caller's thread to avoid blocking the fetcher
+	if err := f.addTxs(txs); err != nil {
+		glog.V(logger.Detail).Infof("Peer %s: failed to import some transactions: %v", peer, err)
+	}
+	// Notify the fetcher loop about the arrivals
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	select {
+	case f.cleanup <- &txDelivery{origin: peer, hashes: hashes, direct: direct}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Drop should be called when a peer disconnects. It cleans up all the internal
+// data structures of the given node and reschedules any of its pending requests.
+func (f *TxFetcher) Drop(peer string) error {
+	select {
+	case f.drop <- peer:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Loop is the main fetcher loop, checking and processing various notification
+// events.
+func (f *TxFetcher) loop() {
+	fetchTimer := time.NewTimer(0)
+	defer fetchTimer.Stop()
+
+	for {
+		select {
+		case <-f.quit:
+			// Fetcher terminating, abort all operations
+			return
+
+		case notification := <-f.notify:
+			// A batch of transactions was announced, make sure the peer isn't DOSing us
+			txAnnounceInMeter.Mark(int64(len(notification.hashes)))
+
+			count := f.announces[notification.origin]
+			for i, hash := range notification.hashes {
+				if count >= maxTxAnnounces {
+					glog.V(logger.Debug).Infof("Peer %s: exceeded outstanding transaction announces (%d)", notification.origin, maxTxAnnounces)
+					txAnnounceDOSMeter.Mark(int64(len(notification.hashes) - i))
+					break
+				}
+				// Skip any transactions we already know about
+				if f.hasTx(hash) {
+					txAnnounceKnownMeter.Mark(1)
+					continue
+				}
+				announce := &txAnnounce{
+					origin:   notification.origin,
+					time:     notification.time,
+					fetchTxs: notification.fetchTxs,
+				}
+				// If the transaction is already being retrieved, track as an alternate
+				if fetch := f.fetching[hash]; fetch != nil {
+					if fetch.origin == notification.origin || hasOrigin(f.alternates[hash], notification.origin) {
+						continue
+					}
+					f.alternates[hash] = append(f.alternates[hash], announce)
+					count++
+					continue
+				}
+				// Otherwise schedule the announce for retrieval
+				if hasOrigin(f.announced[hash], notification.origin) {
+					continue
+				}
+				f.announced[hash] = append(f.announced[hash], announce)
+				count++
+			}
+			if count > 0 {
+				f.announces[notification.origin] = count
+			}
+			f.rescheduleFetch(fetchTimer)
+
+		case delivery := <-f.cleanup:
+			// Transactions arrived, remove all traces of the delivered ones
+			delivered := make(map[common.Hash]struct{}, len(delivery.hashes))
+			for _, hash := range delivery.hashes {
+				delivered[hash] = struct{}{}
+				f.forgetHash(hash)
+			}
+			// If the batch was a reply, anything missing is unavailable at the peer
+			if !delivery.direct {
+				for hash, fetch := range f.fetching {
+					if _, ok := delivered[hash]; !ok && fetch.origin == delivery.origin {
+						f.rescheduleHash(hash)
+					}
+				}
+			}
+			f.rescheduleFetch(fetchTimer)
+
+		case peer := <-f.drop:
+			// A peer disconnected, reschedule all its pending retrievals
+			for hash, fetch := range f.fetching {
+				if fetch.origin == peer {
+					f.rescheduleHash(hash)
+				}
+			}
+			f.forgetOrigin(peer)
+			f.rescheduleFetch(fetchTimer)
+
+		case <-fetchTimer.C:
+			// Reschedule any retrievals that timed out
+			for hash, fetch := range f.fetching {
+				if time.Since(fetch.time) > txFetchTimeout {
+					glog.V(logger.Detail).Infof("Peer %s: transaction [%x…] retrieval timed out", fetch.origin, hash[:4])
+					txFetchTimeoutMeter.Mark(1)
+					f.rescheduleHash(hash)
+				}
+			}
+			// Gather all the expired announces, one request per idle peer at most
+			busy := make(map[string]bool)
+			for _, fetch := range f.fetching {
+				busy[fetch.origin] = true
+			}
+			request := make(map[string][]common.Hash)
+			for hash, announces := range f.announced {
+				if time.Since(announces[0].time) <= txArriveTimeout-txGatherSlack {
+					continue
+				}
+				// If the transaction arrived in the mean time, drop the announces
+				if f.hasTx(hash) {
+					f.forgetHash(hash)
+					continue
+				}
+				// Pick a random idle announcer to retrieve from, keep the others as backups
+				var idle []int
+				for i, announce := range announces {
+					if !busy[announce.origin] && len(request[announce.origin]) < maxTxRetrievals {
+						idle = append(idle, i)
+					}
+				}
+				if len(idle) == 0 {
+					continue
+				}
+				pick := idle[rand.Intn(len(idle))]
+				announce := announces[pick]
+
+				f.alternates[hash] = append(append([]*txAnnounce{}, announces[:pick]...), announces[pick+1:]...)
+				if len(f.alternates[hash]) == 0 {
+					delete(f.alternates, hash)
+				}
+				delete(f.announced, hash)
+
+				announce.time = time.Now()
+				f.fetching[hash] = announce
+				request[announce.origin] = append(request[announce.origin], hash)
+			}
+			// Send out all transaction requests
+			for peer, hashes := range request {
+				glog.V(logger.Detail).Infof("Peer %s: fetching %d transactions", peer, len(hashes))
+
+				// Create a closure of the fetch and schedule in on a new thread
+				peer, fetchTxs, hashes := peer, f.fetching[hashes[0]].fetchTxs, hashes
+				go func() {
+					if f.fetchingHook != nil {
+						f.fetchingHook(peer, hashes)
+					}
+					txFetchMeter.Mark(int64(len(hashes)))
+					fetchTxs(hashes)
+				}()
+			}
+			// Schedule the next fetch if transactions are still pending
+			f.rescheduleFetch(fetchTimer)
+		}
+	}
+}
+
+// rescheduleFetch resets the specified fetch timer to the next announce or
+// retrieval timeout.
+func (f *TxFetcher) rescheduleFetch(fetch *time.Timer) {
+	// Short circuit if no transactions are announced or fetching
+	if len(f.announced) == 0 && len(f.fetching) == 0 {
+		return
+	}
+	// Otherwise find the earliest expiring announcement or retrieval
+	earliest := time.Now().Add(time.Hour)
+	for _, announces := range f.announced {
+		if deadline := announces[0].time.Add(txArriveTimeout); earliest.After(deadline) {
+			earliest = deadline
+		}
+	}
+	for _, fetch := range f.fetching {
+		if deadline := fetch.time.Add(txFetchTimeout); earliest.After(deadline) {
+			earliest = deadline
+		}
+	}
+	// Announces waiting for a busy peer are overdue, don't spin on them
+	wait := earliest.Sub(time.Now())
+	if wait < txGatherSlack {
+		wait = txGatherSlack
+	}
+	fetch.Reset(wait)
+}
+
+// rescheduleHash abandons the current retrieval of a transaction, moving all
+// the alternate announcers back into the announce list for immediate retrieval.
+func (f *TxFetcher) rescheduleHash(hash common.Hash) {
+	if fetch := f.fetching[hash]; fetch != nil {
+		f.forgetAnnounce(fetch.origin)
+		delete(f.fetching, hash)
+	}
+	if alternates := f.alternates[hash]; len(alternates) > 0 {
+		f.announced[hash] = alternates
+	}
+	delete(f.alternates, hash)
+}
+
+// forgetHash removes all traces of a transaction announcement from the fetcher's
+// internal state.
+func (f *TxFetcher) forgetHash(hash common.Hash) {
+	for _, announce := range f.announced[hash] {
+		f.forgetAnnounce(announce.origin)
+	}
+	delete(f.announced, hash)
+
+	if fetch := f.fetching[hash]; fetch != nil {
+		f.forgetAnnounce(fetch.origin)
+		delete(f.fetching, hash)
+	}
+	for _, announce := range f.alternates[hash] {
+		f.forgetAnnounce(announce.origin)
+	}
+	delete(f.alternates, hash)
+}
+
+// forgetOrigin removes all the announcements of a peer from the fetcher's
+// internal state. Retrievals in flight from the peer must be rescheduled first.
+func (f *TxFetcher) forgetOrigin(peer string) {
+	for hash, announces := range f.announced {
+		if announces = dropOrigin(announces, peer); len(announces) > 0 {
+			f.announced[hash] = announces
+		} else {
+			delete(f.announced, hash)
+		}
+	}
+	for hash, announces := range f.alternates {
+		if announces = dropOrigin(announces, peer); len(announces) > 0 {
+			f.alternates[hash] = announces
+		} else {
+			delete(f.alternates, hash)
+		}
+	}
+	delete(f.announces, peer)
+}
+
+// forgetAnnounce decrements the DOS counter of a peer.
+func (f *TxFetcher) forgetAnnounce(peer string) {
+	f.announces[peer]--
+	if f.announces[peer] <= 0 {
+		delete(f.announces, peer)
+	}
+}
+
+// hasOrigin checks whether a list of announcements contains one from a peer.
+func hasOrigin(announces []*txAnnounce, peer string) bool {
+	for _, announce := range announces {
+		if announce.origin == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// dropOrigin filters out the announcements of a peer from a list.
+func dropOrigin(announces []*txAnnounce, peer string) []*txAnnounce {
+	filtered := announces[:0]
+	for _, announce := range announces {
+		if announce.origin != peer {
+			filtered = append(filtered, announce)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// makeTxs creates a batch of n distinct dummy transactions.
+func makeTxs(n int, seed byte) []*types.Transaction {
+	txs := make([]*types.Transaction, n)
+	for i := 0; i < n; i++ {
+		txs[i] = types.NewTransaction(uint64(i), common.Address{seed}, big.NewInt(1), big.NewInt(21000), big.NewInt(1), nil)
+	}
+	return txs
+}
+
+// txHashes extracts the hashes of a batch of transactions.
+func txHashes(txs []*types.Transaction) []common.Hash {
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	return hashes
+}
+
+// txFetcherTester is a test simulator for mocking out a local transaction pool
+// and the remote peers serving transactions.
+type txFetcherTester struct {
+	fetcher *TxFetcher
+
+	pool  map[common.Hash]*types.Transaction            // Transactions known locally
+	peers map[string]map[common.Hash]*types.Transaction // Transactions served by each remote peer
+	mute  map[string]bool                               // Peers not replying to requests at all
+
+	requests map[string]int // Number of transactions requested from each peer
+
+	lock sync.RWMutex
+}
+
+// newTxTester creates a new transaction fetcher test mocker.
+func newTxTester() *txFetcherTester {
+	tester := &txFetcherTester{
+		pool:     make(map[common.Hash]*types.Transaction),
+		peers:    make(map[string]map[common.Hash]*types.Transaction),
+		mute:     make(map[string]bool),
+		requests: make(map[string]int),
+	}
+	tester.fetcher = NewTxFetcher(tester.hasTx, tester.addTxs)
+	tester.fetcher.fetchingHook = func(peer string, hashes []common.Hash) {
+		tester.lock.Lock()
+		tester.requests[peer] += len(hashes)
+		tester.lock.Unlock()
+	}
+	tester.fetcher.Start()
+
+	return tester
+}
+
+// hasTx checks whether a transaction is known to the tester's pool.
+func (f *txFetcherTester) hasTx(hash common.Hash) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.pool[hash] != nil
+}
+
+// addTxs injects a batch of transactions into the tester's pool.
+func (f *txFetcherTester) addTxs(txs []*types.Transaction) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, tx := range txs {
+		f.pool[tx.Hash()] = tx
+	}
+	return nil
+}
+
+// makeTxFetcher retrieves a transaction fetcher associated with a simulated peer.
+func (f *txFetcherTester) makeTxFetcher(peer string, txs []*types.Transaction) txRequesterFn {
+	f.lock.Lock()
+	f.peers[peer] = make(map[common.Hash]*types.Transaction)
+	for _, tx := range txs {
+		f.peers[peer][tx.Hash()] = tx
+	}
+	f.lock.Unlock()
+
+	return func(hashes []common.Hash) error {
+		f.lock.RLock()
+		mute := f.mute[peer]
+		var found []*types.Transaction
+		for _, hash := range hashes {
+			if tx, ok := f.peers[peer][hash]; ok {
+				found = append(found, tx)
+			}
+		}
+		f.lock.RUnlock()
+
+		if !mute {
+			go f.fetcher.Enqueue(peer, found, false)
+		}
+		return nil
+	}
+}
+
+// requested returns the number of transactions requested from a peer.
+func (f *txFetcherTester) requested(peer string) int {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.requests[peer]
+}
+
+// waitPool waits until all the given transactions are in the pool, failing if
+// that doesn't happen within the timeout.
+func (f *txFetcherTester) waitPool(t *testing.T, txs []*types.Transaction, timeout time.Duration) {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(10 * time.Millisecond) {
+		missing := 0
+		for _, tx := range txs {
+			if !f.hasTx(tx.Hash()) {
+				missing++
+			}
+		}
+		if missing == 0 {
+			return
+		}
+	}
+	t.Fatalf("transactions not retrieved within %v", timeout)
+}
+
+// Tests that announced transactions are retrieved from the announcing peer.
+func TestTxFetcherAnnounceRetrieval(t *testing.T) {
+	tester := newTxTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(3, 0x01)
+	tester.fetcher.Notify("A", txHashes(txs), time.Now(), tester.makeTxFetcher("A", txs))
+
+	tester.waitPool(t, txs, time.Second)
+	if have := tester.requested("A"); have != len(txs) {
+		t.Fatalf("requested transaction count mismatch: have %d, want %d", have, len(txs))
+	}
+}
+
+// Tests that transactions already known locally, or arriving via direct
+// propagation before the retrieval deadline, are not requested.
+func TestTxFetcherKnownSkipped(t *testing.T) {
+	tester := newTxTester()
+	defer tester.fetcher.Stop()
+
+	known, propagated := makeTxs(2, 0x01), makeTxs(2, 0x02)
+	tester.addTxs(known)
+
+	all := append(append([]*types.Transaction{}, known...), propagated...)
+	tester.fetcher.Notify("A", txHashes(all), time.Now(), tester.makeTxFetcher("A", all))
+	tester.fetcher.Enqueue("B", propagated, true)
+
+	time.Sleep(2 * txArriveTimeout)
+	if have := tester.requested("A"); have != 0 {
+		t.Fatalf("known transactions requested: have %d, want %d", have, 0)
+	}
+}
+
+// Tests that if the chosen peer doesn't have an announced transaction, it is
+// retrieved from an alternate announcer.
+func TestTxFetcherUnavailableFallback(t *testing.T) {
+	tester := newTxTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(1, 0x01)
+	tester.fetcher.Notify("A", txHashes(txs), time.Now(), tester.makeTxFetcher("A", nil))
+	tester.fetcher.Notify("B", txHashes(txs), time.Now(), tester.makeTxFetcher("B", txs))
+
+	tester.waitPool(t, txs, time.Second)
+}
+
+// Tests that if the chosen peer disconnects during a retrieval, the transaction
+// is retrieved from an alternate announcer without waiting for a timeout.
+func TestTxFetcherDropFallback(t *testing.T) {
+	tester := newTxTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(1, 0x01)
+
+	tester.lock.Lock()
+	tester.mute["A"] = true
+	tester.lock.Unlock()
+
+	tester.fetcher.Notify("A", txHashes(txs), time.Now(), tester.makeTxFetcher("A", txs))
+	for start := time.Now(); tester.requested("A") == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("transaction not requested from first announcer")
+		}
+	}
+	tester.fetcher.Notify("B", txHashes(txs), time.Now(), tester.makeTxFetcher("B", txs))
+	tester.fetcher.Drop("A")
+
+	tester.waitPool(t, txs, txFetchTimeout/2)
+}
+
+// Tests that a peer is prevented from exhausting the fetcher's memory by
+// announcing too many transactions.
+func TestTxFetcherDOSProtection(t *testing.T) {
+	tester := newTxTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(maxTxAnnounces+64, 0x01)
+	tester.fetcher.Notify("A", txHashes(txs), time.Now(), tester.makeTxFetcher("A", nil))
+
+	for start := time.Now(); tester.requested("A") < maxTxAnnounces; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*txFetchTimeout {
+			t.Fatalf("announced transactions not requested: have %d, want %d", tester.requested("A"), maxTxAnnounces)
+		}
+	}
+	time.Sleep(2 * txArriveTimeout)
+	if have := tester.requested("A"); have != maxTxAnnounces {
+		t.Fatalf("requested transaction count mismatch: have %d, want %d", have, maxTxAnnounces)
+	}
+}
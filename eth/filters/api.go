@@ -28,16 +28,29 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
 var (
 	deadline = 5 * time.Minute // consider a filter inactive if it has not been polled for within deadline
+
+	// ErrReplayOverflow is returned if too many live logs arrive while replaying
+	// the history of a log subscription.
+	ErrReplayOverflow = errors.New("too many new logs while replaying the subscription history")
+
+	errResumeFromBlock = errors.New("resume block and starting block are mutually exclusive")
 )
 
+// maxQueuedLogs is the default maximum number of live logs queued up while the
+// history of a log subscription is being replayed.
+const maxQueuedLogs = 10000
+
 // filter is a helper struct that holds meta information over the filter type
 // and associated subscription in the event system.
 type filter struct {
@@ -53,24 +66,27 @@ type filter struct {
 // information related to the Ethereum protocol such als blocks, transactions and logs.
 type PublicFilterAPI struct {
 	backend   Backend
-	useMipMap bool
 	mux       *event.TypeMux
 	quit      chan struct{}
 	chainDb   ethdb.Database
 	events    *EventSystem
 	filtersMu sync.Mutex
 	filters   map[rpc.ID]*filter
+
+	maxBlockRange uint64 // Maximum number of blocks a log query may search (0 = unlimited)
+	maxResults    int    // Maximum number of logs a log query may return (0 = unlimited)
+	maxQueued     int    // Maximum number of live logs queued up during a subscription replay
 }
 
 // NewPublicFilterAPI returns a new PublicFilterAPI instance.
 func NewPublicFilterAPI(backend Backend, lightMode bool) *PublicFilterAPI {
 	api := &PublicFilterAPI{
 		backend:   backend,
-		useMipMap: !lightMode,
 		mux:       backend.EventMux(),
 		chainDb:   backend.ChainDb(),
 		events:    NewEventSystem(backend.EventMux(), backend, lightMode),
 		filters:   make(map[rpc.ID]*filter),
+		maxQueued: maxQueuedLogs,
 	}
 
 	go api.timeoutLoop()
@@ -78,6 +94,13 @@ func NewPublicFilterAPI(backend Backend, lightMode bool) *PublicFilterAPI {
 	return api
 }
 
+// SetLogLimits restricts the number of blocks a single log query may search and
+// the number of logs it may return. Zero values mean unlimited.
+func (api *PublicFilterAPI) SetLogLimits(maxBlockRange uint64, maxResults int) {
+	api.maxBlockRange = maxBlockRange
+	api.maxResults = maxResults
+}
+
 // timeoutLoop runs every 5 minutes and deletes filters that have not been recently used.
 // Tt is started when the api is created.
 func (api *PublicFilterAPI) timeoutLoop() {
@@ -167,6 +190,50 @@ func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Su
 	return rpcSub, nil
 }
 
+// rpcTxPoolEvent is the JSON representation of a transaction pool status change.
+type rpcTxPoolEvent struct {
+	Hash        common.Hash       `json:"hash"`
+	Kind        core.TxEventKind  `json:"kind"`
+	Reason      core.TxDropReason `json:"reason,omitempty"`
+	Replacement *common.Hash      `json:"replacement,omitempty"`
+}
+
+// TxpoolEvents creates a subscription that is triggered each time a transaction
+// enters the transaction pool, gets promoted to executable, is replaced by a
+// higher priced one or is dropped, along with the reason of the removal.
+func (api *PublicFilterAPI) TxpoolEvents(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan core.TxPoolEvent)
+		eventsSub := api.events.SubscribeTxPoolEvents(events)
+
+		for {
+			select {
+			case ev := <-events:
+				res := &rpcTxPoolEvent{Hash: ev.Tx.Hash(), Kind: ev.Kind, Reason: ev.Reason}
+				if ev.Replacement != (common.Hash{}) {
+					res.Replacement = &ev.Replacement
+				}
+				notifier.Notify(rpcSub.ID, res)
+			case <-rpcSub.Err():
+				eventsSub.Unsubscribe()
+				return
+			case <-notifier.Closed():
+				eventsSub.Unsubscribe()
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
 // NewBlockFilter creates a filter that fetches blocks that are imported into the chain.
 // It is part of the filter package since polling goes with eth_getFilterChanges.
 //
@@ -233,12 +300,36 @@ func (api *PublicFilterAPI) NewHeads(ctx context.Context) (*rpc.Subscription, er
 }
 
 // Logs creates a subscription that fires for all new log that match the given filter criteria.
+//
+// If a starting block is given, the matching logs from that block up to the current
+// head are sent first, followed by the new logs. Logs reverted by a chain
+// reorganisation are sent again with the removed property set to true, newest
+// block first, before the logs of the blocks replacing them.
+//
+// A dropped subscription can be resumed by passing the number and hash of the last
+// block the client processed instead of a starting block. If that block has been
+// reorged out of the chain meanwhile, the logs of the reverted blocks down to the
+// canonical ancestor are sent as removed before replaying the new ones. If the
+// block is unknown, ErrCursorReorged is returned.
 func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
 	if !supported {
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
 	}
 
+	// Refuse replays beyond the configured limits up front, with a clear error
+	if crit.Resume != nil {
+		if crit.FromBlock != nil {
+			return nil, errResumeFromBlock
+		}
+		if err := api.newFilter(crit.resumeCriteria()).checkBlockRange(ctx); err != nil {
+			return nil, err
+		}
+	} else if crit.FromBlock != nil && crit.FromBlock.Int64() >= 0 {
+		if err := api.newFilter(crit).checkBlockRange(ctx); err != nil {
+			return nil, err
+		}
+	}
 	var (
 		rpcSub      = notifier.CreateSubscription()
 		matchedLogs = make(chan []*types.Log)
@@ -250,32 +341,330 @@ func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*rpc
 	}
 
 	go func() {
-
-		for {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
 			select {
-			case logs := <-matchedLogs:
-				for _, log := range logs {
-					notifier.Notify(rpcSub.ID, &log)
-				}
 			case <-rpcSub.Err(): // client send an unsubscribe request
-				logsSub.Unsubscribe()
-				return
 			case <-notifier.Closed(): // connection dropped
-				logsSub.Unsubscribe()
-				return
+			case <-ctx.Done():
 			}
+			cancel()
+		}()
+		err := api.streamLogs(ctx, crit, matchedLogs, func(log *types.Log) {
+			notifier.Notify(rpcSub.ID, log)
+		})
+		if err != nil {
+			glog.V(logger.Debug).Infof("Log subscription %s failed: %v", rpcSub.ID, err)
 		}
+		cancel()
+		logsSub.Unsubscribe()
 	}()
 
 	return rpcSub, nil
 }
 
+// streamLogs sends the logs of a subscription to the client until the context is
+// cancelled. If the criteria contain a starting or resume block, the historical
+// logs up to the head are replayed first while the live ones are queued up, after
+// which the live logs of the replayed blocks are deduplicated against the
+// delivered ones. The subscription fails if too many live logs queue up.
+func (api *PublicFilterAPI) streamLogs(ctx context.Context, crit FilterCriteria, live <-chan []*types.Log, send func(*types.Log)) error {
+	var (
+		replay *logReplay // Replay of the historical logs, nil if not replaying
+		done   chan error // Result of the replay, nil if not replaying
+
+		head      = int64(-1)                  // Last block covered by the replay
+		queued    [][]*types.Log               // Live logs received during the replay
+		pending   int                          // Number of live logs queued up
+		delivered = make(map[common.Hash]bool) // Blocks up to the replay head sent to the client
+	)
+	if crit.Resume != nil || (crit.FromBlock != nil && crit.FromBlock.Int64() >= 0) {
+		replay = newLogReplay(api, crit)
+		done = make(chan error, 1)
+		go func() { done <- replay.run(ctx) }()
+	}
+	// deliver sends the live logs the client hasn't seen yet: new logs of replayed
+	// blocks are dropped, as are removals of blocks never sent during the replay.
+	deliver := func(logs []*types.Log) {
+		var added, removed []common.Hash
+		for _, log := range logs {
+			if int64(log.BlockNumber) <= head {
+				if log.Removed != delivered[log.BlockHash] {
+					continue
+				}
+				if log.Removed {
+					removed = append(removed, log.BlockHash)
+				} else {
+					added = append(added, log.BlockHash)
+				}
+			}
+			send(log)
+		}
+		for _, hash := range removed {
+			delete(delivered, hash)
+		}
+		for _, hash := range added {
+			delivered[hash] = true
+		}
+	}
+	var history <-chan []*types.Log
+	if replay != nil {
+		history = replay.history
+	}
+	for {
+		select {
+		case logs := <-live:
+			if done != nil {
+				if pending += len(logs); pending > api.maxQueued {
+					return ErrReplayOverflow
+				}
+				queued = append(queued, logs)
+				continue
+			}
+			deliver(logs)
+
+		case logs := <-history:
+			for _, log := range logs {
+				send(log)
+			}
+			if logs[0].Removed {
+				delete(delivered, logs[0].BlockHash)
+			} else {
+				delivered[logs[0].BlockHash] = true
+			}
+
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			head = replay.head
+			for _, logs := range queued {
+				deliver(logs)
+			}
+			history, done, queued, pending = nil, nil, nil, 0
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// replayedBlock is a block whose logs were sent during a replay.
+type replayedBlock struct {
+	number uint64
+	hash   common.Hash
+}
+
+// logReplay retrieves the historical logs of a subscription, making sure the
+// blocks sent form a single chain: if the chain is reorganised while replaying,
+// the logs of the replayed blocks reorged out are sent as removed, newest block
+// first, and the replay continues from the fork point. The replay is subject to
+// the same block range and result count limits as log queries.
+type logReplay struct {
+	api     *PublicFilterAPI
+	crit    FilterCriteria
+	history chan []*types.Log // Logs to send, all of a single block each
+
+	head  int64           // Last block covered by the replay, valid once done
+	sent  []replayedBlock // Blocks whose logs were sent, in ascending order
+	count int             // Number of matching logs sent
+}
+
+func newLogReplay(api *PublicFilterAPI, crit FilterCriteria) *logReplay {
+	return &logReplay{
+		api:     api,
+		crit:    crit,
+		history: make(chan []*types.Log),
+		head:    -1,
+	}
+}
+
+// run replays the logs up to the current head, rewinding and replaying again
+// for as long as the replayed blocks are reorged out before completion.
+func (r *logReplay) run(ctx context.Context) error {
+	from := uint64(0)
+	if r.crit.Resume != nil {
+		// Revert the logs of the resume block and its ancestors if reorged out
+		ancestor, err := r.ancestor(ctx, r.crit.Resume.Hash, uint64(r.crit.Resume.Number), func(header *types.Header) error {
+			return r.revert(ctx, header)
+		})
+		if err != nil {
+			return err
+		}
+		from = ancestor + 1
+	} else {
+		from = r.crit.FromBlock.Uint64()
+	}
+	for {
+		header, err := r.api.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+		if header == nil || err != nil {
+			return err
+		}
+		if err := r.replay(ctx, from, header.Number.Int64()); err != nil {
+			return err
+		}
+		r.head = header.Number.Int64()
+
+		// Find the lowest block that may differ from what was replayed
+		fork, reorged := uint64(0), false
+		if canon, err := r.canonical(ctx, header.Hash(), header.Number.Uint64()); err != nil {
+			return err
+		} else if !canon {
+			ancestor, err := r.ancestor(ctx, header.Hash(), header.Number.Uint64(), nil)
+			if err != nil {
+				return err
+			}
+			fork, reorged = ancestor+1, true
+		}
+		for _, block := range r.sent {
+			if reorged && block.number >= fork {
+				break
+			}
+			canon, err := r.canonical(ctx, block.hash, block.number)
+			if err != nil {
+				return err
+			}
+			if !canon {
+				ancestor, err := r.ancestor(ctx, block.hash, block.number, nil)
+				if err != nil {
+					return err
+				}
+				if !reorged || ancestor+1 < fork {
+					fork, reorged = ancestor+1, true
+				}
+				break
+			}
+		}
+		if !reorged {
+			return nil
+		}
+		// Revert the replayed blocks past the fork point and replay them again
+		for len(r.sent) > 0 && r.sent[len(r.sent)-1].number >= fork {
+			block := r.sent[len(r.sent)-1]
+			reverted := core.GetHeader(r.api.chainDb, block.hash, block.number)
+			if reverted == nil {
+				return ErrCursorReorged
+			}
+			if err := r.revert(ctx, reverted); err != nil {
+				return err
+			}
+			r.sent = r.sent[:len(r.sent)-1]
+		}
+		if from < fork {
+			from = fork
+		}
+	}
+}
+
+// replay sends the matching logs of the given block range one block at a time.
+func (r *logReplay) replay(ctx context.Context, from uint64, head int64) error {
+	end := head
+	if r.crit.ToBlock != nil && r.crit.ToBlock.Int64() >= 0 && r.crit.ToBlock.Int64() < end {
+		end = r.crit.ToBlock.Int64()
+	}
+	filter := r.api.newFilter(r.crit)
+	filter.SetBeginBlock(int64(from))
+	filter.SetEndBlock(end)
+	if err := filter.checkBlockRange(ctx); err != nil {
+		return err
+	}
+	for {
+		logs, err := filter.FindOnce(ctx)
+		if len(logs) == 0 || err != nil {
+			return err
+		}
+		if r.count += len(logs); r.api.maxResults > 0 && r.count > r.api.maxResults {
+			return ErrTooManyResults
+		}
+		if err := r.send(ctx, logs); err != nil {
+			return err
+		}
+		r.sent = append(r.sent, replayedBlock{number: logs[0].BlockNumber, hash: logs[0].BlockHash})
+	}
+}
+
+// revert sends the matching logs of a block again, marked as removed.
+func (r *logReplay) revert(ctx context.Context, header *types.Header) error {
+	logs, err := r.api.newFilter(r.crit).checkMatches(ctx, header)
+	if len(logs) == 0 || err != nil {
+		return err
+	}
+	removed := make([]*types.Log, len(logs))
+	for i, log := range logs {
+		cpy := *log
+		cpy.Removed = true
+		removed[i] = &cpy
+	}
+	return r.send(ctx, removed)
+}
+
+// send passes the logs of a block on to the subscription.
+func (r *logReplay) send(ctx context.Context, logs []*types.Log) error {
+	select {
+	case r.history <- logs:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// canonical reports whether the given block is part of the canonical chain.
+func (r *logReplay) canonical(ctx context.Context, hash common.Hash, number uint64) (bool, error) {
+	header, err := r.api.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return false, err
+	}
+	return header != nil && header.Hash() == hash, nil
+}
+
+// ancestor walks back from the given block to its first ancestor in the canonical
+// chain, returning its number. If a callback is given, it's called for each non
+// canonical block passed, starting with the given one. ErrCursorReorged is
+// returned if a block of the walk is unknown.
+func (r *logReplay) ancestor(ctx context.Context, hash common.Hash, number uint64, fn func(*types.Header) error) (uint64, error) {
+	for {
+		canon, err := r.canonical(ctx, hash, number)
+		if err != nil {
+			return 0, err
+		}
+		if canon {
+			return number, nil
+		}
+		header := core.GetHeader(r.api.chainDb, hash, number)
+		if header == nil || number == 0 {
+			return 0, ErrCursorReorged
+		}
+		if fn != nil {
+			if err := fn(header); err != nil {
+				return 0, err
+			}
+		}
+		hash, number = header.ParentHash, number-1
+	}
+}
+
 // FilterCriteria represents a request to create a new filter.
 type FilterCriteria struct {
 	FromBlock *big.Int
 	ToBlock   *big.Int
 	Addresses []common.Address
 	Topics    [][]common.Hash
+	Cursor    *Cursor      // Position to continue a paginated query from
+	Resume    *ResumePoint // Last block processed by a resumed subscription
+}
+
+// ResumePoint identifies the last block whose logs a subscriber processed before
+// its subscription was dropped.
+type ResumePoint struct {
+	Number hexutil.Uint64 `json:"blockNumber"`
+	Hash   common.Hash    `json:"blockHash"`
+}
+
+// resumeCriteria returns the criteria of a resumed subscription, starting after
+// the resume block.
+func (crit FilterCriteria) resumeCriteria() FilterCriteria {
+	crit.FromBlock = new(big.Int).SetUint64(uint64(crit.Resume.Number) + 1)
+	return crit
 }
 
 // NewFilter creates a new filter and returns the filter id. It can be
@@ -324,24 +713,55 @@ func (api *PublicFilterAPI) NewFilter(crit FilterCriteria) (rpc.ID, error) {
 }
 
 // GetLogs returns logs matching the given argument that are stored within the state.
+// Queries exceeding the configured block range or result count limits fail, and
+// should be split up using GetLogsPage instead.
 //
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getlogs
 func (api *PublicFilterAPI) GetLogs(ctx context.Context, crit FilterCriteria) ([]*types.Log, error) {
-	if crit.FromBlock == nil {
-		crit.FromBlock = big.NewInt(rpc.LatestBlockNumber.Int64())
+	if crit.Cursor != nil {
+		return nil, errors.New("cursor only supported by paginated log queries")
 	}
-	if crit.ToBlock == nil {
-		crit.ToBlock = big.NewInt(rpc.LatestBlockNumber.Int64())
+	logs, err := api.newFilter(crit).Find(ctx)
+	return returnLogs(logs), err
+}
+
+// LogsPage is a page of the results of a paginated log query.
+type LogsPage struct {
+	Logs   []*types.Log `json:"logs"`
+	Cursor *Cursor      `json:"cursor"` // Position of the next page, nil if done
+}
+
+// GetLogsPage returns a page of the logs matching the given argument, starting at
+// the cursor of the criteria if set. Each page contains at most the maximum result
+// count and searches at most the maximum block range, so large queries can be run
+// in multiple steps by passing the returned cursor until it is null.
+func (api *PublicFilterAPI) GetLogsPage(ctx context.Context, crit FilterCriteria) (*LogsPage, error) {
+	logs, cursor, err := api.newFilter(crit).FindPage(ctx, crit.Cursor)
+	if err != nil {
+		return nil, err
 	}
+	return &LogsPage{Logs: returnLogs(logs), Cursor: cursor}, nil
+}
 
-	filter := New(api.backend, api.useMipMap)
-	filter.SetBeginBlock(crit.FromBlock.Int64())
-	filter.SetEndBlock(crit.ToBlock.Int64())
+// newFilter creates a log filter for the given criteria, restricted to the
+// configured limits. Missing from and to blocks default to the latest block.
+func (api *PublicFilterAPI) newFilter(crit FilterCriteria) *Filter {
+	filter := New(api.backend)
+	if crit.FromBlock != nil {
+		filter.SetBeginBlock(crit.FromBlock.Int64())
+	} else {
+		filter.SetBeginBlock(rpc.LatestBlockNumber.Int64())
+	}
+	if crit.ToBlock != nil {
+		filter.SetEndBlock(crit.ToBlock.Int64())
+	} else {
+		filter.SetEndBlock(rpc.LatestBlockNumber.Int64())
+	}
 	filter.SetAddresses(crit.Addresses)
 	filter.SetTopics(crit.Topics)
+	filter.SetLimits(api.maxBlockRange, api.maxResults)
 
-	logs, err := filter.Find(ctx)
-	return returnLogs(logs), err
+	return filter
 }
 
 // UninstallFilter removes the filter with the given filter id.
@@ -374,21 +794,7 @@ func (api *PublicFilterAPI) GetFilterLogs(ctx context.Context, id rpc.ID) ([]*ty
 		return nil, fmt.Errorf("filter not found")
 	}
 
-	filter := New(api.backend, api.useMipMap)
-	if f.crit.FromBlock != nil {
-		filter.SetBeginBlock(f.crit.FromBlock.Int64())
-	} else {
-		filter.SetBeginBlock(rpc.LatestBlockNumber.Int64())
-	}
-	if f.crit.ToBlock != nil {
-		filter.SetEndBlock(f.crit.ToBlock.Int64())
-	} else {
-		filter.SetEndBlock(rpc.LatestBlockNumber.Int64())
-	}
-	filter.SetAddresses(f.crit.Addresses)
-	filter.SetTopics(f.crit.Topics)
-
-	logs, err := filter.Find(ctx)
+	logs, err := api.newFilter(f.crit).Find(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -454,6 +860,8 @@ func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
 		ToBlock   *rpc.BlockNumber `json:"toBlock"`
 		Addresses interface{}      `json:"address"`
 		Topics    []interface{}    `json:"topics"`
+		Cursor    *Cursor          `json:"cursor"`
+		Resume    *ResumePoint     `json:"resume"`
 	}
 
 	var raw input
@@ -469,6 +877,8 @@ func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
 		args.ToBlock = big.NewInt(raw.ToBlock.Int64())
 	}
 
+	args.Cursor = raw.Cursor
+	args.Resume = raw.Resume
 	args.Addresses = []common.Address{}
 
 	if raw.Addresses != nil {
@@ -194,4 +194,26 @@ func TestUnmarshalJSONNewFilterArgs(t *testing.T) {
 			topic2, nullTopic, test7.Topics[2][0], test7.Topics[2][1],
 		)
 	}
+
+	// test pagination cursor
+	var test8 FilterCriteria
+	cursor := &Cursor{BlockNumber: 5, BlockHash: topic0, Skip: 2}
+	enc, _ := cursor.MarshalText()
+	vector = fmt.Sprintf(`{"cursor": "%s"}`, enc)
+	if err := json.Unmarshal([]byte(vector), &test8); err != nil {
+		t.Fatal(err)
+	}
+	if test8.Cursor == nil || *test8.Cursor != *cursor {
+		t.Fatalf("expected cursor %v, got %v", cursor, test8.Cursor)
+	}
+
+	// test subscription resume point
+	var test9 FilterCriteria
+	vector = fmt.Sprintf(`{"resume": {"blockNumber": "0x5", "blockHash": "0x%x"}}`, topic0)
+	if err := json.Unmarshal([]byte(vector), &test9); err != nil {
+		t.Fatal(err)
+	}
+	if test9.Resume == nil || test9.Resume.Number != 5 || test9.Resume.Hash != topic0 {
+		t.Fatalf("expected resume point #5 [%x], got %v", topic0, test9.Resume)
+	}
 }
@@ -17,13 +17,16 @@
 package filters
 
 import (
-	"math"
-	"time"
-
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/bloombits"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
@@ -31,17 +34,40 @@ import (
 	"golang.org/x/net/context"
 )
 
+var (
+	// ErrBlockRangeTooLarge is returned if a log query spans more blocks than the
+	// configured limit.
+	ErrBlockRangeTooLarge = errors.New("log query exceeds maximum block range")
+
+	// ErrTooManyResults is returned if a log query matches more logs than the
+	// configured limit. Paginated queries should be used for such ranges.
+	ErrTooManyResults = errors.New("log query exceeds maximum result count")
+
+	// ErrCursorReorged is returned if the block a pagination cursor points to is
+	// not part of the canonical chain any more.
+	ErrCursorReorged = errors.New("log query cursor invalidated by chain reorg")
+
+	errCursorOutOfRange = errors.New("log query cursor outside of block range")
+)
+
 type Backend interface {
 	ChainDb() ethdb.Database
 	EventMux() *event.TypeMux
 	HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error)
 	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
+
+	// BloomStatus returns the number of blocks in a bloom bit section and the
+	// number of sections indexed from the genesis block.
+	BloomStatus() (uint64, uint64)
+
+	// GetBloomBits retrieves the decompressed bit vectors of the given bloom bits
+	// in an indexed section.
+	GetBloomBits(ctx context.Context, bits []uint, section uint64) ([][]byte, error)
 }
 
 // Filter can be used to retrieve and filter logs.
 type Filter struct {
-	backend   Backend
-	useMipMap bool
+	backend Backend
 
 	created time.Time
 
@@ -49,17 +75,19 @@ type Filter struct {
 	begin, end int64
 	addresses  []common.Address
 	topics     [][]common.Hash
+
+	maxBlockRange uint64 // Maximum number of blocks to search in one go (0 = unlimited)
+	maxResults    int    // Maximum number of logs to return in one go (0 = unlimited)
 }
 
 // New creates a new filter which uses a bloom filter on blocks to figure out whether
 // a particular block is interesting or not.
-// MipMaps allow past blocks to be searched much more efficiently, but are not available
-// to light clients.
-func New(backend Backend, useMipMap bool) *Filter {
+// The sections of the chain covered by the bloom bit index are tested as a whole,
+// only the remaining blocks are checked one by one.
+func New(backend Backend) *Filter {
 	return &Filter{
-		backend:   backend,
-		useMipMap: useMipMap,
-		db:        backend.ChainDb(),
+		backend: backend,
+		db:      backend.ChainDb(),
 	}
 }
 
@@ -86,85 +114,266 @@ func (f *Filter) SetTopics(topics [][]common.Hash) {
 	f.topics = topics
 }
 
-// FindOnce searches the blockchain for matching log entries, returning
-// all matching entries from the first block that contains matches,
-// updating the start point of the filter accordingly. If no results are
-// found, a nil slice is returned.
-func (f *Filter) FindOnce(ctx context.Context) ([]*types.Log, error) {
+// SetLimits restricts the number of blocks a query may search and the number of
+// logs it may return. Find fails if either is exceeded, whereas FindPage splits
+// the query into pages within the limits. Zero values mean unlimited.
+func (f *Filter) SetLimits(maxBlockRange uint64, maxResults int) {
+	f.maxBlockRange = maxBlockRange
+	f.maxResults = maxResults
+}
+
+// blockRange resolves the block range of the filter against the current head,
+// returning false if the head is unavailable.
+func (f *Filter) blockRange(ctx context.Context) (begin, end uint64, ok bool) {
 	head, _ := f.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
 	if head == nil {
-		return nil, nil
+		return 0, 0, false
 	}
-	headBlockNumber := head.Number.Uint64()
-
-	var beginBlockNo uint64 = uint64(f.begin)
+	begin, end = uint64(f.begin), uint64(f.end)
 	if f.begin == -1 {
-		beginBlockNo = headBlockNumber
+		begin = head.Number.Uint64()
 	}
-	var endBlockNo uint64 = uint64(f.end)
 	if f.end == -1 {
-		endBlockNo = headBlockNumber
+		end = head.Number.Uint64()
 	}
+	return begin, end, true
+}
 
-	// if no addresses are present we can't make use of fast search which
-	// uses the mipmap bloom filters to check for fast inclusion and uses
-	// higher range probability in order to ensure at least a false positive
-	if !f.useMipMap || len(f.addresses) == 0 {
-		logs, blockNumber, err := f.getLogs(ctx, beginBlockNo, endBlockNo)
-		f.begin = int64(blockNumber + 1)
-		return logs, err
+// FindOnce searches the blockchain for matching log entries, returning
+// all matching entries from the first block that contains matches,
+// updating the start point of the filter accordingly. If no results are
+// found, a nil slice is returned.
+func (f *Filter) FindOnce(ctx context.Context) ([]*types.Log, error) {
+	beginBlockNo, endBlockNo, ok := f.blockRange(ctx)
+	if !ok {
+		return nil, nil
 	}
 
-	logs, blockNumber := f.mipFind(beginBlockNo, endBlockNo, 0)
+	// Search the sections covered by the bloom bit index first, then fall back to
+	// checking the blocks of the unindexed tail one by one
+	size, sections := f.backend.BloomStatus()
+	if indexed := sections * size; beginBlockNo < indexed && beginBlockNo <= endBlockNo {
+		end := endBlockNo
+		if end >= indexed {
+			end = indexed - 1
+		}
+		logs, blockNumber, err := f.indexedLogs(ctx, size, beginBlockNo, end)
+		if len(logs) > 0 || err != nil {
+			f.begin = int64(blockNumber + 1)
+			return logs, err
+		}
+		beginBlockNo = end + 1
+	}
+	logs, blockNumber, err := f.getLogs(ctx, beginBlockNo, endBlockNo)
 	f.begin = int64(blockNumber + 1)
-	return logs, nil
+	return logs, err
+}
+
+// checkBlockRange returns ErrBlockRangeTooLarge if the block range of the filter
+// spans more blocks than the configured limit, or an error wrapping
+// core.ErrBlockPruned if it reaches into the pruned history of the chain.
+func (f *Filter) checkBlockRange(ctx context.Context) error {
+	begin, end, ok := f.blockRange(ctx)
+	if !ok || end < begin {
+		return nil
+	}
+	if f.maxBlockRange > 0 && end-begin >= f.maxBlockRange {
+		return ErrBlockRangeTooLarge
+	}
+	// The genesis block is never pruned, only blocks #1 up to the tail are
+	if tail := core.GetPruneTail(f.db); end > 0 && begin < tail {
+		return fmt.Errorf("logs of blocks before #%d unavailable: %v", tail, core.ErrBlockPruned)
+	}
+	return nil
 }
 
 // Run filters logs with the current parameters set
 func (f *Filter) Find(ctx context.Context) (logs []*types.Log, err error) {
+	if err := f.checkBlockRange(ctx); err != nil {
+		return nil, err
+	}
 	for {
 		newLogs, err := f.FindOnce(ctx)
 		if len(newLogs) == 0 || err != nil {
 			return logs, err
 		}
 		logs = append(logs, newLogs...)
+		if f.maxResults > 0 && len(logs) > f.maxResults {
+			return nil, ErrTooManyResults
+		}
 	}
 }
 
-func (f *Filter) mipFind(start, end uint64, depth int) (logs []*types.Log, blockNumber uint64) {
-	level := core.MIPMapLevels[depth]
-	// normalise numerator so we can work in level specific batches and
-	// work with the proper range checks
-	for num := start / level * level; num <= end; num += level {
-		// find addresses in bloom filters
-		bloom := core.GetMipmapBloom(f.db, num, level)
-		// Don't bother checking the first time through the loop - we're probably picking
-		// up where a previous run left off.
-		first := true
-		for _, addr := range f.addresses {
-			if first || bloom.TestBytes(addr[:]) {
-				first = false
-				// range check normalised values and make sure that
-				// we're resolving the correct range instead of the
-				// normalised values.
-				start := uint64(math.Max(float64(num), float64(start)))
-				end := uint64(math.Min(float64(num+level-1), float64(end)))
-				if depth+1 == len(core.MIPMapLevels) {
-					l, blockNumber, _ := f.getLogs(context.Background(), start, end)
-					if len(l) > 0 {
-						return l, blockNumber
-					}
-				} else {
-					l, blockNumber := f.mipFind(start, end, depth+1)
-					if len(l) > 0 {
-						return l, blockNumber
-					}
-				}
+// Cursor is the position of a paginated log query, identifying the last block
+// a page covered and the number of its matching logs already returned.
+type Cursor struct {
+	BlockNumber uint64
+	BlockHash   common.Hash
+	Skip        uint32
+}
+
+// cursorLength is the length of the binary encoding of a cursor.
+const cursorLength = 8 + common.HashLength + 4
+
+// MarshalText implements encoding.TextMarshaler, encoding the cursor as an opaque
+// hex string.
+func (c Cursor) MarshalText() ([]byte, error) {
+	enc := make([]byte, cursorLength)
+	binary.BigEndian.PutUint64(enc, c.BlockNumber)
+	copy(enc[8:], c.BlockHash[:])
+	binary.BigEndian.PutUint32(enc[8+common.HashLength:], c.Skip)
+	return []byte(hexutil.Encode(enc)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Cursor) UnmarshalText(input []byte) error {
+	enc, err := hexutil.Decode(string(input))
+	if err != nil {
+		return err
+	}
+	if len(enc) != cursorLength {
+		return fmt.Errorf("invalid cursor length %d", len(enc))
+	}
+	c.BlockNumber = binary.BigEndian.Uint64(enc)
+	c.BlockHash = common.BytesToHash(enc[8 : 8+common.HashLength])
+	c.Skip = binary.BigEndian.Uint32(enc[8+common.HashLength:])
+	return nil
+}
+
+// FindPage retrieves the next page of matching logs, continuing after the given
+// cursor or from the beginning of the range if it's nil. A page contains at most
+// the maximum result count and covers at most the maximum block range. The cursor
+// of the next page is returned, or nil if the range has been fully searched.
+func (f *Filter) FindPage(ctx context.Context, cursor *Cursor) ([]*types.Log, *Cursor, error) {
+	begin, end, ok := f.blockRange(ctx)
+	if !ok || begin > end {
+		return nil, nil, nil
+	}
+	// Continue from the cursor, making sure it's still on the canonical chain
+	var skip int
+	if cursor != nil {
+		if cursor.BlockNumber < begin || cursor.BlockNumber > end {
+			return nil, nil, errCursorOutOfRange
+		}
+		header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(cursor.BlockNumber))
+		if err != nil {
+			return nil, nil, err
+		}
+		if header == nil || header.Hash() != cursor.BlockHash {
+			return nil, nil, ErrCursorReorged
+		}
+		begin, skip = cursor.BlockNumber, int(cursor.Skip)
+	}
+	last := end
+	if f.maxBlockRange > 0 && last-begin >= f.maxBlockRange {
+		last = begin + f.maxBlockRange - 1
+	}
+	f.begin, f.end = int64(begin), int64(last)
+	if err := f.checkBlockRange(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	// Gather the logs block by block until the page is full or the range is done
+	var (
+		logs   []*types.Log
+		number = last // Last block covered by the page
+		taken  int    // Matching logs of the last block covered by the page
+	)
+	for {
+		blockLogs, err := f.FindOnce(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(blockLogs) == 0 {
+			break
+		}
+		// The filter was moved past the block of the logs, which the logs themselves
+		// don't necessarily identify (e.g. receipts retrieved by light clients)
+		number, taken = uint64(f.begin-1), len(blockLogs)
+		if number == begin && skip > 0 {
+			if skip > len(blockLogs) {
+				skip = len(blockLogs)
 			}
+			blockLogs = blockLogs[skip:]
+		} else {
+			skip = 0
+		}
+		if f.maxResults > 0 && len(logs)+len(blockLogs) > f.maxResults {
+			taken = skip + f.maxResults - len(logs)
+			logs = append(logs, blockLogs[:f.maxResults-len(logs)]...)
+			return f.pageCursor(ctx, logs, number, taken)
 		}
+		logs = append(logs, blockLogs...)
+	}
+	if last == end {
+		return logs, nil, nil
 	}
+	if number != last {
+		taken = 0
+	}
+	return f.pageCursor(ctx, logs, last, taken)
+}
 
-	return nil, end
+// pageCursor creates the cursor pointing after the given number of matching logs
+// of a block, returning it together with the logs of the page.
+func (f *Filter) pageCursor(ctx context.Context, logs []*types.Log, number uint64, taken int) ([]*types.Log, *Cursor, error) {
+	header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+	if header == nil {
+		return nil, nil, err
+	}
+	return logs, &Cursor{BlockNumber: number, BlockHash: header.Hash(), Skip: uint32(taken)}, nil
+}
+
+// indexedLogs returns the logs of the first block in the given range that matches
+// the filter criteria, using the bloom bit index to skip the sections and blocks
+// that can't contain any. The range must be fully covered by the index.
+func (f *Filter) indexedLogs(ctx context.Context, size, start, end uint64) (logs []*types.Log, blockNumber uint64, err error) {
+	matcher := bloombits.NewMatcher(size, f.bloomFilters())
+	retrieve := func(bits []uint, section uint64) ([][]byte, error) {
+		return f.backend.GetBloomBits(ctx, bits, section)
+	}
+	for section := start / size; section <= end/size; section++ {
+		blocks, err := matcher.Match(section, retrieve)
+		if err != nil {
+			return nil, end, err
+		}
+		for _, number := range blocks {
+			if number < start || number > end {
+				continue
+			}
+			header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+			if header == nil || err != nil {
+				return logs, end, err
+			}
+			if logs, err = f.checkMatches(ctx, header); len(logs) > 0 || err != nil {
+				return logs, number, err
+			}
+		}
+	}
+	return nil, end, nil
+}
+
+// bloomFilters converts the filter criteria into the key groups of the bloom bit
+// matcher, with wildcard topics represented by empty keys.
+func (f *Filter) bloomFilters() [][][]byte {
+	var filters [][][]byte
+	if len(f.addresses) > 0 {
+		filter := make([][]byte, len(f.addresses))
+		for i, address := range f.addresses {
+			filter[i] = address.Bytes()
+		}
+		filters = append(filters, filter)
+	}
+	for _, topics := range f.topics {
+		filter := make([][]byte, len(topics))
+		for i, topic := range topics {
+			if topic != (common.Hash{}) {
+				filter[i] = topic.Bytes()
+			}
+		}
+		filters = append(filters, filter)
+	}
+	return filters
 }
 
 func (f *Filter) getLogs(ctx context.Context, start, end uint64) (logs []*types.Log, blockNumber uint64, err error) {
@@ -175,28 +384,43 @@ func (f *Filter) getLogs(ctx context.Context, start, end uint64) (logs []*types.
 			return logs, end, err
 		}
 
-		// Use bloom filtering to see if this block is interesting given the
-		// current parameters
-		if f.bloomFilter(header.Bloom) {
-			// Get the logs of the block
-			receipts, err := f.backend.GetReceipts(ctx, header.Hash())
-			if err != nil {
-				return nil, end, err
-			}
-			var unfiltered []*types.Log
-			for _, receipt := range receipts {
-				unfiltered = append(unfiltered, ([]*types.Log)(receipt.Logs)...)
-			}
-			logs = filterLogs(unfiltered, nil, nil, f.addresses, f.topics)
-			if len(logs) > 0 {
-				return logs, uint64(blockNumber), nil
-			}
+		logs, err = f.checkMatches(ctx, header)
+		if err != nil {
+			return nil, end, err
+		}
+		if len(logs) > 0 {
+			return logs, uint64(blockNumber), nil
 		}
 	}
 
 	return logs, end, nil
 }
 
+// checkMatches returns the logs of the given block matching the filter criteria,
+// retrieving the receipts only if the block's bloom indicates potential matches.
+func (f *Filter) checkMatches(ctx context.Context, header *types.Header) ([]*types.Log, error) {
+	// Use bloom filtering to see if this block is interesting given the
+	// current parameters
+	if !f.bloomFilter(header.Bloom) {
+		return nil, nil
+	}
+	// Get the logs of the block
+	receipts, err := f.backend.GetReceipts(ctx, header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	// Light clients retrieve consensus encoded receipts, which don't carry the
+	// block context of their logs
+	var unfiltered []*types.Log
+	for _, receipt := range receipts {
+		for _, log := range receipt.Logs {
+			log.BlockNumber, log.BlockHash = header.Number.Uint64(), header.Hash()
+		}
+		unfiltered = append(unfiltered, ([]*types.Log)(receipt.Logs)...)
+	}
+	return filterLogs(unfiltered, nil, nil, f.addresses, f.topics), nil
+}
+
 func includes(addresses []common.Address, a common.Address) bool {
 	for _, addr := range addresses {
 		if addr == a {
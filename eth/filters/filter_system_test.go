@@ -19,13 +19,16 @@ package filters
 import (
 	"math/big"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/bitutil"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/bloombits"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
@@ -64,6 +67,56 @@ func (b *testBackend) GetReceipts(ctx context.Context, blockHash common.Hash) (t
 	return core.GetBlockReceipts(b.db, blockHash, num), nil
 }
 
+// testBloomSectionSize is the number of blocks in a bloom bit section of the test
+// backend, small enough to index the short test chains.
+const testBloomSectionSize = 64
+
+func (b *testBackend) BloomStatus() (uint64, uint64) {
+	return testBloomSectionSize, core.GetBloomSections(b.db)
+}
+
+func (b *testBackend) GetBloomBits(ctx context.Context, bits []uint, section uint64) ([][]byte, error) {
+	head := core.GetBloomSectionHead(b.db, section)
+
+	vectors := make([][]byte, len(bits))
+	for i, bit := range bits {
+		data, err := core.GetBloomBits(b.db, bit, section, head)
+		if err != nil {
+			return nil, err
+		}
+		if vectors[i], err = bitutil.DecompressBytes(data, testBloomSectionSize/8); err != nil {
+			return nil, err
+		}
+	}
+	return vectors, nil
+}
+
+// indexTestChain generates the bloom bit index of the full sections of the
+// canonical chain stored in the test database.
+func indexTestChain(t testing.TB, db ethdb.Database) {
+	head := core.GetBlockNumber(db, core.GetHeadBlockHash(db))
+	for section := uint64(0); (section+1)*testBloomSectionSize <= head+1; section++ {
+		gen, err := bloombits.NewGenerator(testBloomSectionSize)
+		if err != nil {
+			t.Fatalf("failed to create bloom generator: %v", err)
+		}
+		var hash common.Hash
+		for i := uint64(0); i < testBloomSectionSize; i++ {
+			number := section*testBloomSectionSize + i
+			hash = core.GetCanonicalHash(db, number)
+			if err := gen.AddBloom(uint(i), core.GetHeader(db, hash, number).Bloom); err != nil {
+				t.Fatalf("failed to add bloom of block #%d: %v", number, err)
+			}
+		}
+		for bit := uint(0); bit < types.BloomBitLength; bit++ {
+			bits, _ := gen.Bitset(bit)
+			core.WriteBloomBits(db, bit, section, hash, bitutil.CompressBytes(bits))
+		}
+		core.WriteBloomSectionHead(db, section, hash)
+		core.WriteBloomSections(db, section+1)
+	}
+}
+
 // TestBlockSubscription tests if a block subscription returns block hashes for posted chain events.
 // It creates multiple subscriptions:
 // - one at the start and should receive all posted chain events and a second (blockHashes)
@@ -173,6 +226,48 @@ func TestPendingTxFilter(t *testing.T) {
 	}
 }
 
+// TestTxPoolEventsSubscription tests that transaction pool status changes are
+// delivered to subscribers in order.
+func TestTxPoolEventsSubscription(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mux     = new(event.TypeMux)
+		db, _   = ethdb.NewMemDatabase()
+		backend = &testBackend{mux, db}
+		api     = NewPublicFilterAPI(backend, false)
+
+		tx  = types.NewTransaction(0, common.HexToAddress("0xb794f5ea0ba39494ce83a213fffba74279579268"), new(big.Int), new(big.Int), new(big.Int), nil)
+		rep = types.NewTransaction(0, common.HexToAddress("0xb794f5ea0ba39494ce83a213fffba74279579268"), new(big.Int), new(big.Int), big.NewInt(1), nil)
+
+		events = []core.TxPoolEvent{
+			{Tx: tx, Kind: core.TxAdded},
+			{Tx: tx, Kind: core.TxPromoted},
+			{Tx: tx, Kind: core.TxReplaced, Replacement: rep.Hash()},
+			{Tx: rep, Kind: core.TxDropped, Reason: core.TxDropNonceTooLow},
+		}
+	)
+	ch := make(chan core.TxPoolEvent)
+	sub := api.events.SubscribeTxPoolEvents(ch)
+	defer sub.Unsubscribe()
+
+	go func() {
+		for _, ev := range events {
+			mux.Post(ev)
+		}
+	}()
+	for i, want := range events {
+		select {
+		case have := <-ch:
+			if have.Tx.Hash() != want.Tx.Hash() || have.Kind != want.Kind || have.Reason != want.Reason || have.Replacement != want.Replacement {
+				t.Errorf("event %d: mismatch: have %+v, want %+v", i, have, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d: timeout", i)
+		}
+	}
+}
+
 // TestLogFilterCreation test whether a given filter criteria makes sense.
 // If not it must return an error.
 func TestLogFilterCreation(t *testing.T) {
@@ -463,3 +558,313 @@ func TestPendingLogsSubscription(t *testing.T) {
 		}
 	}
 }
+
+// TestLogsReplay tests that a log subscription with a starting block replays the
+// historical logs before the live ones, delivering each log exactly once even if
+// the live events overlap with the replayed blocks.
+func TestLogsReplay(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mux     = new(event.TypeMux)
+		db, _   = ethdb.NewMemDatabase()
+		backend = &testBackend{mux, db}
+		api     = NewPublicFilterAPI(backend, false)
+
+		addr = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		crit = FilterCriteria{FromBlock: big.NewInt(1), Addresses: []common.Address{addr}}
+	)
+	// Create a chain with logs in blocks 2 and 5
+	genesis := core.WriteGenesisBlockForTesting(db)
+	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, db, 10, func(i int, gen *core.BlockGen) {
+		if i == 1 || i == 4 {
+			receipt := types.NewReceipt(nil, new(big.Int))
+			receipt.Logs = []*types.Log{{Address: addr}}
+			gen.AddUncheckedReceipt(receipt)
+		}
+	})
+	var history []*types.Log
+	for i, block := range chain {
+		for _, receipt := range receipts[i] {
+			for _, log := range receipt.Logs {
+				log.BlockNumber, log.BlockHash = block.NumberU64(), block.Hash()
+				history = append(history, log)
+			}
+		}
+		core.WriteBlock(db, block)
+		core.WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+		core.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		core.WriteHeadBlockHash(db, block.Hash())
+	}
+	// Start streaming the logs and post live events overlapping with the replay
+	live := make(chan []*types.Log)
+	sub, _ := api.events.SubscribeLogs(crit, live)
+	defer sub.Unsubscribe()
+
+	sent := make(chan *types.Log, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go api.streamLogs(ctx, crit, live, func(log *types.Log) { sent <- log })
+
+	removed := *history[0]
+	removed.Removed = true
+	unknown := removed
+	unknown.BlockNumber, unknown.BlockHash = 3, common.HexToHash("0x03")
+	readded := *history[0]
+	newest := &types.Log{Address: addr, BlockNumber: 11, BlockHash: common.HexToHash("0x0b")}
+
+	events := [][]*types.Log{
+		{history[1]}, // already replayed, dropped
+		{&removed},   // replayed block reverted, sent
+		{&unknown},   // never sent block reverted, dropped
+		{&readded},   // reverted block added again, sent
+		{newest},     // new block, sent
+	}
+	for _, logs := range events {
+		if err := mux.Post(logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := append(history, &removed, &readded, newest)
+	for i, log := range want {
+		select {
+		case have := <-sent:
+			if have.BlockHash != log.BlockHash || have.BlockNumber != log.BlockNumber || have.Removed != log.Removed {
+				t.Fatalf("log %d mismatch: have #%d [%x] removed %v, want #%d [%x] removed %v", i,
+					have.BlockNumber, have.BlockHash[:4], have.Removed, log.BlockNumber, log.BlockHash[:4], log.Removed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("log %d not delivered", i)
+		}
+	}
+	select {
+	case log := <-sent:
+		t.Fatalf("unexpected log delivered: %+v", log)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// makeReorgChains creates a canonical chain of 10 blocks with logs of the given
+// address in blocks 2, 5 and 8, and a fork of it branching off after block 3 with
+// logs in blocks 6 and 9. Only the canonical chain is written to the database.
+func makeReorgChains(db ethdb.Database, addr common.Address) (canon []*types.Block, canonReceipts []types.Receipts, fork []*types.Block, forkReceipts []types.Receipts) {
+	addLog := func(gen *core.BlockGen) {
+		receipt := types.NewReceipt(nil, new(big.Int))
+		receipt.Logs = []*types.Log{{Address: addr}}
+		gen.AddUncheckedReceipt(receipt)
+	}
+	genesis := core.WriteGenesisBlockForTesting(db)
+	canon, canonReceipts = core.GenerateChain(params.TestChainConfig, genesis, db, 10, func(i int, gen *core.BlockGen) {
+		if i == 1 || i == 4 || i == 7 {
+			addLog(gen)
+		}
+	})
+	fork, forkReceipts = core.GenerateChain(params.TestChainConfig, canon[2], db, 7, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(common.Address{0x01})
+		if i == 2 || i == 5 {
+			addLog(gen)
+		}
+	})
+	writeLogChain(db, canon, canonReceipts)
+	return canon, canonReceipts, fork, forkReceipts
+}
+
+// writeLogChain stores the given blocks and receipts as the canonical chain.
+func writeLogChain(db ethdb.Database, blocks []*types.Block, receipts []types.Receipts) {
+	for i, block := range blocks {
+		core.WriteBlock(db, block)
+		core.WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+		core.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		core.WriteHeadBlockHash(db, block.Hash())
+	}
+}
+
+// expectLogs checks that the given blocks' logs are delivered in order, followed
+// by no others.
+func expectLogs(t *testing.T, sent chan *types.Log, want []*types.Block, removed []bool) {
+	for i, block := range want {
+		select {
+		case have := <-sent:
+			if have.BlockHash != block.Hash() || have.BlockNumber != block.NumberU64() || have.Removed != removed[i] {
+				t.Fatalf("log %d mismatch: have #%d [%x] removed %v, want #%d [%x] removed %v", i,
+					have.BlockNumber, have.BlockHash[:4], have.Removed, block.NumberU64(), block.Hash().Bytes()[:4], removed[i])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("log %d not delivered", i)
+		}
+	}
+	select {
+	case log := <-sent:
+		t.Fatalf("unexpected log delivered: %+v", log)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestLogsResume tests that a subscription resumed from a block reorged out of
+// the chain first reverts the logs of the reorged blocks, newest first, before
+// replaying the logs of the new canonical chain.
+func TestLogsResume(t *testing.T) {
+	t.Parallel()
+
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		backend = &testBackend{new(event.TypeMux), db}
+		api     = NewPublicFilterAPI(backend, false)
+		addr    = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	)
+	canon, _, fork, forkReceipts := makeReorgChains(db, addr)
+	writeLogChain(db, fork, forkReceipts)
+
+	// Resume from the old chain's block 8, already processed by the client
+	crit := FilterCriteria{
+		Addresses: []common.Address{addr},
+		Resume:    &ResumePoint{Number: 8, Hash: canon[7].Hash()},
+	}
+	sent := make(chan *types.Log, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go api.streamLogs(ctx, crit, nil, func(log *types.Log) { sent <- log })
+
+	expectLogs(t, sent, []*types.Block{canon[7], canon[4], fork[2], fork[5]}, []bool{true, true, false, false})
+
+	// Resuming from an unknown block must fail
+	crit.Resume = &ResumePoint{Number: 8, Hash: common.HexToHash("0x08")}
+	if err := api.streamLogs(ctx, crit, nil, func(*types.Log) {}); err != ErrCursorReorged {
+		t.Fatalf("unknown resume block error mismatch: have %v, want %v", err, ErrCursorReorged)
+	}
+}
+
+// reorgBackend is a test backend reorganising the chain right after retrieving
+// the receipts of a trigger block.
+type reorgBackend struct {
+	*testBackend
+	trigger common.Hash
+	reorg   func()
+	once    sync.Once
+}
+
+func (b *reorgBackend) GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error) {
+	receipts, err := b.testBackend.GetReceipts(ctx, blockHash)
+	if blockHash == b.trigger {
+		b.once.Do(b.reorg)
+	}
+	return receipts, err
+}
+
+// TestLogsReplayReorg tests that if the chain is reorganised while replaying the
+// historical logs of a subscription, the replayed blocks past the fork point are
+// reverted and replayed from the new chain, keeping the delivered logs ordered.
+func TestLogsReplayReorg(t *testing.T) {
+	t.Parallel()
+
+	var (
+		db, _ = ethdb.NewMemDatabase()
+		addr  = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		crit  = FilterCriteria{FromBlock: big.NewInt(1), Addresses: []common.Address{addr}}
+	)
+	canon, _, fork, forkReceipts := makeReorgChains(db, addr)
+
+	// Reorg the chain once the first log was retrieved, before reading the rest
+	backend := &reorgBackend{
+		testBackend: &testBackend{new(event.TypeMux), db},
+		trigger:     canon[1].Hash(),
+		reorg:       func() { writeLogChain(db, fork, forkReceipts) },
+	}
+	api := NewPublicFilterAPI(backend, false)
+
+	sent := make(chan *types.Log, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go api.streamLogs(ctx, crit, nil, func(log *types.Log) { sent <- log })
+
+	// The blocks read after the reorg are reverted and replayed again, as blocks
+	// preceding them on the new chain might not have been replayed
+	expectLogs(t, sent,
+		[]*types.Block{canon[1], fork[2], fork[5], fork[5], fork[2], fork[2], fork[5]},
+		[]bool{false, false, false, true, true, false, false})
+}
+
+// TestLogsReplayLimits tests that log subscription replays are subject to the
+// block range and result count limits of log queries.
+func TestLogsReplayLimits(t *testing.T) {
+	t.Parallel()
+
+	var (
+		addr    = common.HexToAddress("0x01")
+		backend = makeLimitsChain(t, addr)
+		api     = NewPublicFilterAPI(backend, false)
+		crit    = FilterCriteria{FromBlock: big.NewInt(0), Addresses: []common.Address{addr}}
+	)
+	tests := []struct {
+		maxBlockRange uint64
+		maxResults    int
+		sent          int
+		err           error
+	}{
+		{8, 0, 0, ErrBlockRangeTooLarge},
+		{0, 3, 1, ErrTooManyResults},
+		{21, 5, 5, nil},
+	}
+	for i, tt := range tests {
+		api.SetLogLimits(tt.maxBlockRange, tt.maxResults)
+
+		var (
+			sent        int
+			ctx, cancel = context.WithCancel(context.Background())
+		)
+		err := api.streamLogs(ctx, crit, nil, func(log *types.Log) {
+			if sent++; sent == tt.sent && tt.err == nil {
+				cancel()
+			}
+		})
+		cancel()
+
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if sent != tt.sent {
+			t.Errorf("test %d: sent log count mismatch: have %d, want %d", i, sent, tt.sent)
+		}
+	}
+}
+
+// stalledBackend is a test backend whose head retrieval blocks until released,
+// holding log subscription replays back.
+type stalledBackend struct {
+	*testBackend
+	release chan struct{}
+}
+
+func (b *stalledBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error) {
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return b.testBackend.HeaderByNumber(ctx, blockNr)
+}
+
+// TestLogsReplayOverflow tests that a log subscription fails instead of queueing
+// up live logs without limit while its history is being replayed.
+func TestLogsReplayOverflow(t *testing.T) {
+	t.Parallel()
+
+	var (
+		addr    = common.HexToAddress("0x01")
+		backend = &stalledBackend{makeLimitsChain(t, addr), make(chan struct{})}
+		api     = NewPublicFilterAPI(backend, false)
+		crit    = FilterCriteria{FromBlock: big.NewInt(0), Addresses: []common.Address{addr}}
+	)
+	api.maxQueued = 2
+
+	live := make(chan []*types.Log, 3)
+	for i := 0; i < 3; i++ {
+		live <- []*types.Log{{Address: addr, BlockNumber: uint64(21 + i)}}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := api.streamLogs(ctx, crit, live, func(*types.Log) {}); err != ErrReplayOverflow {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrReplayOverflow)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/context"
@@ -43,7 +45,7 @@ func makeReceipt(addr common.Address) *types.Receipt {
 }
 
 func BenchmarkMipmaps(b *testing.B) {
-	dir, err := ioutil.TempDir("", "mipmap")
+	dir, err := ioutil.TempDir("", "filtertest")
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -89,7 +91,6 @@ func BenchmarkMipmaps(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		core.WriteMipmapBloom(db, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		core.WriteBlock(db, block)
@@ -103,9 +104,10 @@ func BenchmarkMipmaps(b *testing.B) {
 			b.Fatal("error writing block receipts:", err)
 		}
 	}
+	indexTestChain(b, db)
 	b.ResetTimer()
 
-	filter := New(backend, true)
+	filter := New(backend)
 	filter.SetAddresses([]common.Address{addr1, addr2, addr3, addr4})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
@@ -119,7 +121,7 @@ func BenchmarkMipmaps(b *testing.B) {
 }
 
 func TestFilters(t *testing.T) {
-	dir, err := ioutil.TempDir("", "mipmap")
+	dir, err := ioutil.TempDir("", "filtertest")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -190,10 +192,6 @@ func TestFilters(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		// i is used as block number for the writes but since the i
-		// starts at 0 and block 0 (genesis) is already present increment
-		// by one
-		core.WriteMipmapBloom(db, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		core.WriteBlock(db, block)
@@ -207,8 +205,12 @@ func TestFilters(t *testing.T) {
 			t.Fatal("error writing block receipts:", err)
 		}
 	}
+	indexTestChain(t, db)
+	if _, sections := backend.BloomStatus(); sections != 15 {
+		t.Fatalf("indexed section count mismatch: have %d, want %d", sections, 15)
+	}
 
-	filter := New(backend, true)
+	filter := New(backend)
 	filter.SetAddresses([]common.Address{addr})
 	filter.SetTopics([][]common.Hash{{hash1, hash2, hash3, hash4}})
 	filter.SetBeginBlock(0)
@@ -219,7 +221,7 @@ func TestFilters(t *testing.T) {
 		t.Error("expected 4 log, got", len(logs))
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetAddresses([]common.Address{addr})
 	filter.SetTopics([][]common.Hash{{hash3}})
 	filter.SetBeginBlock(900)
@@ -232,7 +234,7 @@ func TestFilters(t *testing.T) {
 		t.Errorf("expected log[0].Topics[0] to be %x, got %x", hash3, logs[0].Topics[0])
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetAddresses([]common.Address{addr})
 	filter.SetTopics([][]common.Hash{{hash3}})
 	filter.SetBeginBlock(990)
@@ -245,7 +247,7 @@ func TestFilters(t *testing.T) {
 		t.Errorf("expected log[0].Topics[0] to be %x, got %x", hash3, logs[0].Topics[0])
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetTopics([][]common.Hash{{hash1, hash2}})
 	filter.SetBeginBlock(1)
 	filter.SetEndBlock(10)
@@ -256,7 +258,7 @@ func TestFilters(t *testing.T) {
 	}
 
 	failHash := common.BytesToHash([]byte("fail"))
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetTopics([][]common.Hash{{failHash}})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
@@ -267,7 +269,7 @@ func TestFilters(t *testing.T) {
 	}
 
 	failAddr := common.BytesToAddress([]byte("failmenow"))
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetAddresses([]common.Address{failAddr})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
@@ -277,7 +279,7 @@ func TestFilters(t *testing.T) {
 		t.Error("expected 0 log, got", len(logs))
 	}
 
-	filter = New(backend, true)
+	filter = New(backend)
 	filter.SetTopics([][]common.Hash{{failHash}, {hash1}})
 	filter.SetBeginBlock(0)
 	filter.SetEndBlock(-1)
@@ -287,3 +289,159 @@ func TestFilters(t *testing.T) {
 		t.Error("expected 0 log, got", len(logs))
 	}
 }
+
+// makeLimitsChain creates a chain of 20 blocks with one log in block 2, three in
+// block 5 and one in block 17, numbered in order through their data.
+func makeLimitsChain(t *testing.T, addr common.Address) *testBackend {
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		backend = &testBackend{new(event.TypeMux), db}
+		logs    = map[int]int{1: 1, 4: 3, 16: 1}
+		counter byte
+	)
+	genesis := core.WriteGenesisBlockForTesting(db)
+	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, db, 20, func(i int, gen *core.BlockGen) {
+		for j := 0; j < logs[i]; j++ {
+			receipt := types.NewReceipt(nil, new(big.Int))
+			receipt.Logs = []*types.Log{{Address: addr, Data: []byte{counter}}}
+			gen.AddUncheckedReceipt(receipt)
+			counter++
+		}
+	})
+	for i, block := range chain {
+		core.WriteBlock(db, block)
+		core.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		core.WriteHeadBlockHash(db, block.Hash())
+		if err := core.WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[i]); err != nil {
+			t.Fatal("error writing block receipts:", err)
+		}
+	}
+	return backend
+}
+
+// Tests that queries exceeding the block range or result count limits fail.
+func TestFilterLimits(t *testing.T) {
+	addr := common.HexToAddress("0x01")
+	backend := makeLimitsChain(t, addr)
+
+	tests := []struct {
+		begin, end    int64
+		maxBlockRange uint64
+		maxResults    int
+		logs          int
+		err           error
+	}{
+		{0, -1, 0, 0, 5, nil},
+		{0, -1, 21, 5, 5, nil},
+		{0, -1, 20, 0, 0, ErrBlockRangeTooLarge},
+		{0, -1, 0, 4, 0, ErrTooManyResults},
+		{3, 10, 8, 3, 3, nil},
+		{3, 10, 7, 3, 0, ErrBlockRangeTooLarge},
+	}
+	for i, tt := range tests {
+		filter := New(backend)
+		filter.SetAddresses([]common.Address{addr})
+		filter.SetBeginBlock(tt.begin)
+		filter.SetEndBlock(tt.end)
+		filter.SetLimits(tt.maxBlockRange, tt.maxResults)
+
+		logs, err := filter.Find(context.Background())
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if len(logs) != tt.logs {
+			t.Errorf("test %d: log count mismatch: have %d, want %d", i, len(logs), tt.logs)
+		}
+	}
+}
+
+// Tests that queries reaching into the pruned history fail explicitly, instead
+// of silently skipping the pruned blocks.
+func TestFilterPruned(t *testing.T) {
+	addr := common.HexToAddress("0x01")
+	backend := makeLimitsChain(t, addr)
+	core.WritePruneTail(backend.db, 6)
+
+	tests := []struct {
+		begin, end int64
+		logs       int
+		pruned     bool
+	}{
+		{0, 0, 0, false},
+		{0, -1, 0, true},
+		{5, 10, 0, true},
+		{6, -1, 1, false},
+	}
+	for i, tt := range tests {
+		filter := New(backend)
+		filter.SetAddresses([]common.Address{addr})
+		filter.SetBeginBlock(tt.begin)
+		filter.SetEndBlock(tt.end)
+
+		logs, err := filter.Find(context.Background())
+		if pruned := err != nil && strings.Contains(err.Error(), core.ErrBlockPruned.Error()); pruned != tt.pruned {
+			t.Errorf("test %d: pruned error mismatch: have %v, want pruned %v", i, err, tt.pruned)
+		}
+		if len(logs) != tt.logs {
+			t.Errorf("test %d: log count mismatch: have %d, want %d", i, len(logs), tt.logs)
+		}
+		filter.SetBeginBlock(tt.begin)
+		filter.SetEndBlock(tt.end)
+		if _, _, err := filter.FindPage(context.Background(), nil); (err != nil) != tt.pruned {
+			t.Errorf("test %d: paginated pruned error mismatch: have %v, want pruned %v", i, err, tt.pruned)
+		}
+	}
+}
+
+// Tests that paginated queries return every log exactly once, in pages within
+// the configured limits, and that cursors are invalidated by reorgs.
+func TestFilterPages(t *testing.T) {
+	addr := common.HexToAddress("0x01")
+	backend := makeLimitsChain(t, addr)
+
+	newFilter := func() *Filter {
+		filter := New(backend)
+		filter.SetAddresses([]common.Address{addr})
+		filter.SetBeginBlock(0)
+		filter.SetEndBlock(-1)
+		filter.SetLimits(8, 2)
+		return filter
+	}
+	var (
+		pages  [][]byte
+		cursor *Cursor
+	)
+	for {
+		logs, next, err := newFilter().FindPage(context.Background(), cursor)
+		if err != nil {
+			t.Fatalf("page %d: failed to retrieve: %v", len(pages), err)
+		}
+		if len(logs) > 2 {
+			t.Fatalf("page %d: too many logs: %d", len(pages), len(logs))
+		}
+		var page []byte
+		for _, log := range logs {
+			page = append(page, log.Data...)
+		}
+		pages = append(pages, page)
+
+		if next == nil {
+			break
+		}
+		// Pass the cursor through its text encoding
+		enc, _ := next.MarshalText()
+		cursor = new(Cursor)
+		if err := cursor.UnmarshalText(enc); err != nil {
+			t.Fatalf("page %d: failed to decode cursor %s: %v", len(pages), enc, err)
+		}
+	}
+	want := [][]byte{{0, 1}, {2, 3}, {4}, nil}
+	if !reflect.DeepEqual(pages, want) {
+		t.Fatalf("pages mismatch: have %v, want %v", pages, want)
+	}
+	// Cursors of non-canonical blocks should be rejected
+	cursor = &Cursor{BlockNumber: 5, BlockHash: common.HexToHash("0x05"), Skip: 1}
+	if _, _, err := newFilter().FindPage(context.Background(), cursor); err != ErrCursorReorged {
+		t.Fatalf("reorged cursor error mismatch: have %v, want %v", err, ErrCursorReorged)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/net/context"
+)
+
+const (
+	// maxFeeHistory is the maximum number of blocks that can be retrieved in a
+	// single fee history request.
+	maxFeeHistory = 1024
+
+	// maxBlockFetchers is the maximum number of blocks retrieved concurrently
+	// for a single fee history request.
+	maxBlockFetchers = 4
+)
+
+var (
+	errInvalidPercentile = errors.New("invalid reward percentile")
+	errMissingBlock      = errors.New("missing block")
+)
+
+// blockFees is the fee history of a single block.
+type blockFees struct {
+	number       uint64
+	reward       []*big.Int
+	gasUsedRatio float64
+	err          error
+}
+
+// txGasAndPrice is the gas used and the gas price of an included transaction.
+type txGasAndPrice struct {
+	gasUsed  *big.Int
+	gasPrice *big.Int
+}
+
+type txGasAndPriceArray []txGasAndPrice
+
+func (s txGasAndPriceArray) Len() int           { return len(s) }
+func (s txGasAndPriceArray) Less(i, j int) bool { return s[i].gasPrice.Cmp(s[j].gasPrice) < 0 }
+func (s txGasAndPriceArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// FeeHistory returns the gas usage ratios and, if requested, the gas price
+// percentiles of up to maxFeeHistory consecutive blocks ending at lastBlock.
+// The percentiles are weighted by the gas used by each transaction, so for
+// example the 50th percentile is the price at which half the gas of the block
+// was bought at or below.
+//
+// The number of the oldest block of the returned range is reported alongside
+// the per block results, which are ordered from the oldest to the newest.
+func (gpo *Oracle) FeeHistory(ctx context.Context, blockCount int, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*big.Int, [][]*big.Int, []float64, error) {
+	if blockCount < 1 {
+		return new(big.Int), nil, nil, nil
+	}
+	if blockCount > maxFeeHistory {
+		blockCount = maxFeeHistory
+	}
+	for i, p := range rewardPercentiles {
+		if p < 0 || p > 100 {
+			return nil, nil, nil, fmt.Errorf("%v: %f", errInvalidPercentile, p)
+		}
+		if i > 0 && p < rewardPercentiles[i-1] {
+			return nil, nil, nil, fmt.Errorf("%v: #%d:%f > #%d:%f", errInvalidPercentile, i-1, rewardPercentiles[i-1], i, p)
+		}
+	}
+	// Resolve the last block of the range, pending blocks are not supported
+	if lastBlock == rpc.PendingBlockNumber {
+		lastBlock = rpc.LatestBlockNumber
+	}
+	head, err := gpo.backend.HeaderByNumber(ctx, lastBlock)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if head == nil {
+		return nil, nil, nil, errMissingBlock
+	}
+	last := head.Number.Uint64()
+	if uint64(blockCount) > last+1 {
+		blockCount = int(last + 1)
+	}
+	oldest := last + 1 - uint64(blockCount)
+
+	// Retrieve the blocks with a few concurrent fetchers and assemble the results
+	// in order. The fetchers stop picking up new blocks once we return.
+	var (
+		numbers = make(chan uint64, blockCount)
+		results = make(chan *blockFees, blockCount)
+		quit    = make(chan struct{})
+	)
+	defer close(quit)
+
+	for number := oldest; number <= last; number++ {
+		numbers <- number
+	}
+	close(numbers)
+
+	for i := 0; i < maxBlockFetchers && i < blockCount; i++ {
+		go func() {
+			for number := range numbers {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				gpo.getBlockFees(ctx, number, rewardPercentiles, results)
+			}
+		}()
+	}
+	var (
+		reward       = make([][]*big.Int, blockCount)
+		gasUsedRatio = make([]float64, blockCount)
+	)
+	for i := 0; i < blockCount; i++ {
+		fees := <-results
+		if fees.err != nil {
+			return nil, nil, nil, fees.err
+		}
+		reward[fees.number-oldest] = fees.reward
+		gasUsedRatio[fees.number-oldest] = fees.gasUsedRatio
+	}
+	if len(rewardPercentiles) == 0 {
+		reward = nil
+	}
+	return new(big.Int).SetUint64(oldest), reward, gasUsedRatio, nil
+}
+
+// getBlockFees calculates the fee history of a single block and sends it to the
+// result channel. The block body and receipts are only retrieved if percentiles
+// were requested.
+func (gpo *Oracle) getBlockFees(ctx context.Context, number uint64, percentiles []float64, results chan *blockFees) {
+	fees := &blockFees{number: number}
+	defer func() { results <- fees }()
+
+	var header *types.Header
+	if len(percentiles) == 0 {
+		header, fees.err = gpo.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+	} else {
+		var block *types.Block
+		if block, fees.err = gpo.backend.BlockByNumber(ctx, rpc.BlockNumber(number)); block != nil {
+			header = block.Header()
+			fees.reward, fees.err = gpo.blockRewards(ctx, block, percentiles)
+		}
+	}
+	if header == nil {
+		if fees.err == nil {
+			fees.err = errMissingBlock
+		}
+		return
+	}
+	if header.GasLimit.Sign() > 0 {
+		fees.gasUsedRatio, _ = new(big.Rat).SetFrac(header.GasUsed, header.GasLimit).Float64()
+	}
+}
+
+// blockRewards calculates the gas used weighted gas price percentiles of the
+// transactions included in a block.
+func (gpo *Oracle) blockRewards(ctx context.Context, block *types.Block, percentiles []float64) ([]*big.Int, error) {
+	reward := make([]*big.Int, len(percentiles))
+
+	txs := block.Transactions()
+	if len(txs) == 0 {
+		for i := range reward {
+			reward[i] = new(big.Int)
+		}
+		return reward, nil
+	}
+	receipts, err := gpo.backend.GetReceipts(ctx, block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	if len(receipts) != len(txs) {
+		return nil, fmt.Errorf("receipt count mismatch for block #%d: have %d, want %d", block.NumberU64(), len(receipts), len(txs))
+	}
+	sorted := make(txGasAndPriceArray, len(txs))
+	prev := new(big.Int)
+	for i, tx := range txs {
+		sorted[i] = txGasAndPrice{gasUsed: new(big.Int).Sub(receipts[i].CumulativeGasUsed, prev), gasPrice: tx.GasPrice()}
+		prev = receipts[i].CumulativeGasUsed
+	}
+	sort.Stable(sorted)
+
+	var (
+		total = new(big.Float).SetInt(prev)
+		used  = new(big.Int).Set(sorted[0].gasUsed)
+		index int
+	)
+	for i, p := range percentiles {
+		threshold := new(big.Float).Mul(total, big.NewFloat(p/100))
+		for new(big.Float).SetInt(used).Cmp(threshold) < 0 && index < len(sorted)-1 {
+			index++
+			used.Add(used, sorted[index].gasUsed)
+		}
+		reward[i] = sorted[index].gasPrice
+	}
+	return reward, nil
+}
@@ -14,216 +14,216 @@
 // You should have received a copy of the GNU Lesser General Public License
 // along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
 
+// Package gasprice implements a gas price oracle recommending prices based on
+// the transactions included in recent blocks. It is shared by full and light
+// clients, retrieving all its data through the generic API backend.
 package gasprice
 
 import (
 	"math/big"
-	"math/rand"
+	"sort"
 	"sync"
 
-	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethdb"
-	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/net/context"
 )
 
 const (
-	gpoProcessPastBlocks = 100
+	sampleNumber = 3 // Number of the cheapest transactions sampled from a block
+)
 
-	// for testing
-	gpoDefaultBaseCorrectionFactor = 110
-	gpoDefaultMinGasPrice          = 10000000000000
+var (
+	DefaultMinPrice = new(big.Int).Mul(big.NewInt(20), common.Shannon)
+	DefaultMaxPrice = new(big.Int).Mul(big.NewInt(500), common.Shannon)
 )
 
-type blockPriceInfo struct {
-	baseGasPrice *big.Int
+// Config contains the settings of the gas price oracle.
+type Config struct {
+	Blocks     int      // Number of recent blocks to sample transactions from
+	Percentile int      // Percentile of the sampled gas prices to suggest
+	MinPrice   *big.Int // Lowest price to suggest, also used if there is nothing to sample
+	MaxPrice   *big.Int // Highest price to suggest
 }
 
-type GpoParams struct {
-	GpoMinGasPrice          *big.Int
-	GpoMaxGasPrice          *big.Int
-	GpoFullBlockRatio       int
-	GpobaseStepDown         int
-	GpobaseStepUp           int
-	GpobaseCorrectionFactor int
+// OracleBackend is the subset of the API backend the oracle retrieves the chain
+// data through. Both the full and the light client API backends implement it.
+type OracleBackend interface {
+	HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error)
+	BlockByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Block, error)
+	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
+	ChainConfig() *params.ChainConfig
 }
 
-// GasPriceOracle recommends gas prices based on the content of recent
-// blocks.
-type GasPriceOracle struct {
-	chain         *core.BlockChain
-	db            ethdb.Database
-	evmux         *event.TypeMux
-	params        *GpoParams
-	initOnce      sync.Once
-	minPrice      *big.Int
-	lastBaseMutex sync.Mutex
-	lastBase      *big.Int
-
-	// state of listenLoop
-	blocks                        map[uint64]*blockPriceInfo
-	firstProcessed, lastProcessed uint64
-	minBase                       *big.Int
+// Oracle recommends gas prices based on the content of recent blocks. It samples
+// the cheapest transactions of each block, ignoring the ones sent by the block's
+// producer, and suggests a configurable percentile of the collected prices.
+type Oracle struct {
+	backend    OracleBackend
+	blocks     int
+	percentile int
+	minPrice   *big.Int
+	maxPrice   *big.Int
+
+	lastHead  common.Hash
+	lastPrice *big.Int
+	cacheLock sync.RWMutex
+	fetchLock sync.Mutex
 }
 
-// NewGasPriceOracle returns a new oracle.
-func NewGasPriceOracle(chain *core.BlockChain, db ethdb.Database, evmux *event.TypeMux, params *GpoParams) *GasPriceOracle {
-	minprice := params.GpoMinGasPrice
-	if minprice == nil {
-		minprice = big.NewInt(gpoDefaultMinGasPrice)
-	}
-	minbase := new(big.Int).Mul(minprice, big.NewInt(100))
-	if params.GpobaseCorrectionFactor > 0 {
-		minbase = minbase.Div(minbase, big.NewInt(int64(params.GpobaseCorrectionFactor)))
-	}
-	return &GasPriceOracle{
-		chain:    chain,
-		db:       db,
-		evmux:    evmux,
-		params:   params,
-		blocks:   make(map[uint64]*blockPriceInfo),
-		minBase:  minbase,
-		minPrice: minprice,
-		lastBase: minprice,
+// NewOracle returns a new gas price oracle, sanitizing any invalid settings.
+func NewOracle(backend OracleBackend, config Config) *Oracle {
+	blocks := config.Blocks
+	if blocks < 1 {
+		glog.V(logger.Warn).Infof("Sanitizing invalid gas price oracle sample blocks: %d -> 1", blocks)
+		blocks = 1
+	}
+	percent := config.Percentile
+	if percent < 0 {
+		glog.V(logger.Warn).Infof("Sanitizing invalid gas price oracle percentile: %d -> 0", percent)
+		percent = 0
+	}
+	if percent > 100 {
+		glog.V(logger.Warn).Infof("Sanitizing invalid gas price oracle percentile: %d -> 100", percent)
+		percent = 100
+	}
+	minPrice := config.MinPrice
+	if minPrice == nil {
+		minPrice = DefaultMinPrice
+	}
+	maxPrice := config.MaxPrice
+	if maxPrice == nil || maxPrice.Sign() <= 0 {
+		maxPrice = DefaultMaxPrice
+	}
+	if maxPrice.Cmp(minPrice) < 0 {
+		glog.V(logger.Warn).Infof("Sanitizing gas price oracle maximum price below the minimum: %v -> %v", maxPrice, minPrice)
+		maxPrice = minPrice
+	}
+	return &Oracle{
+		backend:    backend,
+		blocks:     blocks,
+		percentile: percent,
+		minPrice:   minPrice,
+		maxPrice:   maxPrice,
+		lastPrice:  minPrice,
 	}
 }
 
-func (gpo *GasPriceOracle) init() {
-	gpo.initOnce.Do(func() {
-		gpo.processPastBlocks()
-		go gpo.listenLoop()
-	})
-}
-
-func (self *GasPriceOracle) processPastBlocks() {
-	last := int64(-1)
-	cblock := self.chain.CurrentBlock()
-	if cblock != nil {
-		last = int64(cblock.NumberU64())
-	}
-	first := int64(0)
-	if last > gpoProcessPastBlocks {
-		first = last - gpoProcessPastBlocks
-	}
-	self.firstProcessed = uint64(first)
-	for i := first; i <= last; i++ {
-		block := self.chain.GetBlockByNumber(uint64(i))
-		if block != nil {
-			self.processBlock(block)
+// SuggestPrice returns the recommended gas price.
+func (gpo *Oracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
+	gpo.cacheLock.RLock()
+	lastHead := gpo.lastHead
+	lastPrice := gpo.lastPrice
+	gpo.cacheLock.RUnlock()
+
+	head, _ := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if head == nil {
+		return lastPrice, nil
+	}
+	headHash := head.Hash()
+	if headHash == lastHead {
+		return lastPrice, nil
+	}
+	gpo.fetchLock.Lock()
+	defer gpo.fetchLock.Unlock()
+
+	// Try checking the cache again, maybe the last fetch fetched what we need
+	gpo.cacheLock.RLock()
+	lastHead = gpo.lastHead
+	lastPrice = gpo.lastPrice
+	gpo.cacheLock.RUnlock()
+	if headHash == lastHead {
+		return lastPrice, nil
+	}
+	// Sample the configured number of blocks concurrently. Blocks without any
+	// usable transactions are replaced by older ones, up to twice the limit.
+	var (
+		number    = head.Number.Uint64()
+		maxBlocks = gpo.blocks * 2
+		results   = make(chan blockPricesResult, maxBlocks)
+		sent, exp int
+		prices    []*big.Int
+	)
+	for sent < gpo.blocks && number > 0 {
+		go gpo.getBlockPrices(ctx, number, results)
+		sent++
+		exp++
+		number--
+	}
+	for exp > 0 {
+		res := <-results
+		if res.err != nil {
+			return lastPrice, res.err
 		}
-	}
+		exp--
 
-}
-
-func (self *GasPriceOracle) listenLoop() {
-	events := self.evmux.Subscribe(core.ChainEvent{}, core.ChainSplitEvent{})
-	defer events.Unsubscribe()
-
-	for event := range events.Chan() {
-		switch event := event.Data.(type) {
-		case core.ChainEvent:
-			self.processBlock(event.Block)
-		case core.ChainSplitEvent:
-			self.processBlock(event.Block)
+		if len(res.prices) > 0 {
+			prices = append(prices, res.prices...)
+			continue
+		}
+		if sent < maxBlocks && number > 0 {
+			go gpo.getBlockPrices(ctx, number, results)
+			sent++
+			exp++
+			number--
 		}
 	}
-}
-
-func (self *GasPriceOracle) processBlock(block *types.Block) {
-	i := block.NumberU64()
-	if i > self.lastProcessed {
-		self.lastProcessed = i
-	}
-
-	lastBase := self.minPrice
-	bpl := self.blocks[i-1]
-	if bpl != nil {
-		lastBase = bpl.baseGasPrice
-	}
-	if lastBase == nil {
-		return
-	}
-
-	var corr int
-	lp := self.lowestPrice(block)
-	if lp == nil {
-		return
-	}
-
-	if lastBase.Cmp(lp) < 0 {
-		corr = self.params.GpobaseStepUp
-	} else {
-		corr = -self.params.GpobaseStepDown
+	price := lastPrice
+	if len(prices) > 0 {
+		sort.Sort(bigIntArray(prices))
+		price = prices[(len(prices)-1)*gpo.percentile/100]
 	}
-
-	crand := int64(corr * (900 + rand.Intn(201)))
-	newBase := new(big.Int).Mul(lastBase, big.NewInt(1000000+crand))
-	newBase.Div(newBase, big.NewInt(1000000))
-
-	if newBase.Cmp(self.minBase) < 0 {
-		newBase = self.minBase
+	if price.Cmp(gpo.minPrice) < 0 {
+		price = gpo.minPrice
 	}
-
-	bpi := self.blocks[i]
-	if bpi == nil {
-		bpi = &blockPriceInfo{}
-		self.blocks[i] = bpi
+	if price.Cmp(gpo.maxPrice) > 0 {
+		price = gpo.maxPrice
 	}
-	bpi.baseGasPrice = newBase
-	self.lastBaseMutex.Lock()
-	self.lastBase = newBase
-	self.lastBaseMutex.Unlock()
+	gpo.cacheLock.Lock()
+	gpo.lastHead = headHash
+	gpo.lastPrice = price
+	gpo.cacheLock.Unlock()
 
-	glog.V(logger.Detail).Infof("Processed block #%v, base price is %v\n", i, newBase.Int64())
+	return price, nil
 }
 
-// returns the lowers possible price with which a tx was or could have been included
-func (self *GasPriceOracle) lowestPrice(block *types.Block) *big.Int {
-	gasUsed := big.NewInt(0)
-
-	receipts := core.GetBlockReceipts(self.db, block.Hash(), block.NumberU64())
-	if len(receipts) > 0 {
-		if cgu := receipts[len(receipts)-1].CumulativeGasUsed; cgu != nil {
-			gasUsed = receipts[len(receipts)-1].CumulativeGasUsed
-		}
-	}
-
-	if new(big.Int).Mul(gasUsed, big.NewInt(100)).Cmp(new(big.Int).Mul(block.GasLimit(),
-		big.NewInt(int64(self.params.GpoFullBlockRatio)))) < 0 {
-		// block is not full, could have posted a tx with MinGasPrice
-		return big.NewInt(0)
-	}
+// blockPricesResult is the outcome of sampling the prices of a single block.
+type blockPricesResult struct {
+	prices []*big.Int
+	err    error
+}
 
-	txs := block.Transactions()
-	if len(txs) == 0 {
-		return big.NewInt(0)
+// getBlockPrices samples the cheapest transaction gas prices of a block and
+// sends them to the result channel. Transactions sent by the producer of the
+// block are skipped, as miners are free to include their own ones at any price.
+func (gpo *Oracle) getBlockPrices(ctx context.Context, number uint64, results chan blockPricesResult) {
+	block, err := gpo.backend.BlockByNumber(ctx, rpc.BlockNumber(number))
+	if block == nil {
+		results <- blockPricesResult{nil, err}
+		return
 	}
-	// block is full, find smallest gasPrice
-	minPrice := txs[0].GasPrice()
-	for i := 1; i < len(txs); i++ {
-		price := txs[i].GasPrice()
-		if price.Cmp(minPrice) < 0 {
-			minPrice = price
+	txs := make(types.Transactions, len(block.Transactions()))
+	copy(txs, block.Transactions())
+	sort.Sort(types.TxByPrice(txs))
+
+	var (
+		signer = types.MakeSigner(gpo.backend.ChainConfig(), block.Number())
+		prices []*big.Int
+	)
+	for i := len(txs) - 1; i >= 0 && len(prices) < sampleNumber; i-- {
+		if sender, err := types.Sender(signer, txs[i]); err == nil && sender != block.Coinbase() {
+			prices = append(prices, txs[i].GasPrice())
 		}
 	}
-	return minPrice
+	results <- blockPricesResult{prices, nil}
 }
 
-// SuggestPrice returns the recommended gas price.
-func (self *GasPriceOracle) SuggestPrice() *big.Int {
-	self.init()
-	self.lastBaseMutex.Lock()
-	price := new(big.Int).Set(self.lastBase)
-	self.lastBaseMutex.Unlock()
-
-	price.Mul(price, big.NewInt(int64(self.params.GpobaseCorrectionFactor)))
-	price.Div(price, big.NewInt(100))
-	if price.Cmp(self.minPrice) < 0 {
-		price.Set(self.minPrice)
-	} else if self.params.GpoMaxGasPrice != nil && price.Cmp(self.params.GpoMaxGasPrice) > 0 {
-		price.Set(self.params.GpoMaxGasPrice)
-	}
-	return price
-}
+type bigIntArray []*big.Int
+
+func (s bigIntArray) Len() int           { return len(s) }
+func (s bigIntArray) Less(i, j int) bool { return s[i].Cmp(s[j]) < 0 }
+func (s bigIntArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
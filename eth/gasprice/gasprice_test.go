@@ -0,0 +1,229 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/net/context"
+)
+
+// testBackend is a simple chain of blocks implementing OracleBackend.
+type testBackend struct {
+	blocks   []*types.Block
+	receipts map[common.Hash]types.Receipts
+}
+
+// newTestBackend creates a chain of blocks where block i contains transactions
+// priced at i, i+1 and i+2 Shannon from a user, and one priced at 1 wei from the
+// producer of the block. All transactions use 21000 gas, half of the gas limit.
+func newTestBackend(t *testing.T, length int) *testBackend {
+	var (
+		userKey, _  = crypto.GenerateKey()
+		minerKey, _ = crypto.GenerateKey()
+		miner       = crypto.PubkeyToAddress(minerKey.PublicKey)
+		signer      = types.MakeSigner(params.TestChainConfig, new(big.Int))
+		gas         = big.NewInt(21000)
+		backend     = &testBackend{receipts: make(map[common.Hash]types.Receipts)}
+	)
+	sign := func(key *ecdsa.PrivateKey, nonce uint64, price *big.Int) *types.Transaction {
+		tx := types.NewTransaction(nonce, common.Address{}, new(big.Int), gas, price, nil)
+		signed, err := types.SignTx(tx, signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		return signed
+	}
+	backend.blocks = append(backend.blocks, types.NewBlockWithHeader(&types.Header{Number: new(big.Int), GasLimit: big.NewInt(84000), GasUsed: new(big.Int)}))
+	for i := 1; i <= length; i++ {
+		var (
+			txs      types.Transactions
+			receipts types.Receipts
+		)
+		for j := 0; j < 3; j++ {
+			txs = append(txs, sign(userKey, uint64(3*(i-1)+j), new(big.Int).Mul(big.NewInt(int64(i+j)), common.Shannon)))
+		}
+		txs = append(txs, sign(minerKey, uint64(i-1), big.NewInt(1)))
+		for j := range txs {
+			receipts = append(receipts, types.NewReceipt(nil, new(big.Int).Mul(gas, big.NewInt(int64(j+1)))))
+		}
+		header := &types.Header{
+			ParentHash: backend.blocks[i-1].Hash(),
+			Number:     big.NewInt(int64(i)),
+			Coinbase:   miner,
+			GasLimit:   big.NewInt(168000),
+			GasUsed:    big.NewInt(84000),
+		}
+		block := types.NewBlock(header, txs, nil, receipts)
+		backend.blocks = append(backend.blocks, block)
+		backend.receipts[block.Hash()] = receipts
+	}
+	return backend
+}
+
+func (b *testBackend) block(number rpc.BlockNumber) *types.Block {
+	if number < 0 {
+		return b.blocks[len(b.blocks)-1]
+	}
+	if int(number) >= len(b.blocks) {
+		return nil
+	}
+	return b.blocks[number]
+}
+
+func (b *testBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	if block := b.block(number); block != nil {
+		return block.Header(), nil
+	}
+	return nil, nil
+}
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	return b.block(number), nil
+}
+
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return b.receipts[hash], nil
+}
+
+func (b *testBackend) ChainConfig() *params.ChainConfig {
+	return params.TestChainConfig
+}
+
+// Tests that the oracle suggests the configured percentile of the recent prices,
+// ignoring the transactions of block producers and respecting the price limits.
+func TestSuggestPrice(t *testing.T) {
+	backend := newTestBackend(t, 20)
+
+	tests := []struct {
+		config Config
+		want   int64 // Expected price in Shannon
+	}{
+		{Config{Blocks: 10, Percentile: 50, MinPrice: big.NewInt(1)}, 16},
+		{Config{Blocks: 10, Percentile: 0, MinPrice: big.NewInt(1)}, 11},
+		{Config{Blocks: 10, Percentile: 100, MinPrice: big.NewInt(1)}, 22},
+		{Config{Blocks: 1, Percentile: 50, MinPrice: big.NewInt(1)}, 21},
+		{Config{Blocks: 10, Percentile: 50, MinPrice: big.NewInt(1), MaxPrice: new(big.Int).Mul(big.NewInt(15), common.Shannon)}, 15},
+		{Config{Blocks: 10, Percentile: 50, MinPrice: new(big.Int).Mul(big.NewInt(30), common.Shannon)}, 30},
+	}
+	for i, tt := range tests {
+		price, err := NewOracle(backend, tt.config).SuggestPrice(context.Background())
+		if err != nil {
+			t.Fatalf("test %d: failed to suggest price: %v", i, err)
+		}
+		if want := new(big.Int).Mul(big.NewInt(tt.want), common.Shannon); price.Cmp(want) != 0 {
+			t.Errorf("test %d: price mismatch: have %v, want %v", i, price, want)
+		}
+	}
+}
+
+// Tests that the fee history reports the gas used weighted price percentiles and
+// the gas usage ratios of the requested block range.
+func TestFeeHistory(t *testing.T) {
+	oracle := NewOracle(newTestBackend(t, 20), Config{Blocks: 10, Percentile: 50})
+
+	// Retrieve the percentiles of the last few blocks
+	oldest, reward, ratio, err := oracle.FeeHistory(context.Background(), 4, rpc.LatestBlockNumber, []float64{0, 50, 100})
+	if err != nil {
+		t.Fatalf("failed to retrieve fee history: %v", err)
+	}
+	if oldest.Uint64() != 17 {
+		t.Errorf("oldest block mismatch: have %v, want %d", oldest, 17)
+	}
+	if len(reward) != 4 || len(ratio) != 4 {
+		t.Fatalf("result length mismatch: have %d/%d, want %d", len(reward), len(ratio), 4)
+	}
+	for i := range reward {
+		number := int64(17 + i)
+		want := []*big.Int{big.NewInt(1), new(big.Int).Mul(big.NewInt(number), common.Shannon), new(big.Int).Mul(big.NewInt(number+2), common.Shannon)}
+		for j := range want {
+			if reward[i][j].Cmp(want[j]) != 0 {
+				t.Errorf("block %d, percentile %d: reward mismatch: have %v, want %v", number, j, reward[i][j], want[j])
+			}
+		}
+		if ratio[i] != 0.5 {
+			t.Errorf("block %d: gas used ratio mismatch: have %v, want %v", number, ratio[i], 0.5)
+		}
+	}
+	// Request more blocks than available, without percentiles
+	oldest, reward, ratio, err = oracle.FeeHistory(context.Background(), 100, rpc.BlockNumber(5), nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve fee history: %v", err)
+	}
+	if oldest.Sign() != 0 || reward != nil || len(ratio) != 6 {
+		t.Errorf("clamped history mismatch: have oldest %v, %d rewards, %d ratios", oldest, len(reward), len(ratio))
+	}
+	// Invalid percentiles should be rejected
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 1, rpc.LatestBlockNumber, []float64{50, 10}); err == nil {
+		t.Errorf("decreasing percentiles accepted")
+	}
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 1, rpc.LatestBlockNumber, []float64{101}); err == nil {
+		t.Errorf("out of range percentile accepted")
+	}
+	if _, _, _, err := oracle.FeeHistory(context.Background(), 1, rpc.BlockNumber(50), nil); err == nil {
+		t.Errorf("missing block accepted")
+	}
+}
+
+// concurrencyBackend is a test backend tracking the peak number of concurrent
+// block retrievals.
+type concurrencyBackend struct {
+	*testBackend
+	active int32
+	peak   int32
+}
+
+func (b *concurrencyBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	active := atomic.AddInt32(&b.active, 1)
+	defer atomic.AddInt32(&b.active, -1)
+
+	for {
+		peak := atomic.LoadInt32(&b.peak)
+		if active <= peak || atomic.CompareAndSwapInt32(&b.peak, peak, active) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return b.testBackend.BlockByNumber(ctx, number)
+}
+
+// Tests that the fee history of many blocks is retrieved by a bounded number of
+// concurrent fetchers.
+func TestFeeHistoryConcurrency(t *testing.T) {
+	backend := &concurrencyBackend{testBackend: newTestBackend(t, 64)}
+	oracle := NewOracle(backend, Config{Blocks: 10, Percentile: 50})
+
+	_, reward, _, err := oracle.FeeHistory(context.Background(), 64, rpc.LatestBlockNumber, []float64{50})
+	if err != nil {
+		t.Fatalf("failed to retrieve fee history: %v", err)
+	}
+	if len(reward) != 64 {
+		t.Fatalf("result length mismatch: have %d, want %d", len(reward), 64)
+	}
+	if peak := atomic.LoadInt32(&backend.peak); peak > maxBlockFetchers {
+		t.Errorf("concurrent retrievals exceeded: have %d, want at most %d", peak, maxBlockFetchers)
+	}
+}
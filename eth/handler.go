@@ -28,6 +28,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/forkid"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/eth/fetcher"
@@ -71,10 +72,15 @@ type ProtocolManager struct {
 	chainconfig *params.ChainConfig
 	maxPeers    int
 
+	forkFilter forkid.Filter // Fork ID filter, constant across the lifetime of the node
+
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
+	txFetcher  *fetcher.TxFetcher
 	peers      *peerSet
 
+	reputations *reputationSet // Reputations of recently seen peers, surviving reconnects
+
 	SubProtocols []p2p.Protocol
 
 	eventMux      *event.TypeMux
@@ -109,11 +115,15 @@ func NewProtocolManager(config *params.ChainConfig, fastSync bool, networkId int
 		chainconfig: config,
 		maxPeers:    maxPeers,
 		peers:       newPeerSet(),
+		reputations: newReputationSet(),
 		newPeerCh:   make(chan *peer),
 		noMorePeers: make(chan struct{}),
 		txsyncCh:    make(chan *txsync),
 		quitSync:    make(chan struct{}),
 	}
+	manager.forkFilter = forkid.NewFilter(config, blockchain.Genesis().Hash(), func() uint64 {
+		return blockchain.CurrentHeader().Number.Uint64()
+	})
 	// Figure out whether to allow fast sync or not
 	if fastSync && blockchain.CurrentBlock().NumberU64() > 0 {
 		glog.V(logger.Info).Infof("blockchain not empty, fast sync disabled")
@@ -164,7 +174,8 @@ func NewProtocolManager(config *params.ChainConfig, fastSync bool, networkId int
 	manager.downloader = downloader.New(downloader.FullSync, chaindb, manager.eventMux, blockchain.HasHeader, blockchain.HasBlockAndState, blockchain.GetHeaderByHash,
 		blockchain.GetBlockByHash, blockchain.CurrentHeader, blockchain.CurrentBlock, blockchain.CurrentFastBlock, blockchain.FastSyncCommitHead,
 		blockchain.GetTdByHash, blockchain.InsertHeaderChain, manager.insertChain, blockchain.InsertReceiptChain, blockchain.Rollback,
-		manager.removePeer)
+		manager.penalisePeer)
+	manager.downloader.SetPeerReputation(manager.poorPeer)
 
 	validator := func(block *types.Block, parent *types.Block) error {
 		return core.ValidateHeader(config, pow, block.Header(), parent.Header(), true, false)
@@ -176,7 +187,15 @@ func NewProtocolManager(config *params.ChainConfig, fastSync bool, networkId int
 		manager.setSynced() // Mark initial sync done on any fetcher import
 		return manager.insertChain(blocks)
 	}
-	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
+	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.penalisePeer)
+	manager.fetcher.SetAnnounceFailedHandler(func(id string) {
+		manager.reputations.get(id).markUndelivered()
+	})
+
+	hasTx := func(hash common.Hash) bool {
+		return txpool.Get(hash) != nil
+	}
+	manager.txFetcher = fetcher.NewTxFetcher(hasTx, txpool.AddBatch)
 
 	if blockchain.Genesis().Hash().Hex() == defaultGenesisHash && networkId == 1 {
 		glog.V(logger.Debug).Infoln("Bad Block Reporting is enabled")
@@ -202,8 +221,9 @@ func (pm *ProtocolManager) removePeer(id string) {
 	}
 	glog.V(logger.Debug).Infoln("Removing peer", id)
 
-	// Unregister the peer from the downloader and Ethereum peer set
+	// Unregister the peer from the downloader, transaction fetcher and Ethereum peer set
 	pm.downloader.UnregisterPeer(id)
+	pm.txFetcher.Drop(id)
 	if err := pm.peers.Unregister(id); err != nil {
 		glog.V(logger.Error).Infoln("Removal failed:", err)
 	}
@@ -213,10 +233,59 @@ func (pm *ProtocolManager) removePeer(id string) {
 	}
 }
 
+// penalisePeer drops a peer that delivered invalid data or stalled a sync, also
+// recording the offence in its reputation so it is avoided if it reconnects.
+func (pm *ProtocolManager) penalisePeer(id string) {
+	pm.reputations.get(id).markInvalid()
+	pm.removePeer(id)
+}
+
+// poorPeer reports whether the peer with the given id has a poor reputation, so
+// that the downloader prefers other peers for its requests.
+func (pm *ProtocolManager) poorPeer(id string) bool {
+	rep := pm.reputations.peek(id)
+	return rep != nil && rep.score() < poorScore
+}
+
+// evictLoop periodically disconnects the worst peer if we're at capacity and its
+// reputation is poor. The p2p server refuses new connections once full, so room
+// for better peers has to be made before they arrive.
+func (pm *ProtocolManager) evictLoop() {
+	defer pm.wg.Done()
+
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pm.evictPoorPeer()
+		case <-pm.quitSync:
+			return
+		}
+	}
+}
+
+// evictPoorPeer disconnects the worst peer if the peer set is full and the
+// peer's reputation is poor, reporting whether a peer was evicted.
+func (pm *ProtocolManager) evictPoorPeer() bool {
+	if pm.peers.Len() < pm.maxPeers {
+		return false
+	}
+	worst := pm.peers.WorstPeer()
+	if worst == nil || worst.rep.score() >= poorScore {
+		return false
+	}
+	glog.V(logger.Debug).Infof("%v: evicting poor peer to make room", worst)
+	pm.removePeer(worst.id)
+	return true
+}
+
 func (pm *ProtocolManager) Start() {
 	// broadcast transactions
 	pm.txSub = pm.eventMux.Subscribe(core.TxPreEvent{})
 	go pm.txBroadcastLoop()
+	pm.txFetcher.Start()
 	// broadcast mined blocks
 	pm.minedBlockSub = pm.eventMux.Subscribe(core.NewMinedBlockEvent{})
 	go pm.minedBroadcastLoop()
@@ -224,6 +293,10 @@ func (pm *ProtocolManager) Start() {
 	// start sync handlers
 	go pm.syncer()
 	go pm.txsyncLoop()
+
+	// make room for new peers by evicting poor ones
+	pm.wg.Add(1)
+	go pm.evictLoop()
 }
 
 func (pm *ProtocolManager) Stop() {
@@ -231,6 +304,7 @@ func (pm *ProtocolManager) Stop() {
 
 	pm.txSub.Unsubscribe()         // quits txBroadcastLoop
 	pm.minedBlockSub.Unsubscribe() // quits blockBroadcastLoop
+	pm.txFetcher.Stop()
 
 	// Quit the sync loop.
 	// After this send has completed, no new peers will be accepted.
@@ -265,11 +339,19 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	glog.V(logger.Debug).Infof("%v: peer connected [%s]", p, p.Name())
 
 	// Execute the Ethereum handshake
-	td, head, genesis := pm.blockchain.Status()
-	if err := p.Handshake(pm.networkId, td, head, genesis); err != nil {
+	var (
+		td, head, genesis = pm.blockchain.Status()
+		number            = pm.blockchain.CurrentHeader().Number.Uint64()
+		forkID            = forkid.NewID(pm.chainconfig, genesis, number)
+	)
+	if err := p.Handshake(pm.networkId, td, head, genesis, forkID, pm.forkFilter); err != nil {
 		glog.V(logger.Debug).Infof("%v: handshake failed: %v", p, err)
 		return err
 	}
+	// Only track peers that got through the handshake, so that reconnect churn
+	// can't flush the remembered reputations
+	p.rep = pm.reputations.get(p.id)
+
 	if rw, ok := p.rw.(*meteredMsgReadWriter); ok {
 		rw.Init(p.version)
 	}
@@ -289,10 +371,11 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	// after this will be sent via broadcasts.
 	pm.syncTransactions(p)
 
-	// If we're DAO hard-fork aware, validate any remote peer with regard to the hard-fork
-	if daoBlock := pm.chainconfig.DAOForkBlock; daoBlock != nil {
+	// If we're DAO hard-fork aware, validate any legacy remote peer with regard to
+	// the hard-fork. From eth/64 onwards, the fork ID already did it in the handshake.
+	if daoBlock := pm.chainconfig.DAOForkBlock; daoBlock != nil && p.version < eth64 {
 		// Request the peer's DAO fork header for extra-data validation
-		if err := p.RequestHeadersByNumber(daoBlock.Uint64(), 1, 0, false); err != nil {
+		if err := p.RequestChallengeHeader(daoBlock.Uint64()); err != nil {
 			return err
 		}
 		// Start a timer to disconnect if the peer doesn't reply in time
@@ -338,9 +421,21 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	// Block header query, collect the requested headers and reply
 	case msg.Code == GetBlockHeadersMsg:
-		// Decode the complex header query
-		var query getBlockHeadersData
-		if err := msg.Decode(&query); err != nil {
+		// Decode the complex header query, along with the request ID from eth/66
+		var (
+			reqID uint64
+			query getBlockHeadersData
+		)
+		if p.version >= eth66 {
+			var request getBlockHeadersData66
+			if err := msg.Decode(&request); err != nil {
+				return errResp(ErrDecode, "%v: %v", msg, err)
+			}
+			if request.Query == nil {
+				return errResp(ErrDecode, "%v: missing header query", msg)
+			}
+			reqID, query = request.RequestId, *request.Query
+		} else if err := msg.Decode(&query); err != nil {
 			return errResp(ErrDecode, "%v: %v", msg, err)
 		}
 		hashMode := query.Origin.Hash != (common.Hash{})
@@ -413,7 +508,34 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += (query.Skip + 1)
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.SendBlockHeaders(reqID, headers)
+
+	case p.version >= eth66 && msg.Code == BlockHeadersMsg:
+		// A batch of headers arrived, route it to the request it answers
+		var response blockHeadersData66
+		if err := msg.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		req := resolveReply(p, response.RequestId, BlockHeadersMsg, len(response.Headers))
+		if req == nil {
+			glog.V(logger.Debug).Infof("%v: unrequested headers (id %d), ignoring", p, response.RequestId)
+			return nil
+		}
+		switch req.origin {
+		case originChallenge:
+			if handled, err := pm.handleChallenge(p, response.Headers); err != nil || handled {
+				return err
+			}
+			glog.V(logger.Debug).Infof("%v: unexpected challenge reply, ignoring", p)
+
+		case originFetcher:
+			pm.fetcher.FilterHeaders(response.Headers, time.Now())
+
+		case originDownloader:
+			if err := pm.downloader.DeliverHeaders(p.id, req.token, response.Headers); err != nil {
+				glog.V(logger.Debug).Infoln(err)
+			}
+		}
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
@@ -421,48 +543,20 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&headers); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
-		// If no headers were received, but we're expending a DAO fork check, maybe it's that
-		if len(headers) == 0 && p.forkDrop != nil {
-			// Possibly an empty reply to the fork header checks, sanity check TDs
-			verifyDAO := true
+		p.rep.markResponse(len(headers))
 
-			// If we already have a DAO header, we can check the peer's TD against it. If
-			// the peer's ahead of this, it too must have a reply to the DAO check
-			if daoHeader := pm.blockchain.GetHeaderByNumber(pm.chainconfig.DAOForkBlock.Uint64()); daoHeader != nil {
-				if _, td := p.Head(); td.Cmp(pm.blockchain.GetTd(daoHeader.Hash(), daoHeader.Number.Uint64())) >= 0 {
-					verifyDAO = false
-				}
-			}
-			// If we're seemingly on the same chain, disable the drop timer
-			if verifyDAO {
-				glog.V(logger.Debug).Infof("%v: seems to be on the same side of the DAO fork", p)
-				p.forkDrop.Stop()
-				p.forkDrop = nil
-				return nil
-			}
+		// Legacy replies can't be matched to requests, check the challenges first
+		if handled, err := pm.handleChallenge(p, headers); err != nil || handled {
+			return err
 		}
 		// Filter out any explicitly requested headers, deliver the rest to the downloader
 		filter := len(headers) == 1
 		if filter {
-			// If it's a potential DAO fork check, validate against the rules
-			if p.forkDrop != nil && pm.chainconfig.DAOForkBlock.Cmp(headers[0].Number) == 0 {
-				// Disable the fork drop timer
-				p.forkDrop.Stop()
-				p.forkDrop = nil
-
-				// Validate the header and either drop the peer or continue
-				if err := core.ValidateDAOHeaderExtraData(pm.chainconfig, headers[0]); err != nil {
-					glog.V(logger.Debug).Infof("%v: verified to be on the other side of the DAO fork, dropping", p)
-					return err
-				}
-				glog.V(logger.Debug).Infof("%v: verified to be on the same side of the DAO fork", p)
-				return nil
-			}
 			// Irrelevant of the fork checks, send the header to the fetcher just in case
 			headers = pm.fetcher.FilterHeaders(headers, time.Now())
 		}
 		if len(headers) > 0 || !filter {
-			err := pm.downloader.DeliverHeaders(p.id, headers)
+			err := pm.downloader.DeliverHeaders(p.id, 0, headers)
 			if err != nil {
 				glog.V(logger.Debug).Infoln(err)
 			}
@@ -470,8 +564,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case msg.Code == GetBlockBodiesMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		reqID, msgStream, err := openHashStream(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather blocks until the fetch or network limits is reached
@@ -487,13 +581,42 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			} else if err != nil {
 				return errResp(ErrDecode, "msg %v: %v", msg, err)
 			}
-			// Retrieve the requested block body, stopping if enough was found
+			// Retrieve the requested block body, stopping if enough was found (pruned
+			// bodies are missing from the database, so they are skipped too)
 			if data := pm.blockchain.GetBodyRLP(hash); len(data) != 0 {
 				bodies = append(bodies, data)
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.SendBlockBodiesRLP(reqID, bodies)
+
+	case p.version >= eth66 && msg.Code == BlockBodiesMsg:
+		// A batch of block bodies arrived, route it to the request it answers
+		var response blockBodiesData66
+		if err := msg.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		req := resolveReply(p, response.RequestId, BlockBodiesMsg, len(response.Bodies))
+		if req == nil {
+			glog.V(logger.Debug).Infof("%v: unrequested block bodies (id %d), ignoring", p, response.RequestId)
+			return nil
+		}
+		trasactions := make([][]*types.Transaction, len(response.Bodies))
+		uncles := make([][]*types.Header, len(response.Bodies))
+
+		for i, body := range response.Bodies {
+			trasactions[i] = body.Transactions
+			uncles[i] = body.Uncles
+		}
+		switch req.origin {
+		case originFetcher:
+			pm.fetcher.FilterBodies(trasactions, uncles, time.Now())
+
+		case originDownloader:
+			if err := pm.downloader.DeliverBodies(p.id, req.token, trasactions, uncles); err != nil {
+				glog.V(logger.Debug).Infoln(err)
+			}
+		}
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
@@ -501,6 +624,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&request); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.rep.markResponse(len(request))
+
 		// Deliver them all to the downloader for queuing
 		trasactions := make([][]*types.Transaction, len(request))
 		uncles := make([][]*types.Header, len(request))
@@ -515,7 +640,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			trasactions, uncles = pm.fetcher.FilterBodies(trasactions, uncles, time.Now())
 		}
 		if len(trasactions) > 0 || len(uncles) > 0 || !filter {
-			err := pm.downloader.DeliverBodies(p.id, trasactions, uncles)
+			err := pm.downloader.DeliverBodies(p.id, 0, trasactions, uncles)
 			if err != nil {
 				glog.V(logger.Debug).Infoln(err)
 			}
@@ -523,8 +648,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case p.version >= eth63 && msg.Code == GetNodeDataMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		reqID, msgStream, err := openHashStream(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -546,7 +671,22 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.SendNodeData(reqID, data)
+
+	case p.version >= eth66 && msg.Code == NodeDataMsg:
+		// A batch of node state data arrived, deliver it if we requested it
+		var response nodeData66
+		if err := msg.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		req := resolveReply(p, response.RequestId, NodeDataMsg, len(response.Data))
+		if req == nil {
+			glog.V(logger.Debug).Infof("%v: unrequested node state data (id %d), ignoring", p, response.RequestId)
+			return nil
+		}
+		if err := pm.downloader.DeliverNodeData(p.id, req.token, response.Data); err != nil {
+			glog.V(logger.Debug).Infof("failed to deliver node state data: %v", err)
+		}
 
 	case p.version >= eth63 && msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
@@ -554,15 +694,17 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&data); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.rep.markResponse(len(data))
+
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
+		if err := pm.downloader.DeliverNodeData(p.id, 0, data); err != nil {
 			glog.V(logger.Debug).Infof("failed to deliver node state data: %v", err)
 		}
 
 	case p.version >= eth63 && msg.Code == GetReceiptsMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		reqID, msgStream, err := openHashStream(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -578,8 +720,12 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			} else if err != nil {
 				return errResp(ErrDecode, "msg %v: %v", msg, err)
 			}
-			// Retrieve the requested block's receipts, skipping if unknown to us
-			results := core.GetBlockReceipts(pm.chaindb, hash, core.GetBlockNumber(pm.chaindb, hash))
+			// Retrieve the requested block's receipts, skipping if unknown or pruned
+			number := core.GetBlockNumber(pm.chaindb, hash)
+			if pm.blockchain.IsPruned(number) {
+				continue
+			}
+			results := core.GetBlockReceipts(pm.chaindb, hash, number)
 			if results == nil {
 				if header := pm.blockchain.GetHeaderByHash(hash); header == nil || header.ReceiptHash != types.EmptyRootHash {
 					continue
@@ -593,7 +739,22 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.SendReceiptsRLP(reqID, receipts)
+
+	case p.version >= eth66 && msg.Code == ReceiptsMsg:
+		// A batch of receipts arrived, deliver them if we requested them
+		var response receiptsData66
+		if err := msg.Decode(&response); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		req := resolveReply(p, response.RequestId, ReceiptsMsg, len(response.Receipts))
+		if req == nil {
+			glog.V(logger.Debug).Infof("%v: unrequested receipts (id %d), ignoring", p, response.RequestId)
+			return nil
+		}
+		if err := pm.downloader.DeliverReceipts(p.id, req.token, response.Receipts); err != nil {
+			glog.V(logger.Debug).Infof("failed to deliver receipts: %v", err)
+		}
 
 	case p.version >= eth63 && msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
@@ -601,8 +762,10 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&receipts); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		p.rep.markResponse(len(receipts))
+
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
+		if err := pm.downloader.DeliverReceipts(p.id, 0, receipts); err != nil {
 			glog.V(logger.Debug).Infof("failed to deliver receipts: %v", err)
 		}
 
@@ -623,7 +786,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 		for _, block := range unknown {
-			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestBodies)
+			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestAnnouncedBodies)
 		}
 
 	case msg.Code == NewBlockMsg:
@@ -675,7 +838,77 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 			p.MarkTransaction(tx.Hash())
 		}
-		pm.txpool.AddBatch(txs)
+		pm.txFetcher.Enqueue(p.id, txs, true)
+
+	case p.version >= eth65 && msg.Code == NewPooledTransactionHashesMsg:
+		// New transactions were announced, make sure we have a valid and fresh chain to handle them
+		if atomic.LoadUint32(&pm.synced) == 0 {
+			break
+		}
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Mark the hashes as present at the remote node and schedule any unknown ones
+		for _, hash := range hashes {
+			p.MarkTransaction(hash)
+		}
+		pm.txFetcher.Notify(p.id, hashes, time.Now(), p.RequestTxs)
+
+	case p.version >= eth65 && msg.Code == GetPooledTransactionsMsg:
+		// Decode the retrieval message
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		if _, err := msgStream.List(); err != nil {
+			return err
+		}
+		// Gather transactions until the fetch or network limits is reached
+		var (
+			hash   common.Hash
+			bytes  int
+			hashes []common.Hash
+			txs    []rlp.RawValue
+		)
+		for bytes < softResponseLimit {
+			// Retrieve the hash of the next transaction
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested transaction, skipping if unknown to us
+			tx := pm.txpool.Get(hash)
+			if tx == nil {
+				continue
+			}
+			// If known, encode and queue for response packet
+			if encoded, err := rlp.EncodeToBytes(tx); err != nil {
+				glog.V(logger.Error).Infof("failed to encode transaction: %v", err)
+			} else {
+				hashes = append(hashes, hash)
+				txs = append(txs, encoded)
+				bytes += len(encoded)
+			}
+		}
+		return p.SendPooledTransactionsRLP(hashes, txs)
+
+	case p.version >= eth65 && msg.Code == PooledTransactionsMsg:
+		// A batch of transactions arrived to one of our previous requests
+		if atomic.LoadUint32(&pm.synced) == 0 {
+			break
+		}
+		var txs []*types.Transaction
+		if err := msg.Decode(&txs); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, tx := range txs {
+			// Validate and mark the remote transaction
+			if tx == nil {
+				return errResp(ErrDecode, "transaction %d is nil", i)
+			}
+			p.MarkTransaction(tx.Hash())
+		}
+		p.rep.markResponse(len(txs))
+		pm.txFetcher.Enqueue(p.id, txs, false)
 
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
@@ -683,6 +916,84 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	return nil
 }
 
+// handleChallenge checks whether a batch of headers is a reply to the DAO fork
+// challenge, validating it if so. The returned flag reports whether the headers
+// were consumed by the challenge.
+func (pm *ProtocolManager) handleChallenge(p *peer, headers []*types.Header) (bool, error) {
+	// If no headers were received, but we're expending a DAO fork check, maybe it's that
+	if len(headers) == 0 && p.forkDrop != nil {
+		// Possibly an empty reply to the fork header checks, sanity check TDs
+		verifyDAO := true
+
+		// If we already have a DAO header, we can check the peer's TD against it. If
+		// the peer's ahead of this, it too must have a reply to the DAO check
+		if daoHeader := pm.blockchain.GetHeaderByNumber(pm.chainconfig.DAOForkBlock.Uint64()); daoHeader != nil {
+			if _, td := p.Head(); td.Cmp(pm.blockchain.GetTd(daoHeader.Hash(), daoHeader.Number.Uint64())) >= 0 {
+				verifyDAO = false
+			}
+		}
+		// If we're seemingly on the same chain, disable the drop timer
+		if verifyDAO {
+			glog.V(logger.Debug).Infof("%v: seems to be on the same side of the DAO fork", p)
+			p.forkDrop.Stop()
+			p.forkDrop = nil
+			return true, nil
+		}
+	}
+	if len(headers) != 1 {
+		return false, nil
+	}
+	// If it's a potential DAO fork check, validate against the rules
+	if p.forkDrop != nil && pm.chainconfig.DAOForkBlock.Cmp(headers[0].Number) == 0 {
+		// Disable the fork drop timer
+		p.forkDrop.Stop()
+		p.forkDrop = nil
+
+		// Validate the header and either drop the peer or continue
+		if err := core.ValidateDAOHeaderExtraData(pm.chainconfig, headers[0]); err != nil {
+			glog.V(logger.Debug).Infof("%v: verified to be on the other side of the DAO fork, dropping", p)
+			return true, err
+		}
+		glog.V(logger.Debug).Infof("%v: verified to be on the same side of the DAO fork", p)
+		return true, nil
+	}
+	return false, nil
+}
+
+// resolveReply matches an eth/66 reply to the request it answers, rating the peer
+// by the latency and contents of the reply. Replies not answering any pending
+// request are rated useless and nil is returned.
+func resolveReply(p *peer, id uint64, code uint64, items int) *request {
+	req := p.resolve(id, code)
+	if req == nil {
+		p.rep.markUseless()
+		return nil
+	}
+	p.rep.markLatency(time.Since(req.sent))
+	p.rep.markResponse(items)
+	return req
+}
+
+// openHashStream opens the list of hashes contained in a hash based retrieval
+// request, returning the stream positioned at the first hash. From eth/66 the
+// hashes are preceded by a request ID, which is also returned.
+func openHashStream(p *peer, msg p2p.Msg) (uint64, *rlp.Stream, error) {
+	stream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+	if _, err := stream.List(); err != nil {
+		return 0, nil, err
+	}
+	var reqID uint64
+	if p.version >= eth66 {
+		if err := stream.Decode(&reqID); err != nil {
+			return 0, nil, errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if _, err := stream.List(); err != nil {
+			return 0, nil, err
+		}
+	}
+	return reqID, stream, nil
+}
+
 // BroadcastBlock will either propagate a block to a subset of it's peers, or
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
@@ -716,15 +1027,32 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 }
 
 // BroadcastTx will propagate a transaction to all peers which are not known to
-// already have the given transaction.
+// already have the given transaction. Legacy peers get the entire transaction,
+// whereas only a square root subset of eth/65 peers do, the rest of them being
+// announced the hash only, retrieving the transaction on demand.
 func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction) {
-	// Broadcast transaction to a batch of peers not knowing about it
-	peers := pm.peers.PeersWithoutTx(hash)
-	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
-	for _, peer := range peers {
+	// Split the peers not knowing about the transaction by protocol capability
+	var legacy, pooled []*peer
+	for _, peer := range pm.peers.PeersWithoutTx(hash) {
+		if peer.version >= eth65 {
+			pooled = append(pooled, peer)
+		} else {
+			legacy = append(legacy, peer)
+		}
+	}
+	//FIXME include this again: legacy = legacy[:int(math.Sqrt(float64(len(legacy))))]
+	for _, peer := range legacy {
+		peer.SendTransactions(types.Transactions{tx})
+	}
+	// Propagate to a subset of the eth/65 peers, and announce to the rest
+	transfer := pooled[:int(math.Sqrt(float64(len(pooled))))]
+	for _, peer := range transfer {
 		peer.SendTransactions(types.Transactions{tx})
 	}
-	glog.V(logger.Detail).Infoln("broadcast tx to", len(peers), "peers")
+	for _, peer := range pooled[len(transfer):] {
+		peer.SendTransactionHashes([]common.Hash{hash})
+	}
+	glog.V(logger.Detail).Infof("broadcast tx to %d peers, announced to %d", len(legacy)+len(transfer), len(pooled)-len(transfer))
 }
 
 // Mined broadcast loop
@@ -754,6 +1082,7 @@ type EthNodeInfo struct {
 	Difficulty *big.Int    `json:"difficulty"` // Total difficulty of the host's blockchain
 	Genesis    common.Hash `json:"genesis"`    // SHA3 hash of the host's genesis block
 	Head       common.Hash `json:"head"`       // SHA3 hash of the host's best owned block
+	Tail       uint64      `json:"tail"`       // Oldest block with available bodies and receipts
 }
 
 // NodeInfo retrieves some protocol metadata about the running host node.
@@ -764,5 +1093,6 @@ func (self *ProtocolManager) NodeInfo() *EthNodeInfo {
 		Difficulty: self.blockchain.GetTd(currentBlock.Hash(), currentBlock.NumberU64()),
 		Genesis:    self.blockchain.Genesis().Hash(),
 		Head:       currentBlock.Hash(),
+		Tail:       self.blockchain.PruneTail(),
 	}
 }
@@ -44,8 +44,8 @@ func TestProtocolCompatibility(t *testing.T) {
 		fastSync   bool
 		compatible bool
 	}{
-		{61, false, true}, {62, false, true}, {63, false, true},
-		{61, true, false}, {62, true, false}, {63, true, true},
+		{61, false, true}, {62, false, true}, {63, false, true}, {64, false, true}, {65, false, true}, {66, false, true},
+		{61, true, false}, {62, true, false}, {63, true, true}, {64, true, true}, {65, true, true}, {66, true, true},
 	}
 	// Make sure anything we screw up is restored
 	backup := ProtocolVersions
@@ -68,6 +68,8 @@ func TestProtocolCompatibility(t *testing.T) {
 // Tests that block headers can be retrieved from a remote chain based on user queries.
 func TestGetBlockHeaders62(t *testing.T) { testGetBlockHeaders(t, 62) }
 func TestGetBlockHeaders63(t *testing.T) { testGetBlockHeaders(t, 63) }
+func TestGetBlockHeaders64(t *testing.T) { testGetBlockHeaders(t, 64) }
+func TestGetBlockHeaders66(t *testing.T) { testGetBlockHeaders(t, 66) }
 
 func testGetBlockHeaders(t *testing.T, protocol int) {
 	pm := newTestProtocolManagerMust(t, false, downloader.MaxHashFetch+15, nil, nil)
@@ -206,8 +208,8 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 			headers = append(headers, pm.blockchain.GetBlockByHash(hash).Header())
 		}
 		// Send the hash request and verify the response
-		p2p.Send(peer.app, 0x03, tt.query)
-		if err := p2p.ExpectMsg(peer.app, 0x04, headers); err != nil {
+		sendHeaderQuery(peer, protocol, uint64(i), tt.query)
+		if err := expectHeaders(peer, protocol, uint64(i), headers); err != nil {
 			t.Errorf("test %d: headers mismatch: %v", i, err)
 		}
 		// If the test used number origins, repeat with hashes as the too
@@ -215,8 +217,8 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 			if origin := pm.blockchain.GetBlockByNumber(tt.query.Origin.Number); origin != nil {
 				tt.query.Origin.Hash, tt.query.Origin.Number = origin.Hash(), 0
 
-				p2p.Send(peer.app, 0x03, tt.query)
-				if err := p2p.ExpectMsg(peer.app, 0x04, headers); err != nil {
+				sendHeaderQuery(peer, protocol, uint64(i), tt.query)
+				if err := expectHeaders(peer, protocol, uint64(i), headers); err != nil {
 					t.Errorf("test %d: headers mismatch: %v", i, err)
 				}
 			}
@@ -224,9 +226,47 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 	}
 }
 
+// sendHeaderQuery sends a header query to the protocol manager, wrapped with the
+// given request ID from eth/66 onwards.
+func sendHeaderQuery(peer *testPeer, protocol int, id uint64, query *getBlockHeadersData) error {
+	if protocol >= eth66 {
+		return p2p.Send(peer.app, GetBlockHeadersMsg, &getBlockHeadersData66{RequestId: id, Query: query})
+	}
+	return p2p.Send(peer.app, GetBlockHeadersMsg, query)
+}
+
+// sendHeaders sends a header reply to the protocol manager, wrapped with the given
+// request ID from eth/66 onwards.
+func sendHeaders(peer *testPeer, protocol int, id uint64, headers []*types.Header) error {
+	if protocol >= eth66 {
+		return p2p.Send(peer.app, BlockHeadersMsg, &blockHeadersData66{RequestId: id, Headers: headers})
+	}
+	return p2p.Send(peer.app, BlockHeadersMsg, headers)
+}
+
+// expectHeaderQuery waits for a header query from the protocol manager, checking
+// that it carries the given request ID from eth/66 onwards.
+func expectHeaderQuery(peer *testPeer, protocol int, id uint64, query *getBlockHeadersData) error {
+	if protocol >= eth66 {
+		return p2p.ExpectMsg(peer.app, GetBlockHeadersMsg, &getBlockHeadersData66{RequestId: id, Query: query})
+	}
+	return p2p.ExpectMsg(peer.app, GetBlockHeadersMsg, query)
+}
+
+// expectHeaders waits for a header reply from the protocol manager, checking that
+// it echoes the given request ID from eth/66 onwards.
+func expectHeaders(peer *testPeer, protocol int, id uint64, headers []*types.Header) error {
+	if protocol >= eth66 {
+		return p2p.ExpectMsg(peer.app, BlockHeadersMsg, &blockHeadersData66{RequestId: id, Headers: headers})
+	}
+	return p2p.ExpectMsg(peer.app, BlockHeadersMsg, headers)
+}
+
 // Tests that block contents can be retrieved from a remote chain based on their hashes.
 func TestGetBlockBodies62(t *testing.T) { testGetBlockBodies(t, 62) }
 func TestGetBlockBodies63(t *testing.T) { testGetBlockBodies(t, 63) }
+func TestGetBlockBodies64(t *testing.T) { testGetBlockBodies(t, 64) }
+func TestGetBlockBodies66(t *testing.T) { testGetBlockBodies(t, 66) }
 
 func testGetBlockBodies(t *testing.T, protocol int) {
 	pm := newTestProtocolManagerMust(t, false, downloader.MaxBlockFetch+15, nil, nil)
@@ -289,8 +329,15 @@ func testGetBlockBodies(t *testing.T, protocol int) {
 			}
 		}
 		// Send the hash request and verify the response
-		p2p.Send(peer.app, 0x05, hashes)
-		if err := p2p.ExpectMsg(peer.app, 0x06, bodies); err != nil {
+		var err error
+		if protocol >= eth66 {
+			p2p.Send(peer.app, 0x05, &hashesData66{RequestId: uint64(i), Hashes: hashes})
+			err = p2p.ExpectMsg(peer.app, 0x06, &blockBodiesData66{RequestId: uint64(i), Bodies: bodies})
+		} else {
+			p2p.Send(peer.app, 0x05, hashes)
+			err = p2p.ExpectMsg(peer.app, 0x06, bodies)
+		}
+		if err != nil {
 			t.Errorf("test %d: bodies mismatch: %v", i, err)
 		}
 	}
@@ -298,6 +345,8 @@ func testGetBlockBodies(t *testing.T, protocol int) {
 
 // Tests that the node state database can be retrieved based on hashes.
 func TestGetNodeData63(t *testing.T) { testGetNodeData(t, 63) }
+func TestGetNodeData64(t *testing.T) { testGetNodeData(t, 64) }
+func TestGetNodeData66(t *testing.T) { testGetNodeData(t, 66) }
 
 func testGetNodeData(t *testing.T, protocol int) {
 	// Define three accounts to simulate transactions with
@@ -347,7 +396,11 @@ func testGetNodeData(t *testing.T, protocol int) {
 			hashes = append(hashes, common.BytesToHash(key))
 		}
 	}
-	p2p.Send(peer.app, 0x0d, hashes)
+	if protocol >= eth66 {
+		p2p.Send(peer.app, 0x0d, &hashesData66{RequestId: 1, Hashes: hashes})
+	} else {
+		p2p.Send(peer.app, 0x0d, hashes)
+	}
 	msg, err := peer.app.ReadMsg()
 	if err != nil {
 		t.Fatalf("failed to read node data response: %v", err)
@@ -356,7 +409,16 @@ func testGetNodeData(t *testing.T, protocol int) {
 		t.Fatalf("response packet code mismatch: have %x, want %x", msg.Code, 0x0c)
 	}
 	var data [][]byte
-	if err := msg.Decode(&data); err != nil {
+	if protocol >= eth66 {
+		var response nodeData66
+		if err := msg.Decode(&response); err != nil {
+			t.Fatalf("failed to decode response node data: %v", err)
+		}
+		if response.RequestId != 1 {
+			t.Fatalf("request ID mismatch: have %d, want %d", response.RequestId, 1)
+		}
+		data = response.Data
+	} else if err := msg.Decode(&data); err != nil {
 		t.Fatalf("failed to decode response node data: %v", err)
 	}
 	// Verify that all hashes correspond to the requested data, and reconstruct a state tree
@@ -390,6 +452,8 @@ func testGetNodeData(t *testing.T, protocol int) {
 
 // Tests that the transaction receipts can be retrieved based on hashes.
 func TestGetReceipt63(t *testing.T) { testGetReceipt(t, 63) }
+func TestGetReceipt64(t *testing.T) { testGetReceipt(t, 64) }
+func TestGetReceipt66(t *testing.T) { testGetReceipt(t, 66) }
 
 func testGetReceipt(t *testing.T, protocol int) {
 	// Define three accounts to simulate transactions with
@@ -441,8 +505,15 @@ func testGetReceipt(t *testing.T, protocol int) {
 		receipts = append(receipts, core.GetBlockReceipts(pm.chaindb, block.Hash(), block.NumberU64()))
 	}
 	// Send the hash request and verify the response
-	p2p.Send(peer.app, 0x0f, hashes)
-	if err := p2p.ExpectMsg(peer.app, 0x10, receipts); err != nil {
+	var err error
+	if protocol >= eth66 {
+		p2p.Send(peer.app, 0x0f, &hashesData66{RequestId: 1, Hashes: hashes})
+		err = p2p.ExpectMsg(peer.app, 0x10, []interface{}{uint64(1), receipts})
+	} else {
+		p2p.Send(peer.app, 0x0f, hashes)
+		err = p2p.ExpectMsg(peer.app, 0x10, receipts)
+	}
+	if err != nil {
 		t.Errorf("receipts mismatch: %v", err)
 	}
 }
@@ -518,3 +589,46 @@ func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool
 		}
 	}
 }
+
+// Tests that eth/66 replies not answering any pending request (e.g. late ones to
+// already abandoned requests) are ignored instead of disconnecting the peer, and
+// that replies of the wrong type don't resolve a pending request.
+func TestUnrequestedReplies66(t *testing.T) {
+	pm := newTestProtocolManagerMust(t, false, 0, nil, nil)
+	peer, _ := newTestPeer("peer", eth66, pm, true)
+	defer peer.close()
+
+	// Wait for the peer to be registered, then issue a challenge request to it
+	for start := time.Now(); pm.peers.Len() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("peer not registered")
+		}
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- peer.RequestChallengeHeader(1) }()
+
+	if err := expectHeaderQuery(peer, eth66, 1, &getBlockHeadersData{Origin: hashOrNumber{Number: 1}, Amount: 1}); err != nil {
+		t.Fatalf("challenge mismatch: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to send challenge request: %v", err)
+	}
+	// Send replies with unknown IDs, and one with the correct ID but wrong type
+	if err := sendHeaders(peer, eth66, 2, []*types.Header{{Number: big.NewInt(1)}}); err != nil {
+		t.Fatalf("failed to send unrequested headers: %v", err)
+	}
+	if err := p2p.Send(peer.app, BlockBodiesMsg, &blockBodiesData66{RequestId: 1}); err != nil {
+		t.Fatalf("failed to send mistyped reply: %v", err)
+	}
+	if err := p2p.Send(peer.app, ReceiptsMsg, []interface{}{uint64(3), [][]*types.Receipt{}}); err != nil {
+		t.Fatalf("failed to send unrequested receipts: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if peers := pm.peers.Len(); peers != 1 {
+		t.Fatalf("peer count mismatch: have %d, want %d", peers, 1)
+	}
+	if req := peer.resolve(1, BlockHeadersMsg); req == nil || req.origin != originChallenge {
+		t.Fatalf("pending request mismatch: have %v, want challenge", req)
+	}
+}
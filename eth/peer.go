@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
@@ -42,14 +43,34 @@ const (
 	maxKnownTxs      = 32768 // Maximum transactions hashes to keep in the known list (prevent DOS)
 	maxKnownBlocks   = 1024  // Maximum block hashes to keep in the known list (prevent DOS)
 	handshakeTimeout = 5 * time.Second
+	requestTTL       = time.Minute // Time after which an unanswered request is forgotten
 )
 
+// requestOrigin identifies the local component that issued a data retrieval
+// request, and hence which one the reply needs to be routed to.
+type requestOrigin int
+
+const (
+	originDownloader requestOrigin = iota // Request issued by the synchroniser
+	originFetcher                         // Request issued by the block fetcher
+	originChallenge                       // Request issued by the DAO fork challenge
+)
+
+// request is the metadata of an eth/66 data retrieval request awaiting a reply.
+type request struct {
+	code   uint64        // Message code of the expected reply
+	origin requestOrigin // Local component awaiting the reply
+	token  uint64        // Request ID of the downloader to deliver the reply with
+	sent   time.Time     // Time the request was sent, used to forget stale ones
+}
+
 // PeerInfo represents a short summary of the Ethereum sub-protocol metadata known
 // about a connected peer.
 type PeerInfo struct {
 	Version    int      `json:"version"`    // Ethereum protocol version negotiated
 	Difficulty *big.Int `json:"difficulty"` // Total difficulty of the peer's blockchain
 	Head       string   `json:"head"`       // SHA3 hash of the peer's best owned block
+	Reputation float64  `json:"reputation"` // Reputation score of the peer (0 = neutral)
 }
 
 type peer struct {
@@ -65,8 +86,13 @@ type peer struct {
 	td   *big.Int
 	lock sync.RWMutex
 
-	knownTxs    *set.Set // Set of transaction hashes known to be known by this peer
-	knownBlocks *set.Set // Set of block hashes known to be known by this peer
+	knownTxs    *set.Set    // Set of transaction hashes known to be known by this peer
+	knownBlocks *set.Set    // Set of block hashes known to be known by this peer
+	rep         *reputation // Reputation of the peer, based on its past usefulness
+
+	requests map[uint64]*request // Pending eth/66 requests, keyed by wire request ID
+	nextID   uint64              // Next wire request ID to assign
+	reqLock  sync.Mutex          // Lock protecting the pending requests
 }
 
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
@@ -79,6 +105,8 @@ func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
 		id:          fmt.Sprintf("%x", id[:8]),
 		knownTxs:    set.New(),
 		knownBlocks: set.New(),
+		rep:         newReputation(),
+		requests:    make(map[uint64]*request),
 	}
 }
 
@@ -90,6 +118,7 @@ func (p *peer) Info() *PeerInfo {
 		Version:    p.version,
 		Difficulty: td,
 		Head:       hash.Hex(),
+		Reputation: p.rep.score(),
 	}
 }
 
@@ -141,6 +170,25 @@ func (p *peer) SendTransactions(txs types.Transactions) error {
 	return p2p.Send(p.rw, TxMsg, txs)
 }
 
+// SendTransactionHashes announces the availability of a number of transactions
+// through a hash notification, and includes the hashes in the peer's transaction
+// hash set for future reference.
+func (p *peer) SendTransactionHashes(hashes []common.Hash) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, hashes)
+}
+
+// SendPooledTransactionsRLP sends a batch of pooled transactions to the remote
+// peer from an already RLP encoded format, corresponding to the ones requested.
+func (p *peer) SendPooledTransactionsRLP(hashes []common.Hash, txs []rlp.RawValue) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return p2p.Send(p.rw, PooledTransactionsMsg, txs)
+}
+
 // SendNewBlockHashes announces the availability of a number of blocks through
 // a hash notification.
 func (p *peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
@@ -161,93 +209,210 @@ func (p *peer) SendNewBlock(block *types.Block, td *big.Int) error {
 	return p2p.Send(p.rw, NewBlockMsg, []interface{}{block, td})
 }
 
-// SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
+// SendBlockHeaders sends a batch of block headers to the remote peer, in reply
+// to the request with the given ID (ignored before eth/66).
+func (p *peer) SendBlockHeaders(id uint64, headers []*types.Header) error {
+	if p.version >= eth66 {
+		return p2p.Send(p.rw, BlockHeadersMsg, &blockHeadersData66{RequestId: id, Headers: headers})
+	}
 	return p2p.Send(p.rw, BlockHeadersMsg, headers)
 }
 
-// SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
+// SendBlockBodies sends a batch of block contents to the remote peer, in reply
+// to the request with the given ID (ignored before eth/66).
+func (p *peer) SendBlockBodies(id uint64, bodies []*blockBody) error {
+	if p.version >= eth66 {
+		return p2p.Send(p.rw, BlockBodiesMsg, &blockBodiesData66{RequestId: id, Bodies: blockBodiesData(bodies)})
+	}
 	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
 }
 
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
-// an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
+// an already RLP encoded format, in reply to the request with the given ID.
+func (p *peer) SendBlockBodiesRLP(id uint64, bodies []rlp.RawValue) error {
+	if p.version >= eth66 {
+		return p2p.Send(p.rw, BlockBodiesMsg, &blockBodiesRLPData66{RequestId: id, Bodies: bodies})
+	}
 	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
 }
 
-// SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
-// hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
+// SendNodeData sends a batch of arbitrary internal data, corresponding to the
+// hashes requested by the request with the given ID.
+func (p *peer) SendNodeData(id uint64, data [][]byte) error {
+	if p.version >= eth66 {
+		return p2p.Send(p.rw, NodeDataMsg, &nodeData66{RequestId: id, Data: data})
+	}
 	return p2p.Send(p.rw, NodeDataMsg, data)
 }
 
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
-// ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
+// ones requested by the request with the given ID, from an already RLP encoded
+// format.
+func (p *peer) SendReceiptsRLP(id uint64, receipts []rlp.RawValue) error {
+	if p.version >= eth66 {
+		return p2p.Send(p.rw, ReceiptsMsg, &receiptsRLPData66{RequestId: id, Receipts: receipts})
+	}
 	return p2p.Send(p.rw, ReceiptsMsg, receipts)
 }
 
-// RequestHeaders is a wrapper around the header query functions to fetch a
+// track registers a new eth/66 request awaiting a reply with the given message
+// code, returning the wire request ID to send it with. Requests left unanswered
+// for too long are forgotten, so late replies to them are ignored.
+func (p *peer) track(code uint64, origin requestOrigin, token uint64) uint64 {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	now := time.Now()
+	for id, req := range p.requests {
+		if now.Sub(req.sent) > requestTTL {
+			delete(p.requests, id)
+		}
+	}
+	p.nextID++
+	p.requests[p.nextID] = &request{code: code, origin: origin, token: token, sent: now}
+
+	return p.nextID
+}
+
+// resolve retrieves and forgets the pending eth/66 request a reply with the given
+// wire request ID and message code answers, or nil if no such request exists.
+func (p *peer) resolve(id uint64, code uint64) *request {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	req := p.requests[id]
+	if req == nil || req.code != code {
+		return nil
+	}
+	delete(p.requests, id)
+	return req
+}
+
+// requestHeaders sends a header query to the remote peer, wrapping it with a
+// tracked request ID from eth/66 onwards.
+func (p *peer) requestHeaders(origin requestOrigin, token uint64, query *getBlockHeadersData) error {
+	if p.version >= eth66 {
+		id := p.track(BlockHeadersMsg, origin, token)
+		return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData66{RequestId: id, Query: query})
+	}
+	return p2p.Send(p.rw, GetBlockHeadersMsg, query)
+}
+
+// requestHashes sends a hash based retrieval request to the remote peer, wrapping
+// it with a tracked request ID from eth/66 onwards.
+func (p *peer) requestHashes(code uint64, reply uint64, origin requestOrigin, token uint64, hashes []common.Hash) error {
+	if p.version >= eth66 {
+		id := p.track(reply, origin, token)
+		return p2p.Send(p.rw, code, &hashesData66{RequestId: id, Hashes: hashes})
+	}
+	return p2p.Send(p.rw, code, hashes)
+}
+
+// RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
 func (p *peer) RequestOneHeader(hash common.Hash) error {
 	glog.V(logger.Debug).Infof("%v fetching a single header: %x", p, hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.requestHeaders(originFetcher, 0, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+}
+
+// RequestChallengeHeader fetches the single header at the given block number, to
+// validate the remote peer against the DAO fork challenge.
+func (p *peer) RequestChallengeHeader(number uint64) error {
+	glog.V(logger.Debug).Infof("%v fetching challenge header #%d", p, number)
+	return p.requestHeaders(originChallenge, 0, &getBlockHeadersData{Origin: hashOrNumber{Number: number}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
-// specified header query, based on the hash of an origin block.
-func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+// specified header query, based on the hash of an origin block. The reqID is the
+// downloader's own identifier to deliver the reply with.
+func (p *peer) RequestHeadersByHash(reqID uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	glog.V(logger.Debug).Infof("%v fetching %d headers from %x, skipping %d (reverse = %v)", p, amount, origin[:4], skip, reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.requestHeaders(originDownloader, reqID, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
-// specified header query, based on the number of an origin block.
-func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+// specified header query, based on the number of an origin block. The reqID is
+// the downloader's own identifier to deliver the reply with.
+func (p *peer) RequestHeadersByNumber(reqID uint64, origin uint64, amount int, skip int, reverse bool) error {
 	glog.V(logger.Debug).Infof("%v fetching %d headers from #%d, skipping %d (reverse = %v)", p, amount, origin, skip, reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.requestHeaders(originDownloader, reqID, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
+
+// RequestAnnouncedBodies fetches a batch of blocks' bodies corresponding to the
+// hashes specified. It is used solely by the fetcher.
+func (p *peer) RequestAnnouncedBodies(hashes []common.Hash) error {
+	glog.V(logger.Debug).Infof("%v fetching %d announced block bodies", p, len(hashes))
+	return p.requestHashes(GetBlockBodiesMsg, BlockBodiesMsg, originFetcher, 0, hashes)
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
-// specified.
-func (p *peer) RequestBodies(hashes []common.Hash) error {
+// specified, on behalf of the downloader request reqID.
+func (p *peer) RequestBodies(reqID uint64, hashes []common.Hash) error {
 	glog.V(logger.Debug).Infof("%v fetching %d block bodies", p, len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return p.requestHashes(GetBlockBodiesMsg, BlockBodiesMsg, originDownloader, reqID, hashes)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
-// data, corresponding to the specified hashes.
-func (p *peer) RequestNodeData(hashes []common.Hash) error {
+// data, corresponding to the specified hashes, on behalf of the downloader
+// request reqID.
+func (p *peer) RequestNodeData(reqID uint64, hashes []common.Hash) error {
 	glog.V(logger.Debug).Infof("%v fetching %v state data", p, len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return p.requestHashes(GetNodeDataMsg, NodeDataMsg, originDownloader, reqID, hashes)
 }
 
-// RequestReceipts fetches a batch of transaction receipts from a remote node.
-func (p *peer) RequestReceipts(hashes []common.Hash) error {
+// RequestTxs fetches a batch of pooled transactions from a remote node.
+func (p *peer) RequestTxs(hashes []common.Hash) error {
+	glog.V(logger.Debug).Infof("%v fetching %v pooled transactions", p, len(hashes))
+	return p2p.Send(p.rw, GetPooledTransactionsMsg, hashes)
+}
+
+// RequestReceipts fetches a batch of transaction receipts from a remote node, on
+// behalf of the downloader request reqID.
+func (p *peer) RequestReceipts(reqID uint64, hashes []common.Hash) error {
 	glog.V(logger.Debug).Infof("%v fetching %v receipts", p, len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return p.requestHashes(GetReceiptsMsg, ReceiptsMsg, originDownloader, reqID, hashes)
 }
 
 // Handshake executes the eth protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network int, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks. From eth/64 onwards, the
+// fork identifiers are also exchanged and the remote one validated.
+func (p *peer) Handshake(network int, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
-	var status statusData // safe to read after two values have been received from errc
+
+	var status statusData64 // safe to read after two values have been received from errc
 
 	go func() {
-		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
-			ProtocolVersion: uint32(p.version),
-			NetworkId:       uint32(network),
-			TD:              td,
-			CurrentBlock:    head,
-			GenesisBlock:    genesis,
-		})
+		switch {
+		case p.version >= eth64:
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData64{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       uint32(network),
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				ForkID:          forkID,
+			})
+		default:
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       uint32(network),
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+			})
+		}
 	}()
 	go func() {
-		errc <- p.readStatus(network, &status, genesis)
+		switch {
+		case p.version >= eth64:
+			errc <- p.readStatus64(network, &status, genesis, forkFilter)
+		default:
+			var legacy statusData
+			err := p.readStatus(network, &legacy, genesis)
+			status.TD, status.CurrentBlock = legacy.TD, legacy.CurrentBlock
+			errc <- err
+		}
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
 	defer timeout.Stop()
@@ -292,6 +457,36 @@ func (p *peer) readStatus(network int, status *statusData, genesis common.Hash)
 	return nil
 }
 
+func (p *peer) readStatus64(network int, status *statusData64, genesis common.Hash, forkFilter forkid.Filter) (err error) {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	// Decode the handshake and make sure everything matches
+	if err := msg.Decode(&status); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	if status.GenesisBlock != genesis {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisBlock, genesis)
+	}
+	if int(status.NetworkId) != network {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status.NetworkId, network)
+	}
+	if int(status.ProtocolVersion) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
+	}
+	if err := forkFilter(status.ForkID); err != nil {
+		return errResp(ErrForkIDRejected, "%v: %v", status.ForkID, err)
+	}
+	return nil
+}
+
 // String implements fmt.Stringer.
 func (p *peer) String() string {
 	return fmt.Sprintf("Peer %s [%s]", p.id,
@@ -390,22 +585,50 @@ func (ps *peerSet) PeersWithoutTx(hash common.Hash) []*peer {
 }
 
 // BestPeer retrieves the known peer with the currently highest total difficulty.
+// Peers with a poor reputation are only considered if no other peers are known.
 func (ps *peerSet) BestPeer() *peer {
 	ps.lock.RLock()
 	defer ps.lock.RUnlock()
 
 	var (
-		bestPeer *peer
-		bestTd   *big.Int
+		bestPeer, bestPoorPeer *peer
+		bestTd, bestPoorTd     *big.Int
 	)
 	for _, p := range ps.peers {
-		if _, td := p.Head(); bestPeer == nil || td.Cmp(bestTd) > 0 {
+		_, td := p.Head()
+		if p.rep.score() < poorScore {
+			if bestPoorPeer == nil || td.Cmp(bestPoorTd) > 0 {
+				bestPoorPeer, bestPoorTd = p, td
+			}
+			continue
+		}
+		if bestPeer == nil || td.Cmp(bestTd) > 0 {
 			bestPeer, bestTd = p, td
 		}
 	}
+	if bestPeer == nil {
+		return bestPoorPeer
+	}
 	return bestPeer
 }
 
+// WorstPeer retrieves the known peer with the currently lowest reputation score.
+func (ps *peerSet) WorstPeer() *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	var (
+		worstPeer  *peer
+		worstScore float64
+	)
+	for _, p := range ps.peers {
+		if score := p.rep.score(); worstPeer == nil || score < worstScore {
+			worstPeer, worstScore = p, score
+		}
+	}
+	return worstPeer
+}
+
 // Close disconnects all peers.
 // No new peers can be registered after Close has returned.
 func (ps *peerSet) Close() {
@@ -22,6 +22,7 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
 )
@@ -30,16 +31,19 @@ import (
 const (
 	eth62 = 62
 	eth63 = 63
+	eth64 = 64
+	eth65 = 65
+	eth66 = 66
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "eth"
 
 // Supported versions of the eth protocol (first is primary).
-var ProtocolVersions = []uint{eth63, eth62}
+var ProtocolVersions = []uint{eth66, eth65, eth64, eth63, eth62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{17, 17, 17, 17, 8}
 
 const (
 	NetworkId          = 1
@@ -58,6 +62,11 @@ const (
 	BlockBodiesMsg     = 0x06
 	NewBlockMsg        = 0x07
 
+	// Protocol messages belonging to eth/65
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
+
 	// Protocol messages belonging to eth/63
 	GetNodeDataMsg = 0x0d
 	NodeDataMsg    = 0x0e
@@ -77,6 +86,7 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrForkIDRejected
 )
 
 func (e errCode) String() string {
@@ -94,6 +104,7 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrForkIDRejected:          "Fork ID rejected",
 }
 
 type txPool interface {
@@ -103,6 +114,10 @@ type txPool interface {
 	// Pending should return pending transactions.
 	// The slice should be modifiable by the caller.
 	Pending() (map[common.Address]types.Transactions, error)
+
+	// Get should return a transaction if it is contained in the pool, or nil
+	// otherwise.
+	Get(hash common.Hash) *types.Transaction
 }
 
 // statusData is the network packet for the status message.
@@ -114,6 +129,16 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// statusData64 is the network packet for the status message for eth/64 and later.
+type statusData64 struct {
+	ProtocolVersion uint32
+	NetworkId       uint32
+	TD              *big.Int
+	CurrentBlock    common.Hash
+	GenesisBlock    common.Hash
+	ForkID          forkid.ID
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
@@ -178,3 +203,59 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+// From eth/66 onwards, every data retrieval request carries a request ID chosen
+// by the requester, which the serving peer echoes back in its reply. This allows
+// responses to be matched to the exact request they answer, instead of guessing
+// based on the contents.
+
+// getBlockHeadersData66 is the eth/66 network packet for a header query.
+type getBlockHeadersData66 struct {
+	RequestId uint64
+	Query     *getBlockHeadersData
+}
+
+// blockHeadersData66 is the eth/66 network packet for a header query reply.
+type blockHeadersData66 struct {
+	RequestId uint64
+	Headers   []*types.Header
+}
+
+// hashesData66 is the eth/66 network packet for the hash based retrieval
+// requests: block bodies, node data and receipts.
+type hashesData66 struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+// blockBodiesData66 is the eth/66 network packet for block content distribution.
+type blockBodiesData66 struct {
+	RequestId uint64
+	Bodies    blockBodiesData
+}
+
+// blockBodiesRLPData66 is the eth/66 network packet for block content
+// distribution from an already RLP encoded format.
+type blockBodiesRLPData66 struct {
+	RequestId uint64
+	Bodies    []rlp.RawValue
+}
+
+// nodeData66 is the eth/66 network packet for state data distribution.
+type nodeData66 struct {
+	RequestId uint64
+	Data      [][]byte
+}
+
+// receiptsData66 is the eth/66 network packet for receipt distribution.
+type receiptsData66 struct {
+	RequestId uint64
+	Receipts  [][]*types.Receipt
+}
+
+// receiptsRLPData66 is the eth/66 network packet for receipt distribution from
+// an already RLP encoded format.
+type receiptsRLPData66 struct {
+	RequestId uint64
+	Receipts  []rlp.RawValue
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	reputationHalfLife = 10 * time.Minute // Time after which recorded events lose half of their weight
+	maxReputations     = 1024             // Maximum number of peer reputations to remember
+	latencyImpact      = 0.1              // Weight of a new latency measurement in the moving average
+
+	uselessPenalty     = 0.5 // Score penalty of an empty or unrequested response
+	undeliveredPenalty = 2   // Score penalty of an announced block never delivered
+	invalidPenalty     = 25  // Score penalty of invalid data or stalling a synchronisation
+	latencyPenalty     = 1   // Score penalty of each second of average response latency
+
+	poorScore     = -10              // Score below which a peer is avoided for syncing and may be evicted
+	evictInterval = 30 * time.Second // Interval to check for a poor peer to evict if at capacity
+)
+
+// reputation tracks how useful a remote peer has been to us, based on the quality
+// of its responses and block announcements. Recorded events decay over time, so
+// peers can both build up and lose their standing.
+type reputation struct {
+	useful      float64       // Decayed number of responses containing data
+	useless     float64       // Decayed number of empty or unrequested responses
+	invalid     float64       // Decayed number of invalid deliveries or stalled syncs
+	undelivered float64       // Decayed number of announced blocks never delivered
+	latency     time.Duration // Decayed moving average of the response latency (eth/66 only)
+	updated     time.Time     // Time the counters and the latency were last decayed
+
+	lock sync.Mutex
+}
+
+// newReputation creates a neutral reputation for a newly seen peer.
+func newReputation() *reputation {
+	return &reputation{updated: time.Now()}
+}
+
+// decay reduces the weight of all recorded events according to the time passed
+// since the last update. The lock must be held by the caller.
+func (r *reputation) decay(now time.Time) {
+	elapsed := now.Sub(r.updated)
+	if elapsed <= 0 {
+		return
+	}
+	factor := math.Pow(0.5, float64(elapsed)/float64(reputationHalfLife))
+
+	r.useful *= factor
+	r.useless *= factor
+	r.invalid *= factor
+	r.undelivered *= factor
+	r.latency = time.Duration(float64(r.latency) * factor)
+	r.updated = now
+}
+
+// mark records an event by incrementing the given counter.
+func (r *reputation) mark(counter *float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.decay(time.Now())
+	*counter++
+}
+
+// markResponse records a response to one of our requests, useful if it contained
+// any items, useless otherwise.
+func (r *reputation) markResponse(items int) {
+	if items > 0 {
+		r.mark(&r.useful)
+	} else {
+		r.mark(&r.useless)
+	}
+}
+
+// markUseless records a response that did not answer any of our requests.
+func (r *reputation) markUseless() { r.mark(&r.useless) }
+
+// markInvalid records a delivery of invalid data, or a stalled synchronisation.
+func (r *reputation) markInvalid() { r.mark(&r.invalid) }
+
+// markUndelivered records a block announcement that was never delivered.
+func (r *reputation) markUndelivered() { r.mark(&r.undelivered) }
+
+// markLatency integrates a new response latency measurement into the average.
+func (r *reputation) markLatency(latency time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.decay(time.Now())
+	if r.latency == 0 {
+		r.latency = latency
+		return
+	}
+	r.latency = time.Duration((1-latencyImpact)*float64(r.latency) + latencyImpact*float64(latency))
+}
+
+// score calculates the current reputation score of the peer. Neutral peers score
+// zero, useful responses raise the score, while any misbehaviour or slowness
+// lowers it.
+func (r *reputation) score() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.decay(time.Now())
+	return r.useful - uselessPenalty*r.useless - invalidPenalty*r.invalid -
+		undeliveredPenalty*r.undelivered - latencyPenalty*r.latency.Seconds()
+}
+
+// reputationSet remembers the reputation of recently seen peers, so that a peer
+// cannot clear its record by reconnecting.
+type reputationSet struct {
+	reps map[string]*reputation
+	lock sync.Mutex
+}
+
+// newReputationSet creates a new set to track peer reputations.
+func newReputationSet() *reputationSet {
+	return &reputationSet{
+		reps: make(map[string]*reputation),
+	}
+}
+
+// peek retrieves the reputation of the peer with the given id, or nil if the
+// peer is not known.
+func (rs *reputationSet) peek(id string) *reputation {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	return rs.reps[id]
+}
+
+// get retrieves the reputation of the peer with the given id, creating a neutral
+// one if the peer is not known. If too many peers are tracked, the one updated
+// least recently is forgotten.
+func (rs *reputationSet) get(id string) *reputation {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if rep, ok := rs.reps[id]; ok {
+		return rep
+	}
+	if len(rs.reps) >= maxReputations {
+		var (
+			stalest string
+			oldest  time.Time
+		)
+		for id, rep := range rs.reps {
+			rep.lock.Lock()
+			updated := rep.updated
+			rep.lock.Unlock()
+
+			if stalest == "" || updated.Before(oldest) {
+				stalest, oldest = id, updated
+			}
+		}
+		delete(rs.reps, stalest)
+	}
+	rep := newReputation()
+	rs.reps[id] = rep
+	return rep
+}
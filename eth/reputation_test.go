@@ -0,0 +1,208 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// Tests that the reputation score reflects the recorded events and that they
+// lose their weight over time.
+func TestReputationScore(t *testing.T) {
+	rep := newReputation()
+	if score := rep.score(); score != 0 {
+		t.Fatalf("fresh score mismatch: have %v, want %v", score, 0)
+	}
+	rep.markResponse(10)
+	rep.markResponse(1)
+	rep.markResponse(0)
+	rep.markUseless()
+	rep.markUndelivered()
+	rep.markInvalid()
+
+	want := 2 - 2*uselessPenalty - undeliveredPenalty - invalidPenalty
+	if score := rep.score(); math.Abs(score-want) > 0.01 {
+		t.Fatalf("score mismatch: have %v, want %v", score, want)
+	}
+	// Simulate a half-life passing and check that the events lost half their weight
+	rep.lock.Lock()
+	rep.updated = rep.updated.Add(-reputationHalfLife)
+	rep.lock.Unlock()
+
+	if score := rep.score(); math.Abs(score-want/2) > 0.01 {
+		t.Fatalf("decayed score mismatch: have %v, want %v", score, want/2)
+	}
+	// Slow responses should lower the score too
+	rep = newReputation()
+	rep.markLatency(2 * time.Second)
+	if score := rep.score(); math.Abs(score+2*latencyPenalty) > 0.01 {
+		t.Fatalf("latency score mismatch: have %v, want %v", score, -2*latencyPenalty)
+	}
+	// Old slowness should be forgotten over time too
+	rep.lock.Lock()
+	rep.updated = rep.updated.Add(-reputationHalfLife)
+	rep.lock.Unlock()
+
+	if score := rep.score(); math.Abs(score+latencyPenalty) > 0.01 {
+		t.Fatalf("decayed latency score mismatch: have %v, want %v", score, -latencyPenalty)
+	}
+}
+
+// Tests that reputations are remembered across reconnects, and that the number
+// of remembered reputations is capped.
+func TestReputationSet(t *testing.T) {
+	set := newReputationSet()
+
+	rep := set.get("peer")
+	rep.markInvalid()
+	if set.get("peer") != rep {
+		t.Fatalf("reputation not remembered")
+	}
+	for i := 0; i < 2*maxReputations; i++ {
+		set.get(fmt.Sprintf("peer-%d", i))
+	}
+	if len(set.reps) != maxReputations {
+		t.Fatalf("remembered reputation count mismatch: have %d, want %d", len(set.reps), maxReputations)
+	}
+}
+
+// newReputationTestPeer creates a disconnected peer with the given total
+// difficulty for peer set tests.
+func newReputationTestPeer(td int64) *peer {
+	var id discover.NodeID
+	rand.Read(id[:])
+
+	p := newPeer(eth63, p2p.NewPeer(id, "test", nil), nil)
+	p.td = big.NewInt(td)
+	return p
+}
+
+// Tests that peers with a poor reputation are only chosen to sync with if there
+// are no other peers available, and that the worst one is found for eviction.
+func TestBestPeerReputation(t *testing.T) {
+	ps := newPeerSet()
+
+	poor, good := newReputationTestPeer(200), newReputationTestPeer(100)
+	poor.rep.markInvalid()
+
+	ps.Register(poor)
+	if best := ps.BestPeer(); best != poor {
+		t.Fatalf("best peer mismatch with only poor peers: have %v, want %v", best, poor)
+	}
+	ps.Register(good)
+	if best := ps.BestPeer(); best != good {
+		t.Fatalf("best peer mismatch: have %v, want %v", best, good)
+	}
+	if worst := ps.WorstPeer(); worst != poor {
+		t.Fatalf("worst peer mismatch: have %v, want %v", worst, poor)
+	}
+}
+
+// Tests that if the peer limit is reached, the worst peer is evicted to make room
+// for new ones, but only if its reputation is poor.
+func TestPoorPeerEviction(t *testing.T) {
+	pm := newTestProtocolManagerMust(t, false, 0, nil, nil)
+	pm.maxPeers = 1
+
+	// Connect a first peer and wait for it to be registered
+	first, _ := newTestPeer("first", eth63, pm, true)
+	defer first.close()
+
+	for start := time.Now(); pm.peers.Len() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("first peer not registered")
+		}
+	}
+	// Check that a new peer is rejected and nobody is evicted while the existing
+	// peer is in good standing
+	second, errc := newTestPeer("second", eth63, pm, false)
+	defer second.close()
+
+	select {
+	case err := <-errc:
+		if err != p2p.DiscTooManyPeers {
+			t.Fatalf("rejection error mismatch: have %v, want %v", err, p2p.DiscTooManyPeers)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("new peer not rejected")
+	}
+	if pm.evictPoorPeer() {
+		t.Fatalf("peer in good standing evicted")
+	}
+	// Ruin the reputation of the first peer and check that it's evicted, making
+	// room for a new peer
+	pm.peers.Peer(first.id).rep.markInvalid()
+	if !pm.evictPoorPeer() {
+		t.Fatalf("poor peer not evicted")
+	}
+	third, _ := newTestPeer("third", eth63, pm, true)
+	defer third.close()
+
+	for start := time.Now(); pm.peers.Peer(third.id) == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("new peer not registered")
+		}
+	}
+	if pm.peers.Peer(first.id) != nil {
+		t.Fatalf("poor peer still registered")
+	}
+	if peers := pm.peers.Len(); peers != 1 {
+		t.Fatalf("peer count mismatch: have %d, want %d", peers, 1)
+	}
+	// Check that the poor reputation is remembered across reconnects
+	if rep := pm.reputations.peek(first.id); rep == nil || rep.score() >= poorScore {
+		t.Fatalf("poor reputation not remembered")
+	}
+}
+
+// Tests that peers failing the handshake don't get a reputation tracked, so that
+// they can't push out the remembered ones.
+func TestReputationHandshakeFailure(t *testing.T) {
+	pm := newTestProtocolManagerMust(t, false, 0, nil, nil)
+
+	p, errc := newTestPeer("peer", eth63, pm, false)
+	defer p.close()
+
+	// Send a status with a mismatching network id to fail the handshake
+	td, head, genesis := pm.blockchain.Status()
+	msg := &statusData{ProtocolVersion: uint32(eth63), NetworkId: NetworkId + 1, TD: td, CurrentBlock: head, GenesisBlock: genesis}
+	if err := p2p.ExpectMsg(p.app, StatusMsg, nil); err != nil {
+		t.Fatalf("status not received: %v", err)
+	}
+	if err := p2p.Send(p.app, StatusMsg, msg); err != nil {
+		t.Fatalf("failed to send status: %v", err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatalf("handshake succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handshake not failed")
+	}
+	if rep := pm.reputations.peek(p.id); rep != nil {
+		t.Fatalf("reputation tracked for failed handshake")
+	}
+}
@@ -19,6 +19,7 @@ package ethdb
 import (
 	"os"
 	"path/filepath"
+	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -32,3 +33,31 @@ func newDb() *LDBDatabase {
 
 	return db
 }
+
+// Tests that batches apply deletions together with the insertions, both on
+// the plain databases and on prefixed tables.
+func TestBatchDelete(t *testing.T) {
+	ldb := newDb()
+	defer ldb.Close()
+	mdb, _ := NewMemDatabase()
+
+	for name, db := range map[string]Database{"leveldb": ldb, "memory": mdb, "table": NewTable(mdb, "t-")} {
+		db.Put([]byte("a"), []byte{1})
+
+		batch := db.NewBatch()
+		batch.Put([]byte("b"), []byte{2})
+		batch.Delete([]byte("a"))
+		if _, err := db.Get([]byte("a")); err != nil {
+			t.Errorf("%s: deletion applied before batch write", name)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatalf("%s: failed to write batch: %v", name, err)
+		}
+		if _, err := db.Get([]byte("a")); err == nil {
+			t.Errorf("%s: deleted key still present", name)
+		}
+		if value, err := db.Get([]byte("b")); err != nil || len(value) != 1 || value[0] != 2 {
+			t.Errorf("%s: inserted key mismatch: have %x (%v), want 02", name, value, err)
+		}
+	}
+}
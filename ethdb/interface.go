@@ -16,15 +16,28 @@
 
 package ethdb
 
-type Database interface {
+// Putter wraps the database write operation supported by both batches and
+// regular databases.
+type Putter interface {
 	Put(key []byte, value []byte) error
-	Get(key []byte) ([]byte, error)
+}
+
+// Deleter wraps the database delete operation supported by both batches and
+// regular databases.
+type Deleter interface {
 	Delete(key []byte) error
+}
+
+type Database interface {
+	Putter
+	Deleter
+	Get(key []byte) ([]byte, error)
 	Close()
 	NewBatch() Batch
 }
 
 type Batch interface {
-	Put(key, value []byte) error
+	Putter
+	Deleter
 	Write() error
 }
@@ -0,0 +1,415 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package chaintest contains declarative fork choice scenarios and a runner to
+// test both the full and the light chain against them.
+package chaintest
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// eventTimeout is the time allowed for a chain to post all the expected events
+// of a scenario. It's only reached if some event is missing.
+const eventTimeout = 5 * time.Second
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+	testFunds   = big.NewInt(1000000000000000000)
+)
+
+// Chain is a block or header chain under test.
+type Chain interface {
+	// InsertBlocks imports a batch of blocks, or their headers only.
+	InsertBlocks(blocks []*types.Block) error
+
+	// CurrentHash returns the hash of the current head of the chain.
+	CurrentHash() common.Hash
+
+	// GetTd retrieves the total difficulty of a block.
+	GetTd(hash common.Hash, number uint64) *big.Int
+
+	// GetReceipts retrieves the receipts of a canonical block.
+	GetReceipts(block *types.Block) types.Receipts
+
+	// Stop terminates the chain.
+	Stop()
+}
+
+// TxIndexer is implemented by chains that index the transactions of the
+// canonical chain.
+type TxIndexer interface {
+	// GetReceipt retrieves the receipt of a transaction by its hash, nil if the
+	// transaction is not in the canonical chain.
+	GetReceipt(hash common.Hash) *types.Receipt
+}
+
+// NewChainFunc creates the chain to test on top of a database containing the
+// genesis of the scenarios. The block tree of the scenario is passed along for
+// chains that need to retrieve data of the imported blocks from a server.
+type NewChainFunc func(db ethdb.Database, mux *event.TypeMux, tree *core.BlockTree) (Chain, error)
+
+// emitLog creates a block generator adding a contract creation to the block,
+// whose init code emits a single log. The label is appended after the code
+// to make the transactions of different blocks unique.
+func emitLog(label string) func(*core.BlockGen) {
+	return func(b *core.BlockGen) {
+		code := append([]byte{0x60, 0x00, 0x60, 0x00, 0xa0, 0x00}, label...) // PUSH1 0 PUSH1 0 LOG0 STOP <label>
+		tx, err := types.SignTx(types.NewContractCreation(b.TxNonce(testAddress), new(big.Int), big.NewInt(100000), new(big.Int), code), types.HomesteadSigner{}, testKey)
+		if err != nil {
+			panic(err)
+		}
+		b.AddTx(tx)
+	}
+}
+
+// insert is a batch of blocks to import into a chain.
+type insert struct {
+	blocks []string // Labels of the blocks to import
+	fail   bool     // Whether the import is expected to fail
+}
+
+// scenario is a declarative fork choice test: a block tree, the order in which
+// its blocks are imported and the expected outcome.
+type scenario struct {
+	tree    []core.BlockSpec // Block tree to generate
+	inserts []insert         // Import batches to feed into the chain
+	head    string           // Expected head block after all imports
+	chain   []string         // Expected blocks announced via ChainEvent
+	side    []string         // Expected blocks announced via ChainSideEvent when imported
+	reorged []string         // Expected canonical blocks reorged out of the chain
+}
+
+// Fork choice scenarios. Blocks named with a "fast" suffix are mined quicker
+// than their parents, resulting in a higher difficulty, which is used to avoid
+// ties in total difficulty (resolved randomly) across the forks.
+var scenarios = []scenario{
+	// Plain linear chain imported in a single batch
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1", Gen: emitLog("a1")},
+			{Name: "a2", Parent: "a1", Gen: emitLog("a2")},
+			{Name: "a3", Parent: "a2", Gen: emitLog("a3")},
+		},
+		inserts: []insert{{blocks: []string{"a1", "a2", "a3"}}},
+		head:    "a3",
+		chain:   []string{"a1", "a2", "a3"},
+	},
+	// Heavier side chain imported after the canonical one, reorging it out
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1", Gen: emitLog("a1")},
+			{Name: "a2", Parent: "a1", Gen: emitLog("a2")},
+			{Name: "a3", Parent: "a2", Gen: emitLog("a3")},
+			{Name: "b1fast", Time: 5, Gen: emitLog("b1")},
+			{Name: "b2fast", Parent: "b1fast", Time: 10, Gen: emitLog("b2")},
+			{Name: "b3fast", Parent: "b2fast", Time: 15, Gen: emitLog("b3")},
+		},
+		inserts: []insert{
+			{blocks: []string{"a1", "a2", "a3"}},
+			{blocks: []string{"b1fast", "b2fast", "b3fast"}},
+		},
+		head:    "b3fast",
+		chain:   []string{"a1", "a2", "a3", "b3fast"},
+		side:    []string{"b1fast", "b2fast"},
+		reorged: []string{"a1", "a2", "a3"},
+	},
+	// Lighter side chain imported after the canonical one, leaving it intact
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1fast", Time: 5, Gen: emitLog("a1")},
+			{Name: "a2fast", Parent: "a1fast", Time: 10, Gen: emitLog("a2")},
+			{Name: "b1", Gen: emitLog("b1")},
+			{Name: "b2", Parent: "b1", Gen: emitLog("b2")},
+		},
+		inserts: []insert{
+			{blocks: []string{"a1fast", "a2fast"}},
+			{blocks: []string{"b1", "b2"}},
+		},
+		head:  "a2fast",
+		chain: []string{"a1fast", "a2fast"},
+		side:  []string{"b1", "b2"},
+	},
+	// Deep reorg from a fork point within the chain, imported in pieces
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1"},
+			{Name: "a2", Parent: "a1", Gen: emitLog("a2")},
+			{Name: "a3", Parent: "a2", Gen: emitLog("a3")},
+			{Name: "a4", Parent: "a3", Gen: emitLog("a4")},
+			{Name: "b2fast", Parent: "a1", Time: 15},
+			{Name: "b3fast", Parent: "b2fast", Time: 20},
+			{Name: "b4fast", Parent: "b3fast", Time: 25},
+			{Name: "b5", Parent: "b4fast", Time: 35},
+		},
+		inserts: []insert{
+			{blocks: []string{"a1", "a2", "a3", "a4"}},
+			{blocks: []string{"b2fast", "b3fast"}},
+			{blocks: []string{"b4fast", "b5"}},
+		},
+		head:    "b5",
+		chain:   []string{"a1", "a2", "a3", "a4", "b4fast", "b5"},
+		side:    []string{"b2fast", "b3fast"},
+		reorged: []string{"a2", "a3", "a4"},
+	},
+	// Uncle inclusion, the uncle itself imported afterwards as a side block
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1"},
+			{Name: "u1"},
+			{Name: "a2", Parent: "a1", Uncles: []string{"u1"}},
+		},
+		inserts: []insert{
+			{blocks: []string{"a1", "a2"}},
+			{blocks: []string{"u1"}},
+		},
+		head:  "a2",
+		chain: []string{"a1", "a2"},
+		side:  []string{"u1"},
+	},
+	// Re-importing known blocks is a noop, importing orphans fails
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1"},
+			{Name: "a2", Parent: "a1"},
+			{Name: "a3", Parent: "a2"},
+		},
+		inserts: []insert{
+			{blocks: []string{"a1", "a2"}},
+			{blocks: []string{"a1", "a2"}},
+			{blocks: []string{"a3"}},
+		},
+		head:  "a3",
+		chain: []string{"a1", "a2", "a3"},
+	},
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1"},
+			{Name: "a2", Parent: "a1"},
+		},
+		inserts: []insert{{blocks: []string{"a2"}, fail: true}},
+	},
+	// Blocks too far in the future are rejected
+	{
+		tree: []core.BlockSpec{
+			{Name: "a1"},
+			{Name: "a2", Parent: "a1", Time: uint64(time.Now().Unix() + 3600)},
+		},
+		inserts: []insert{
+			{blocks: []string{"a1"}},
+			{blocks: []string{"a2"}, fail: true},
+		},
+		head:  "a1",
+		chain: []string{"a1"},
+	},
+}
+
+// WriteGenesis stores the genesis block of the scenarios, funding the account
+// sending their transactions.
+func WriteGenesis(db ethdb.Database) *types.Block {
+	return core.WriteGenesisBlockForTesting(db, core.GenesisAccount{Address: testAddress, Balance: testFunds})
+}
+
+// Run tests the fork choice of a chain against all the scenarios. If reorgs is
+// set, the chain is expected to announce the blocks reorged out of the chain
+// via ChainSideEvent and their logs via RemovedLogsEvent, as the full chain does.
+func Run(t *testing.T, newChain NewChainFunc, reorgs bool) {
+	for i, test := range scenarios {
+		var (
+			gendb, _ = ethdb.NewMemDatabase()
+			db, _    = ethdb.NewMemDatabase()
+			genesis  = WriteGenesis(gendb)
+			tree     = core.GenerateBlockTree(params.TestChainConfig, genesis, gendb, test.tree)
+			mux      = new(event.TypeMux)
+		)
+		WriteGenesis(db)
+		chain, err := newChain(db, mux, tree)
+		if err != nil {
+			t.Fatalf("test %d: failed to create chain: %v", i, err)
+		}
+		sub := mux.Subscribe(core.ChainEvent{}, core.ChainSideEvent{}, core.RemovedLogsEvent{})
+
+		for j, insert := range test.inserts {
+			if err := chain.InsertBlocks(tree.Select(insert.blocks...)); (err != nil) != insert.fail {
+				t.Errorf("test %d, insert %d: import failure mismatch: have %v, want failure %v", i, j, err, insert.fail)
+			}
+		}
+		// Assemble the expected events and wait for the chain to post them
+		want := &events{chain: test.chain, side: test.side}
+		if reorgs {
+			want.side = append(append([]string{}, test.side...), test.reorged...)
+			for _, name := range test.reorged {
+				for _, receipt := range tree.Receipts[name] {
+					for range receipt.Logs {
+						want.removed = append(want.removed, name)
+					}
+				}
+			}
+		}
+		have := collectEvents(sub, tree, want)
+		sub.Unsubscribe()
+
+		checkEvents(t, i, have, want)
+		checkChain(t, i, chain, db, tree, test.head)
+		chain.Stop()
+	}
+}
+
+// checkChain verifies the head, total difficulty and canonical chain after the
+// imports of a scenario, along with the receipts of the canonical blocks.
+func checkChain(t *testing.T, i int, chain Chain, db ethdb.Database, tree *core.BlockTree, head string) {
+	want := tree.Genesis
+	if head != "" {
+		want = tree.Blocks[head]
+	}
+	if hash := chain.CurrentHash(); hash != want.Hash() {
+		t.Errorf("test %d: head mismatch: have %x, want %q #%d [%x…]", i, hash, head, want.NumberU64(), want.Hash().Bytes()[:4])
+		return
+	}
+	if head == "" {
+		return
+	}
+	if td := chain.GetTd(want.Hash(), want.NumberU64()); td == nil || td.Cmp(tree.TD(head)) != 0 {
+		t.Errorf("test %d: head total difficulty mismatch: have %v, want %v", i, td, tree.TD(head))
+	}
+	indexer, _ := chain.(TxIndexer)
+
+	canonical := make(map[string]bool)
+	for _, block := range tree.Chain(head) {
+		name := label(tree, block.Hash())
+		canonical[name] = true
+
+		if hash := core.GetCanonicalHash(db, block.NumberU64()); hash != block.Hash() {
+			t.Errorf("test %d: block %q: canonical hash mismatch: have %x, want %x", i, name, hash, block.Hash())
+		}
+		receipts := chain.GetReceipts(block)
+		if types.DeriveSha(receipts) != types.DeriveSha(tree.Receipts[name]) {
+			t.Errorf("test %d: block %q: receipts mismatch", i, name)
+		}
+		for j, receipt := range receipts {
+			if len(receipt.Logs) != len(tree.Receipts[name][j].Logs) {
+				t.Errorf("test %d: block %q: receipt %d: log count mismatch: have %d, want %d", i, name, j, len(receipt.Logs), len(tree.Receipts[name][j].Logs))
+			}
+		}
+		if indexer != nil {
+			for _, tx := range block.Transactions() {
+				if receipt := indexer.GetReceipt(tx.Hash()); receipt == nil || len(receipt.Logs) != 1 {
+					t.Errorf("test %d: block %q: transaction %x: receipt lookup or logs missing", i, name, tx.Hash())
+				}
+			}
+		}
+	}
+	// Transactions of non canonical blocks must not be resolvable
+	if indexer != nil {
+		for name, block := range tree.Blocks {
+			if canonical[name] {
+				continue
+			}
+			for _, tx := range block.Transactions() {
+				if indexer.GetReceipt(tx.Hash()) != nil {
+					t.Errorf("test %d: block %q: side transaction %x resolvable", i, name, tx.Hash())
+				}
+			}
+		}
+	}
+}
+
+// events contains the block labels announced by the events posted by a chain.
+type events struct {
+	chain, side, removed []string
+}
+
+// label returns the label of a block within a block tree.
+func label(tree *core.BlockTree, hash common.Hash) string {
+	for name, block := range tree.Blocks {
+		if block.Hash() == hash {
+			return name
+		}
+	}
+	return "?"
+}
+
+// collectEvents gathers the events posted by a chain until as many arrived as
+// expected, after which any events already pending are gathered too, so that
+// surplus ones are reported. If some events are missing, collection is aborted
+// after a timeout.
+func collectEvents(sub event.Subscription, tree *core.BlockTree, want *events) *events {
+	have := new(events)
+	add := func(ev *event.Event) {
+		switch ev := ev.Data.(type) {
+		case core.ChainEvent:
+			have.chain = append(have.chain, label(tree, ev.Hash))
+		case core.ChainSideEvent:
+			have.side = append(have.side, label(tree, ev.Block.Hash()))
+		case core.RemovedLogsEvent:
+			for _, log := range ev.Logs {
+				have.removed = append(have.removed, label(tree, log.BlockHash))
+			}
+		}
+	}
+	timeout := time.After(eventTimeout)
+	for len(have.chain) < len(want.chain) || len(have.side) < len(want.side) || len(have.removed) < len(want.removed) {
+		select {
+		case ev := <-sub.Chan():
+			add(ev)
+		case <-timeout:
+			return have
+		}
+	}
+	for {
+		select {
+		case ev := <-sub.Chan():
+			add(ev)
+		default:
+			return have
+		}
+	}
+}
+
+// checkEvents compares the collected events against the expectations.
+func checkEvents(t *testing.T, i int, have, want *events) {
+	for _, check := range []struct {
+		kind       string
+		have, want []string
+	}{
+		{"chain", have.chain, want.chain},
+		{"side", have.side, want.side},
+		{"removed logs", have.removed, want.removed},
+	} {
+		have := append([]string{}, check.have...)
+		want := append([]string{}, check.want...)
+		sort.Strings(have)
+		sort.Strings(want)
+		if len(have) != 0 || len(want) != 0 {
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("test %d: %s events mismatch: have %v, want %v", i, check.kind, have, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements a streamed, checksummed archive format for chain segments.
+//
+// An archive starts with a small header identifying the format, followed by a
+// sequence of entries, each containing a block, its receipts and its total
+// difficulty. Entries are grouped into epochs aligned to block numbers, and every
+// epoch is closed by an accumulator record committing to the block hashes and
+// total difficulties it contains, as well as a checksum of the raw entries. Since
+// epochs are aligned, the accumulator roots of complete epochs are identical for
+// any archive covering them, regardless of the exported range.
+package era
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	// Version is the archive format version produced by the writer.
+	Version = 1
+
+	// DefaultEpochSize is the default number of blocks grouped under a single
+	// accumulator record.
+	DefaultEpochSize = 8192
+)
+
+const (
+	kindEntry       = 1 // Record containing a block, its receipts and total difficulty
+	kindAccumulator = 2 // Record closing an epoch with its accumulator and checksum
+)
+
+// archiveMagic is the format identifier at the start of every archive.
+var archiveMagic = "geth-era"
+
+var (
+	errInvalidMagic       = errors.New("not a chain archive")
+	errUnsupportedVersion = errors.New("unsupported archive version")
+	errTruncated          = errors.New("archive truncated mid-epoch")
+	errUnexpectedRecord   = errors.New("unexpected archive record")
+	errEmptyEpoch         = errors.New("empty epoch")
+	errRootMismatch       = errors.New("accumulator root mismatch")
+	errChecksumMismatch   = errors.New("epoch checksum mismatch")
+	errUntrustedRoot      = errors.New("accumulator root not trusted")
+)
+
+// header is the preamble of an archive.
+type header struct {
+	Magic   string
+	Version uint64
+}
+
+// record is the envelope of every item following the archive header.
+type record struct {
+	Kind uint64
+	Data rlp.RawValue
+}
+
+// storedEntry is the RLP representation of an archive entry.
+type storedEntry struct {
+	Block    *types.Block
+	Receipts []*types.ReceiptForStorage
+	TD       *big.Int
+}
+
+// Entry is a single block of the archive, along with its receipts and total
+// difficulty.
+type Entry struct {
+	Block    *types.Block
+	Receipts types.Receipts
+	TD       *big.Int
+}
+
+// Accumulator is the record closing an epoch. Root commits to the hashes and
+// total difficulties of the contained blocks, Checksum to the raw entry data.
+type Accumulator struct {
+	Start    uint64      // Number of the first block in the epoch
+	Count    uint64      // Number of blocks in the epoch
+	Root     common.Hash // Accumulator of the block hashes and total difficulties
+	Checksum common.Hash // Keccak256 checksum of the raw entries
+}
+
+// epoch accumulates the commitments of the epoch currently being written or read.
+type epoch struct {
+	start, count uint64
+	root, sum    hash.Hash
+}
+
+func newEpoch(start uint64) *epoch {
+	return &epoch{start: start, root: sha3.NewKeccak256(), sum: sha3.NewKeccak256()}
+}
+
+// add appends an entry to the epoch's commitments.
+func (e *epoch) add(hash common.Hash, td *big.Int, raw []byte) {
+	e.root.Write(hash[:])
+	e.root.Write(common.LeftPadBytes(td.Bytes(), 32))
+	e.sum.Write(raw)
+	e.count++
+}
+
+// accumulator finalizes the epoch's commitments.
+func (e *epoch) accumulator() *Accumulator {
+	acc := &Accumulator{Start: e.start, Count: e.count}
+	e.root.Sum(acc.Root[:0])
+	e.sum.Sum(acc.Checksum[:0])
+	return acc
+}
+
+// Writer streams chain segments into an archive.
+type Writer struct {
+	w         io.Writer
+	epochSize uint64
+	next      uint64         // Number of the next block expected (if an epoch is open)
+	epoch     *epoch         // Epoch currently being written, nil if none
+	accs      []*Accumulator // Accumulators of the epochs already closed
+}
+
+// NewWriter creates an archive writer, emitting the archive header and closing
+// epochs whenever a block number multiple of epochSize is reached.
+func NewWriter(w io.Writer, epochSize uint64) (*Writer, error) {
+	if epochSize == 0 {
+		return nil, errors.New("zero epoch size")
+	}
+	if err := rlp.Encode(w, &header{Magic: archiveMagic, Version: Version}); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, epochSize: epochSize}, nil
+}
+
+// Write appends a block with its receipts and total difficulty to the archive.
+// Blocks must be written in ascending, contiguous order.
+func (w *Writer) Write(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	number := block.NumberU64()
+	if w.epoch != nil && number != w.next {
+		return fmt.Errorf("non contiguous write: have #%d, want #%d", number, w.next)
+	}
+	if len(receipts) != len(block.Transactions()) {
+		return fmt.Errorf("block #%d: receipt count mismatch: have %d, want %d", number, len(receipts), len(block.Transactions()))
+	}
+	stored := &storedEntry{Block: block, Receipts: make([]*types.ReceiptForStorage, len(receipts)), TD: td}
+	for i, receipt := range receipts {
+		stored.Receipts[i] = (*types.ReceiptForStorage)(receipt)
+	}
+	data, err := rlp.EncodeToBytes(stored)
+	if err != nil {
+		return err
+	}
+	if err := rlp.Encode(w.w, &record{Kind: kindEntry, Data: data}); err != nil {
+		return err
+	}
+	if w.epoch == nil {
+		w.epoch = newEpoch(number)
+	}
+	w.epoch.add(block.Hash(), td, data)
+	w.next = number + 1
+
+	if w.next%w.epochSize == 0 {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush closes the currently open epoch (if any) by writing its accumulator.
+func (w *Writer) Flush() error {
+	if w.epoch == nil {
+		return nil
+	}
+	acc := w.epoch.accumulator()
+	data, err := rlp.EncodeToBytes(acc)
+	if err != nil {
+		return err
+	}
+	w.epoch, w.accs = nil, append(w.accs, acc)
+	return rlp.Encode(w.w, &record{Kind: kindAccumulator, Data: data})
+}
+
+// Accumulators returns the accumulators of the epochs written so far, which can
+// be published for others to verify the archive against.
+func (w *Writer) Accumulators() []*Accumulator {
+	return w.accs
+}
+
+// Reader streams epochs out of an archive, verifying their integrity.
+type Reader struct {
+	stream  *rlp.Stream
+	trusted map[common.Hash]bool // Accepted accumulator roots, nil if any
+}
+
+// NewReader creates an archive reader, checking the archive header.
+func NewReader(r io.Reader) (*Reader, error) {
+	stream := rlp.NewStream(r, 0)
+
+	var head header
+	if err := stream.Decode(&head); err != nil {
+		if err == io.EOF {
+			return nil, errInvalidMagic
+		}
+		return nil, err
+	}
+	if head.Magic != archiveMagic {
+		return nil, errInvalidMagic
+	}
+	if head.Version != Version {
+		return nil, fmt.Errorf("%v: %d", errUnsupportedVersion, head.Version)
+	}
+	return &Reader{stream: stream}, nil
+}
+
+// SetTrustedRoots restricts the reader to epochs whose accumulator root is one of
+// the given ones. The accumulators of an archive only guarantee its integrity, not
+// that it contains the canonical chain, since anyone can create an archive with
+// matching accumulators. Complete epochs however have identical roots in every
+// archive, so roots published by a trusted source can authenticate the contents.
+func (r *Reader) SetTrustedRoots(roots []common.Hash) {
+	r.trusted = make(map[common.Hash]bool)
+	for _, root := range roots {
+		r.trusted[root] = true
+	}
+}
+
+// Read retrieves the next epoch from the archive. The entries are only returned
+// after the epoch's accumulator and checksum were verified, and the contents of
+// every block were checked against its header. At the end of the archive io.EOF
+// is returned.
+func (r *Reader) Read() ([]*Entry, *Accumulator, error) {
+	var (
+		entries []*Entry
+		current *epoch
+	)
+	for {
+		var rec record
+		if err := r.stream.Decode(&rec); err != nil {
+			if err == io.EOF && current != nil {
+				return nil, nil, errTruncated
+			}
+			return nil, nil, err
+		}
+		switch rec.Kind {
+		case kindEntry:
+			var stored storedEntry
+			if err := rlp.DecodeBytes(rec.Data, &stored); err != nil {
+				return nil, nil, err
+			}
+			entry := &Entry{Block: stored.Block, Receipts: make(types.Receipts, len(stored.Receipts)), TD: stored.TD}
+			for i, receipt := range stored.Receipts {
+				entry.Receipts[i] = (*types.Receipt)(receipt)
+			}
+			number := entry.Block.NumberU64()
+			if current == nil {
+				current = newEpoch(number)
+			} else if prev := entries[len(entries)-1].Block; number != prev.NumberU64()+1 || entry.Block.ParentHash() != prev.Hash() {
+				return nil, nil, fmt.Errorf("non contiguous entry: #%d [%x…] after #%d [%x…]", number, entry.Block.Hash().Bytes()[:4], prev.NumberU64(), prev.Hash().Bytes()[:4])
+			}
+			if err := verifyEntry(entry); err != nil {
+				return nil, nil, err
+			}
+			current.add(entry.Block.Hash(), entry.TD, rec.Data)
+			entries = append(entries, entry)
+
+		case kindAccumulator:
+			if current == nil {
+				return nil, nil, errEmptyEpoch
+			}
+			var acc Accumulator
+			if err := rlp.DecodeBytes(rec.Data, &acc); err != nil {
+				return nil, nil, err
+			}
+			want := current.accumulator()
+			if acc.Start != want.Start || acc.Count != want.Count {
+				return nil, nil, fmt.Errorf("epoch range mismatch: have #%d+%d, want #%d+%d", acc.Start, acc.Count, want.Start, want.Count)
+			}
+			if acc.Root != want.Root {
+				return nil, nil, fmt.Errorf("epoch #%d: %v", acc.Start, errRootMismatch)
+			}
+			if acc.Checksum != want.Checksum {
+				return nil, nil, fmt.Errorf("epoch #%d: %v", acc.Start, errChecksumMismatch)
+			}
+			if r.trusted != nil && !r.trusted[acc.Root] {
+				return nil, nil, fmt.Errorf("epoch #%d: %v: %x", acc.Start, errUntrustedRoot, acc.Root)
+			}
+			return entries, &acc, nil
+
+		default:
+			return nil, nil, fmt.Errorf("%v: kind %d", errUnexpectedRecord, rec.Kind)
+		}
+	}
+}
+
+// verifyEntry checks that the transactions, uncles and receipts of an entry
+// match the commitments in its block header.
+func verifyEntry(entry *Entry) error {
+	var (
+		block  = entry.Block
+		header = block.Header()
+	)
+	if entry.TD == nil || entry.TD.Cmp(header.Difficulty) < 0 {
+		return fmt.Errorf("block #%d: invalid total difficulty", header.Number)
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != header.TxHash {
+		return fmt.Errorf("block #%d: transaction root mismatch: have %x, want %x", header.Number, hash, header.TxHash)
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != header.UncleHash {
+		return fmt.Errorf("block #%d: uncle root mismatch: have %x, want %x", header.Number, hash, header.UncleHash)
+	}
+	if hash := types.DeriveSha(entry.Receipts); hash != header.ReceiptHash {
+		return fmt.Errorf("block #%d: receipt root mismatch: have %x, want %x", header.Number, hash, header.ReceiptHash)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// makeChain creates a chain of n blocks (plus genesis), each with a transaction,
+// returning the blocks, their receipts and total difficulties.
+func makeChain(n int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		db, _   = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		genesis = core.GenesisBlockForTesting(db, address, big.NewInt(1000000000))
+		signer  = types.HomesteadSigner{}
+	)
+	blocks, receipts := core.GenerateChain(params.TestChainConfig, genesis, db, n, func(i int, block *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	blocks = append([]*types.Block{genesis}, blocks...)
+	receipts = append([]types.Receipts{nil}, receipts...)
+
+	tds := make([]*big.Int, len(blocks))
+	for i, block := range blocks {
+		tds[i] = new(big.Int).Set(block.Difficulty())
+		if i > 0 {
+			tds[i].Add(tds[i], tds[i-1])
+		}
+	}
+	return blocks, receipts, tds
+}
+
+// writeArchive exports the [first, last] range of a chain into an archive.
+func writeArchive(t *testing.T, blocks []*types.Block, receipts []types.Receipts, tds []*big.Int, first, last int) []byte {
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, 16)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	for i := first; i <= last; i++ {
+		if err := w.Write(blocks[i], receipts[i], tds[i]); err != nil {
+			t.Fatalf("failed to write block #%d: %v", i, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// readArchive reads all epochs out of an archive.
+func readArchive(data []byte) ([]*Entry, []*Accumulator, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		entries []*Entry
+		accs    []*Accumulator
+	)
+	for {
+		epoch, acc, err := r.Read()
+		if err == io.EOF {
+			return entries, accs, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		entries, accs = append(entries, epoch...), append(accs, acc)
+	}
+}
+
+// Tests that full and partial chain segments can be archived and read back, and
+// that complete epochs have identical accumulators irrespective of the range.
+func TestArchiveRoundtrip(t *testing.T) {
+	blocks, receipts, tds := makeChain(40)
+
+	entries, full, err := readArchive(writeArchive(t, blocks, receipts, tds, 0, 40))
+	if err != nil {
+		t.Fatalf("failed to read full archive: %v", err)
+	}
+	if len(entries) != 41 {
+		t.Fatalf("entry count mismatch: have %d, want %d", len(entries), 41)
+	}
+	for i, entry := range entries {
+		if entry.Block.Hash() != blocks[i].Hash() {
+			t.Errorf("entry %d: block hash mismatch: have %x, want %x", i, entry.Block.Hash(), blocks[i].Hash())
+		}
+		if entry.TD.Cmp(tds[i]) != 0 {
+			t.Errorf("entry %d: total difficulty mismatch: have %v, want %v", i, entry.TD, tds[i])
+		}
+		if types.DeriveSha(entry.Receipts) != types.DeriveSha(receipts[i]) {
+			t.Errorf("entry %d: receipts mismatch", i)
+		}
+	}
+	if len(full) != 3 {
+		t.Fatalf("epoch count mismatch: have %d, want %d", len(full), 3)
+	}
+	entries, partial, err := readArchive(writeArchive(t, blocks, receipts, tds, 20, 40))
+	if err != nil {
+		t.Fatalf("failed to read partial archive: %v", err)
+	}
+	if len(entries) != 21 || entries[0].Block.NumberU64() != 20 {
+		t.Fatalf("partial entries mismatch: have %d from #%d, want %d from #%d", len(entries), entries[0].Block.NumberU64(), 21, 20)
+	}
+	if len(partial) != 2 {
+		t.Fatalf("partial epoch count mismatch: have %d, want %d", len(partial), 2)
+	}
+	if *partial[1] != *full[2] {
+		t.Errorf("complete epoch accumulator mismatch: have %+v, want %+v", partial[1], full[2])
+	}
+	if partial[0].Start != 20 || partial[0].Count != 12 {
+		t.Errorf("partial epoch range mismatch: have #%d+%d, want #%d+%d", partial[0].Start, partial[0].Count, 20, 12)
+	}
+}
+
+// Tests that only epochs with trusted accumulator roots are accepted if the
+// reader is restricted to them.
+func TestArchiveTrustedRoots(t *testing.T) {
+	blocks, receipts, tds := makeChain(40)
+	data := writeArchive(t, blocks, receipts, tds, 0, 40)
+
+	_, accs, err := readArchive(data)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	read := func(roots []common.Hash) (int, error) {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		r.SetTrustedRoots(roots)
+		for epochs := 0; ; epochs++ {
+			if _, _, err := r.Read(); err == io.EOF {
+				return epochs, nil
+			} else if err != nil {
+				return epochs, err
+			}
+		}
+	}
+	if epochs, err := read([]common.Hash{accs[0].Root, accs[1].Root, accs[2].Root}); err != nil || epochs != 3 {
+		t.Errorf("trusted archive rejected after %d epochs: %v", epochs, err)
+	}
+	if epochs, err := read([]common.Hash{accs[0].Root, accs[2].Root}); err == nil || epochs != 1 {
+		t.Errorf("untrusted epoch accepted: read %d epochs, error %v", epochs, err)
+	}
+}
+
+// Tests that tampered or truncated archives are rejected.
+func TestArchiveCorruption(t *testing.T) {
+	blocks, receipts, tds := makeChain(20)
+	data := writeArchive(t, blocks, receipts, tds, 0, 20)
+
+	// Truncate the archive mid-epoch
+	if _, _, err := readArchive(data[:len(data)-40]); err == nil {
+		t.Errorf("truncated archive accepted")
+	}
+	// Rewrite the total difficulty of an entry, keeping everything else valid
+	stream := rlp.NewStream(bytes.NewReader(data), 0)
+	out := new(bytes.Buffer)
+
+	var head header
+	if err := stream.Decode(&head); err != nil {
+		t.Fatalf("failed to decode header: %v", err)
+	}
+	rlp.Encode(out, &head)
+	for i := 0; ; i++ {
+		var rec record
+		if err := stream.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to decode record %d: %v", i, err)
+		}
+		if rec.Kind == kindEntry && i == 5 {
+			var stored storedEntry
+			if err := rlp.DecodeBytes(rec.Data, &stored); err != nil {
+				t.Fatalf("failed to decode entry: %v", err)
+			}
+			stored.TD.Add(stored.TD, common.Big1)
+			rec.Data, _ = rlp.EncodeToBytes(&stored)
+		}
+		rlp.Encode(out, &rec)
+	}
+	if _, _, err := readArchive(out.Bytes()); err == nil {
+		t.Errorf("tampered archive accepted")
+	}
+	// Feed in something that's not an archive
+	if _, err := NewReader(bytes.NewReader([]byte{0xc0})); err == nil {
+		t.Errorf("invalid archive accepted")
+	}
+}
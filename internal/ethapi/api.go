@@ -26,7 +26,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -38,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/pow"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -63,6 +63,36 @@ func (s *PublicEthereumAPI) GasPrice(ctx context.Context) (*big.Int, error) {
 	return s.b.SuggestPrice(ctx)
 }
 
+// feeHistoryResult is the fee history of a range of blocks.
+type feeHistoryResult struct {
+	OldestBlock  *hexutil.Big     `json:"oldestBlock"`
+	Reward       [][]*hexutil.Big `json:"reward,omitempty"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+// FeeHistory returns the gas usage ratios and the gas used weighted gas price
+// percentiles of the transactions of a range of blocks ending at lastBlock.
+func (s *PublicEthereumAPI) FeeHistory(ctx context.Context, blockCount hexutil.Uint64, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*feeHistoryResult, error) {
+	oldest, reward, gasUsedRatio, err := s.b.FeeHistory(ctx, int(blockCount), lastBlock, rewardPercentiles)
+	if err != nil {
+		return nil, err
+	}
+	result := &feeHistoryResult{
+		OldestBlock:  (*hexutil.Big)(oldest),
+		GasUsedRatio: gasUsedRatio,
+	}
+	if reward != nil {
+		result.Reward = make([][]*hexutil.Big, len(reward))
+		for i, prices := range reward {
+			result.Reward[i] = make([]*hexutil.Big, len(prices))
+			for j, price := range prices {
+				result.Reward[i][j] = (*hexutil.Big)(price)
+			}
+		}
+	}
+	return result, nil
+}
+
 // ProtocolVersion returns the current Ethereum protocol version this node supports
 func (s *PublicEthereumAPI) ProtocolVersion() hexutil.Uint {
 	return hexutil.Uint(s.b.ProtocolVersion())
@@ -129,13 +159,29 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	return content
 }
 
-// Status returns the number of pending and queued transaction in the pool.
-func (s *PublicTxPoolAPI) Status() map[string]hexutil.Uint {
-	pending, queue := s.b.Stats()
-	return map[string]hexutil.Uint{
-		"pending": hexutil.Uint(pending),
-		"queued":  hexutil.Uint(queue),
+// Status returns the number of pending and queued transaction in the pool. If a
+// transaction hash is given, the whereabouts of that particular transaction are
+// returned instead: whether it's pending or queued, included, or why it was dropped.
+func (s *PublicTxPoolAPI) Status(hash *common.Hash) map[string]interface{} {
+	if hash == nil {
+		pending, queue := s.b.Stats()
+		return map[string]interface{}{
+			"pending": hexutil.Uint(pending),
+			"queued":  hexutil.Uint(queue),
+		}
+	}
+	status := s.b.TxPoolStatus(*hash)
+
+	fields := map[string]interface{}{
+		"status": status.Status,
 	}
+	if status.Reason != "" {
+		fields["reason"] = status.Reason
+	}
+	if status.Replacement != (common.Hash{}) {
+		fields["replacement"] = status.Replacement
+	}
+	return fields
 }
 
 // Inspect retrieves the content of the transaction pool and flattens it into an
@@ -428,21 +474,23 @@ func (s *PublicBlockChainAPI) GetUncleByBlockHashAndIndex(ctx context.Context, b
 }
 
 // GetUncleCountByBlockNumber returns number of uncles in the block for the given block number
-func (s *PublicBlockChainAPI) GetUncleCountByBlockNumber(ctx context.Context, blockNr rpc.BlockNumber) *hexutil.Uint {
-	if block, _ := s.b.BlockByNumber(ctx, blockNr); block != nil {
+func (s *PublicBlockChainAPI) GetUncleCountByBlockNumber(ctx context.Context, blockNr rpc.BlockNumber) (*hexutil.Uint, error) {
+	block, err := s.b.BlockByNumber(ctx, blockNr)
+	if block != nil {
 		n := hexutil.Uint(len(block.Uncles()))
-		return &n
+		return &n, nil
 	}
-	return nil
+	return nil, err
 }
 
 // GetUncleCountByBlockHash returns number of uncles in the block for the given block hash
-func (s *PublicBlockChainAPI) GetUncleCountByBlockHash(ctx context.Context, blockHash common.Hash) *hexutil.Uint {
-	if block, _ := s.b.GetBlock(ctx, blockHash); block != nil {
+func (s *PublicBlockChainAPI) GetUncleCountByBlockHash(ctx context.Context, blockHash common.Hash) (*hexutil.Uint, error) {
+	block, err := s.b.GetBlock(ctx, blockHash)
+	if block != nil {
 		n := hexutil.Uint(len(block.Uncles()))
-		return &n
+		return &n, nil
 	}
-	return nil
+	return nil, err
 }
 
 // GetCode returns the code stored at the given address in the state for the given block number.
@@ -795,54 +843,74 @@ func getTransaction(chainDb ethdb.Database, b Backend, txHash common.Hash) (*typ
 	return tx, isPending, nil
 }
 
+// prunedTxError returns an error for a transaction that could not be found if its
+// lookup entry survived the pruning of its block, proving that it was included
+// in the pruned history of the chain. Unknown transactions yield no error.
+func prunedTxError(chainDb ethdb.Database, txHash common.Hash) error {
+	blockHash, number, _, err := getTransactionBlockData(chainDb, txHash)
+	if err != nil {
+		return nil
+	}
+	if tail := core.GetPruneTail(chainDb); number == 0 || number >= tail {
+		return nil
+	}
+	return fmt.Errorf("transaction %x included in block #%d [%x…]: %v", txHash, number, blockHash[:4], core.ErrBlockPruned)
+}
+
 // GetBlockTransactionCountByNumber returns the number of transactions in the block with the given block number.
-func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByNumber(ctx context.Context, blockNr rpc.BlockNumber) *hexutil.Uint {
-	if block, _ := s.b.BlockByNumber(ctx, blockNr); block != nil {
+func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*hexutil.Uint, error) {
+	block, err := s.b.BlockByNumber(ctx, blockNr)
+	if block != nil {
 		n := hexutil.Uint(len(block.Transactions()))
-		return &n
+		return &n, nil
 	}
-	return nil
+	return nil, err
 }
 
 // GetBlockTransactionCountByHash returns the number of transactions in the block with the given hash.
-func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByHash(ctx context.Context, blockHash common.Hash) *hexutil.Uint {
-	if block, _ := s.b.GetBlock(ctx, blockHash); block != nil {
+func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByHash(ctx context.Context, blockHash common.Hash) (*hexutil.Uint, error) {
+	block, err := s.b.GetBlock(ctx, blockHash)
+	if block != nil {
 		n := hexutil.Uint(len(block.Transactions()))
-		return &n
+		return &n, nil
 	}
-	return nil
+	return nil, err
 }
 
 // GetTransactionByBlockNumberAndIndex returns the transaction for the given block number and index.
 func (s *PublicTransactionPoolAPI) GetTransactionByBlockNumberAndIndex(ctx context.Context, blockNr rpc.BlockNumber, index hexutil.Uint) (*RPCTransaction, error) {
-	if block, _ := s.b.BlockByNumber(ctx, blockNr); block != nil {
+	block, err := s.b.BlockByNumber(ctx, blockNr)
+	if block != nil {
 		return newRPCTransactionFromBlockIndex(block, uint(index))
 	}
-	return nil, nil
+	return nil, err
 }
 
 // GetTransactionByBlockHashAndIndex returns the transaction for the given block hash and index.
 func (s *PublicTransactionPoolAPI) GetTransactionByBlockHashAndIndex(ctx context.Context, blockHash common.Hash, index hexutil.Uint) (*RPCTransaction, error) {
-	if block, _ := s.b.GetBlock(ctx, blockHash); block != nil {
+	block, err := s.b.GetBlock(ctx, blockHash)
+	if block != nil {
 		return newRPCTransactionFromBlockIndex(block, uint(index))
 	}
-	return nil, nil
+	return nil, err
 }
 
 // GetRawTransactionByBlockNumberAndIndex returns the bytes of the transaction for the given block number and index.
 func (s *PublicTransactionPoolAPI) GetRawTransactionByBlockNumberAndIndex(ctx context.Context, blockNr rpc.BlockNumber, index hexutil.Uint) (hexutil.Bytes, error) {
-	if block, _ := s.b.BlockByNumber(ctx, blockNr); block != nil {
+	block, err := s.b.BlockByNumber(ctx, blockNr)
+	if block != nil {
 		return newRPCRawTransactionFromBlockIndex(block, uint(index))
 	}
-	return nil, nil
+	return nil, err
 }
 
 // GetRawTransactionByBlockHashAndIndex returns the bytes of the transaction for the given block hash and index.
 func (s *PublicTransactionPoolAPI) GetRawTransactionByBlockHashAndIndex(ctx context.Context, blockHash common.Hash, index hexutil.Uint) (hexutil.Bytes, error) {
-	if block, _ := s.b.GetBlock(ctx, blockHash); block != nil {
+	block, err := s.b.GetBlock(ctx, blockHash)
+	if block != nil {
 		return newRPCRawTransactionFromBlockIndex(block, uint(index))
 	}
-	return nil, nil
+	return nil, err
 }
 
 // GetTransactionCount returns the number of transactions the given address has sent for the given block number
@@ -890,7 +958,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, txH
 		glog.V(logger.Debug).Infof("%v\n", err)
 		return nil, nil
 	} else if tx == nil {
-		return nil, nil
+		return nil, prunedTxError(s.b.ChainDb(), txHash)
 	}
 
 	if isPending {
@@ -930,7 +998,10 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(txHash common.Hash) (ma
 	receipt := core.GetReceipt(s.b.ChainDb(), txHash)
 	if receipt == nil {
 		glog.V(logger.Debug).Infof("receipt not found for transaction %s", txHash.Hex())
-		return nil, nil
+		if s.b.GetPoolTransaction(txHash) != nil {
+			return nil, nil
+		}
+		return nil, prunedTxError(s.b.ChainDb(), txHash)
 	}
 
 	tx, _, err := getTransaction(s.b.ChainDb(), s.b, txHash)
@@ -1217,7 +1288,10 @@ func NewPublicDebugAPI(b Backend) *PublicDebugAPI {
 
 // GetBlockRlp retrieves the RLP encoded for of a single block.
 func (api *PublicDebugAPI) GetBlockRlp(ctx context.Context, number uint64) (string, error) {
-	block, _ := api.b.BlockByNumber(ctx, rpc.BlockNumber(number))
+	block, err := api.b.BlockByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return "", err
+	}
 	if block == nil {
 		return "", fmt.Errorf("block #%d not found", number)
 	}
@@ -1230,7 +1304,10 @@ func (api *PublicDebugAPI) GetBlockRlp(ctx context.Context, number uint64) (stri
 
 // PrintBlock retrieves a block and returns its pretty printed form.
 func (api *PublicDebugAPI) PrintBlock(ctx context.Context, number uint64) (string, error) {
-	block, _ := api.b.BlockByNumber(ctx, rpc.BlockNumber(number))
+	block, err := api.b.BlockByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return "", err
+	}
 	if block == nil {
 		return "", fmt.Errorf("block #%d not found", number)
 	}
@@ -1243,13 +1320,33 @@ func (api *PublicDebugAPI) SeedHash(ctx context.Context, number uint64) (string,
 	if block == nil {
 		return "", fmt.Errorf("block #%d not found", number)
 	}
-	hash, err := ethash.GetSeedHash(number)
+	hash, err := pow.SeedHash(number)
 	if err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("0x%x", hash), nil
 }
 
+// TxIndexStatus is the range of canonical blocks whose transactions and receipts
+// can be looked up by hash. Nothing is indexed if From is above Head.
+type TxIndexStatus struct {
+	From hexutil.Uint64 `json:"from"`
+	Head hexutil.Uint64 `json:"head"`
+}
+
+// TxIndexStatus returns the range of blocks covered by the transaction lookup
+// index, as lookups of transactions outside of it return nothing, the same way
+// as for unknown ones.
+func (api *PublicDebugAPI) TxIndexStatus() *TxIndexStatus {
+	db := api.b.ChainDb()
+
+	status := &TxIndexStatus{Head: hexutil.Uint64(core.GetBlockNumber(db, core.GetHeadBlockHash(db)))}
+	if tail := core.GetTxIndexTail(db); tail != nil {
+		status.From = hexutil.Uint64(*tail)
+	}
+	return status
+}
+
 // PrivateDebugAPI is the collection of Etheruem APIs exposed over the private
 // debugging endpoint.
 type PrivateDebugAPI struct {
@@ -37,7 +37,7 @@ import (
 
 type LesApiBackend struct {
 	eth *LightEthereum
-	gpo *gasprice.LightPriceOracle
+	gpo *gasprice.Oracle
 }
 
 func (b *LesApiBackend) ChainConfig() *params.ChainConfig {
@@ -130,6 +130,14 @@ func (b *LesApiBackend) TxPoolContent() (map[common.Address]types.Transactions,
 	return b.eth.txPool.Content()
 }
 
+func (b *LesApiBackend) TxPoolStatus(txHash common.Hash) core.TxStatus {
+	// The light pool only tracks locally submitted, not yet mined transactions
+	if b.eth.txPool.GetTransaction(txHash) != nil {
+		return core.TxStatus{Status: "pending"}
+	}
+	return core.TxStatus{Status: "unknown"}
+}
+
 func (b *LesApiBackend) Downloader() *downloader.Downloader {
 	return b.eth.Downloader()
 }
@@ -142,6 +150,10 @@ func (b *LesApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *LesApiBackend) FeeHistory(ctx context.Context, blockCount int, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*big.Int, [][]*big.Int, []float64, error) {
+	return b.gpo.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+}
+
 func (b *LesApiBackend) ChainDb() ethdb.Database {
 	return b.eth.chainDb
 }
@@ -153,3 +165,11 @@ func (b *LesApiBackend) EventMux() *event.TypeMux {
 func (b *LesApiBackend) AccountManager() *accounts.Manager {
 	return b.eth.accountManager
 }
+
+func (b *LesApiBackend) BloomStatus() (uint64, uint64) {
+	return params.BloomBitsBlocks, light.GetTrustedBloomTrie(b.eth.chainDb).Number
+}
+
+func (b *LesApiBackend) GetBloomBits(ctx context.Context, bits []uint, section uint64) ([][]byte, error) {
+	return light.GetBloomBits(ctx, b.eth.odr, bits, section)
+}
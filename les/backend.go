@@ -66,8 +66,10 @@ type LightEthereum struct {
 	solcPath       string
 	solc           *compiler.Solidity
 
-	netVersionId  int
-	netRPCService *ethapi.PublicNetAPI
+	netVersionId   int
+	netRPCService  *ethapi.PublicNetAPI
+	logsMaxRange   uint64 // Maximum number of blocks a log query may search
+	logsMaxResults int    // Maximum number of logs a log query may return
 }
 
 func New(ctx *node.ServiceContext, config *eth.Config) (*LightEthereum, error) {
@@ -78,7 +80,7 @@ func New(ctx *node.ServiceContext, config *eth.Config) (*LightEthereum, error) {
 	if err := eth.SetupGenesisBlock(&chainDb, config); err != nil {
 		return nil, err
 	}
-	pow, err := eth.CreatePoW(config)
+	pow, err := eth.CreatePoW(ctx, config)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +97,8 @@ func New(ctx *node.ServiceContext, config *eth.Config) (*LightEthereum, error) {
 		shutdownChan:   make(chan bool),
 		netVersionId:   config.NetworkId,
 		solcPath:       config.SolcPath,
+		logsMaxRange:   config.LogsMaxRange,
+		logsMaxResults: config.LogsMaxResults,
 	}
 
 	if config.ChainConfig == nil {
@@ -108,6 +112,9 @@ func New(ctx *node.ServiceContext, config *eth.Config) (*LightEthereum, error) {
 		}
 		return nil, err
 	}
+	if config.Checkpoint != nil {
+		eth.blockchain.AddTrustedCheckpoint(config.Checkpoint)
+	}
 
 	eth.txPool = light.NewTxPool(eth.chainConfig, eth.eventMux, eth.blockchain, eth.relay)
 	if eth.protocolManager, err = NewProtocolManager(eth.chainConfig, config.LightMode, config.NetworkId, eth.eventMux, eth.pow, eth.blockchain, nil, chainDb, odr, relay); err != nil {
@@ -115,7 +122,7 @@ func New(ctx *node.ServiceContext, config *eth.Config) (*LightEthereum, error) {
 	}
 
 	eth.ApiBackend = &LesApiBackend{eth, nil}
-	eth.ApiBackend.gpo = gasprice.NewLightPriceOracle(eth.ApiBackend)
+	eth.ApiBackend.gpo = gasprice.NewOracle(eth.ApiBackend, config.GasPriceOracle())
 	return eth, nil
 }
 
@@ -144,6 +151,9 @@ func (s *LightDummyAPI) Mining() bool {
 // APIs returns the collection of RPC services the ethereum package offers.
 // NOTE, some of these services probably need to be moved to somewhere else.
 func (s *LightEthereum) APIs() []rpc.API {
+	filterAPI := filters.NewPublicFilterAPI(s.ApiBackend, true)
+	filterAPI.SetLogLimits(s.logsMaxRange, s.logsMaxResults)
+
 	return append(ethapi.GetAPIs(s.ApiBackend, s.solcPath), []rpc.API{
 		{
 			Namespace: "eth",
@@ -158,7 +168,7 @@ func (s *LightEthereum) APIs() []rpc.API {
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
-			Service:   filters.NewPublicFilterAPI(s.ApiBackend, true),
+			Service:   filterAPI,
 			Public:    true,
 		}, {
 			Namespace: "net",
@@ -34,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/light"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -59,7 +60,11 @@ const (
 	MaxHeaderProofsFetch = 64  // Amount of merkle proofs to be fetched per retrieval request
 	MaxTxSend            = 64  // Amount of transactions to be send per request
 
+	MaxBloomBitsProofsFetch = light.BloomBitsFetchLimit // Amount of bloom bit proofs to be fetched per retrieval request
+
 	disableClientRemovePeer = false
+
+	checkpointChallengeTimeout = 15 * time.Second // Time allowance for a server to reply to the checkpoint challenge
 )
 
 // errIncompatibleConfig is returned if the requested protocols and configs are
@@ -121,6 +126,10 @@ type ProtocolManager struct {
 	syncing  bool
 	syncDone chan struct{}
 
+	checkpointNumber uint64       // Block number of the trusted checkpoint head (if any)
+	checkpointHash   common.Hash  // Block hash of the trusted checkpoint head (zero = unresolved)
+	checkpointLock   sync.RWMutex // Lock protecting the trusted checkpoint head
+
 	// wait group is used for graceful shutdowns during downloading
 	// and processing
 	wg sync.WaitGroup
@@ -310,6 +319,43 @@ func (pm *ProtocolManager) newPeer(pv, nv int, p *p2p.Peer, rw p2p.MsgReadWriter
 	return newPeer(pv, nv, p, newMeteredMsgWriter(rw))
 }
 
+// setCheckpoint starts enforcing the head of the trusted checkpoint, proven by
+// a CHT proof, on the servers connecting from now on and during synchronisation,
+// dropping anyone whose chain doesn't contain it.
+func (pm *ProtocolManager) setCheckpoint(number uint64, hash common.Hash) {
+	pm.checkpointLock.Lock()
+	pm.checkpointNumber, pm.checkpointHash = number, hash
+	pm.checkpointLock.Unlock()
+
+	pm.downloader.SetCheckpoint(number, hash)
+}
+
+// trustedCheckpoint retrieves the number and hash of the enforced trusted
+// checkpoint head, the hash being zero if there's none.
+func (pm *ProtocolManager) trustedCheckpoint() (uint64, common.Hash) {
+	pm.checkpointLock.RLock()
+	defer pm.checkpointLock.RUnlock()
+
+	return pm.checkpointNumber, pm.checkpointHash
+}
+
+// verifyCheckpoint validates a server's reply to the trusted checkpoint challenge
+// sent after the handshake, failing if its chain doesn't contain the checkpoint.
+func (pm *ProtocolManager) verifyCheckpoint(p *peer, headers []*types.Header) error {
+	p.checkpointReq = 0
+	if p.checkpointDrop != nil {
+		p.checkpointDrop.Stop()
+		p.checkpointDrop = nil
+	}
+	number, hash := pm.trustedCheckpoint()
+	if len(headers) != 1 || headers[0].Number.Uint64() != number || headers[0].Hash() != hash {
+		glog.V(logger.Debug).Infof("%v: verified not to contain the trusted checkpoint, dropping", p)
+		return errResp(ErrCheckpointMismatch, "")
+	}
+	glog.V(logger.Debug).Infof("%v: verified to contain the trusted checkpoint", p)
+	return nil
+}
+
 // handle is the callback invoked to manage the life cycle of a les peer. When
 // this function terminates, the peer is disconnected.
 func (pm *ProtocolManager) handle(p *peer) error {
@@ -341,14 +387,15 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	// Register the peer in the downloader. If the downloader considers it banned, we disconnect
 	glog.V(logger.Debug).Infof("LES: register peer %v", p.id)
 	if pm.lightSync {
-		requestHeadersByHash := func(origin common.Hash, amount int, skip int, reverse bool) error {
+		// LES replies are matched by their flow control request IDs, the downloader's are not needed
+		requestHeadersByHash := func(_ uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 			reqID := getNextReqID()
 			cost := p.GetRequestCost(GetBlockHeadersMsg, amount)
 			p.fcServer.MustAssignRequest(reqID)
 			p.fcServer.SendRequest(reqID, cost)
 			return p.RequestHeadersByHash(reqID, cost, origin, amount, skip, reverse)
 		}
-		requestHeadersByNumber := func(origin uint64, amount int, skip int, reverse bool) error {
+		requestHeadersByNumber := func(_ uint64, origin uint64, amount int, skip int, reverse bool) error {
 			reqID := getNextReqID()
 			cost := p.GetRequestCost(GetBlockHeadersMsg, amount)
 			p.fcServer.MustAssignRequest(reqID)
@@ -374,6 +421,30 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		if p.poolEntry != nil {
 			pm.serverPool.registered(p.poolEntry)
 		}
+		// If a trusted checkpoint is enforced, validate that a server claiming to be
+		// past it has it
+		if number, hash := pm.trustedCheckpoint(); hash != (common.Hash{}) && p.headBlockInfo().Number >= number {
+			reqID := getNextReqID()
+			cost := p.GetRequestCost(GetBlockHeadersMsg, 1)
+			p.fcServer.MustAssignRequest(reqID)
+			p.fcServer.SendRequest(reqID, cost)
+			p.checkpointReq = reqID
+			if err := p.RequestHeadersByNumber(reqID, cost, number, 1, 0, false); err != nil {
+				return err
+			}
+			// Start a timer to disconnect if the server doesn't reply in time
+			p.checkpointDrop = time.AfterFunc(checkpointChallengeTimeout, func() {
+				glog.V(logger.Debug).Infof("%v: timed out checkpoint check, dropping", p)
+				pm.removePeer(p.id)
+			})
+			// Make sure it's cleaned up if the peer dies off
+			defer func() {
+				if p.checkpointDrop != nil {
+					p.checkpointDrop.Stop()
+					p.checkpointDrop = nil
+				}
+			}()
+		}
 	}
 
 	stop := make(chan struct{})
@@ -399,7 +470,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 }
 
-var reqList = []uint64{GetBlockHeadersMsg, GetBlockBodiesMsg, GetCodeMsg, GetReceiptsMsg, GetProofsMsg, SendTxMsg, GetHeaderProofsMsg}
+var reqList = []uint64{GetBlockHeadersMsg, GetBlockBodiesMsg, GetCodeMsg, GetReceiptsMsg, GetProofsMsg, SendTxMsg, GetHeaderProofsMsg, GetBloomBitsProofsMsg}
 
 // handleMsg is invoked whenever an inbound message is received from a remote
 // peer. The remote connection is torn down upon returning any error.
@@ -552,10 +623,13 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		p.fcServer.GotReply(resp.ReqID, resp.BV)
+		if p.checkpointReq != 0 && resp.ReqID == p.checkpointReq {
+			return pm.verifyCheckpoint(p, resp.Headers)
+		}
 		if pm.fetcher != nil && pm.fetcher.requestedID(resp.ReqID) {
 			pm.fetcher.deliverHeaders(p, resp.ReqID, resp.Headers)
 		} else {
-			err := pm.downloader.DeliverHeaders(p.id, resp.Headers)
+			err := pm.downloader.DeliverHeaders(p.id, 0, resp.Headers)
 			if err != nil {
 				glog.V(logger.Debug).Infoln(err)
 			}
@@ -831,7 +905,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 
 			if header := pm.blockchain.GetHeaderByNumber(req.BlockNum); header != nil {
-				if root := getChtRoot(pm.chainDb, req.ChtNum); root != (common.Hash{}) {
+				if root := light.GetChtRoot(pm.chainDb, req.ChtNum); root != (common.Hash{}) {
 					if tr, _ := trie.New(root, pm.chainDb); tr != nil {
 						var encNumber [8]byte
 						binary.BigEndian.PutUint64(encNumber[:], req.BlockNum)
@@ -866,6 +940,63 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			Obj:     resp.Data,
 		}
 
+	case GetBloomBitsProofsMsg:
+		glog.V(logger.Debug).Infof("<=== GetBloomBitsProofsMsg from peer %v", p.id)
+		// Decode the retrieval message
+		var req struct {
+			ReqID uint64
+			Reqs  []BloomReq
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Gather the bloom bit proofs until the fetch or network limits is reached
+		var (
+			bytes  int
+			proofs [][]rlp.RawValue
+		)
+		reqCnt := len(req.Reqs)
+		if reject(uint64(reqCnt), MaxBloomBitsProofsFetch) {
+			return errResp(ErrRequestRejected, "")
+		}
+		for _, req := range req.Reqs {
+			if bytes >= softResponseLimit {
+				break
+			}
+			if root := getBloomTrieRoot(pm.chainDb, req.BloomTrieNum); root != (common.Hash{}) {
+				if tr, _ := trie.New(root, pm.chainDb); tr != nil {
+					proof := tr.Prove(light.BloomTrieKey(uint(req.BitIdx), req.SectionIdx))
+					proofs = append(proofs, proof)
+					for _, node := range proof {
+						bytes += len(node)
+					}
+				}
+			}
+		}
+		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
+		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		return p.SendBloomBitsProofs(req.ReqID, bv, proofs)
+
+	case BloomBitsProofsMsg:
+		if pm.odr == nil {
+			return errResp(ErrUnexpectedResponse, "")
+		}
+
+		glog.V(logger.Debug).Infof("<=== BloomBitsProofsMsg from peer %v", p.id)
+		var resp struct {
+			ReqID, BV uint64
+			Data      [][]rlp.RawValue
+		}
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		p.fcServer.GotReply(resp.ReqID, resp.BV)
+		deliverMsg = &Msg{
+			MsgType: MsgBloomBitsProofs,
+			ReqID:   resp.ReqID,
+			Obj:     resp.Data,
+		}
+
 	case SendTxMsg:
 		if pm.txpool == nil {
 			return errResp(ErrUnexpectedResponse, "")
@@ -18,7 +18,9 @@ package les
 
 import (
 	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -336,3 +338,39 @@ func testGetProofs(t *testing.T, protocol int) {
 		t.Errorf("proofs mismatch: %v", err)
 	}
 }
+
+// Tests that a light client challenges the servers connecting to it with the
+// enforced trusted checkpoint, dropping those whose chain doesn't contain it.
+func TestCheckpointChallengeLes1(t *testing.T) { testCheckpointChallenge(t, 1) }
+func TestCheckpointChallengeLes2(t *testing.T) { testCheckpointChallenge(t, 2) }
+
+func testCheckpointChallenge(t *testing.T, protocol int) {
+	for i, match := range []bool{true, false} {
+		// Assemble the test environment and enforce the checkpoint on the client
+		pm, db, _ := newTestProtocolManagerMust(t, false, 4, testChainGen)
+		lpm, _, _ := newTestProtocolManagerMust(t, true, 0, nil)
+
+		hash := core.GetCanonicalHash(db, 2)
+		if !match {
+			hash = common.Hash{0x01}
+		}
+		lpm.setCheckpoint(2, hash)
+
+		// Connect the server and check whether it's dropped by the client
+		_, err1, _, err2 := newTestPeerPair("peer", protocol, pm, lpm)
+		select {
+		case err := <-err2:
+			if match {
+				t.Errorf("test %d: server containing the checkpoint dropped: %v", i, err)
+			} else if err == nil || !strings.Contains(err.Error(), errCode(ErrCheckpointMismatch).String()) {
+				t.Errorf("test %d: drop error mismatch: have %v, want %v", i, err, ErrCheckpointMismatch)
+			}
+		case err := <-err1:
+			t.Errorf("test %d: client dropped by server: %v", i, err)
+		case <-time.After(500 * time.Millisecond):
+			if !match {
+				t.Errorf("test %d: server missing the checkpoint not dropped", i)
+			}
+		}
+	}
+}
@@ -53,6 +53,8 @@ func LesRequest(req light.OdrRequest) LesOdrRequest {
 		return (*CodeRequest)(r)
 	case *light.ChtRequest:
 		return (*ChtRequest)(r)
+	case *light.BloomRequest:
+		return (*BloomRequest)(r)
 	default:
 		return nil
 	}
@@ -351,3 +353,71 @@ func (self *ChtRequest) Valid(db ethdb.Database, msg *Msg) bool {
 	glog.V(logger.Debug).Infof("ODR: validation successful")
 	return true
 }
+
+type BloomReq struct {
+	BloomTrieNum, BitIdx, SectionIdx uint64
+}
+
+// ODR request type for requesting bloom bit vectors by bloom trie, see LesOdrRequest interface
+type BloomRequest light.BloomRequest
+
+// GetCost returns the cost of the given ODR request according to the serving
+// peer's cost table (implementation of LesOdrRequest)
+func (self *BloomRequest) GetCost(peer *peer) uint64 {
+	return peer.GetRequestCost(GetBloomBitsProofsMsg, len(self.BitIdxs))
+}
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (self *BloomRequest) CanSend(peer *peer) bool {
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+
+	if peer.version < lpv2 || peer.headInfo.Number < light.ChtConfirmations {
+		return false
+	}
+	return self.BloomTrieNum <= (peer.headInfo.Number-light.ChtConfirmations)/light.ChtFrequency
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (self *BloomRequest) Request(reqID uint64, peer *peer) error {
+	glog.V(logger.Debug).Infof("ODR: requesting %d bloom bits of section %d from peer %v", len(self.BitIdxs), self.SectionIdx, peer.id)
+	reqs := make([]*BloomReq, len(self.BitIdxs))
+	for i, bit := range self.BitIdxs {
+		reqs[i] = &BloomReq{
+			BloomTrieNum: self.BloomTrieNum,
+			BitIdx:       uint64(bit),
+			SectionIdx:   self.SectionIdx,
+		}
+	}
+	return peer.RequestBloomBitsProofs(reqID, self.GetCost(peer), reqs)
+}
+
+// Valid processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (self *BloomRequest) Valid(db ethdb.Database, msg *Msg) bool {
+	glog.V(logger.Debug).Infof("ODR: validating %d bloom bits of section %d", len(self.BitIdxs), self.SectionIdx)
+
+	if msg.MsgType != MsgBloomBitsProofs {
+		glog.V(logger.Debug).Infof("ODR: invalid message type")
+		return false
+	}
+	proofs := msg.Obj.([][]rlp.RawValue)
+	if len(proofs) != len(self.BitIdxs) {
+		glog.V(logger.Debug).Infof("ODR: invalid number of entries: %d", len(proofs))
+		return false
+	}
+	bits := make([][]byte, len(self.BitIdxs))
+	for i, bit := range self.BitIdxs {
+		value, err := trie.VerifyProof(self.BloomTrieRoot, light.BloomTrieKey(bit, self.SectionIdx), proofs[i])
+		if err != nil {
+			glog.V(logger.Debug).Infof("ODR: bloom trie merkle proof verification error: %v", err)
+			return false
+		}
+		bits[i] = value
+	}
+	self.BloomBits = bits
+	self.Proofs = proofs
+	glog.V(logger.Debug).Infof("ODR: validation successful")
+	return true
+}
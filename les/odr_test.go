@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/bitutil"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -201,3 +202,84 @@ func testOdr(t *testing.T, protocol int, expFail uint64, fn odrTestFn) {
 	// still expect all retrievals to pass, now data should be cached locally
 	test(5)
 }
+
+// Tests that bloom bit vectors indexed by a server can be retrieved by a light
+// client, verified against the trusted bloom trie and cached locally.
+func TestOdrBloomBitsLes2(t *testing.T) {
+	// Assemble the test environment
+	pm, db, _ := newTestProtocolManagerMust(t, false, 4, testChainGen)
+	lpm, ldb, odr := newTestProtocolManagerMust(t, true, 0, nil)
+	_, err1, lpeer, err2 := newTestPeerPair("peer", 2, pm, lpm)
+	pool := &testServerPool{}
+	pool.setPeer(lpeer)
+	odr.serverPool = pool
+	select {
+	case <-time.After(time.Millisecond * 100):
+	case err := <-err1:
+		t.Fatalf("peer 1 handshake error: %v", err)
+	case err := <-err2:
+		t.Fatalf("peer 1 handshake error: %v", err)
+	}
+	// Index a fake bloom section on the server, leaving bit 2 all zero
+	head := common.HexToHash("0xdeadbeef")
+	want := make([][]byte, 3)
+	for bit := uint(0); bit < types.BloomBitLength; bit++ {
+		vector := make([]byte, params.BloomBitsBlocks/8)
+		if bit != 2 {
+			vector[bit%uint(len(vector))] = byte(bit)
+			vector[0] |= 0x80
+		}
+		if bit < 3 {
+			want[bit] = vector
+		}
+		core.WriteBloomBits(db, bit, 0, head, bitutil.CompressBytes(vector))
+	}
+	core.WriteBloomSectionHead(db, 0, head)
+	core.WriteBloomSections(db, 1)
+
+	// Pretend the section is confirmed and build the bloom trie from it
+	confirmed := &types.Header{Number: new(big.Int).SetUint64(light.ChtConfirmations + light.ChtFrequency)}
+	core.WriteHeader(db, confirmed)
+	core.WriteHeadBlockHash(db, confirmed.Hash())
+	if makeBloomTrie(db) {
+		t.Fatalf("more bloom trie sections reported")
+	}
+	root := getBloomTrieRoot(db, 1)
+	if root == (common.Hash{}) {
+		t.Fatalf("bloom trie not created")
+	}
+	lpeer.lock.Lock()
+	lpeer.headInfo.Number = confirmed.Number.Uint64()
+	lpeer.lock.Unlock()
+
+	// Retrieve the vectors with and without a trusted trie and from the cache
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := light.GetBloomBits(ctx, odr, []uint{0, 1, 2}, 0); err != light.ErrNoTrustedBloomTrie {
+		t.Fatalf("untrusted retrieval error mismatch: have %v, want %v", err, light.ErrNoTrustedBloomTrie)
+	}
+	light.WriteTrustedBloomTrie(ldb, light.TrustedBloomTrie{Number: 1, Root: root})
+	for i := 0; i < 2; i++ {
+		have, err := light.GetBloomBits(ctx, odr, []uint{0, 1, 2}, 0)
+		if err != nil {
+			t.Fatalf("attempt %d: failed to retrieve bloom bits: %v", i, err)
+		}
+		for bit := range want {
+			if !bytes.Equal(have[bit], want[bit]) {
+				t.Errorf("attempt %d: bloom bit %d mismatch: have %x, want %x", i, bit, have[bit], want[bit])
+			}
+		}
+		// Remove the peer, the second attempt should be served from the cache
+		pool.setPeer(nil)
+	}
+	// Check that vectors not matching the trusted root are rejected
+	light.WriteTrustedBloomTrie(ldb, light.TrustedBloomTrie{Number: 1, Root: common.HexToHash("0x01")})
+	pool.setPeer(lpeer)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := light.GetBloomBits(ctx, odr, []uint{0}, 0); err == nil {
+		t.Fatalf("bloom bits of untrusted trie accepted")
+	}
+}
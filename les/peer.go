@@ -22,8 +22,10 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/les/flowcontrol"
@@ -63,6 +65,9 @@ type peer struct {
 	fcServer       *flowcontrol.ServerNode // nil if the peer is client only
 	fcServerParams *flowcontrol.ServerParams
 	fcCosts        requestCostTable
+
+	checkpointReq  uint64      // Request ID of the trusted checkpoint challenge (zero = none pending)
+	checkpointDrop *time.Timer // Timed connection dropper if the checkpoint challenge isn't answered
 }
 
 func newPeer(version, network int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
@@ -193,6 +198,12 @@ func (p *peer) SendHeaderProofs(reqID, bv uint64, proofs []ChtResp) error {
 	return sendResponse(p.rw, HeaderProofsMsg, reqID, bv, proofs)
 }
 
+// SendBloomBitsProofs sends a batch of bloom trie proofs, corresponding to the
+// ones requested.
+func (p *peer) SendBloomBitsProofs(reqID, bv uint64, proofs [][]rlp.RawValue) error {
+	return sendResponse(p.rw, BloomBitsProofsMsg, reqID, bv, proofs)
+}
+
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
 func (p *peer) RequestHeadersByHash(reqID, cost uint64, origin common.Hash, amount int, skip int, reverse bool) error {
@@ -239,6 +250,13 @@ func (p *peer) RequestHeaderProofs(reqID, cost uint64, reqs []*ChtReq) error {
 	return sendRequest(p.rw, GetHeaderProofsMsg, reqID, cost, reqs)
 }
 
+// RequestBloomBitsProofs fetches a batch of bloom trie merkle proofs from a
+// remote node.
+func (p *peer) RequestBloomBitsProofs(reqID, cost uint64, reqs []*BloomReq) error {
+	glog.V(logger.Debug).Infof("%v fetching %v bloom bit proofs", p, len(reqs))
+	return sendRequest(p.rw, GetBloomBitsProofsMsg, reqID, cost, reqs)
+}
+
 func (p *peer) SendTxs(cost uint64, txs types.Transactions) error {
 	glog.V(logger.Debug).Infof("%v relaying %v txs", p, len(txs))
 	reqID := getNextReqID()
@@ -329,7 +347,7 @@ func (p *peer) Handshake(td *big.Int, head common.Hash, headNum uint64, genesis
 	send = send.add("genesisHash", genesis)
 	if server != nil {
 		send = send.add("serveHeaders", nil)
-		send = send.add("serveChainSince", uint64(0))
+		send = send.add("serveChainSince", core.GetPruneTail(server.protocolManager.chainDb))
 		send = send.add("serveStateSince", uint64(0))
 		send = send.add("txRelay", nil)
 		send = send.add("flowControl/BL", server.defParams.BufLimit)
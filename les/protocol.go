@@ -30,13 +30,14 @@ import (
 // Constants to match up protocol versions and messages
 const (
 	lpv1 = 1
+	lpv2 = 2
 )
 
 // Supported versions of the les protocol (first is primary).
-var ProtocolVersions = []uint{lpv1}
+var ProtocolVersions = []uint{lpv2, lpv1}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{15}
+var ProtocolLengths = []uint64{17, 15}
 
 const (
 	NetworkId          = 1
@@ -61,6 +62,9 @@ const (
 	SendTxMsg          = 0x0c
 	GetHeaderProofsMsg = 0x0d
 	HeaderProofsMsg    = 0x0e
+	// Protocol messages belonging to LPV2
+	GetBloomBitsProofsMsg = 0x0f
+	BloomBitsProofsMsg    = 0x10
 )
 
 type errCode int
@@ -81,6 +85,7 @@ const (
 	ErrInvalidResponse
 	ErrTooManyTimeouts
 	ErrHandshakeMissingKey
+	ErrCheckpointMismatch
 )
 
 func (e errCode) String() string {
@@ -103,6 +108,7 @@ var errorToString = map[int]string{
 	ErrInvalidResponse:         "Invalid response",
 	ErrTooManyTimeouts:         "Too many request timeouts",
 	ErrHandshakeMissingKey:     "Key missing from handshake message",
+	ErrCheckpointMismatch:      "Trusted checkpoint mismatch",
 }
 
 type chainManager interface {
@@ -332,9 +332,10 @@ func (pm *ProtocolManager) blockLoop() {
 			case <-newCht:
 				go func() {
 					mu.Lock()
-					more := makeCht(pm.chainDb)
+					more := light.UpdateCht(pm.chainDb)
+					moreBlooms := makeBloomTrie(pm.chainDb)
 					mu.Unlock()
-					if more {
+					if more || moreBlooms {
 						time.Sleep(time.Millisecond * 10)
 						newCht <- struct{}{}
 					}
@@ -349,84 +350,86 @@ func (pm *ProtocolManager) blockLoop() {
 }
 
 var (
-	lastChtKey = []byte("LastChtNumber") // chtNum (uint64 big endian)
-	chtPrefix  = []byte("cht")           // chtPrefix + chtNum (uint64 big endian) -> trie root hash
+	lastBloomTrieKey = []byte("LastBloomTrieNumber") // bloomTrieNum (uint64 big endian)
+	bloomTriePrefix  = []byte("bltRoot")             // bloomTriePrefix + bloomTrieNum (uint64 big endian) -> trie root hash
 )
 
-func getChtRoot(db ethdb.Database, num uint64) common.Hash {
+func getBloomTrieRoot(db ethdb.Database, num uint64) common.Hash {
 	var encNumber [8]byte
 	binary.BigEndian.PutUint64(encNumber[:], num)
-	data, _ := db.Get(append(chtPrefix, encNumber[:]...))
+	data, _ := db.Get(append(bloomTriePrefix, encNumber[:]...))
 	return common.BytesToHash(data)
 }
 
-func storeChtRoot(db ethdb.Database, num uint64, root common.Hash) {
+func storeBloomTrieRoot(db ethdb.Database, num uint64, root common.Hash) {
 	var encNumber [8]byte
 	binary.BigEndian.PutUint64(encNumber[:], num)
-	db.Put(append(chtPrefix, encNumber[:]...), root[:])
+	db.Put(append(bloomTriePrefix, encNumber[:]...), root[:])
 }
 
-func makeCht(db ethdb.Database) bool {
+// makeBloomTrie adds the next section of the bloom bit index to the bloom trie,
+// which light clients verify the served bit vectors against. Like the CHT, trie
+// number N covers the first N sections, and a section is only added once it has
+// enough confirmations to be considered final. It returns whether further
+// sections are waiting to be added.
+func makeBloomTrie(db ethdb.Database) bool {
 	headHash := core.GetHeadBlockHash(db)
 	headNum := core.GetBlockNumber(db, headHash)
 
-	var newChtNum uint64
+	var newTrieNum uint64
 	if headNum > light.ChtConfirmations {
-		newChtNum = (headNum - light.ChtConfirmations) / light.ChtFrequency
+		newTrieNum = (headNum - light.ChtConfirmations) / light.ChtFrequency
 	}
-
-	var lastChtNum uint64
-	data, _ := db.Get(lastChtKey)
+	// Only sections already in the bloom bit index can be added
+	if sections := core.GetBloomSections(db); newTrieNum > sections {
+		newTrieNum = sections
+	}
+	var lastTrieNum uint64
+	data, _ := db.Get(lastBloomTrieKey)
 	if len(data) == 8 {
-		lastChtNum = binary.BigEndian.Uint64(data[:])
+		lastTrieNum = binary.BigEndian.Uint64(data[:])
 	}
-	if newChtNum <= lastChtNum {
+	if newTrieNum <= lastTrieNum {
 		return false
 	}
 
 	var t *trie.Trie
-	if lastChtNum > 0 {
+	if lastTrieNum > 0 {
 		var err error
-		t, err = trie.New(getChtRoot(db, lastChtNum), db)
+		t, err = trie.New(getBloomTrieRoot(db, lastTrieNum), db)
 		if err != nil {
-			lastChtNum = 0
+			lastTrieNum = 0
 		}
 	}
-	if lastChtNum == 0 {
+	if lastTrieNum == 0 {
 		t, _ = trie.New(common.Hash{}, db)
 	}
 
-	for num := lastChtNum * light.ChtFrequency; num < (lastChtNum+1)*light.ChtFrequency; num++ {
-		hash := core.GetCanonicalHash(db, num)
-		if hash == (common.Hash{}) {
-			panic("Canonical hash not found")
-		}
-		td := core.GetTd(db, hash, num)
-		if td == nil {
-			panic("TD not found")
+	section := lastTrieNum
+	head := core.GetBloomSectionHead(db, section)
+	for bit := uint(0); bit < types.BloomBitLength; bit++ {
+		bits, err := core.GetBloomBits(db, bit, section, head)
+		if err != nil {
+			glog.V(logger.Debug).Infof("bloom trie: bit %d of section %d missing: %v", bit, section, err)
+			return false
 		}
-		var encNumber [8]byte
-		binary.BigEndian.PutUint64(encNumber[:], num)
-		var node light.ChtNode
-		node.Hash = hash
-		node.Td = td
-		data, _ := rlp.EncodeToBytes(node)
-		t.Update(encNumber[:], data)
+		// All-zero vectors compress to empty data and are left out of the trie
+		t.Update(light.BloomTrieKey(bit, section), bits)
 	}
 
 	root, err := t.Commit()
 	if err != nil {
-		lastChtNum = 0
+		lastTrieNum = 0
 	} else {
-		lastChtNum++
+		lastTrieNum++
 
-		glog.V(logger.Detail).Infof("cht: %d %064x", lastChtNum, root)
+		glog.V(logger.Detail).Infof("bloom trie: %d %064x", lastTrieNum, root)
 
-		storeChtRoot(db, lastChtNum, root)
+		storeBloomTrieRoot(db, lastTrieNum, root)
 		var data [8]byte
-		binary.BigEndian.PutUint64(data[:], lastChtNum)
-		db.Put(lastChtKey, data[:])
+		binary.BigEndian.PutUint64(data[:], lastTrieNum)
+		db.Put(lastBloomTrieKey, data[:])
 	}
 
-	return newChtNum > lastChtNum
+	return newTrieNum > lastTrieNum
 }
@@ -22,6 +22,8 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/light"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
 	"golang.org/x/net/context"
 )
 
@@ -77,8 +79,18 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 		return
 	}
 
+	// Skip ahead to the trusted checkpoint and enforce it on the servers. Rather
+	// than falling back to downloading every header from genesis if its proof
+	// can't be retrieved, wait for the next sync attempt.
 	ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
-	pm.blockchain.(*light.LightChain).SyncCht(ctx)
+	head, err := pm.blockchain.(*light.LightChain).SyncCht(ctx)
+	if err != nil {
+		glog.V(logger.Debug).Infof("Failed to skip ahead to the trusted checkpoint: %v", err)
+		return
+	}
+	if head != nil {
+		pm.setCheckpoint(head.Number.Uint64(), head.Hash())
+	}
 
 	pm.downloader.Synchronise(peer.id, peer.Head(), peer.Td(), downloader.LightSync)
 }
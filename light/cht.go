@@ -0,0 +1,125 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	lastChtKey = []byte("LastChtNumber") // chtNum (uint64 big endian)
+	chtPrefix  = []byte("cht")           // chtPrefix + chtNum (uint64 big endian) -> trie root hash
+
+	chtLock sync.Mutex // Serializes concurrent CHT generation runs
+)
+
+// GetChtRoot retrieves the root of the locally generated canonical hash trie
+// covering the first num sections of the chain.
+func GetChtRoot(db ethdb.Database, num uint64) common.Hash {
+	var encNumber [8]byte
+	binary.BigEndian.PutUint64(encNumber[:], num)
+	data, _ := db.Get(append(chtPrefix, encNumber[:]...))
+	return common.BytesToHash(data)
+}
+
+// StoreChtRoot stores the root of the locally generated canonical hash trie
+// covering the first num sections of the chain.
+func StoreChtRoot(db ethdb.Database, num uint64, root common.Hash) {
+	var encNumber [8]byte
+	binary.BigEndian.PutUint64(encNumber[:], num)
+	db.Put(append(chtPrefix, encNumber[:]...), root[:])
+}
+
+// UpdateCht extends the local canonical hash trie with the next confirmed
+// section of the canonical chain, returning whether there are more sections
+// left to generate.
+func UpdateCht(db ethdb.Database) bool {
+	chtLock.Lock()
+	defer chtLock.Unlock()
+
+	headHash := core.GetHeadBlockHash(db)
+	headNum := core.GetBlockNumber(db, headHash)
+
+	var newChtNum uint64
+	if headNum > ChtConfirmations {
+		newChtNum = (headNum - ChtConfirmations) / ChtFrequency
+	}
+
+	var lastChtNum uint64
+	data, _ := db.Get(lastChtKey)
+	if len(data) == 8 {
+		lastChtNum = binary.BigEndian.Uint64(data[:])
+	}
+	if newChtNum <= lastChtNum {
+		return false
+	}
+
+	var t *trie.Trie
+	if lastChtNum > 0 {
+		var err error
+		t, err = trie.New(GetChtRoot(db, lastChtNum), db)
+		if err != nil {
+			lastChtNum = 0
+		}
+	}
+	if lastChtNum == 0 {
+		t, _ = trie.New(common.Hash{}, db)
+	}
+
+	for num := lastChtNum * ChtFrequency; num < (lastChtNum+1)*ChtFrequency; num++ {
+		hash := core.GetCanonicalHash(db, num)
+		if hash == (common.Hash{}) {
+			panic("Canonical hash not found")
+		}
+		td := core.GetTd(db, hash, num)
+		if td == nil {
+			panic("TD not found")
+		}
+		var encNumber [8]byte
+		binary.BigEndian.PutUint64(encNumber[:], num)
+		var node ChtNode
+		node.Hash = hash
+		node.Td = td
+		data, _ := rlp.EncodeToBytes(node)
+		t.Update(encNumber[:], data)
+	}
+
+	root, err := t.Commit()
+	if err != nil {
+		lastChtNum = 0
+	} else {
+		lastChtNum++
+
+		glog.V(logger.Detail).Infof("cht: %d %064x", lastChtNum, root)
+
+		StoreChtRoot(db, lastChtNum, root)
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], lastChtNum)
+		db.Put(lastChtKey, data[:])
+	}
+
+	return newChtNum > lastChtNum
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that the local canonical hash trie is only extended with a section once
+// the chain head confirms it.
+func TestUpdateCht(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	genesis := core.WriteGenesisBlockForTesting(db)
+
+	// Store a canonical chain just long enough to confirm the first CHT section
+	headers := makeHeaderChain(genesis.Header(), int(ChtFrequency+ChtConfirmations), db, canonicalSeed)
+
+	td := new(big.Int).Set(genesis.Difficulty())
+	for _, header := range headers {
+		td.Add(td, header.Difficulty)
+		core.WriteHeader(db, header)
+		core.WriteTd(db, header.Hash(), header.Number.Uint64(), td)
+		core.WriteCanonicalHash(db, header.Hash(), header.Number.Uint64())
+	}
+	// Until the chain head confirms the section, the CHT can't be generated
+	core.WriteHeadBlockHash(db, headers[len(headers)-2].Hash())
+	if UpdateCht(db) {
+		t.Fatalf("more CHT sections reported before confirmation")
+	}
+	if root := GetChtRoot(db, 1); root != (common.Hash{}) {
+		t.Fatalf("unconfirmed CHT section generated: %x", root)
+	}
+	// Confirm the section and ensure it's generated
+	core.WriteHeadBlockHash(db, headers[len(headers)-1].Hash())
+	if UpdateCht(db) {
+		t.Fatalf("more CHT sections reported than available")
+	}
+	if root := GetChtRoot(db, 1); root == (common.Hash{}) {
+		t.Fatalf("CHT section not generated")
+	}
+}
@@ -65,8 +65,9 @@ type LightChain struct {
 	procInterrupt int32 // interrupt signaler for block processing
 	wg            sync.WaitGroup
 
-	pow       pow.PoW
-	validator core.HeaderValidator
+	pow        pow.PoW
+	validator  core.HeaderValidator
+	checkpoint *params.TrustedCheckpoint // Trusted checkpoint to skip the header sync ahead to
 }
 
 // NewLightChain returns a fully initialised light chain using information
@@ -104,33 +105,13 @@ func NewLightChain(odr OdrBackend, config *params.ChainConfig, pow pow.PoW, mux
 		glog.V(logger.Info).Infoln("WARNING: Wrote default ethereum genesis block")
 	}
 
-	if bc.genesisBlock.Hash() == (common.Hash{212, 229, 103, 64, 248, 118, 174, 248, 192, 16, 184, 106, 64, 213, 245, 103, 69, 161, 24, 208, 144, 106, 52, 230, 154, 236, 140, 13, 177, 203, 143, 163}) {
-		// add trusted CHT
-		if config.DAOForkSupport {
-			WriteTrustedCht(bc.chainDb, TrustedCht{
-				Number: 637,
-				Root:   common.HexToHash("01e408d9b1942f05dba1a879f3eaafe34d219edaeb8223fecf1244cc023d3e23"),
-			})
-		} else {
-			WriteTrustedCht(bc.chainDb, TrustedCht{
-				Number: 523,
-				Root:   common.HexToHash("c035076523faf514038f619715de404a65398c51899b5dccca9c05b00bc79315"),
-			})
-		}
-		glog.V(logger.Info).Infoln("Added trusted CHT for mainnet")
+	// Add the built-in trusted checkpoint of the network, if any
+	if checkpoint := params.TrustedCheckpointFor(bc.genesisBlock.Hash(), config); checkpoint != nil {
+		bc.AddTrustedCheckpoint(checkpoint)
 	} else {
-		if bc.genesisBlock.Hash() == (common.Hash{12, 215, 134, 162, 66, 93, 22, 241, 82, 198, 88, 49, 108, 66, 62, 108, 225, 24, 30, 21, 195, 41, 88, 38, 215, 201, 144, 76, 186, 156, 227, 3}) {
-			// add trusted CHT for testnet
-			WriteTrustedCht(bc.chainDb, TrustedCht{
-				Number: 452,
-				Root:   common.HexToHash("511da2c88e32b14cf4a4e62f7fcbb297139faebc260a4ab5eb43cce6edcba324"),
-			})
-			glog.V(logger.Info).Infoln("Added trusted CHT for testnet")
-		} else {
-			DeleteTrustedCht(bc.chainDb)
-		}
+		DeleteTrustedCht(bc.chainDb)
+		DeleteTrustedBloomTrie(bc.chainDb)
 	}
-
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
@@ -488,22 +469,57 @@ func (self *LightChain) GetHeaderByNumberOdr(ctx context.Context, number uint64)
 	return GetHeaderByNumber(ctx, self.odr, number)
 }
 
-func (self *LightChain) SyncCht(ctx context.Context) bool {
-	headNum := self.CurrentHeader().Number.Uint64()
+// AddTrustedCheckpoint sets the trusted checkpoint from which the light chain
+// may start syncing, skipping the download of all the headers preceding it.
+func (self *LightChain) AddTrustedCheckpoint(checkpoint *params.TrustedCheckpoint) {
+	self.mu.Lock()
+	self.checkpoint = checkpoint
+	self.mu.Unlock()
+
+	WriteTrustedCht(self.chainDb, TrustedCht{
+		Number: checkpoint.SectionIndex + 1,
+		Root:   checkpoint.CHTRoot,
+	})
+	if checkpoint.BloomRoot != (common.Hash{}) {
+		WriteTrustedBloomTrie(self.chainDb, TrustedBloomTrie{
+			Number: checkpoint.SectionIndex + 1,
+			Root:   checkpoint.BloomRoot,
+		})
+	} else {
+		DeleteTrustedBloomTrie(self.chainDb)
+	}
+	glog.V(logger.Info).Infof("Added trusted checkpoint: section #%d, head [%x…], CHT [%x…]", checkpoint.SectionIndex, checkpoint.SectionHead[:4], checkpoint.CHTRoot[:4])
+}
+
+// SyncCht skips the header chain ahead to the last block covered by the trusted
+// canonical hash trie, retrieving it through a CHT proof, and returns it so that
+// it can be enforced on remote peers. If the checkpoint also specifies a section
+// head hash, the retrieved header must match it. If there's no trusted CHT, nil
+// is returned.
+func (self *LightChain) SyncCht(ctx context.Context) (*types.Header, error) {
 	cht := GetTrustedCht(self.chainDb)
-	if headNum+1 < cht.Number*ChtFrequency {
-		num := cht.Number*ChtFrequency - 1
-		header, err := GetHeaderByNumber(ctx, self.odr, num)
-		if header != nil && err == nil {
-			self.mu.Lock()
-			if self.hc.CurrentHeader().Number.Uint64() < header.Number.Uint64() {
-				self.hc.SetCurrentHeader(header)
-			}
-			self.mu.Unlock()
-			return true
-		}
+	if cht.Number == 0 {
+		return nil, nil
+	}
+	num := cht.Number*ChtFrequency - 1
+	header, err := GetHeaderByNumber(ctx, self.odr, num)
+	if err != nil {
+		return nil, err
+	}
+	self.mu.RLock()
+	checkpoint := self.checkpoint
+	self.mu.RUnlock()
+
+	if checkpoint != nil && checkpoint.SectionHead != (common.Hash{}) && header.Hash() != checkpoint.SectionHead {
+		glog.V(logger.Warn).Infof("Checkpoint header mismatch: have #%d [%x…], want [%x…]", num, header.Hash().Bytes()[:4], checkpoint.SectionHead[:4])
+		return nil, ErrCheckpointMismatch
+	}
+	self.mu.Lock()
+	if self.hc.CurrentHeader().Number.Uint64() < num {
+		self.hc.SetCurrentHeader(header)
 	}
-	return false
+	self.mu.Unlock()
+	return header, nil
 }
 
 // LockChain locks the chain mutex for reading so that multiple canonical hashes can be
@@ -22,12 +22,12 @@ import (
 	"runtime"
 	"testing"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/internal/chaintest"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/pow"
 	"github.com/hashicorp/golang-lru"
@@ -83,8 +83,7 @@ func init() {
 }
 
 func thePow() pow.PoW {
-	pow, _ := ethash.NewForTesting()
-	return pow
+	return pow.NewTestEthash()
 }
 
 func theLightChain(db ethdb.Database, t *testing.T) *LightChain {
@@ -402,3 +401,48 @@ func TestReorgBadHeaderHashes(t *testing.T) {
 		t.Errorf("last header hash mismatch: have: %x, want %x", ncm.CurrentHeader().Hash(), headers[2].Hash())
 	}
 }
+
+// harnessChain adapts the light chain to the fork choice test harness.
+type harnessChain struct {
+	*LightChain
+}
+
+func (c *harnessChain) InsertBlocks(blocks []*types.Block) error {
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	_, err := c.InsertHeaderChain(headers, 1)
+	return err
+}
+
+func (c *harnessChain) CurrentHash() common.Hash {
+	return c.CurrentHeader().Hash()
+}
+
+func (c *harnessChain) GetReceipts(block *types.Block) types.Receipts {
+	receipts, _ := GetBlockReceipts(context.Background(), c.Odr(), block.Hash(), block.NumberU64())
+	return receipts
+}
+
+// Tests the fork choice of the light chain against the declarative scenarios,
+// retrieving the receipts of the canonical blocks from a server holding all
+// the blocks of the tree.
+func TestLightChainHarness(t *testing.T) {
+	chaintest.Run(t, func(db ethdb.Database, mux *event.TypeMux, tree *core.BlockTree) (chaintest.Chain, error) {
+		sdb, _ := ethdb.NewMemDatabase()
+		for name, block := range tree.Blocks {
+			if err := core.WriteBlock(sdb, block); err != nil {
+				return nil, err
+			}
+			if err := core.WriteBlockReceipts(sdb, block.Hash(), block.NumberU64(), tree.Receipts[name]); err != nil {
+				return nil, err
+			}
+		}
+		lightchain, err := NewLightChain(&testOdr{sdb: sdb, ldb: db}, testChainConfig(), core.FakePow{}, mux)
+		if err != nil {
+			return nil, err
+		}
+		return &harnessChain{lightchain}, nil
+	}, false)
+}
@@ -148,6 +148,26 @@ type ChtRequest struct {
 	Proof            []rlp.RawValue
 }
 
+// BloomRequest is the ODR request type for retrieving the compressed bloom bit
+// vectors of a section, verified against a trusted bloom trie
+type BloomRequest struct {
+	OdrRequest
+	BloomTrieNum  uint64
+	BloomTrieRoot common.Hash
+	BitIdxs       []uint
+	SectionIdx    uint64
+	BloomBits     [][]byte
+	Proofs        [][]rlp.RawValue
+}
+
+// StoreResult stores the retrieved data in local database, keyed by the root of
+// the bloom trie they were verified with
+func (req *BloomRequest) StoreResult(db ethdb.Database) {
+	for i, bit := range req.BitIdxs {
+		core.WriteBloomBits(db, bit, req.SectionIdx, req.BloomTrieRoot, req.BloomBits[i])
+	}
+}
+
 // StoreResult stores the retrieved data in local database
 func (req *ChtRequest) StoreResult(db ethdb.Database) {
 	// if there is a canonical hash, there is a header too
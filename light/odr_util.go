@@ -18,16 +18,19 @@ package light
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/bitutil"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"golang.org/x/net/context"
 )
@@ -35,14 +38,21 @@ import (
 var sha3_nil = crypto.Keccak256Hash(nil)
 
 var (
-	ErrNoTrustedCht = errors.New("No trusted canonical hash trie")
-	ErrNoHeader     = errors.New("Header not found")
+	ErrNoTrustedCht       = errors.New("No trusted canonical hash trie")
+	ErrNoTrustedBloomTrie = errors.New("No trusted bloom trie")
+	ErrNoHeader           = errors.New("Header not found")
+	ErrCheckpointMismatch = errors.New("Trusted checkpoint mismatch")
 
-	ChtFrequency     = uint64(4096)
-	ChtConfirmations = uint64(2048)
-	trustedChtKey    = []byte("TrustedCHT")
+	ChtFrequency        = uint64(params.CHTFrequency)
+	ChtConfirmations    = uint64(2048)
+	trustedChtKey       = []byte("TrustedCHT")
+	trustedBloomTrieKey = []byte("TrustedBloomTrie")
 )
 
+// BloomBitsFetchLimit is the maximum number of bloom bit vectors retrieved in a
+// single request.
+const BloomBitsFetchLimit = 64
+
 type ChtNode struct {
 	Hash common.Hash
 	Td   *big.Int
@@ -71,6 +81,91 @@ func DeleteTrustedCht(db ethdb.Database) {
 	db.Delete(trustedChtKey)
 }
 
+// TrustedBloomTrie is the root of the trie containing the compressed bloom bit
+// vectors of the sections preceding Number, keyed by BloomTrieKey.
+type TrustedBloomTrie struct {
+	Number uint64
+	Root   common.Hash
+}
+
+func GetTrustedBloomTrie(db ethdb.Database) TrustedBloomTrie {
+	data, _ := db.Get(trustedBloomTrieKey)
+	var res TrustedBloomTrie
+	if err := rlp.DecodeBytes(data, &res); err != nil {
+		return TrustedBloomTrie{0, common.Hash{}}
+	}
+	return res
+}
+
+func WriteTrustedBloomTrie(db ethdb.Database, trie TrustedBloomTrie) {
+	data, _ := rlp.EncodeToBytes(trie)
+	db.Put(trustedBloomTrieKey, data)
+}
+
+func DeleteTrustedBloomTrie(db ethdb.Database) {
+	db.Delete(trustedBloomTrieKey)
+}
+
+// BloomTrieKey returns the key of a section's bloom bit vector in the bloom trie.
+func BloomTrieKey(bit uint, section uint64) []byte {
+	var key [10]byte
+	binary.BigEndian.PutUint16(key[0:2], uint16(bit))
+	binary.BigEndian.PutUint64(key[2:], section)
+	return key[:]
+}
+
+// GetBloomBits retrieves the decompressed bit vectors of the given bloom bits in
+// a section covered by the trusted bloom trie, from the local database if they
+// were already retrieved, or from the network otherwise.
+func GetBloomBits(ctx context.Context, odr OdrBackend, bits []uint, section uint64) ([][]byte, error) {
+	db := odr.Database()
+	trie := GetTrustedBloomTrie(db)
+	if section >= trie.Number {
+		return nil, ErrNoTrustedBloomTrie
+	}
+	// Gather the vectors already available and the ones to be retrieved
+	var (
+		compressed = make([][]byte, len(bits))
+		missing    []int
+	)
+	for i, bit := range bits {
+		// Retrieved vectors are stored keyed by the trie root they were verified with
+		if data, err := core.GetBloomBits(db, bit, section, trie.Root); err == nil {
+			compressed[i] = data
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > BloomBitsFetchLimit {
+			batch = batch[:BloomBitsFetchLimit]
+		}
+		missing = missing[len(batch):]
+
+		r := &BloomRequest{BloomTrieRoot: trie.Root, BloomTrieNum: trie.Number, SectionIdx: section}
+		for _, i := range batch {
+			r.BitIdxs = append(r.BitIdxs, bits[i])
+		}
+		if err := odr.Retrieve(ctx, r); err != nil {
+			return nil, err
+		}
+		for j, i := range batch {
+			compressed[i] = r.BloomBits[j]
+		}
+	}
+	// Decompress all the vectors
+	vectors := make([][]byte, len(bits))
+	for i, data := range compressed {
+		vector, err := bitutil.DecompressBytes(data, int(params.BloomBitsBlocks/8))
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
 func GetHeaderByNumber(ctx context.Context, odr OdrBackend, number uint64) (*types.Header, error) {
 	db := odr.Database()
 	hash := core.GetCanonicalHash(db, number)
@@ -123,7 +123,6 @@ var severityName = []string{
 // matching vmodule filters.
 var trimPrefixes = []string{
 	"/github.com/ethereum/go-ethereum",
-	"/github.com/ethereum/ethash",
 }
 
 func trimToImportPath(file string) string {
@@ -0,0 +1,142 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// DevAgent is a mining agent for developer networks that seals blocks instantly,
+// without doing any proof-of-work. Blocks are either sealed as soon as they
+// contain transactions, or on a fixed period regardless of their contents.
+//
+// The sealed blocks only pass validation with a fake proof-of-work engine.
+type DevAgent struct {
+	period time.Duration // Block period to seal on (0 = seal when transactions are pending)
+
+	workCh   chan *Work
+	quitCh   chan struct{}
+	rejectCh chan common.Hash // Parents of sealed blocks that failed to import
+	returnCh chan<- *Result
+
+	running int32 // running indicates whether the agent is active. Call atomically
+}
+
+// NewDevAgent creates a developer mode sealing agent.
+func NewDevAgent(period time.Duration) *DevAgent {
+	return &DevAgent{
+		period:   period,
+		rejectCh: make(chan common.Hash, 1),
+	}
+}
+
+func (self *DevAgent) Work() chan<- *Work            { return self.workCh }
+func (self *DevAgent) SetReturnCh(ch chan<- *Result) { self.returnCh = ch }
+func (self *DevAgent) GetHashRate() int64            { return 0 }
+
+func (self *DevAgent) Start() {
+	if !atomic.CompareAndSwapInt32(&self.running, 0, 1) {
+		return
+	}
+	self.quitCh = make(chan struct{})
+	self.workCh = make(chan *Work, 1)
+	go self.loop(self.workCh, self.quitCh)
+}
+
+// rejected notifies the agent that a block it sealed failed to import, so new
+// work on the same parent may be sealed again.
+func (self *DevAgent) rejected(block *types.Block) {
+	select {
+	case self.rejectCh <- block.ParentHash():
+	default:
+	}
+}
+
+func (self *DevAgent) Stop() {
+	if !atomic.CompareAndSwapInt32(&self.running, 1, 0) {
+		return
+	}
+	close(self.quitCh)
+	close(self.workCh)
+}
+
+// loop waits for new work and seals it whenever the configured condition holds.
+//
+// Note, the work and quit channels are passed as parameters for the same reason
+// as in RemoteAgent: Start recreates them, so the loop cannot rely on the fields.
+func (self *DevAgent) loop(workCh chan *Work, quitCh chan struct{}) {
+	var (
+		current *Work
+		sealed  common.Hash // Parent of the last sealed block, to avoid sealing siblings
+		tick    <-chan time.Time
+	)
+	if self.period > 0 {
+		ticker := time.NewTicker(self.period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	seal := func() {
+		work := current
+		block := work.Block.WithMiningResult(types.BlockNonce{}, common.Hash{})
+		glog.V(logger.Info).Infof("Dev mode sealed block #%d with %d txs", block.NumberU64(), len(block.Transactions()))
+
+		sealed, current = block.ParentHash(), nil
+		self.returnCh <- &Result{work, block}
+	}
+	// sealable reports whether the current work may be sealed, i.e. it doesn't
+	// build on the parent of the last sealed block.
+	sealable := func() bool {
+		return current != nil && current.Block.ParentHash() != sealed
+	}
+	for {
+		select {
+		case work, ok := <-workCh:
+			if !ok {
+				return
+			}
+			// Work for a new head means the chain moved, any parent may be used again
+			current = work
+			if work.Block.ParentHash() != sealed {
+				sealed = common.Hash{}
+			}
+			if self.period == 0 && sealable() && len(work.Block.Transactions()) > 0 {
+				seal()
+			}
+		case parent := <-self.rejectCh:
+			// The last sealed block didn't make it into the chain, unblock its parent
+			if parent != sealed {
+				continue
+			}
+			sealed = common.Hash{}
+			if self.period == 0 && sealable() && len(current.Block.Transactions()) > 0 {
+				seal()
+			}
+		case <-tick:
+			if sealable() {
+				seal()
+			}
+		case <-quitCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// newDevTestWork creates a mining work on top of parent with the given number
+// of (dummy) transactions.
+func newDevTestWork(parent common.Hash, txs int) *Work {
+	header := &types.Header{ParentHash: parent, Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+
+	var transactions []*types.Transaction
+	for i := 0; i < txs; i++ {
+		transactions = append(transactions, types.NewTransaction(uint64(i), common.Address{}, new(big.Int), new(big.Int), new(big.Int), nil))
+	}
+	return &Work{Block: types.NewBlock(header, transactions, nil, nil)}
+}
+
+// Tests that the instant dev agent seals blocks only if they contain transactions
+// and never seals two blocks on the same parent.
+func TestDevAgentInstant(t *testing.T) {
+	results := make(chan *Result, 3)
+
+	agent := NewDevAgent(0)
+	agent.SetReturnCh(results)
+	agent.Start()
+	defer agent.Stop()
+
+	agent.Work() <- newDevTestWork(common.Hash{1}, 0)
+	agent.Work() <- newDevTestWork(common.Hash{1}, 1)
+	agent.Work() <- newDevTestWork(common.Hash{1}, 2)
+	agent.Work() <- newDevTestWork(common.Hash{2}, 3)
+
+	for i, want := range []int{1, 3} {
+		select {
+		case result := <-results:
+			if have := len(result.Block.Transactions()); have != want {
+				t.Errorf("result %d: transaction count mismatch: have %d, want %d", i, have, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("result %d: block not sealed", i)
+		}
+	}
+	select {
+	case result := <-results:
+		t.Fatalf("unexpected block sealed with %d txs", len(result.Block.Transactions()))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that the periodic dev agent seals the latest work, even if empty.
+func TestDevAgentPeriodic(t *testing.T) {
+	results := make(chan *Result, 1)
+
+	agent := NewDevAgent(200 * time.Millisecond)
+	agent.SetReturnCh(results)
+	agent.Start()
+	defer agent.Stop()
+
+	agent.Work() <- newDevTestWork(common.Hash{1}, 1)
+	agent.Work() <- newDevTestWork(common.Hash{1}, 0)
+
+	select {
+	case result := <-results:
+		if txs := len(result.Block.Transactions()); txs != 0 {
+			t.Errorf("transaction count mismatch: have %d, want %d", txs, 0)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("block not sealed")
+	}
+}
+
+// Tests that the dev agent seals on the same parent again if its last sealed
+// block failed to import, instead of stalling.
+func TestDevAgentRejected(t *testing.T) {
+	results := make(chan *Result, 2)
+
+	agent := NewDevAgent(0)
+	agent.SetReturnCh(results)
+	agent.Start()
+	defer agent.Stop()
+
+	agent.Work() <- newDevTestWork(common.Hash{1}, 1)
+	var sealed *Result
+	select {
+	case sealed = <-results:
+	case <-time.After(time.Second):
+		t.Fatalf("block not sealed")
+	}
+	// Work on the same parent must wait for the outcome of the import
+	agent.Work() <- newDevTestWork(common.Hash{1}, 2)
+	select {
+	case result := <-results:
+		t.Fatalf("sibling block sealed with %d txs", len(result.Block.Transactions()))
+	case <-time.After(50 * time.Millisecond):
+	}
+	// A failed import must release the parent and seal the pending work
+	agent.rejected(sealed.Block)
+	select {
+	case result := <-results:
+		if txs := len(result.Block.Transactions()); txs != 2 {
+			t.Errorf("transaction count mismatch: have %d, want %d", txs, 2)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("block not resealed after rejection")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"math/big"
 	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
@@ -129,8 +130,11 @@ func (self *Miner) Start(coinbase common.Address, threads int) {
 	}
 	atomic.StoreInt32(&self.mining, 1)
 
-	for i := 0; i < threads; i++ {
-		self.worker.register(NewCpuAgent(i, self.pow))
+	// Developer mode seals without proof-of-work, CPU agents would only spin
+	if !self.worker.devMode() {
+		for i := 0; i < threads; i++ {
+			self.worker.register(NewCpuAgent(i, self.pow))
+		}
 	}
 
 	glog.V(logger.Info).Infof("Starting mining operation (CPU=%d TOT=%d)\n", threads, len(self.worker.agents))
@@ -178,6 +182,26 @@ func (self *Miner) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetTxOrderer sets the strategy deciding the order in which pending transactions
+// are included into the mined blocks.
+func (self *Miner) SetTxOrderer(orderer TxOrderer) {
+	self.worker.setTxOrderer(orderer)
+}
+
+// SetRecommitInterval sets the interval after which the block being mined is
+// rebuilt to include any newly arrived, more lucrative transactions.
+func (self *Miner) SetRecommitInterval(interval time.Duration) {
+	self.worker.setRecommitInterval(interval)
+}
+
+// SetDevMode switches the miner into developer mode, sealing blocks without any
+// proof-of-work as soon as transactions arrive (period 0) or on a fixed period.
+// It should be used together with a fake proof-of-work engine only.
+func (self *Miner) SetDevMode(period time.Duration) {
+	self.worker.setDevMode()
+	self.Register(NewDevAgent(period))
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -0,0 +1,484 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DefaultTxOrder is the name of the transaction ordering strategy used if none
+// is explicitly configured.
+const DefaultTxOrder = "price"
+
+// maxTrackedArrivals is the maximum number of transaction arrivals remembered by
+// the arrival orderer. Older ones are considered to have arrived when next seen.
+const maxTrackedArrivals = 32768
+
+// TxSet is an ordered set of transactions offered for inclusion into a block.
+type TxSet interface {
+	// Peek returns the next transaction to include, or nil if the set is empty.
+	Peek() *types.Transaction
+
+	// Shift accepts the current transaction, replacing it with the next one
+	// from the same account (if any).
+	Shift()
+
+	// Pop rejects the current transaction, discarding all subsequent ones from
+	// the same account too.
+	Pop()
+}
+
+// TxOrderer decides the order in which pending transactions are included into
+// the blocks built by the miner. Implementations must honour the nonce ordering
+// of each account's transactions.
+type TxOrderer interface {
+	// Order creates an ordered transaction set out of the executable transactions
+	// of the pool, grouped by account and sorted by nonce. The input map is owned
+	// by the orderer from here on.
+	Order(signer types.Signer, pending map[common.Address]types.Transactions) TxSet
+}
+
+// TxObserver is an optional interface of transaction orderers that need to be
+// notified of the transactions entering the pool, in their order of arrival.
+type TxObserver interface {
+	ObserveTx(tx *types.Transaction)
+}
+
+// TxSkipper is an optional interface of transaction sets that need to tell apart
+// the transactions skipped by the miner without inclusion (e.g. because of stale
+// nonces) from the included ones. Sets not implementing it get them shifted.
+type TxSkipper interface {
+	// Skip discards the current transaction without including it, replacing it
+	// with the next one from the same account (if any).
+	Skip()
+}
+
+// skipTx discards the current transaction of a set without including it.
+func skipTx(txs TxSet) {
+	if skipper, ok := txs.(TxSkipper); ok {
+		skipper.Skip()
+		return
+	}
+	txs.Shift()
+}
+
+// TxOrdererFactory creates a transaction orderer out of its textual arguments,
+// as specified after the strategy name in the node configuration.
+type TxOrdererFactory func(args string) (TxOrderer, error)
+
+// PriceNonceOrderer is the default transaction orderer, maximising the fees of
+// a block by picking the best priced transactions first, while honouring the
+// account nonces.
+type PriceNonceOrderer struct{}
+
+// Order implements TxOrderer, ordering the transactions by price and nonce.
+func (PriceNonceOrderer) Order(signer types.Signer, pending map[common.Address]types.Transactions) TxSet {
+	return types.NewTransactionsByPriceAndNonce(pending)
+}
+
+var (
+	txOrderersLock sync.RWMutex
+	txOrderers     = map[string]TxOrdererFactory{
+		DefaultTxOrder: newPriceNonceOrderer,
+		"fifo":         newArrivalOrderer,
+		"priority":     newPriorityOrderer,
+		"capped":       newCappedOrderer,
+	}
+)
+
+// RegisterTxOrderer makes a transaction ordering strategy available by name, so
+// that it can be selected through the node configuration. Registering the same
+// name twice is an error.
+func RegisterTxOrderer(name string, factory TxOrdererFactory) error {
+	txOrderersLock.Lock()
+	defer txOrderersLock.Unlock()
+
+	if _, ok := txOrderers[name]; ok {
+		return fmt.Errorf("transaction orderer %q already registered", name)
+	}
+	txOrderers[name] = factory
+	return nil
+}
+
+// LookupTxOrderer creates a registered transaction ordering strategy from its
+// specification of the form name[:args], e.g. "capped:4" or "priority:0x1,0x2".
+func LookupTxOrderer(spec string) (TxOrderer, error) {
+	name, args := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, args = spec[:i], spec[i+1:]
+	}
+	txOrderersLock.RLock()
+	defer txOrderersLock.RUnlock()
+
+	if factory, ok := txOrderers[name]; ok {
+		orderer, err := factory(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction orderer %q: %v", spec, err)
+		}
+		return orderer, nil
+	}
+	names := make([]string, 0, len(txOrderers))
+	for name := range txOrderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown transaction orderer %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// newPriceNonceOrderer is the registry factory of the price and nonce orderer.
+func newPriceNonceOrderer(args string) (TxOrderer, error) {
+	if args != "" {
+		return nil, errors.New("no arguments accepted")
+	}
+	return PriceNonceOrderer{}, nil
+}
+
+// arrivalOrderer includes the transactions in their order of arrival (first in,
+// first out), while honouring the account nonces. Arrivals are tracked through
+// ObserveTx, unobserved transactions are considered to arrive when first ordered.
+type arrivalOrderer struct {
+	arrivals map[common.Hash]uint64 // Arrival sequence numbers of the tracked transactions
+	tracked  []common.Hash          // Tracked transactions in arrival order, for expiration
+	next     uint64                 // Sequence number of the next arriving transaction
+	lock     sync.Mutex
+}
+
+// NewArrivalOrderer creates a transaction orderer including the transactions in
+// the order the miner first saw them.
+func NewArrivalOrderer() TxOrderer {
+	return &arrivalOrderer{arrivals: make(map[common.Hash]uint64)}
+}
+
+// newArrivalOrderer is the registry factory of the arrival orderer.
+func newArrivalOrderer(args string) (TxOrderer, error) {
+	if args != "" {
+		return nil, errors.New("no arguments accepted")
+	}
+	return NewArrivalOrderer(), nil
+}
+
+// ObserveTx implements TxObserver, recording the arrival of a transaction.
+func (o *arrivalOrderer) ObserveTx(tx *types.Transaction) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.arrival(tx.Hash())
+}
+
+// arrival returns the arrival sequence number of a transaction, tracking it as
+// just arrived if unknown. The lock must be held by the caller.
+func (o *arrivalOrderer) arrival(hash common.Hash) uint64 {
+	if seq, ok := o.arrivals[hash]; ok {
+		return seq
+	}
+	if len(o.tracked) >= maxTrackedArrivals {
+		delete(o.arrivals, o.tracked[0])
+		o.tracked = o.tracked[1:]
+	}
+	seq := o.next
+	o.arrivals[hash] = seq
+	o.tracked = append(o.tracked, hash)
+	o.next++
+
+	return seq
+}
+
+// Order implements TxOrderer, ordering the transactions by arrival and nonce.
+func (o *arrivalOrderer) Order(signer types.Signer, pending map[common.Address]types.Transactions) TxSet {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	// Unobserved transactions arrive now, in a deterministic order
+	addrs := make(addressesByBytes, 0, len(pending))
+	for addr := range pending {
+		addrs = append(addrs, addr)
+	}
+	sort.Sort(addrs)
+
+	set := &arrivalTxSet{
+		signer:   signer,
+		txs:      pending,
+		arrivals: make(map[common.Hash]uint64),
+	}
+	for _, addr := range addrs {
+		txs := pending[addr]
+		if len(txs) == 0 {
+			delete(pending, addr)
+			continue
+		}
+		for _, tx := range txs {
+			set.arrivals[tx.Hash()] = o.arrival(tx.Hash())
+		}
+		set.heads = append(set.heads, arrivalHead{tx: txs[0], seq: set.arrivals[txs[0].Hash()]})
+		pending[addr] = txs[1:]
+	}
+	heap.Init(&set.heads)
+
+	return set
+}
+
+// addressesByBytes implements sort.Interface to order addresses bytewise.
+type addressesByBytes []common.Address
+
+func (a addressesByBytes) Len() int           { return len(a) }
+func (a addressesByBytes) Less(i, j int) bool { return bytes.Compare(a[i][:], a[j][:]) < 0 }
+func (a addressesByBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// arrivalHead is the next transaction of an account along with its arrival.
+type arrivalHead struct {
+	tx  *types.Transaction
+	seq uint64
+}
+
+// arrivalHeads is a heap of account head transactions, earliest arrival first.
+type arrivalHeads []arrivalHead
+
+func (h arrivalHeads) Len() int            { return len(h) }
+func (h arrivalHeads) Less(i, j int) bool  { return h[i].seq < h[j].seq }
+func (h arrivalHeads) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *arrivalHeads) Push(x interface{}) { *h = append(*h, x.(arrivalHead)) }
+
+func (h *arrivalHeads) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// arrivalTxSet is a transaction set returning the earliest arrived executable
+// transaction first.
+type arrivalTxSet struct {
+	signer   types.Signer
+	txs      map[common.Address]types.Transactions // Remaining transactions of each account, nonce sorted
+	arrivals map[common.Hash]uint64                // Arrival sequence numbers of the ordered transactions
+	heads    arrivalHeads                          // Next transaction of each account, earliest arrival first
+}
+
+func (s *arrivalTxSet) Peek() *types.Transaction {
+	if len(s.heads) == 0 {
+		return nil
+	}
+	return s.heads[0].tx
+}
+
+func (s *arrivalTxSet) Shift() {
+	if len(s.heads) == 0 {
+		return
+	}
+	from, _ := types.Sender(s.signer, s.heads[0].tx) // already validated by the pool
+	if txs := s.txs[from]; len(txs) > 0 {
+		s.heads[0], s.txs[from] = arrivalHead{tx: txs[0], seq: s.arrivals[txs[0].Hash()]}, txs[1:]
+		heap.Fix(&s.heads, 0)
+		return
+	}
+	heap.Pop(&s.heads)
+}
+
+func (s *arrivalTxSet) Pop() {
+	if len(s.heads) == 0 {
+		return
+	}
+	heap.Pop(&s.heads)
+}
+
+// priorityOrderer includes the transactions of a set of privileged senders ahead
+// of everybody else, ordering both groups with an inner orderer.
+type priorityOrderer struct {
+	senders map[common.Address]struct{}
+	inner   TxOrderer
+}
+
+// NewPriorityOrderer creates a transaction orderer that includes all transactions
+// of the given senders before any other ones. Both the privileged and the rest
+// of the transactions are ordered by inner.
+func NewPriorityOrderer(senders []common.Address, inner TxOrderer) TxOrderer {
+	orderer := &priorityOrderer{
+		senders: make(map[common.Address]struct{}),
+		inner:   inner,
+	}
+	for _, sender := range senders {
+		orderer.senders[sender] = struct{}{}
+	}
+	return orderer
+}
+
+// newPriorityOrderer is the registry factory of the priority orderer, accepting
+// a comma separated list of privileged senders, ordered by price and nonce.
+func newPriorityOrderer(args string) (TxOrderer, error) {
+	var senders []common.Address
+	for _, arg := range strings.Split(args, ",") {
+		if arg = strings.TrimSpace(arg); arg == "" {
+			continue
+		}
+		if !common.IsHexAddress(arg) {
+			return nil, fmt.Errorf("invalid sender address %q", arg)
+		}
+		senders = append(senders, common.HexToAddress(arg))
+	}
+	if len(senders) == 0 {
+		return nil, errors.New("no privileged senders")
+	}
+	return NewPriorityOrderer(senders, PriceNonceOrderer{}), nil
+}
+
+// ObserveTx implements TxObserver, forwarding arrivals to the inner orderer.
+func (o *priorityOrderer) ObserveTx(tx *types.Transaction) {
+	if observer, ok := o.inner.(TxObserver); ok {
+		observer.ObserveTx(tx)
+	}
+}
+
+// Order implements TxOrderer, splitting the pending transactions into privileged
+// and normal ones.
+func (o *priorityOrderer) Order(signer types.Signer, pending map[common.Address]types.Transactions) TxSet {
+	privileged := make(map[common.Address]types.Transactions)
+	for addr, txs := range pending {
+		if _, ok := o.senders[addr]; ok {
+			privileged[addr] = txs
+			delete(pending, addr)
+		}
+	}
+	return &priorityTxSet{
+		sets: []TxSet{o.inner.Order(signer, privileged), o.inner.Order(signer, pending)},
+	}
+}
+
+// priorityTxSet drains a list of transaction sets one after the other.
+type priorityTxSet struct {
+	sets []TxSet
+}
+
+// current returns the first non-empty set, or nil if all are drained.
+func (s *priorityTxSet) current() TxSet {
+	for len(s.sets) > 0 {
+		if s.sets[0].Peek() != nil {
+			return s.sets[0]
+		}
+		s.sets = s.sets[1:]
+	}
+	return nil
+}
+
+func (s *priorityTxSet) Peek() *types.Transaction {
+	if set := s.current(); set != nil {
+		return set.Peek()
+	}
+	return nil
+}
+
+func (s *priorityTxSet) Shift() {
+	if set := s.current(); set != nil {
+		set.Shift()
+	}
+}
+
+// Skip implements TxSkipper, forwarding the skip to the set being drained.
+func (s *priorityTxSet) Skip() {
+	if set := s.current(); set != nil {
+		skipTx(set)
+	}
+}
+
+func (s *priorityTxSet) Pop() {
+	if set := s.current(); set != nil {
+		set.Pop()
+	}
+}
+
+// cappedOrderer limits the number of transactions a single sender may have
+// included in one block.
+type cappedOrderer struct {
+	limit int
+	inner TxOrderer
+}
+
+// NewCappedOrderer creates a transaction orderer that includes at most limit
+// transactions from any single sender into a block, ordering the rest by inner.
+// The limit must allow at least one transaction per sender.
+func NewCappedOrderer(limit int, inner TxOrderer) (TxOrderer, error) {
+	if limit < 1 {
+		return nil, fmt.Errorf("invalid per sender limit %d", limit)
+	}
+	return &cappedOrderer{limit: limit, inner: inner}, nil
+}
+
+// newCappedOrderer is the registry factory of the capped orderer, accepting the
+// per sender limit, ordered by price and nonce.
+func newCappedOrderer(args string) (TxOrderer, error) {
+	limit, err := strconv.Atoi(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid per sender limit %q", args)
+	}
+	return NewCappedOrderer(limit, PriceNonceOrderer{})
+}
+
+// ObserveTx implements TxObserver, forwarding arrivals to the inner orderer.
+func (o *cappedOrderer) ObserveTx(tx *types.Transaction) {
+	if observer, ok := o.inner.(TxObserver); ok {
+		observer.ObserveTx(tx)
+	}
+}
+
+// Order implements TxOrderer, wrapping the inner set into a per-sender counter.
+func (o *cappedOrderer) Order(signer types.Signer, pending map[common.Address]types.Transactions) TxSet {
+	return &cappedTxSet{
+		TxSet:    o.inner.Order(signer, pending),
+		signer:   signer,
+		limit:    o.limit,
+		included: make(map[common.Address]int),
+	}
+}
+
+// cappedTxSet is a transaction set dropping accounts which reached their limit.
+type cappedTxSet struct {
+	TxSet
+	signer   types.Signer
+	limit    int
+	included map[common.Address]int
+}
+
+// Skip implements TxSkipper, discarding the current transaction without counting
+// it towards the account's allowance, as it was never included.
+func (s *cappedTxSet) Skip() {
+	skipTx(s.TxSet)
+}
+
+// Shift accepts the current transaction, discarding the rest of the account's
+// transactions if it reached its allowance.
+func (s *cappedTxSet) Shift() {
+	tx := s.Peek()
+	if tx == nil {
+		return
+	}
+	from, _ := types.Sender(s.signer, tx) // already validated by the pool
+	if s.included[from]++; s.included[from] >= s.limit {
+		s.TxSet.Pop()
+		return
+	}
+	s.TxSet.Shift()
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// makeOrderingTxs creates count nonce-ordered transactions with the given gas
+// price for each of the keys, returning them grouped by sender.
+func makeOrderingTxs(signer types.Signer, keys []*ecdsa.PrivateKey, prices []int64, count int) map[common.Address]types.Transactions {
+	pending := make(map[common.Address]types.Transactions)
+	for i, key := range keys {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		for nonce := 0; nonce < count; nonce++ {
+			tx, _ := types.SignTx(types.NewTransaction(uint64(nonce), common.Address{}, big.NewInt(100), big.NewInt(100), big.NewInt(prices[i]), nil), signer, key)
+			pending[addr] = append(pending[addr], tx)
+		}
+	}
+	return pending
+}
+
+// drainTxSet accepts every transaction of a set, returning their senders.
+func drainTxSet(signer types.Signer, set TxSet) []common.Address {
+	var senders []common.Address
+	for tx := set.Peek(); tx != nil; tx = set.Peek() {
+		from, _ := types.Sender(signer, tx)
+		senders = append(senders, from)
+		set.Shift()
+	}
+	return senders
+}
+
+// Tests that privileged senders are included before everyone else, regardless
+// of their prices.
+func TestPriorityOrderer(t *testing.T) {
+	signer := types.HomesteadSigner{}
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	cheap := crypto.PubkeyToAddress(keys[0].PublicKey)
+
+	orderer := NewPriorityOrderer([]common.Address{cheap}, PriceNonceOrderer{})
+	senders := drainTxSet(signer, orderer.Order(signer, makeOrderingTxs(signer, keys, []int64{1, 2, 3}, 2)))
+
+	if len(senders) != 6 {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(senders), 6)
+	}
+	for i := 0; i < 2; i++ {
+		if senders[i] != cheap {
+			t.Errorf("transaction %d: sender mismatch: have %x, want privileged %x", i, senders[i], cheap)
+		}
+	}
+	if best := crypto.PubkeyToAddress(keys[2].PublicKey); senders[2] != best {
+		t.Errorf("transaction 2: sender mismatch: have %x, want best priced %x", senders[2], best)
+	}
+}
+
+// Tests that the capped orderer drops the remaining transactions of accounts
+// which reached their per block allowance.
+func TestCappedOrderer(t *testing.T) {
+	signer := types.HomesteadSigner{}
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	orderer, err := NewCappedOrderer(2, PriceNonceOrderer{})
+	if err != nil {
+		t.Fatalf("failed to create capped orderer: %v", err)
+	}
+	senders := drainTxSet(signer, orderer.Order(signer, makeOrderingTxs(signer, keys, []int64{1, 2, 3}, 5)))
+
+	if len(senders) != 6 {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(senders), 6)
+	}
+	included := make(map[common.Address]int)
+	for _, sender := range senders {
+		included[sender]++
+	}
+	for _, key := range keys {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); included[addr] != 2 {
+			t.Errorf("sender %x: included transaction mismatch: have %d, want %d", addr, included[addr], 2)
+		}
+	}
+}
+
+// Tests that transactions skipped by the miner without inclusion (e.g. stale
+// nonces) don't count towards the per block allowance of their accounts.
+func TestCappedOrdererSkips(t *testing.T) {
+	signer := types.HomesteadSigner{}
+
+	keys := make([]*ecdsa.PrivateKey, 2)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	skipper := crypto.PubkeyToAddress(keys[0].PublicKey)
+
+	orderer, err := NewCappedOrderer(2, PriceNonceOrderer{})
+	if err != nil {
+		t.Fatalf("failed to create capped orderer: %v", err)
+	}
+	set := orderer.Order(signer, makeOrderingTxs(signer, keys, []int64{1, 2}, 5))
+
+	// Skip the first two transactions of the first account, include everything else
+	included, skipped := make(map[common.Address]int), 0
+	for tx := set.Peek(); tx != nil; tx = set.Peek() {
+		from, _ := types.Sender(signer, tx)
+		if from == skipper && skipped < 2 {
+			skipTx(set)
+			skipped++
+			continue
+		}
+		included[from]++
+		set.Shift()
+	}
+	for _, key := range keys {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); included[addr] != 2 {
+			t.Errorf("sender %x: included transaction mismatch: have %d, want %d", addr, included[addr], 2)
+		}
+	}
+}
+
+// Tests that the capped orderer rejects limits not allowing any transactions.
+func TestCappedOrdererLimit(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		if _, err := NewCappedOrderer(limit, PriceNonceOrderer{}); err == nil {
+			t.Errorf("limit %d: capped orderer created", limit)
+		}
+	}
+}
+
+// Tests that the arrival orderer includes transactions in the order they were
+// observed, regardless of their prices, while still honouring the nonces.
+func TestArrivalOrderer(t *testing.T) {
+	signer := types.HomesteadSigner{}
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	pending := makeOrderingTxs(signer, keys, []int64{3, 2, 1}, 2)
+
+	// Observe the cheapest sender first, and the second nonce ahead of the first
+	orderer := NewArrivalOrderer()
+	arrivals := []*types.Transaction{pending[addrs[2]][0], pending[addrs[1]][1], pending[addrs[0]][0], pending[addrs[1]][0], pending[addrs[2]][1], pending[addrs[0]][1]}
+	for _, tx := range arrivals {
+		orderer.(TxObserver).ObserveTx(tx)
+	}
+	senders := drainTxSet(signer, orderer.Order(signer, pending))
+
+	want := []common.Address{addrs[2], addrs[0], addrs[1], addrs[1], addrs[2], addrs[0]}
+	if len(senders) != len(want) {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(senders), len(want))
+	}
+	for i := range want {
+		if senders[i] != want[i] {
+			t.Errorf("transaction %d: sender mismatch: have %x, want %x", i, senders[i], want[i])
+		}
+	}
+}
+
+// Tests that transaction orderers can be registered and created by name.
+func TestTxOrdererRegistry(t *testing.T) {
+	if orderer, err := LookupTxOrderer(DefaultTxOrder); err != nil || orderer != (PriceNonceOrderer{}) {
+		t.Fatalf("default orderer mismatch: have %v/%v, want %v", orderer, err, PriceNonceOrderer{})
+	}
+	// Make sure all built in strategies are available with valid arguments only
+	valid := []string{"fifo", "priority:0x0000000000000000000000000000000000000001", "capped:2"}
+	for _, spec := range valid {
+		if _, err := LookupTxOrderer(spec); err != nil {
+			t.Errorf("orderer %q: failed to create: %v", spec, err)
+		}
+	}
+	invalid := []string{"price:1", "fifo:1", "priority", "priority:0x01", "capped", "capped:0", "unknown"}
+	for _, spec := range invalid {
+		if _, err := LookupTxOrderer(spec); err == nil {
+			t.Errorf("orderer %q: created with invalid specification", spec)
+		}
+	}
+	// Make sure custom strategies can be registered, but only once
+	custom := NewPriorityOrderer(nil, PriceNonceOrderer{})
+	factory := func(args string) (TxOrderer, error) { return custom, nil }
+
+	if _, err := LookupTxOrderer("custom"); err == nil {
+		t.Fatalf("unregistered orderer found")
+	}
+	if err := RegisterTxOrderer("custom", factory); err != nil {
+		t.Fatalf("failed to register orderer: %v", err)
+	}
+	if err := RegisterTxOrderer("custom", factory); err == nil {
+		t.Fatalf("duplicate orderer registered")
+	}
+	if orderer, err := LookupTxOrderer("custom"); err != nil || orderer != custom {
+		t.Fatalf("registered orderer mismatch: have %v/%v, want %v", orderer, err, custom)
+	}
+}
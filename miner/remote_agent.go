@@ -23,7 +23,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/logger"
@@ -50,6 +49,9 @@ type RemoteAgent struct {
 	hashrateMu sync.RWMutex
 	hashrate   map[common.Hash]hashrate
 
+	subsMu sync.Mutex
+	subs   map[chan struct{}]struct{} // Channels notified when new work arrives
+
 	running int32 // running indicates whether the agent is active. Call atomically
 }
 
@@ -58,9 +60,28 @@ func NewRemoteAgent(pow pow.PoW) *RemoteAgent {
 		pow:      pow,
 		work:     make(map[common.Hash]*Work),
 		hashrate: make(map[common.Hash]hashrate),
+		subs:     make(map[chan struct{}]struct{}),
 	}
 }
 
+// SubscribeWork registers a channel to be notified whenever a new mining work
+// package becomes available. Notifications are dropped if the channel is not
+// ready to receive, so it should be buffered.
+func (a *RemoteAgent) SubscribeWork(ch chan struct{}) {
+	a.subsMu.Lock()
+	defer a.subsMu.Unlock()
+
+	a.subs[ch] = struct{}{}
+}
+
+// UnsubscribeWork stops notifying a previously subscribed channel.
+func (a *RemoteAgent) UnsubscribeWork(ch chan struct{}) {
+	a.subsMu.Lock()
+	defer a.subsMu.Unlock()
+
+	delete(a.subs, ch)
+}
+
 func (a *RemoteAgent) SubmitHashrate(id common.Hash, rate uint64) {
 	a.hashrateMu.Lock()
 	defer a.hashrateMu.Unlock()
@@ -115,7 +136,7 @@ func (a *RemoteAgent) GetWork() ([3]string, error) {
 		block := a.currentWork.Block
 
 		res[0] = block.HashNoNonce().Hex()
-		seedHash, _ := ethash.GetSeedHash(block.NumberU64())
+		seedHash, _ := pow.SeedHash(block.NumberU64())
 		res[1] = common.BytesToHash(seedHash).Hex()
 		// Calculate the "target" to be returned to the external miner
 		n := big.NewInt(1)
@@ -173,6 +194,15 @@ func (a *RemoteAgent) loop(workCh chan *Work, quitCh chan struct{}) {
 			a.mu.Lock()
 			a.currentWork = work
 			a.mu.Unlock()
+
+			a.subsMu.Lock()
+			for ch := range a.subs {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+			a.subsMu.Unlock()
 		case <-ticker:
 			// cleanup
 			a.mu.Lock()
@@ -0,0 +1,438 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+const (
+	stratumReadTimeout  = 5 * time.Minute  // Maximum time a miner may stay silent before being dropped
+	stratumWriteTimeout = 10 * time.Second // Maximum time allowed for a single message to be written
+	stratumMaxLineSize  = 64 * 1024        // Maximum size of a single request line
+	stratumPushQueue    = 4                // Maximum number of pushed work packages queued for a miner before it's dropped
+)
+
+var (
+	errStratumNoWork       = errors.New("no work available yet")
+	errStratumNotLoggedIn  = errors.New("not logged in")
+	errStratumInvalidParam = errors.New("invalid parameters")
+)
+
+// StratumWorker contains the statistics of a remote miner connected through the
+// Stratum server.
+type StratumWorker struct {
+	Name     string    `json:"name"`     // Worker name reported at login
+	Login    string    `json:"login"`    // Login (usually an address) used by the miner
+	Address  string    `json:"address"`  // Remote network address of the miner
+	Hashrate uint64    `json:"hashrate"` // Last hash rate reported by the miner
+	Accepted uint64    `json:"accepted"` // Number of valid solutions submitted
+	Rejected uint64    `json:"rejected"` // Number of invalid or stale solutions submitted
+	LastSeen time.Time `json:"lastSeen"` // Time of the last request received from the miner
+}
+
+// StratumServer is a TCP server speaking the line based JSON-RPC Stratum dialect
+// used by the Ethereum proxies (eth_submitLogin, eth_getWork, eth_submitWork and
+// eth_submitHashrate). Compared to polling the HTTP RPC endpoint, it pushes any
+// new work package to the connected miners as soon as it becomes available.
+type StratumServer struct {
+	agent    *RemoteAgent
+	addr     string
+	listener net.Listener
+
+	sessions map[*stratumSession]struct{}
+	lock     sync.Mutex
+
+	notify chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewStratumServer creates a Stratum server handing out the work packages of the
+// given remote agent to miners connecting on addr.
+func NewStratumServer(agent *RemoteAgent, addr string) *StratumServer {
+	return &StratumServer{
+		agent:    agent,
+		addr:     addr,
+		sessions: make(map[*stratumSession]struct{}),
+		notify:   make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start opens the listening socket and starts accepting remote miners.
+func (self *StratumServer) Start() error {
+	listener, err := net.Listen("tcp", self.addr)
+	if err != nil {
+		return err
+	}
+	self.listener = listener
+	self.agent.SubscribeWork(self.notify)
+
+	self.wg.Add(2)
+	go self.accept()
+	go self.broadcast()
+
+	glog.V(logger.Info).Infof("Stratum server started on %v", listener.Addr())
+	return nil
+}
+
+// Stop closes the listening socket and disconnects all miners.
+func (self *StratumServer) Stop() {
+	self.agent.UnsubscribeWork(self.notify)
+	close(self.quit)
+	self.listener.Close()
+
+	self.lock.Lock()
+	for session := range self.sessions {
+		session.conn.Close()
+	}
+	self.lock.Unlock()
+
+	self.wg.Wait()
+	glog.V(logger.Info).Infof("Stratum server stopped")
+}
+
+// Addr returns the network address the server is listening on.
+func (self *StratumServer) Addr() net.Addr {
+	return self.listener.Addr()
+}
+
+// Workers returns the statistics of all the logged in miners.
+func (self *StratumServer) Workers() []StratumWorker {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	workers := make([]StratumWorker, 0, len(self.sessions))
+	for session := range self.sessions {
+		session.lock.Lock()
+		if session.loggedIn {
+			workers = append(workers, session.stats)
+		}
+		session.lock.Unlock()
+	}
+	return workers
+}
+
+// accept keeps accepting new miner connections until the listener is closed.
+func (self *StratumServer) accept() {
+	defer self.wg.Done()
+
+	for {
+		conn, err := self.listener.Accept()
+		if err != nil {
+			select {
+			case <-self.quit:
+			default:
+				glog.V(logger.Warn).Infof("Stratum server failed to accept connection: %v", err)
+			}
+			return
+		}
+		session := newStratumSession(self, conn)
+		self.lock.Lock()
+		select {
+		case <-self.quit:
+			self.lock.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		self.sessions[session] = struct{}{}
+		self.lock.Unlock()
+
+		self.wg.Add(2)
+		go func() {
+			defer self.wg.Done()
+			session.loop()
+		}()
+		go func() {
+			defer self.wg.Done()
+			session.handle()
+			close(session.closed)
+
+			self.lock.Lock()
+			delete(self.sessions, session)
+			self.lock.Unlock()
+		}()
+	}
+}
+
+// broadcast pushes every new work package to the logged in miners. The packages
+// are only queued up, the actual writes being done by each session's own loop so
+// a single slow miner cannot delay the others.
+func (self *StratumServer) broadcast() {
+	defer self.wg.Done()
+
+	for {
+		select {
+		case <-self.notify:
+			work, err := self.agent.GetWork()
+			if err != nil {
+				continue
+			}
+			self.lock.Lock()
+			sessions := make([]*stratumSession, 0, len(self.sessions))
+			for session := range self.sessions {
+				sessions = append(sessions, session)
+			}
+			self.lock.Unlock()
+
+			for _, session := range sessions {
+				if session.isLoggedIn() {
+					session.push(work)
+				}
+			}
+		case <-self.quit:
+			return
+		}
+	}
+}
+
+// stratumRequest is a single request line sent by a miner.
+type stratumRequest struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Worker string            `json:"worker"`
+}
+
+// stratumResponse is a reply to a request, or an unsolicited work notification.
+type stratumResponse struct {
+	Id      json.RawMessage `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result"`
+	Error   *stratumError   `json:"error,omitempty"`
+}
+
+// stratumError is the error object returned for failed requests.
+type stratumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// stratumSession is a single connected remote miner.
+type stratumSession struct {
+	server *StratumServer
+	conn   net.Conn
+
+	loggedIn bool
+	stats    StratumWorker
+	lock     sync.Mutex // Protects the login state and statistics
+
+	pushes    chan [3]string // Work packages queued up for pushing to the miner
+	closed    chan struct{}  // Channel closed when the miner disconnects
+	writeLock sync.Mutex
+}
+
+// newStratumSession creates a session for a freshly connected remote miner.
+func newStratumSession(server *StratumServer, conn net.Conn) *stratumSession {
+	return &stratumSession{
+		server: server,
+		conn:   conn,
+		stats:  StratumWorker{Address: conn.RemoteAddr().String()},
+		pushes: make(chan [3]string, stratumPushQueue),
+		closed: make(chan struct{}),
+	}
+}
+
+// loop writes the queued work packages to the miner until it disconnects.
+func (self *stratumSession) loop() {
+	for {
+		select {
+		case work := <-self.pushes:
+			if err := self.send(&stratumResponse{Id: json.RawMessage("0"), Version: "2.0", Result: work}); err != nil {
+				return
+			}
+		case <-self.closed:
+			return
+		}
+	}
+}
+
+// push queues a work package for pushing to the miner, dropping the connection
+// if the miner cannot keep up with the already queued ones.
+func (self *stratumSession) push(work [3]string) bool {
+	select {
+	case self.pushes <- work:
+		return true
+	default:
+		glog.V(logger.Debug).Infof("Stratum miner %v dropped: work push queue full", self.conn.RemoteAddr())
+		self.conn.Close()
+		return false
+	}
+}
+
+// handle reads and serves the requests of the miner until it disconnects.
+func (self *stratumSession) handle() {
+	defer self.conn.Close()
+
+	scanner := bufio.NewScanner(self.conn)
+	scanner.Buffer(make([]byte, 0, 1024), stratumMaxLineSize)
+	for {
+		self.conn.SetReadDeadline(time.Now().Add(stratumReadTimeout))
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				glog.V(logger.Detail).Infof("Stratum miner %v disconnected: %v", self.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var req stratumRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			glog.V(logger.Debug).Infof("Stratum miner %v sent malformed request: %v", self.conn.RemoteAddr(), err)
+			return
+		}
+		self.lock.Lock()
+		self.stats.LastSeen = time.Now()
+		self.lock.Unlock()
+
+		res := &stratumResponse{Id: req.Id, Version: "2.0"}
+		if result, err := self.serve(&req); err != nil {
+			res.Error = &stratumError{Code: -1, Message: err.Error()}
+		} else {
+			res.Result = result
+		}
+		if err := self.send(res); err != nil {
+			return
+		}
+	}
+}
+
+// serve executes a single request, returning its result.
+func (self *stratumSession) serve(req *stratumRequest) (interface{}, error) {
+	agent := self.server.agent
+
+	switch req.Method {
+	case "eth_submitLogin":
+		var login string
+		if len(req.Params) < 1 || json.Unmarshal(req.Params[0], &login) != nil {
+			return nil, errStratumInvalidParam
+		}
+		worker := req.Worker
+		if worker == "" {
+			if idx := strings.Index(login, "."); idx >= 0 {
+				login, worker = login[:idx], login[idx+1:]
+			}
+		}
+		if worker == "" {
+			worker = self.conn.RemoteAddr().String()
+		}
+		self.lock.Lock()
+		self.loggedIn = true
+		self.stats.Login, self.stats.Name = login, worker
+		self.lock.Unlock()
+
+		glog.V(logger.Info).Infof("Stratum miner %s (%s) logged in from %v", worker, login, self.conn.RemoteAddr())
+		return true, nil
+
+	case "eth_getWork":
+		if !self.isLoggedIn() {
+			return nil, errStratumNotLoggedIn
+		}
+		work, err := agent.GetWork()
+		if err != nil {
+			return nil, errStratumNoWork
+		}
+		return work, nil
+
+	case "eth_submitWork":
+		var (
+			nonce  types.BlockNonce
+			hash   common.Hash
+			digest common.Hash
+		)
+		if !self.isLoggedIn() {
+			return nil, errStratumNotLoggedIn
+		}
+		if len(req.Params) < 3 || json.Unmarshal(req.Params[0], &nonce) != nil || json.Unmarshal(req.Params[1], &hash) != nil || json.Unmarshal(req.Params[2], &digest) != nil {
+			return nil, errStratumInvalidParam
+		}
+		accepted := agent.SubmitWork(nonce, digest, hash)
+
+		self.lock.Lock()
+		if accepted {
+			self.stats.Accepted++
+		} else {
+			self.stats.Rejected++
+		}
+		self.lock.Unlock()
+
+		return accepted, nil
+
+	case "eth_submitHashrate":
+		var (
+			rate hexutil.Uint64
+			id   common.Hash
+		)
+		if len(req.Params) < 2 || json.Unmarshal(req.Params[0], &rate) != nil || json.Unmarshal(req.Params[1], &id) != nil {
+			return nil, errStratumInvalidParam
+		}
+		agent.SubmitHashrate(id, uint64(rate))
+
+		self.lock.Lock()
+		self.stats.Hashrate = uint64(rate)
+		self.lock.Unlock()
+
+		return true, nil
+
+	default:
+		return nil, errors.New("method not found: " + req.Method)
+	}
+}
+
+// isLoggedIn reports whether the miner already authenticated itself.
+func (self *stratumSession) isLoggedIn() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.loggedIn
+}
+
+// send writes a single message to the miner, dropping the connection if it
+// cannot keep up.
+func (self *stratumSession) send(msg *stratumResponse) error {
+	blob, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+
+	self.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+	if _, err := self.conn.Write(append(blob, '\n')); err != nil {
+		glog.V(logger.Debug).Infof("Stratum miner %v dropped: %v", self.conn.RemoteAddr(), err)
+		self.conn.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// stratumTestClient is a minimal line based Stratum miner.
+type stratumTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *stratumTestClient) request(t *testing.T, id int, method string, params ...interface{}) {
+	blob, _ := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if _, err := c.conn.Write(append(blob, '\n')); err != nil {
+		t.Fatalf("failed to send %s: %v", method, err)
+	}
+}
+
+type stratumTestResponse struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *stratumError   `json:"error"`
+}
+
+func (c *stratumTestClient) read(t *testing.T, id int) *stratumTestResponse {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("failed to read response %d: %v", id, err)
+	}
+	res := new(stratumTestResponse)
+	if err := json.Unmarshal(line, res); err != nil {
+		t.Fatalf("failed to decode response %d: %v", id, err)
+	}
+	if res.Id != id {
+		t.Fatalf("response id mismatch: have %d, want %d", res.Id, id)
+	}
+	return res
+}
+
+func (c *stratumTestClient) response(t *testing.T, id int, result interface{}) {
+	res := c.read(t, id)
+	if res.Error != nil {
+		t.Fatalf("response %d failed: %s", id, res.Error.Message)
+	}
+	if err := json.Unmarshal(res.Result, result); err != nil {
+		t.Fatalf("failed to decode result %d: %v", id, err)
+	}
+}
+
+// Tests that remote miners can log in, receive pushed work packages, submit
+// solutions and report their hash rates through the Stratum server.
+func TestStratumServer(t *testing.T) {
+	agent := NewRemoteAgent(core.FakePow{})
+	results := make(chan *Result, 1)
+	agent.SetReturnCh(results)
+	agent.Start()
+	defer agent.Stop()
+
+	server := NewStratumServer(agent, "127.0.0.1:0")
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start stratum server: %v", err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to stratum server: %v", err)
+	}
+	defer conn.Close()
+	client := &stratumTestClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	// Make sure nothing is served before logging in
+	client.request(t, 1, "eth_getWork")
+	if res := client.read(t, 1); res.Error == nil || res.Error.Message != errStratumNotLoggedIn.Error() {
+		t.Fatalf("work served before login: %s", res.Result)
+	}
+	client.request(t, 1, "eth_submitWork", "0x0000000000000001", fmt.Sprintf("0x%064x", 0), fmt.Sprintf("0x%064x", 0))
+	if res := client.read(t, 1); res.Error == nil || res.Error.Message != errStratumNotLoggedIn.Error() {
+		t.Fatalf("solution accepted before login: %s", res.Result)
+	}
+	// Log in and make sure the new work gets pushed
+	var ok bool
+	client.request(t, 1, "eth_submitLogin", "0x0000000000000000000000000000000000000001.rig")
+	if client.response(t, 1, &ok); !ok {
+		t.Fatalf("login rejected")
+	}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1000)})
+	agent.Work() <- &Work{Block: block, createdAt: time.Now()}
+
+	var pushed [3]string
+	client.response(t, 0, &pushed)
+	if want := block.HashNoNonce().Hex(); pushed[0] != want {
+		t.Fatalf("pushed work mismatch: have %s, want %s", pushed[0], want)
+	}
+	// Submit a solution and a hash rate report
+	client.request(t, 2, "eth_submitWork", "0x0000000000000001", pushed[0], fmt.Sprintf("0x%064x", 0))
+	if client.response(t, 2, &ok); !ok {
+		t.Fatalf("valid solution rejected")
+	}
+	select {
+	case result := <-results:
+		if result.Block.Nonce() != 1 {
+			t.Errorf("sealed nonce mismatch: have %d, want %d", result.Block.Nonce(), 1)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("solution not forwarded to the miner")
+	}
+	client.request(t, 3, "eth_submitWork", "0x0000000000000002", pushed[0], fmt.Sprintf("0x%064x", 0))
+	if client.response(t, 3, &ok); ok {
+		t.Fatalf("stale solution accepted")
+	}
+	client.request(t, 4, "eth_submitHashrate", "0x100", fmt.Sprintf("0x%064x", 1))
+	if client.response(t, 4, &ok); !ok {
+		t.Fatalf("hash rate report rejected")
+	}
+	if rate := agent.GetHashRate(); rate != 256 {
+		t.Errorf("agent hash rate mismatch: have %d, want %d", rate, 256)
+	}
+	workers := server.Workers()
+	if len(workers) != 1 {
+		t.Fatalf("worker count mismatch: have %d, want %d", len(workers), 1)
+	}
+	if w := workers[0]; w.Name != "rig" || w.Hashrate != 256 || w.Accepted != 1 || w.Rejected != 1 {
+		t.Errorf("worker stats mismatch: have %+v", w)
+	}
+}
+
+// Tests that miners not keeping up with the pushed work packages are dropped
+// instead of blocking the broadcast.
+func TestStratumSlowMiner(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	session := newStratumSession(nil, local)
+	for i := 0; i < stratumPushQueue; i++ {
+		if !session.push([3]string{}) {
+			t.Fatalf("push %d: queued work rejected", i)
+		}
+	}
+	done := make(chan bool)
+	go func() { done <- session.push([3]string{}) }()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("work queued beyond the push queue limit")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("push blocked on a slow miner")
+	}
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("slow miner not disconnected")
+	}
+}
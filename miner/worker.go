@@ -42,6 +42,14 @@ import (
 const (
 	resultQueueSize  = 10
 	miningLogAtDepth = 5
+
+	// DefaultRecommitInterval is the default time interval after which the block
+	// being mined is rebuilt to include newly arrived transactions.
+	DefaultRecommitInterval = 3 * time.Second
+
+	// minRecommitInterval is the minimal allowed recommit interval, preventing
+	// the worker from spending all its time on rebuilding blocks.
+	minRecommitInterval = time.Second
 )
 
 // Agent can register themself with the worker
@@ -53,6 +61,12 @@ type Agent interface {
 	GetHashRate() int64
 }
 
+// rejectObserver is implemented by agents that need to know if a block they
+// sealed failed to import.
+type rejectObserver interface {
+	rejected(block *types.Block)
+}
+
 // Work is the workers current environment and holds
 // all of the current state information
 type Work struct {
@@ -105,9 +119,13 @@ type worker struct {
 	coinbase common.Address
 	gasPrice *big.Int
 	extra    []byte
+	orderer  TxOrderer
+	recommit time.Duration // interval to rebuild the current work with fresh transactions
+	dev      bool          // developer mode, rebuilding the current work on every new transaction
 
 	currentMu sync.Mutex
 	current   *Work
+	snapshot  *Work // pending work new transactions are applied to, separate from current while mining
 
 	uncleMu        sync.Mutex
 	possibleUncles map[common.Hash]*types.Block
@@ -120,6 +138,7 @@ type worker struct {
 	// atomic status counters
 	mining int32
 	atWork int32
+	dirty  int32 // set if the pool changed since the current work was assembled
 
 	fullValidation bool
 }
@@ -136,12 +155,14 @@ func newWorker(config *params.ChainConfig, coinbase common.Address, eth Backend,
 		proc:           eth.BlockChain().Validator(),
 		possibleUncles: make(map[common.Hash]*types.Block),
 		coinbase:       coinbase,
+		orderer:        PriceNonceOrderer{},
+		recommit:       DefaultRecommitInterval,
 		txQueue:        make(map[common.Hash]*types.Transaction),
 		agents:         make(map[Agent]struct{}),
 		unconfirmed:    newUnconfirmedBlocks(eth.BlockChain(), 5),
 		fullValidation: false,
 	}
-	worker.events = worker.mux.Subscribe(core.ChainHeadEvent{}, core.ChainSideEvent{}, core.TxPreEvent{})
+	worker.events = worker.mux.Subscribe(core.ChainHeadEvent{}, core.ChainSideEvent{}, core.TxPreEvent{}, core.TxPoolEvent{})
 	go worker.update()
 
 	go worker.wait()
@@ -162,34 +183,68 @@ func (self *worker) setExtra(extra []byte) {
 	self.extra = extra
 }
 
+func (self *worker) setTxOrderer(orderer TxOrderer) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.orderer = orderer
+}
+
+func (self *worker) txOrderer() TxOrderer {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.orderer
+}
+
+func (self *worker) setRecommitInterval(interval time.Duration) {
+	if interval < minRecommitInterval {
+		glog.V(logger.Warn).Infof("Sanitizing miner recommit interval: %v -> %v", interval, minRecommitInterval)
+		interval = minRecommitInterval
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.recommit = interval
+}
+
+func (self *worker) setDevMode() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.dev = true
+}
+
+func (self *worker) devMode() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.dev
+}
+
+func (self *worker) recommitInterval() time.Duration {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.recommit
+}
+
 func (self *worker) pending() (*types.Block, *state.StateDB) {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
 
-	if atomic.LoadInt32(&self.mining) == 0 {
-		return types.NewBlock(
-			self.current.header,
-			self.current.txs,
-			nil,
-			self.current.receipts,
-		), self.current.state.Copy()
-	}
-	return self.current.Block, self.current.state.Copy()
+	return types.NewBlock(
+		self.snapshot.header,
+		self.snapshot.txs,
+		nil,
+		self.snapshot.receipts,
+	), self.snapshot.state.Copy()
 }
 
 func (self *worker) pendingBlock() *types.Block {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
 
-	if atomic.LoadInt32(&self.mining) == 0 {
-		return types.NewBlock(
-			self.current.header,
-			self.current.txs,
-			nil,
-			self.current.receipts,
-		)
-	}
-	return self.current.Block
+	return types.NewBlock(
+		self.snapshot.header,
+		self.snapshot.txs,
+		nil,
+		self.snapshot.receipts,
+	)
 }
 
 func (self *worker) start() {
@@ -239,27 +294,60 @@ func (self *worker) unregister(agent Agent) {
 }
 
 func (self *worker) update() {
-	for event := range self.events.Chan() {
-		// A real event arrived, process interesting content
-		switch ev := event.Data.(type) {
-		case core.ChainHeadEvent:
-			self.commitNewWork()
-		case core.ChainSideEvent:
-			self.uncleMu.Lock()
-			self.possibleUncles[ev.Block.Hash()] = ev.Block
-			self.uncleMu.Unlock()
-		case core.TxPreEvent:
-			// Apply transaction to the pending state if we're not mining
-			if atomic.LoadInt32(&self.mining) == 0 {
+	recommit := time.NewTimer(self.recommitInterval())
+	defer recommit.Stop()
+
+	for {
+		select {
+		case event, ok := <-self.events.Chan():
+			if !ok {
+				return
+			}
+			// A real event arrived, process interesting content
+			switch ev := event.Data.(type) {
+			case core.ChainHeadEvent:
+				self.commitNewWork()
+			case core.ChainSideEvent:
+				self.uncleMu.Lock()
+				self.possibleUncles[ev.Block.Hash()] = ev.Block
+				self.uncleMu.Unlock()
+			case core.TxPreEvent:
+				// Let the orderer know of the arrival if it cares about it
+				if observer, ok := self.txOrderer().(TxObserver); ok {
+					observer.ObserveTx(ev.Tx)
+				}
+				// Apply transaction to the pending state. If we're mining, leave
+				// the sealed work for the next recommit (or seal right away in
+				// developer mode)
+				orderer := self.txOrderer()
 				self.currentMu.Lock()
 
-				acc, _ := types.Sender(self.current.signer, ev.Tx)
+				acc, _ := types.Sender(self.snapshot.signer, ev.Tx)
 				txs := map[common.Address]types.Transactions{acc: {ev.Tx}}
-				txset := types.NewTransactionsByPriceAndNonce(txs)
+				txset := orderer.Order(self.snapshot.signer, txs)
 
-				self.current.commitTransactions(self.mux, txset, self.gasPrice, self.chain)
+				self.snapshot.commitTransactions(self.mux, txset, self.gasPrice, self.chain)
 				self.currentMu.Unlock()
+
+				if atomic.LoadInt32(&self.mining) == 1 {
+					if self.devMode() {
+						self.commitNewWork()
+					} else {
+						atomic.StoreInt32(&self.dirty, 1)
+					}
+				}
+			case core.TxPoolEvent:
+				// Replacements and drops invalidate the pending state, rebuild it
+				if ev.Kind == core.TxReplaced || ev.Kind == core.TxDropped {
+					atomic.StoreInt32(&self.dirty, 1)
+				}
 			}
+		case <-recommit.C:
+			// Rebuild the current work if the pool changed since it was assembled
+			if atomic.CompareAndSwapInt32(&self.dirty, 1, 0) {
+				self.commitWork(true)
+			}
+			recommit.Reset(self.recommitInterval())
 		}
 	}
 }
@@ -279,6 +367,7 @@ func (self *worker) wait() {
 			if self.fullValidation {
 				if _, err := self.chain.InsertChain(types.Blocks{block}); err != nil {
 					glog.V(logger.Error).Infoln("mining err", err)
+					self.reject(block)
 					continue
 				}
 				go self.mux.Post(core.NewMinedBlockEvent{Block: block})
@@ -287,18 +376,21 @@ func (self *worker) wait() {
 				parent := self.chain.GetBlock(block.ParentHash(), block.NumberU64()-1)
 				if parent == nil {
 					glog.V(logger.Error).Infoln("Invalid block found during mining")
+					self.reject(block)
 					continue
 				}
 
 				auxValidator := self.eth.BlockChain().AuxValidator()
 				if err := core.ValidateHeader(self.config, auxValidator, block.Header(), parent.Header(), true, false); err != nil && err != core.BlockFutureErr {
 					glog.V(logger.Error).Infoln("Invalid header on mined block:", err)
+					self.reject(block)
 					continue
 				}
 
 				stat, err := self.chain.WriteBlock(block)
 				if err != nil {
 					glog.V(logger.Error).Infoln("error writing block to chain", err)
+					self.reject(block)
 					continue
 				}
 
@@ -318,8 +410,6 @@ func (self *worker) wait() {
 					core.WriteTransactions(self.chainDb, block)
 					// store the receipts
 					core.WriteReceipts(self.chainDb, work.receipts)
-					// Write map map bloom filters
-					core.WriteMipmapBloom(self.chainDb, block.NumberU64(), work.receipts)
 					// implicit by posting ChainHeadEvent
 					mustCommitNewWork = false
 				}
@@ -348,6 +438,18 @@ func (self *worker) wait() {
 	}
 }
 
+// reject notifies the interested agents that a sealed block failed to import.
+func (self *worker) reject(block *types.Block) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for agent := range self.agents {
+		if observer, ok := agent.(rejectObserver); ok {
+			observer.rejected(block)
+		}
+	}
+}
+
 // push sends a new work task to currently live miner agents.
 func (self *worker) push(work *Work) {
 	if atomic.LoadInt32(&self.mining) != 1 {
@@ -406,7 +508,16 @@ func (w *worker) setGasPrice(p *big.Int) {
 	w.mux.Post(core.GasPriceChanged{Price: w.gasPrice})
 }
 
+// commitNewWork assembles a new block on top of the current chain head and pushes
+// it to the mining agents.
 func (self *worker) commitNewWork() {
+	self.commitWork(false)
+}
+
+// commitWork assembles a new block out of the current transaction pool contents.
+// If recommit is set and mining is in progress, the work being sealed is kept
+// unless the new one builds on the same parent and pays more fees.
+func (self *worker) commitWork(recommit bool) {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 	self.uncleMu.Lock()
@@ -416,6 +527,7 @@ func (self *worker) commitNewWork() {
 
 	tstart := time.Now()
 	parent := self.chain.CurrentBlock()
+	atomic.StoreInt32(&self.dirty, 0)
 
 	tstamp := tstart.Unix()
 	if parent.Time().Cmp(new(big.Int).SetInt64(tstamp)) >= 0 {
@@ -453,6 +565,7 @@ func (self *worker) commitNewWork() {
 		}
 	}
 	// Could potentially happen if starting to mine in an odd state.
+	prev := self.current
 	err := self.makeCurrent(parent, header)
 	if err != nil {
 		glog.V(logger.Info).Infoln("Could not create new env for mining, retrying on next block.")
@@ -470,7 +583,7 @@ func (self *worker) commitNewWork() {
 		return
 	}
 
-	txs := types.NewTransactionsByPriceAndNonce(pending)
+	txs := self.orderer.Order(work.signer, pending)
 	work.commitTransactions(self.mux, txs, self.gasPrice, self.chain)
 
 	self.eth.TxPool().RemoveBatch(work.lowGasTxs)
@@ -499,6 +612,20 @@ func (self *worker) commitNewWork() {
 	for _, hash := range badUncles {
 		delete(self.possibleUncles, hash)
 	}
+	// The pending block always reflects the latest pool contents. While mining
+	// it needs its own copy, as the sealed work's state gets the rewards.
+	self.snapshot = work
+	if atomic.LoadInt32(&self.mining) == 1 {
+		self.snapshot = work.copy()
+	}
+	// Keep sealing the previous block unless the rebuilt one pays more fees,
+	// there's no point in restarting the seal for a less profitable block.
+	if recommit && atomic.LoadInt32(&self.mining) == 1 && prev != nil && prev.Block != nil {
+		if prev.header.ParentHash == header.ParentHash && work.fees().Cmp(prev.fees()) <= 0 {
+			self.current = prev
+			return
+		}
+	}
 
 	if atomic.LoadInt32(&self.mining) == 1 {
 		// commit state root after all state transitions.
@@ -517,6 +644,28 @@ func (self *worker) commitNewWork() {
 	self.push(work)
 }
 
+// copy creates a deep copy of the work's header, state and transactions, so
+// that new transactions can be applied to it without altering the original.
+func (env *Work) copy() *Work {
+	cpy := *env
+	cpy.state = env.state.Copy()
+	cpy.header = types.CopyHeader(env.header)
+	cpy.txs = append([]*types.Transaction(nil), env.txs...)
+	cpy.receipts = append([]*types.Receipt(nil), env.receipts...)
+	cpy.lowGasTxs, cpy.failedTxs = nil, nil
+	cpy.Block = nil
+	return &cpy
+}
+
+// fees returns the total fees the transactions of the work pay to the miner.
+func (env *Work) fees() *big.Int {
+	fees := new(big.Int)
+	for i, tx := range env.txs {
+		fees.Add(fees, new(big.Int).Mul(env.receipts[i].GasUsed, tx.GasPrice()))
+	}
+	return fees
+}
+
 func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	hash := uncle.Hash()
 	if work.uncles.Has(hash) {
@@ -532,7 +681,7 @@ func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	return nil
 }
 
-func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsByPriceAndNonce, gasPrice *big.Int, bc *core.BlockChain) {
+func (env *Work) commitTransactions(mux *event.TypeMux, txs TxSet, gasPrice *big.Int, bc *core.BlockChain) {
 	gp := new(core.GasPool).AddGas(env.header.GasLimit)
 
 	var coalescedLogs []*types.Log
@@ -571,12 +720,19 @@ func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsB
 		env.state.StartRecord(tx.Hash(), common.Hash{}, env.tcount)
 
 		err, logs := env.commitTransaction(tx, bc, gp)
+		nonceErr, _ := err.(*core.NonceErr)
 		switch {
 		case core.IsGasLimitErr(err):
 			// Pop the current out-of-gas transaction without shifting in the next from the account
 			glog.V(logger.Detail).Infof("Gas limit reached for (%x) in this block. Continue to try smaller txs\n", from[:4])
 			txs.Pop()
 
+		case nonceErr != nil && nonceErr.Is < nonceErr.Exp:
+			// Skip the stale transaction (pool and chain head out of sync) without including
+			// it, shifting in the next one from the account
+			glog.V(logger.Detail).Infof("Transaction (%x) has a stale nonce, skipping: %v\n", tx.Hash().Bytes()[:4], err)
+			skipTx(txs)
+
 		case err != nil:
 			// Pop the current failed transaction without shifting in the next from the account
 			glog.V(logger.Detail).Infof("Transaction (%x) failed, will be removed: %v\n", tx.Hash().Bytes()[:4], err)
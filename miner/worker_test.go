@@ -0,0 +1,230 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	testBankKey, _  = crypto.GenerateKey()
+	testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
+	testBankFunds   = big.NewInt(1000000000000000000)
+)
+
+// testWorkerBackend implements the miner Backend with a funded test account.
+type testWorkerBackend struct {
+	db      ethdb.Database
+	chain   *core.BlockChain
+	txPool  *core.TxPool
+	accman  *accounts.Manager
+	keydir  string
+	signer  types.Signer
+	eventer *event.TypeMux
+}
+
+func newTestWorkerBackend(t *testing.T) *testWorkerBackend {
+	db, _ := ethdb.NewMemDatabase()
+	core.WriteGenesisBlockForTesting(db, core.GenesisAccount{Address: testBankAddress, Balance: testBankFunds})
+
+	mux := new(event.TypeMux)
+	chain, err := core.NewBlockChain(db, params.TestChainConfig, core.FakePow{}, mux, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	config := core.DefaultTxPoolConfig
+	config.Journal = ""
+
+	keydir, err := ioutil.TempDir("", "worker-test")
+	if err != nil {
+		t.Fatalf("failed to create keystore: %v", err)
+	}
+	return &testWorkerBackend{
+		db:      db,
+		chain:   chain,
+		txPool:  core.NewTxPool(config, params.TestChainConfig, mux, chain.State, chain.GasLimit),
+		accman:  accounts.NewManager(keydir, accounts.LightScryptN, accounts.LightScryptP),
+		keydir:  keydir,
+		signer:  types.NewEIP155Signer(params.TestChainConfig.ChainId),
+		eventer: mux,
+	}
+}
+
+func (b *testWorkerBackend) AccountManager() *accounts.Manager { return b.accman }
+func (b *testWorkerBackend) BlockChain() *core.BlockChain      { return b.chain }
+func (b *testWorkerBackend) TxPool() *core.TxPool              { return b.txPool }
+func (b *testWorkerBackend) ChainDb() ethdb.Database           { return b.db }
+
+func (b *testWorkerBackend) close() {
+	b.txPool.Stop()
+	b.chain.Stop()
+	os.RemoveAll(b.keydir)
+}
+
+// newTx creates the next transaction of the test account with the given price.
+func (b *testWorkerBackend) newTx(t *testing.T, nonce uint64, price int64) *types.Transaction {
+	tx, err := types.SignTx(types.NewTransaction(nonce, common.Address{0x01}, big.NewInt(1), params.TxGas, big.NewInt(price), nil), b.signer, testBankKey)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+// testAgent is a mining agent that doesn't seal, only reports the work it got.
+type testAgent struct {
+	works chan *Work
+}
+
+func newTestAgent() *testAgent { return &testAgent{works: make(chan *Work, 16)} }
+
+func (a *testAgent) Work() chan<- *Work         { return a.works }
+func (a *testAgent) SetReturnCh(chan<- *Result) {}
+func (a *testAgent) Start()                     {}
+func (a *testAgent) Stop()                      {}
+func (a *testAgent) GetHashRate() int64         { return 0 }
+
+// currentWork retrieves the work the worker is sealing.
+func currentWork(w *worker) *Work {
+	w.currentMu.Lock()
+	defer w.currentMu.Unlock()
+
+	return w.current
+}
+
+// Tests that the recommit interval is sanitized and that, while mining, newly
+// arrived transactions are only picked up by the periodic recommit.
+func TestWorkerRecommitInterval(t *testing.T) {
+	backend := newTestWorkerBackend(t)
+	defer backend.close()
+
+	w := newWorker(params.TestChainConfig, common.Address{}, backend, backend.eventer)
+	defer w.stop()
+
+	w.setRecommitInterval(0)
+	if have := w.recommitInterval(); have != minRecommitInterval {
+		t.Fatalf("recommit interval not sanitized: have %v, want %v", have, minRecommitInterval)
+	}
+	agent := newTestAgent()
+	w.register(agent)
+	w.start()
+	w.commitNewWork()
+	<-agent.works
+
+	start := time.Now()
+	if err := backend.txPool.Add(backend.newTx(t, 0, 1)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	select {
+	case work := <-agent.works:
+		if txs := len(work.Block.Transactions()); txs != 1 {
+			t.Fatalf("recommitted transaction count mismatch: have %d, want %d", txs, 1)
+		}
+		if elapsed := time.Since(start); elapsed < minRecommitInterval/2 {
+			t.Errorf("work recommitted before the interval: %v", elapsed)
+		}
+	case <-time.After(DefaultRecommitInterval + 2*minRecommitInterval):
+		t.Fatalf("work not recommitted")
+	}
+}
+
+// Tests that a recommit keeps sealing the current work unless the rebuilt one
+// pays more fees.
+func TestWorkerRecommitReplace(t *testing.T) {
+	backend := newTestWorkerBackend(t)
+	defer backend.close()
+
+	w := newWorker(params.TestChainConfig, common.Address{}, backend, backend.eventer)
+	defer w.stop()
+
+	w.start()
+	txs := []*types.Transaction{backend.newTx(t, 0, 10), backend.newTx(t, 1, 10)}
+	if err := backend.txPool.AddBatch(txs); err != nil {
+		t.Fatalf("failed to add transactions: %v", err)
+	}
+	w.commitNewWork()
+	prev := currentWork(w)
+	if have := len(prev.Block.Transactions()); have != 2 {
+		t.Fatalf("transaction count mismatch: have %d, want %d", have, 2)
+	}
+	// Recommitting the same transactions should keep the current work
+	w.commitWork(true)
+	if currentWork(w) != prev {
+		t.Fatalf("work replaced without fee increase")
+	}
+	// Dropping a transaction lowers the fees, the current work must be kept
+	backend.txPool.Remove(txs[1].Hash())
+	w.commitWork(true)
+	if currentWork(w) != prev {
+		t.Fatalf("work replaced after fee decrease")
+	}
+	// Replacing the dropped transaction with a pricier one must replace the work
+	if err := backend.txPool.Add(backend.newTx(t, 1, 20)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	w.commitWork(true)
+	if current := currentWork(w); current == prev {
+		t.Fatalf("work kept after fee increase")
+	} else if have := len(current.Block.Transactions()); have != 2 {
+		t.Fatalf("transaction count mismatch: have %d, want %d", have, 2)
+	}
+}
+
+// Tests that the pending block picks up new transactions right away while
+// mining, without touching the work being sealed.
+func TestWorkerPendingWhileMining(t *testing.T) {
+	backend := newTestWorkerBackend(t)
+	defer backend.close()
+
+	w := newWorker(params.TestChainConfig, common.Address{}, backend, backend.eventer)
+	defer w.stop()
+
+	w.start()
+	w.commitNewWork()
+	sealing := currentWork(w)
+
+	if err := backend.txPool.Add(backend.newTx(t, 0, 1)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	for i := 0; ; i++ {
+		if len(w.pendingBlock().Transactions()) == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("pending block not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if current := currentWork(w); current != sealing {
+		t.Fatalf("sealed work replaced before the recommit")
+	} else if have := len(current.Block.Transactions()); have != 0 {
+		t.Fatalf("sealed transaction count mismatch: have %d, want %d", have, 0)
+	}
+}
@@ -141,17 +141,15 @@ func NewNode(datadir string, config *NodeConfig) (stack *Node, _ error) {
 				EIP155Block:    big.NewInt(config.EthereumChainConfig.EIP155Block),
 				EIP158Block:    big.NewInt(config.EthereumChainConfig.EIP158Block),
 			},
-			Genesis:                 config.EthereumGenesis,
-			LightMode:               true,
-			DatabaseCache:           config.EthereumDatabaseCache,
-			NetworkId:               config.EthereumNetworkID,
-			GasPrice:                new(big.Int).Mul(big.NewInt(20), common.Shannon),
-			GpoMinGasPrice:          new(big.Int).Mul(big.NewInt(20), common.Shannon),
-			GpoMaxGasPrice:          new(big.Int).Mul(big.NewInt(500), common.Shannon),
-			GpoFullBlockRatio:       80,
-			GpobaseStepDown:         10,
-			GpobaseStepUp:           100,
-			GpobaseCorrectionFactor: 110,
+			Genesis:        config.EthereumGenesis,
+			LightMode:      true,
+			DatabaseCache:  config.EthereumDatabaseCache,
+			NetworkId:      config.EthereumNetworkID,
+			GasPrice:       new(big.Int).Mul(big.NewInt(20), common.Shannon),
+			GpoBlocks:      10,
+			GpoPercentile:  50,
+			GpoMinGasPrice: new(big.Int).Mul(big.NewInt(20), common.Shannon),
+			GpoMaxGasPrice: new(big.Int).Mul(big.NewInt(500), common.Shannon),
 		}
 		if err := rawStack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
 			return les.New(ctx, ethConf)
@@ -0,0 +1,131 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package params
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// CHTFrequency is the number of blocks in a single canonical hash trie section.
+const CHTFrequency = 4096
+
+// BloomBitsBlocks is the number of blocks in a single section of the bloom bit
+// index. It matches the CHT sections so that light clients can verify both with
+// the same trusted checkpoint.
+const BloomBitsBlocks = CHTFrequency
+
+var (
+	// MainnetTrustedCheckpoint contains the trusted checkpoint of the main network
+	// on the side of the DAO hard-fork.
+	MainnetTrustedCheckpoint = &TrustedCheckpoint{
+		SectionIndex: 636,
+		CHTRoot:      common.HexToHash("0x01e408d9b1942f05dba1a879f3eaafe34d219edaeb8223fecf1244cc023d3e23"),
+	}
+
+	// MainnetNoDAOTrustedCheckpoint contains the trusted checkpoint of the main
+	// network on the side opposing the DAO hard-fork.
+	MainnetNoDAOTrustedCheckpoint = &TrustedCheckpoint{
+		SectionIndex: 522,
+		CHTRoot:      common.HexToHash("0xc035076523faf514038f619715de404a65398c51899b5dccca9c05b00bc79315"),
+	}
+
+	// MordenTrustedCheckpoint contains the trusted checkpoint of the old Morden
+	// test network.
+	MordenTrustedCheckpoint = &TrustedCheckpoint{
+		SectionIndex: 451,
+		CHTRoot:      common.HexToHash("0x511da2c88e32b14cf4a4e62f7fcbb297139faebc260a4ab5eb43cce6edcba324"),
+	}
+
+	mordenGenesisHash = common.HexToHash("0x0cd786a2425d16f152c658316c423e6ce1181e15c3295826d7c9904cba9ce303")
+)
+
+// TrustedCheckpoint represents a canonical hash trie root (CHT) along with the
+// index and head hash of the last section it covers. Checkpoints are only used
+// by light clients: they skip downloading the headers before the checkpoint by
+// retrieving its head through a CHT proof, and reject any peer whose chain does
+// not contain it. Full and fast sync don't use them.
+//
+// The section head is optional: if unset, it is resolved from the CHT proof
+// itself. The bloom trie root is optional too: if unset, light clients can't
+// use the bloom bit index of the covered sections to filter logs.
+type TrustedCheckpoint struct {
+	SectionIndex uint64      `json:"sectionIndex"` // Index of the last CHT section covered by the checkpoint
+	SectionHead  common.Hash `json:"sectionHead"`  // Hash of the last block in the section (zero = unknown)
+	CHTRoot      common.Hash `json:"chtRoot"`      // Root of the canonical hash trie up to the section
+	BloomRoot    common.Hash `json:"bloomRoot"`    // Root of the bloom bit index trie up to the section (zero = unknown)
+}
+
+// HeadNumber returns the number of the last block covered by the checkpoint.
+func (c *TrustedCheckpoint) HeadNumber() uint64 {
+	return (c.SectionIndex+1)*CHTFrequency - 1
+}
+
+// String implements the Stringer interface, producing the same format accepted
+// by ParseTrustedCheckpoint.
+func (c *TrustedCheckpoint) String() string {
+	if c.BloomRoot == (common.Hash{}) {
+		return fmt.Sprintf("%d:%x:%x", c.SectionIndex, c.SectionHead, c.CHTRoot)
+	}
+	return fmt.Sprintf("%d:%x:%x:%x", c.SectionIndex, c.SectionHead, c.CHTRoot, c.BloomRoot)
+}
+
+// ParseTrustedCheckpoint parses a trusted checkpoint from its textual format of
+// <section index>:<section head hash>:<CHT root>[:<bloom trie root>].
+func ParseTrustedCheckpoint(text string) (*TrustedCheckpoint, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("invalid checkpoint %q, want <section index>:<section head>:<CHT root>[:<bloom trie root>]", text)
+	}
+	index, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint section index %q: %v", parts[0], err)
+	}
+	for _, hash := range parts[1:] {
+		if len(common.FromHex(hash)) != common.HashLength {
+			return nil, fmt.Errorf("invalid checkpoint hash %q", hash)
+		}
+	}
+	checkpoint := &TrustedCheckpoint{
+		SectionIndex: index,
+		SectionHead:  common.HexToHash(parts[1]),
+		CHTRoot:      common.HexToHash(parts[2]),
+	}
+	if len(parts) == 4 {
+		checkpoint.BloomRoot = common.HexToHash(parts[3])
+	}
+	return checkpoint, nil
+}
+
+// TrustedCheckpointFor retrieves the built-in trusted checkpoint of the network
+// identified by its genesis hash and chain configuration, or nil if there is no
+// checkpoint known for it.
+func TrustedCheckpointFor(genesis common.Hash, config *ChainConfig) *TrustedCheckpoint {
+	switch genesis {
+	case MainNetGenesisHash:
+		if config.DAOForkSupport {
+			return MainnetTrustedCheckpoint
+		}
+		return MainnetNoDAOTrustedCheckpoint
+	case mordenGenesisHash:
+		return MordenTrustedCheckpoint
+	}
+	return nil
+}
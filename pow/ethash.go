@@ -0,0 +1,672 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+const (
+	// algorithmRevision is the data structure version used for file naming.
+	algorithmRevision = 23
+
+	// datasetPregenHeight is the block height within an epoch after which the
+	// mining dataset of the next epoch is generated in the background.
+	datasetPregenHeight = epochLength / 2
+
+	// testCacheSize and testDatasetSize are the tiny verification cache and
+	// mining dataset sizes used by the tester instances.
+	testCacheSize   = 1024
+	testDatasetSize = 32 * 1024
+)
+
+var (
+	// maxUint256 is a big integer representing 2^256.
+	maxUint256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
+
+	// sharedEthash is a full instance that can be shared between multiple users.
+	sharedEthash = New("", 3, 0, "", 1, 0)
+
+	// dumpMagic is a dataset dump header to sanity check a data dump.
+	dumpMagic = []uint32{0xbaddcafe, 0xfee1dead}
+
+	// DefaultDir is the default directory to store the ethash mining datasets in.
+	DefaultDir = defaultDir()
+)
+
+// defaultDir returns the platform specific default location of the datasets.
+func defaultDir() string {
+	home := os.Getenv("HOME")
+	if user, err := user.Current(); err == nil {
+		home = user.HomeDir
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "AppData", "Ethash")
+	}
+	return filepath.Join(home, ".ethash")
+}
+
+// memoryMap tries to memory map a file of uint32s for read only access.
+func memoryMap(path string) (*os.File, []byte, []uint32, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mem, err := mapFile(file, false)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	buffer := bytesToUint32s(mem)
+	for i, magic := range dumpMagic {
+		if i >= len(buffer) || buffer[i] != magic {
+			unmapFile(file, mem, false)
+			file.Close()
+			return nil, nil, nil, errors.New("invalid dump magic")
+		}
+	}
+	return file, mem, buffer[len(dumpMagic):], nil
+}
+
+// memoryMapAndGenerate tries to memory map a temporary file of uint32s for write
+// access, fill it with the data from a generator and then move it into the final
+// path requested.
+func memoryMapAndGenerate(path string, size uint64, generator func(buffer []uint32)) (*os.File, []byte, []uint32, error) {
+	// Ensure the data folder exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, nil, err
+	}
+	// Create a huge temporary empty file to fill with data
+	temp := path + "." + strconv.Itoa(rand.Int())
+
+	dump, err := os.Create(temp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err = dump.Truncate(int64(len(dumpMagic))*4 + int64(size)); err != nil {
+		dump.Close()
+		os.Remove(temp)
+		return nil, nil, nil, err
+	}
+	// Memory map the file for writing and fill it with the generator
+	mem, err := mapFile(dump, true)
+	if err != nil {
+		dump.Close()
+		os.Remove(temp)
+		return nil, nil, nil, err
+	}
+	data := bytesToUint32s(mem)
+	copy(data, dumpMagic)
+	generator(data[len(dumpMagic):])
+
+	err = unmapFile(dump, mem, true)
+	if cerr := dump.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return nil, nil, nil, err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return nil, nil, nil, err
+	}
+	return memoryMap(path)
+}
+
+// dumpPath returns the file path of a cache or dataset dump of a given epoch.
+func dumpPath(dir string, kind string, epoch uint64) string {
+	seed := seedHash(epoch*epochLength + 1)
+
+	var endian string
+	if !isLittleEndian() {
+		endian = ".be"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-R%d-%x%s", kind, algorithmRevision, seed[:8], endian))
+}
+
+// removeDumps deletes all the dumps of a given kind which are older than the
+// specified epoch.
+func removeDumps(dir string, kind string, epoch int) {
+	for ep := epoch; ep >= 0; ep-- {
+		path := dumpPath(dir, kind, uint64(ep))
+		if err := os.Remove(path); err == nil {
+			glog.V(logger.Debug).Infof("Removed old ethash %s dump %s", kind, path)
+		}
+	}
+}
+
+// cache wraps an ethash cache with some metadata to allow easier concurrent use.
+type cache struct {
+	epoch uint64 // Epoch for which this cache is relevant
+
+	dump *os.File  // File descriptor of the memory mapped cache
+	mmap []byte    // Memory map itself to unmap before releasing
+	used time.Time // Timestamp of the last use for smarter eviction
+
+	cache []uint32  // The actual cache data content (may be memory mapped)
+	once  sync.Once // Ensures the cache is generated only once
+}
+
+// generate ensures that the cache content is generated before use.
+func (c *cache) generate(dir string, limit int, test bool) {
+	c.once.Do(func() {
+		seed := seedHash(c.epoch*epochLength + 1)
+
+		size := cacheSize(c.epoch*epochLength + 1)
+		if test {
+			size = testCacheSize
+		}
+		// If we don't store anything on disk, generate and return
+		if dir == "" {
+			c.cache = make([]uint32, size/4)
+			generateCache(c.cache, c.epoch, seed)
+			return
+		}
+		// Disk storage is needed, this will get fancy
+		path := dumpPath(dir, "cache", c.epoch)
+
+		// Try to load the file from disk and memory map it
+		var err error
+		c.dump, c.mmap, c.cache, err = memoryMap(path)
+		if err == nil {
+			glog.V(logger.Debug).Infof("Loaded old ethash cache for epoch %d from disk", c.epoch)
+			runtime.SetFinalizer(c, (*cache).release)
+			return
+		}
+		glog.V(logger.Debug).Infof("Failed to load old ethash cache for epoch %d: %v", c.epoch, err)
+
+		// No previous cache available, create a new cache file to fill
+		c.dump, c.mmap, c.cache, err = memoryMapAndGenerate(path, size, func(buffer []uint32) { generateCache(buffer, c.epoch, seed) })
+		if err != nil {
+			glog.V(logger.Error).Infof("Failed to generate mapped ethash cache for epoch %d: %v", c.epoch, err)
+
+			c.cache = make([]uint32, size/4)
+			generateCache(c.cache, c.epoch, seed)
+			return
+		}
+		runtime.SetFinalizer(c, (*cache).release)
+
+		// Iterate over all previous instances and delete old ones
+		removeDumps(dir, "cache", int(c.epoch)-limit)
+	})
+}
+
+// release closes any file handlers and memory maps open.
+func (c *cache) release() {
+	if c.mmap != nil {
+		unmapFile(c.dump, c.mmap, false)
+		c.mmap = nil
+	}
+	if c.dump != nil {
+		c.dump.Close()
+		c.dump = nil
+	}
+}
+
+// dataset wraps an ethash dataset with some metadata to allow easier concurrent use.
+type dataset struct {
+	epoch uint64 // Epoch for which this dataset is relevant
+
+	dump *os.File  // File descriptor of the memory mapped dataset
+	mmap []byte    // Memory map itself to unmap before releasing
+	used time.Time // Timestamp of the last use for smarter eviction
+
+	dataset []uint32  // The actual dataset content (may be memory mapped)
+	once    sync.Once // Ensures the dataset is generated only once
+}
+
+// generate ensures that the dataset content is generated before use.
+func (d *dataset) generate(dir string, limit int, test bool) {
+	d.once.Do(func() {
+		seed := seedHash(d.epoch*epochLength + 1)
+
+		csize := cacheSize(d.epoch*epochLength + 1)
+		dsize := datasetSize(d.epoch*epochLength + 1)
+		if test {
+			csize = testCacheSize
+			dsize = testDatasetSize
+		}
+		// If we don't store anything on disk, generate and return
+		if dir == "" {
+			cache := make([]uint32, csize/4)
+			generateCache(cache, d.epoch, seed)
+
+			d.dataset = make([]uint32, dsize/4)
+			generateDataset(d.dataset, d.epoch, cache)
+			return
+		}
+		// Disk storage is needed, this will get fancy
+		path := dumpPath(dir, "full", d.epoch)
+
+		// Try to load the file from disk and memory map it
+		var err error
+		d.dump, d.mmap, d.dataset, err = memoryMap(path)
+		if err == nil {
+			glog.V(logger.Debug).Infof("Loaded old ethash dataset for epoch %d from disk", d.epoch)
+			runtime.SetFinalizer(d, (*dataset).release)
+			return
+		}
+		glog.V(logger.Debug).Infof("Failed to load old ethash dataset for epoch %d: %v", d.epoch, err)
+
+		// No previous dataset available, create a new dataset file to fill
+		cache := make([]uint32, csize/4)
+		generateCache(cache, d.epoch, seed)
+
+		d.dump, d.mmap, d.dataset, err = memoryMapAndGenerate(path, dsize, func(buffer []uint32) { generateDataset(buffer, d.epoch, cache) })
+		if err != nil {
+			glog.V(logger.Error).Infof("Failed to generate mapped ethash dataset for epoch %d: %v", d.epoch, err)
+
+			d.dataset = make([]uint32, dsize/4)
+			generateDataset(d.dataset, d.epoch, cache)
+			return
+		}
+		runtime.SetFinalizer(d, (*dataset).release)
+
+		// Iterate over all previous instances and delete old ones
+		removeDumps(dir, "full", int(d.epoch)-limit)
+	})
+}
+
+// release closes any file handlers and memory maps open.
+func (d *dataset) release() {
+	if d.mmap != nil {
+		unmapFile(d.dump, d.mmap, false)
+		d.mmap = nil
+	}
+	if d.dump != nil {
+		d.dump.Close()
+		d.dump = nil
+	}
+}
+
+// MakeCache generates a new ethash cache and optionally stores it to disk.
+func MakeCache(block uint64, dir string) error {
+	if block/epochLength >= maxEpoch {
+		return fmt.Errorf("block number too high, limit is %d", epochLength*maxEpoch)
+	}
+	c := cache{epoch: block / epochLength}
+	c.generate(dir, math.MaxInt32, false)
+	c.release()
+	return nil
+}
+
+// MakeDataset generates a new ethash dataset and optionally stores it to disk.
+// Only the limit most recent datasets up to the epoch of the block are kept on
+// disk, older ones are removed.
+func MakeDataset(block uint64, dir string, limit int) error {
+	if block/epochLength >= maxEpoch {
+		return fmt.Errorf("block number too high, limit is %d", epochLength*maxEpoch)
+	}
+	d := dataset{epoch: block / epochLength}
+	d.generate(dir, limit, false)
+	d.release()
+	return nil
+}
+
+// SeedHash is the seed to use for generating a verification cache and the mining
+// dataset of the epoch the given block belongs to.
+func SeedHash(block uint64) ([]byte, error) {
+	if block/epochLength >= maxEpoch {
+		return nil, fmt.Errorf("block number too high, limit is %d", epochLength*maxEpoch)
+	}
+	return seedHash(block), nil
+}
+
+// Ethash is a native Go implementation of the ethash proof-of-work algorithm,
+// maintaining the verification caches and mining datasets of recent epochs.
+type Ethash struct {
+	cachedir     string // Data directory to store the verification caches
+	cachesinmem  int    // Number of caches to keep in memory
+	cachesondisk int    // Number of caches to keep on disk
+	dagdir       string // Data directory to store full mining datasets
+	dagsinmem    int    // Number of mining datasets to keep in memory
+	dagsondisk   int    // Number of mining datasets to keep on disk
+
+	caches   map[uint64]*cache   // In memory caches to avoid regenerating too often
+	fcache   *cache              // Pre-generated cache for the estimated future epoch
+	datasets map[uint64]*dataset // In memory datasets to avoid regenerating too often
+	fdataset *dataset            // Pre-generated dataset for the estimated future epoch
+
+	hashrate int32 // Current mining hash rate of all search threads, atomic
+	turbo    int32 // Whether mining threads should spin without pausing, atomic
+
+	// The fields below are hooks for testing
+	tester    bool          // Flag whether to use a smaller test dataset
+	shared    *Ethash       // Shared PoW verifier to avoid cache regeneration
+	fakeMode  bool          // Flag whether to disable PoW checking
+	fakeFail  uint64        // Block number which fails PoW check even in fake mode
+	fakeDelay time.Duration // Time delay to sleep for before returning from verify
+
+	lock sync.Mutex // Ensures thread safety for the in-memory caches
+}
+
+// New creates a full sized ethash PoW scheme, storing the verification caches in
+// cachedir and the mining datasets in dagdir. Empty directories disable storing
+// the respective data structures on disk.
+func New(cachedir string, cachesinmem, cachesondisk int, dagdir string, dagsinmem, dagsondisk int) *Ethash {
+	if cachesinmem <= 0 {
+		glog.V(logger.Warn).Infof("One ethash cache must always be in memory, requested %d", cachesinmem)
+		cachesinmem = 1
+	}
+	if dagsinmem <= 0 {
+		glog.V(logger.Warn).Infof("One ethash dataset must always be in memory, requested %d", dagsinmem)
+		dagsinmem = 1
+	}
+	if cachedir != "" && cachesondisk > 0 {
+		glog.V(logger.Info).Infof("Disk storage enabled for ethash caches (dir: %s, count: %d)", cachedir, cachesondisk)
+	}
+	if dagdir != "" && dagsondisk > 0 {
+		glog.V(logger.Info).Infof("Disk storage enabled for ethash datasets (dir: %s, count: %d)", dagdir, dagsondisk)
+	}
+	return &Ethash{
+		cachedir:     cachedir,
+		cachesinmem:  cachesinmem,
+		cachesondisk: cachesondisk,
+		dagdir:       dagdir,
+		dagsinmem:    dagsinmem,
+		dagsondisk:   dagsondisk,
+		caches:       make(map[uint64]*cache),
+		datasets:     make(map[uint64]*dataset),
+		turbo:        1,
+	}
+}
+
+// NewTestEthash creates a small sized ethash PoW scheme useful only for testing
+// purposes. Nonces found by it are not verifiable with a full sized instance.
+func NewTestEthash() *Ethash {
+	return &Ethash{
+		cachesinmem: 1,
+		dagsinmem:   1,
+		caches:      make(map[uint64]*cache),
+		datasets:    make(map[uint64]*dataset),
+		turbo:       1,
+		tester:      true,
+	}
+}
+
+// NewFakeEthash creates an ethash scheme with a fake PoW scheme that accepts all
+// blocks' seal as valid, though they still have to conform to the Ethereum
+// consensus rules.
+func NewFakeEthash() *Ethash {
+	return &Ethash{fakeMode: true, fakeFail: math.MaxUint64}
+}
+
+// NewFakeFailer creates an ethash scheme with a fake PoW scheme that accepts all
+// blocks as valid apart from the single one specified, though they still have
+// to conform to the Ethereum consensus rules.
+func NewFakeFailer(number uint64) *Ethash {
+	return &Ethash{fakeMode: true, fakeFail: number}
+}
+
+// NewFakeDelayer creates an ethash scheme with a fake PoW scheme that accepts
+// all blocks as valid, but delays verifications by some time, though they still
+// have to conform to the Ethereum consensus rules.
+func NewFakeDelayer(delay time.Duration) *Ethash {
+	return &Ethash{fakeMode: true, fakeFail: math.MaxUint64, fakeDelay: delay}
+}
+
+// NewShared creates a full sized ethash PoW shared between all requesters
+// running in the same process.
+func NewShared() *Ethash {
+	return &Ethash{shared: sharedEthash}
+}
+
+// Verify implements PoW, checking whether the given block satisfies the PoW
+// difficulty requirements.
+func (ethash *Ethash) Verify(block Block) bool {
+	// If we're running a fake PoW, accept any seal as valid
+	if ethash.fakeMode {
+		time.Sleep(ethash.fakeDelay)
+		return block.NumberU64() != ethash.fakeFail
+	}
+	// If we're running a shared PoW, delegate verification to it
+	if ethash.shared != nil {
+		return ethash.shared.Verify(block)
+	}
+	// Sanity check that the block number is below the lookup table size (60M blocks)
+	number := block.NumberU64()
+	if number/epochLength >= maxEpoch {
+		glog.V(logger.Debug).Infof("Block number %d too high, limit is %d", number, epochLength*maxEpoch)
+		return false
+	}
+	// Ensure that we have a valid difficulty for the block
+	difficulty := block.Difficulty()
+	if difficulty.Sign() <= 0 {
+		glog.V(logger.Debug).Infof("Invalid block difficulty %v", difficulty)
+		return false
+	}
+	// Recompute the digest and PoW value and verify against the block
+	cache := ethash.cache(number)
+
+	size := datasetSize(number)
+	if ethash.tester {
+		size = testDatasetSize
+	}
+	hash := block.HashNoNonce()
+	digest, result := hashimotoLight(size, cache.cache, hash[:], block.Nonce())
+
+	// Caches are unmapped in a finalizer, ensure that it's not run during hashing
+	runtime.KeepAlive(cache)
+
+	// Avoid mix digest malleability as it's not included in a block's "hashNoNonce"
+	if mix := block.MixDigest(); !bytes.Equal(mix[:], digest) {
+		return false
+	}
+	target := new(big.Int).Div(maxUint256, difficulty)
+	return new(big.Int).SetBytes(result).Cmp(target) <= 0
+}
+
+// cache tries to retrieve a verification cache for the specified block number
+// by first checking against a list of in-memory caches, then against caches
+// stored on disk, and finally generating one if none can be found.
+func (ethash *Ethash) cache(block uint64) *cache {
+	epoch := block / epochLength
+
+	// If we have a PoW for that epoch, use that
+	ethash.lock.Lock()
+
+	current, future := ethash.caches[epoch], (*cache)(nil)
+	if current == nil {
+		// No in-memory cache, evict the oldest if the cache limit was reached
+		for len(ethash.caches) > 0 && len(ethash.caches) >= ethash.cachesinmem {
+			var evict *cache
+			for _, cache := range ethash.caches {
+				if evict == nil || evict.used.After(cache.used) {
+					evict = cache
+				}
+			}
+			delete(ethash.caches, evict.epoch)
+			glog.V(logger.Debug).Infof("Evicted ethash cache for epoch %d", evict.epoch)
+		}
+		// If we have the new cache pre-generated, use that, otherwise create a new one
+		if ethash.fcache != nil && ethash.fcache.epoch == epoch {
+			glog.V(logger.Debug).Infof("Using pre-generated ethash cache for epoch %d", epoch)
+			current, ethash.fcache = ethash.fcache, nil
+		} else {
+			glog.V(logger.Debug).Infof("Requiring new ethash cache for epoch %d", epoch)
+			current = &cache{epoch: epoch}
+		}
+		ethash.caches[epoch] = current
+
+		// If we just used up the future cache, or need a refresh, regenerate
+		if (ethash.fcache == nil || ethash.fcache.epoch <= epoch) && epoch+1 < maxEpoch {
+			glog.V(logger.Debug).Infof("Requiring new future ethash cache for epoch %d", epoch+1)
+			future = &cache{epoch: epoch + 1}
+			ethash.fcache = future
+		}
+	}
+	current.used = time.Now()
+	ethash.lock.Unlock()
+
+	// Wait for generation finish, bump the timestamp and finalize the cache
+	current.generate(ethash.cachedir, ethash.cachesondisk, ethash.tester)
+
+	// If we exhausted the future cache, now's a good time to regenerate it
+	if future != nil {
+		go future.generate(ethash.cachedir, ethash.cachesondisk, ethash.tester)
+	}
+	return current
+}
+
+// Search implements PoW, attempting to find a nonce that satisfies the block's
+// difficulty requirements.
+func (ethash *Ethash) Search(block Block, stop <-chan struct{}, index int) (uint64, []byte) {
+	// If we're running a fake PoW, simply return a 0 nonce immediately
+	if ethash.fakeMode {
+		return 0, nil
+	}
+	// If we're running a shared PoW, delegate sealing to it
+	if ethash.shared != nil {
+		return ethash.shared.Search(block, stop, index)
+	}
+	// Extract some data from the block
+	var (
+		hash     = block.HashNoNonce()
+		target   = new(big.Int).Div(maxUint256, block.Difficulty())
+		dataset  = ethash.dataset(block.NumberU64())
+		attempts = int64(0)
+		start    = time.Now()
+		rate     = int32(0)
+		nonce    = uint64(rand.New(rand.NewSource(time.Now().UnixNano() + int64(index))).Int63())
+	)
+	defer func() {
+		atomic.AddInt32(&ethash.hashrate, -rate)
+		runtime.KeepAlive(dataset)
+	}()
+	for {
+		select {
+		case <-stop:
+			return 0, nil
+		default:
+		}
+		// We don't have to update hash rate on every nonce, so update after
+		// the first nonce check and then after 2^X nonces
+		attempts++
+		if attempts == 2 || attempts%(1<<15) == 0 {
+			current := int32(float64(attempts) / time.Since(start).Seconds())
+			atomic.AddInt32(&ethash.hashrate, current-rate)
+			rate = current
+		}
+		// Compute the PoW value of this nonce (zero nonces mean failure to the agents)
+		if nonce != 0 {
+			digest, result := hashimotoFull(dataset.dataset, hash[:], nonce)
+			if new(big.Int).SetBytes(result).Cmp(target) <= 0 {
+				return nonce, digest
+			}
+		}
+		nonce++
+
+		if atomic.LoadInt32(&ethash.turbo) == 0 {
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+}
+
+// dataset tries to retrieve a mining dataset for the specified block number
+// by first checking against a list of in-memory datasets, then against DAGs
+// stored on disk, and finally generating one if none can be found. Once the
+// block is past the middle of its epoch, the dataset of the next epoch is
+// generated in the background.
+func (ethash *Ethash) dataset(block uint64) *dataset {
+	epoch := block / epochLength
+
+	// If we have a PoW for that epoch, use that
+	ethash.lock.Lock()
+
+	current, future := ethash.datasets[epoch], (*dataset)(nil)
+	if current == nil {
+		// No in-memory dataset, evict the oldest if the dataset limit was reached
+		for len(ethash.datasets) > 0 && len(ethash.datasets) >= ethash.dagsinmem {
+			var evict *dataset
+			for _, dataset := range ethash.datasets {
+				if evict == nil || evict.used.After(dataset.used) {
+					evict = dataset
+				}
+			}
+			delete(ethash.datasets, evict.epoch)
+			glog.V(logger.Debug).Infof("Evicted ethash dataset for epoch %d", evict.epoch)
+		}
+		// If we have the new dataset pre-generated, use that, otherwise create a new one
+		if ethash.fdataset != nil && ethash.fdataset.epoch == epoch {
+			glog.V(logger.Debug).Infof("Using pre-generated ethash dataset for epoch %d", epoch)
+			current, ethash.fdataset = ethash.fdataset, nil
+		} else {
+			glog.V(logger.Debug).Infof("Requiring new ethash dataset for epoch %d", epoch)
+			current = &dataset{epoch: epoch}
+		}
+		ethash.datasets[epoch] = current
+	}
+	// Pre-generate the next dataset if we're far enough into the current epoch
+	if block%epochLength >= datasetPregenHeight && epoch+1 < maxEpoch {
+		if ethash.fdataset == nil || ethash.fdataset.epoch <= epoch {
+			glog.V(logger.Debug).Infof("Requiring new future ethash dataset for epoch %d", epoch+1)
+			future = &dataset{epoch: epoch + 1}
+			ethash.fdataset = future
+		}
+	}
+	current.used = time.Now()
+	ethash.lock.Unlock()
+
+	// Wait for generation finish, bump the timestamp and finalize the dataset
+	current.generate(ethash.dagdir, ethash.dagsondisk, ethash.tester)
+
+	// If we exhausted the future dataset, now's a good time to regenerate it
+	if future != nil {
+		go future.generate(ethash.dagdir, ethash.dagsondisk, ethash.tester)
+	}
+	return current
+}
+
+// GetHashrate implements PoW, returning the measured rate of the search invocations
+// per second over the last minute.
+func (ethash *Ethash) GetHashrate() int64 {
+	if ethash.shared != nil {
+		return ethash.shared.GetHashrate()
+	}
+	return int64(atomic.LoadInt32(&ethash.hashrate))
+}
+
+// Turbo implements PoW, toggling whether the search threads may pause between
+// nonce checks to spare the CPU.
+func (ethash *Ethash) Turbo(on bool) {
+	if ethash.shared != nil {
+		ethash.shared.Turbo(on)
+		return
+	}
+	if on {
+		atomic.StoreInt32(&ethash.turbo, 1)
+	} else {
+		atomic.StoreInt32(&ethash.turbo, 0)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pow
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Tests that the verification cache and mining dataset sizes are calculated
+// correctly for various epochs.
+func TestSizeCalculations(t *testing.T) {
+	tests := []struct {
+		epoch   uint64
+		cache   uint64
+		dataset uint64
+	}{
+		{0, 16776896, 1073739904},
+		{1, 16907456, 1082130304},
+		{2, 17039296, 1090514816},
+		{100, 29882816, 1912601216},
+		{2047, 285081536, 18245220736},
+	}
+	for i, tt := range tests {
+		if size := cacheSize(tt.epoch*epochLength + 1); size != tt.cache {
+			t.Errorf("test %d: cache size mismatch: have %d, want %d", i, size, tt.cache)
+		}
+		if size := datasetSize(tt.epoch*epochLength + 1); size != tt.dataset {
+			t.Errorf("test %d: dataset size mismatch: have %d, want %d", i, size, tt.dataset)
+		}
+	}
+}
+
+// Tests that the seed hashes are derived correctly for the epochs.
+func TestSeedHash(t *testing.T) {
+	if seed := seedHash(1); !bytes.Equal(seed, make([]byte, 32)) {
+		t.Errorf("epoch 0 seed mismatch: have %x, want zero hash", seed)
+	}
+	want := hexutil.MustDecode("0x290decd9548b62a8d60345a988386fc84ba6bc95484008f6362f93160ef3e563")
+	if seed := seedHash(epochLength + 1); !bytes.Equal(seed, want) {
+		t.Errorf("epoch 1 seed mismatch: have %x, want %x", seed, want)
+	}
+	if _, err := SeedHash(maxEpoch * epochLength); err == nil {
+		t.Errorf("seed hash beyond the maximum epoch accepted")
+	}
+}
+
+// Tests that the cache, the dataset and the light and full hashimoto results
+// match the ones produced by the reference implementation.
+func TestHashimoto(t *testing.T) {
+	cache := make([]uint32, testCacheSize/4)
+	generateCache(cache, 0, seedHash(1))
+
+	want := hexutil.MustDecode("0x7ce2991c951f7bf4c4c1bb119887ee07871eb5339d7b97b8588e85c742de90e5bafd5bbe6ce93a134fb6be9ad3e30db99d9528a2ea7846833f52e9ca119b6b54")
+	if have := uint32sToBytes(cache)[:64]; !bytes.Equal(have, want) {
+		t.Errorf("cache mismatch: have %x, want %x", have, want)
+	}
+	dataset := make([]uint32, testDatasetSize/4)
+	generateDataset(dataset, 0, cache)
+
+	want = hexutil.MustDecode("0x4bc09fbd530a041dd2ec296110a29e8f130f179c59d223f51ecce3126e8b0c74326abc2f32ccd9d7f976bd0944e3ccf8479db39343cbbffa467046ca97e2da63")
+	if have := uint32sToBytes(dataset)[:64]; !bytes.Equal(have, want) {
+		t.Errorf("dataset mismatch: have %x, want %x", have, want)
+	}
+	var (
+		hash   = []byte("c9149cc0386e689d789a1c2f3d5d169a")
+		digest = hexutil.MustDecode("0xa5a68327a6c69c282d72ad06945c47a3f6ff8594292740d6d1d3686005e284cc")
+		result = hexutil.MustDecode("0x662f2f358744689333e59032ee701cc0f953d7876f44fe79e808d600ec4eeb04")
+	)
+	if d, r := hashimotoLight(testDatasetSize, cache, hash, 0); !bytes.Equal(d, digest) || !bytes.Equal(r, result) {
+		t.Errorf("light hashimoto mismatch: have %x/%x, want %x/%x", d, r, digest, result)
+	}
+	if d, r := hashimotoFull(dataset, hash, 0); !bytes.Equal(d, digest) || !bytes.Equal(r, result) {
+		t.Errorf("full hashimoto mismatch: have %x/%x, want %x/%x", d, r, digest, result)
+	}
+}
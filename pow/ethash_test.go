@@ -0,0 +1,120 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pow
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Tests that ethash works correctly in test mode, sealing and verifying blocks.
+func TestTestMode(t *testing.T) {
+	ethash := NewTestEthash()
+
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100)})
+	nonce, mix := ethash.Search(block, nil, 0)
+
+	sealed := block.WithMiningResult(types.EncodeNonce(nonce), common.BytesToHash(mix))
+	if !ethash.Verify(sealed) {
+		t.Fatalf("sealed block failed verification")
+	}
+	tampered := block.WithMiningResult(types.EncodeNonce(nonce+1), common.BytesToHash(mix))
+	if ethash.Verify(tampered) {
+		t.Fatalf("tampered block passed verification")
+	}
+}
+
+// Tests that the fake modes accept all blocks apart from the designated failure.
+func TestFakeMode(t *testing.T) {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100)})
+	if !NewFakeEthash().Verify(block) {
+		t.Errorf("fake ethash rejected block")
+	}
+	if NewFakeFailer(1).Verify(block) {
+		t.Errorf("fake failer accepted designated block")
+	}
+	if !NewFakeFailer(2).Verify(block) {
+		t.Errorf("fake failer rejected non-designated block")
+	}
+}
+
+// Tests that caches are stored on disk, reloaded from there and that stale ones
+// are removed once they fall outside the retention limit.
+func TestCacheFileRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ethash-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Generate a few caches and check that only the latest ones are kept
+	for epoch := uint64(0); epoch < 3; epoch++ {
+		c := &cache{epoch: epoch}
+		c.generate(dir, 2, true)
+		c.release()
+	}
+	for epoch, want := range []bool{false, true, true} {
+		_, err := os.Stat(dumpPath(dir, "cache", uint64(epoch)))
+		if have := err == nil; have != want {
+			t.Errorf("epoch %d: cache file existence mismatch: have %v, want %v", epoch, have, want)
+		}
+	}
+	// Reload a cache from disk and ensure it matches a freshly generated one
+	loaded := &cache{epoch: 2}
+	loaded.generate(dir, 2, true)
+	defer loaded.release()
+
+	if loaded.mmap == nil {
+		t.Fatalf("cache not loaded from disk")
+	}
+	fresh := &cache{epoch: 2}
+	fresh.generate("", 0, true)
+
+	for i := range fresh.cache {
+		if loaded.cache[i] != fresh.cache[i] {
+			t.Fatalf("cache item %d mismatch: have %x, want %x", i, loaded.cache[i], fresh.cache[i])
+		}
+	}
+}
+
+// Tests that the in-memory caches are evicted once the limit is reached, and the
+// next epoch's cache gets pre-generated in the background.
+func TestCacheEviction(t *testing.T) {
+	ethash := NewTestEthash()
+	ethash.cachesinmem = 2
+
+	for _, block := range []uint64{1, epochLength + 1, 2*epochLength + 1} {
+		ethash.cache(block)
+	}
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	if len(ethash.caches) != 2 {
+		t.Fatalf("in-memory cache count mismatch: have %d, want %d", len(ethash.caches), 2)
+	}
+	if _, ok := ethash.caches[0]; ok {
+		t.Errorf("oldest cache not evicted")
+	}
+	if ethash.fcache == nil || ethash.fcache.epoch != 3 {
+		t.Errorf("future cache not scheduled for epoch 3: have %v", ethash.fcache)
+	}
+}
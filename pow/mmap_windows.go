@@ -0,0 +1,40 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pow
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// mapFile loads the entire content of a file into memory. Memory mapping is not
+// supported on Windows, so datasets stored on disk need to be read fully.
+func mapFile(file *os.File, write bool) ([]byte, error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(file)
+}
+
+// unmapFile releases a loaded file, writing any modifications back to disk.
+func unmapFile(file *os.File, mem []byte, write bool) error {
+	if !write {
+		return nil
+	}
+	_, err := file.WriteAt(mem, 0)
+	return err
+}
@@ -19,8 +19,10 @@ package trie
 import (
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
@@ -136,7 +138,13 @@ func (s *TrieSync) AddRawEntry(hash common.Hash, depth int, parent common.Hash)
 func (s *TrieSync) Missing(max int) []common.Hash {
 	requests := []common.Hash{}
 	for !s.queue.Empty() && (max == 0 || len(requests) < max) {
-		requests = append(requests, s.queue.PopItem().(common.Hash))
+		hash := s.queue.PopItem().(common.Hash)
+
+		// Skip any entries already filled in from a restored checkpoint
+		if req := s.requests[hash]; req == nil || req.data != nil {
+			continue
+		}
+		requests = append(requests, hash)
 	}
 	return requests
 }
@@ -190,6 +198,67 @@ func (s *TrieSync) Pending() int {
 	return len(s.requests)
 }
 
+// Uncommitted retrieves the data content of all the nodes that were already
+// retrieved, but are still waiting for some of their children to complete before
+// they can be committed to the database. Together with the database content, it
+// is enough to checkpoint the progress of the sync.
+//
+// If limit is positive, at most that many nodes are returned, the ones closest to
+// the root first, so that the nodes returned can still be restored.
+func (s *TrieSync) Uncommitted(limit int) [][]byte {
+	reqs := make([]*request, 0, len(s.requests))
+	for _, req := range s.requests {
+		if req.data != nil {
+			reqs = append(reqs, req)
+		}
+	}
+	if limit > 0 && len(reqs) > limit {
+		sort.Sort(requestsByDepth(reqs))
+		reqs = reqs[:limit]
+	}
+	nodes := make([][]byte, len(reqs))
+	for i, req := range reqs {
+		nodes[i] = req.data
+	}
+	return nodes
+}
+
+// requestsByDepth implements sort.Interface to order requests by their depth
+// within the trie, shallowest first.
+type requestsByDepth []*request
+
+func (r requestsByDepth) Len() int           { return len(r) }
+func (r requestsByDepth) Less(i, j int) bool { return r[i].depth < r[j].depth }
+func (r requestsByDepth) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// Restore reinjects a batch of previously retrieved, but uncommitted entries
+// into the sync (e.g. from a checkpoint of an earlier sync). Only the entries
+// actually needed by this sync are accepted, either directly or through the
+// restored ancestors, so the batch may originate from a different root. The
+// number of restored entries is returned.
+func (s *TrieSync) Restore(nodes [][]byte, dbw DatabaseWriter) (int, error) {
+	pool := make(map[common.Hash][]byte, len(nodes))
+	for _, blob := range nodes {
+		pool[crypto.Keccak256Hash(blob)] = blob
+	}
+	restored := 0
+	for progressed := true; progressed; {
+		progressed = false
+		for hash, blob := range pool {
+			if req := s.requests[hash]; req == nil || req.data != nil {
+				continue
+			}
+			if _, _, err := s.Process([]SyncResult{{Hash: hash, Data: blob}}, dbw); err != nil {
+				return restored, err
+			}
+			delete(pool, hash)
+			restored++
+			progressed = true
+		}
+	}
+	return restored, nil
+}
+
 // schedule inserts a new state retrieval request into the fetch queue. If there
 // is already a pending request for this node, the new request will be discarded
 // and only a parent reference added to the old one.
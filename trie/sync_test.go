@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 )
 
@@ -331,3 +332,69 @@ func TestIncompleteTrieSync(t *testing.T) {
 		dstDb.Put(key, value)
 	}
 }
+
+// Tests that the uncommitted progress of an interrupted sync can be restored into
+// a new scheduler, which completes the sync without retrieving them again.
+func TestRestoredTrieSync(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+
+	// Create a destination trie and sync it partially with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewTrieSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	queue := append([]common.Hash{}, sched.Missing(1)...)
+	for i := 0; i < 3 && len(queue) > 0; i++ {
+		results := make([]SyncResult, len(queue))
+		for j, hash := range queue {
+			data, err := srcDb.Get(hash.Bytes())
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+			}
+			results[j] = SyncResult{hash, data}
+		}
+		if _, index, err := sched.Process(results, dstDb); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = append(queue[:0], sched.Missing(1)...)
+	}
+	nodes := sched.Uncommitted(0)
+	if len(nodes) == 0 {
+		t.Fatalf("no uncommitted nodes after partial sync")
+	}
+	// A limited checkpoint must keep the nodes closest to the root
+	if limited := sched.Uncommitted(1); len(limited) != 1 || crypto.Keccak256Hash(limited[0]) != common.BytesToHash(srcTrie.Root()) {
+		t.Fatalf("limited uncommitted nodes don't start at the root")
+	}
+	// Restore the progress into a fresh scheduler and finish the sync
+	sched = NewTrieSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+	if restored, err := sched.Restore(nodes, dstDb); err != nil {
+		t.Fatalf("failed to restore nodes: %v", err)
+	} else if restored != len(nodes) {
+		t.Fatalf("restored node count mismatch: have %d, want %d", restored, len(nodes))
+	}
+	known := make(map[common.Hash]bool)
+	for _, blob := range nodes {
+		known[crypto.Keccak256Hash(blob)] = true
+	}
+	queue = append(queue[:0], sched.Missing(10000)...)
+	for len(queue) > 0 {
+		results := make([]SyncResult, len(queue))
+		for i, hash := range queue {
+			if known[hash] {
+				t.Fatalf("restored node %x requested again", hash)
+			}
+			data, err := srcDb.Get(hash.Bytes())
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+			}
+			results[i] = SyncResult{hash, data}
+		}
+		if _, index, err := sched.Process(results, dstDb); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = append(queue[:0], sched.Missing(10000)...)
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}